@@ -1,9 +1,11 @@
 package main
 
 import (
+	"os"
+
 	"filesrv/internal/cmd"
 )
 
 func main() {
-	cmd.Serve()
+	os.Exit(cmd.Main(os.Args[1:]))
 }
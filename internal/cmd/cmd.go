@@ -0,0 +1,192 @@
+// Package cmd contains the command-line interface of the file server.
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filesrv/internal/version"
+)
+
+// Exit codes.  Those follow sysexits(3), which is also understood by systemd,
+// except for the usage error, which follows the flag package.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+	exitConfig  = 78
+)
+
+// command is a subcommand of the binary.
+type command struct {
+	// run executes the command with its arguments and returns the exit code.
+	run func(args []string) (code int)
+
+	// name is the name of the command as typed.
+	name string
+
+	// synopsis is the arguments part of the usage line.
+	synopsis string
+
+	// summary is the one-line description of the command.
+	summary string
+}
+
+// commands returns all the supported commands.  The first one is the default.
+func commands() (cmds []*command) {
+	return []*command{{
+		run:      runServe,
+		name:     "serve",
+		synopsis: "[options] [dir]",
+		summary:  "serve the directory, the current one by default",
+	}, {
+		run:      runCheckConfig,
+		name:     "check-config",
+		synopsis: "[options]",
+		summary:  "validate the configuration and print the effective values",
+	}, {
+		run:      runShare,
+		name:     "share",
+		synopsis: "[options] path | -list | -revoke id",
+		summary:  "create a share link to the path, list or revoke the shares",
+	}, {
+		run:      runHashPassword,
+		name:     "hash-password",
+		synopsis: "[options]",
+		summary:  "hash the password read from stdin with bcrypt",
+	}, {
+		run:      runVersion,
+		name:     "version",
+		synopsis: "",
+		summary:  "print the version information",
+	}}
+}
+
+// Main runs the command specified by args, which shouldn't include the program
+// name, and returns the exit code.  If no command is specified, it serves.
+func Main(args []string) (code int) {
+	cmds := commands()
+	if len(args) > 0 {
+		switch args[0] {
+		case "help", "-h", "-help", "--help":
+			printUsage(os.Stdout, cmds)
+
+			return exitOK
+		}
+	}
+
+	cmd := cmds[0]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd = findCommand(cmds, args[0])
+		if cmd == nil {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+			printUsage(os.Stderr, cmds)
+
+			return exitUsage
+		}
+
+		args = args[1:]
+	}
+
+	return cmd.run(args)
+}
+
+// findCommand returns the command named name or nil if there is none.
+func findCommand(cmds []*command, name string) (cmd *command) {
+	for _, c := range cmds {
+		if c.name == name {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// printUsage prints the usage of the binary to w.
+func printUsage(w io.Writer, cmds []*command) {
+	fmt.Fprintf(w, "Usage: srv <command> [arguments]\n\nCommands:\n")
+	for _, c := range cmds {
+		fmt.Fprintf(w, "  %-15s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nThe default command is %q.  Run \"srv <command> --help\" for details.\n", cmds[0].name)
+}
+
+// newFlagSet returns a new flag set for the command with name.
+func newFlagSet(name string) (fs *flag.FlagSet) {
+	cmd := findCommand(commands(), name)
+
+	fs = flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: srv %s %s\n\n%s.\n", cmd.name, cmd.synopsis, capitalize(cmd.summary))
+
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintf(out, "\nOptions:\n")
+			fs.PrintDefaults()
+		}
+	}
+
+	return fs
+}
+
+// capitalize returns s with the first byte in upper case.  s is expected to be
+// ASCII.
+func capitalize(s string) (res string) {
+	if s == "" {
+		return s
+	}
+
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// parseFlags parses args with fs allowing the flags to follow the positional
+// arguments.  code is the exit code if the parsing failed or the help was
+// requested, in which case ok is false.
+func parseFlags(fs *flag.FlagSet, args []string) (positional []string, code int, ok bool) {
+	for {
+		err := fs.Parse(args)
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, exitOK, false
+		} else if err != nil {
+			return nil, exitUsage, false
+		}
+
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, exitOK, true
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// usageError prints the error about the invalid usage of the command with fs
+// and returns the corresponding exit code.
+func usageError(fs *flag.FlagSet, format string, args ...any) (code int) {
+	fmt.Fprintf(fs.Output(), format+"\n", args...)
+	fs.Usage()
+
+	return exitUsage
+}
+
+// runVersion is the "version" command.
+func runVersion(args []string) (code int) {
+	fs := newFlagSet("version")
+	positional, code, ok := parseFlags(fs, args)
+	if !ok {
+		return code
+	} else if len(positional) > 0 {
+		return usageError(fs, "unexpected arguments: %q", positional)
+	}
+
+	fmt.Println(version.Full())
+
+	return exitOK
+}
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"golang.org/x/exp/maps"
+)
+
+// silence discards the output of the commands for the duration of the test.
+func silence(t *testing.T) {
+	t.Helper()
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, logOut := os.Stdout, os.Stderr, log.Writer()
+	os.Stdout, os.Stderr = devNull, devNull
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		os.Stdout, os.Stderr = stdout, stderr
+		log.SetOutput(logOut)
+		_ = devNull.Close()
+	})
+}
+
+func TestConfigFlags_overrides(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+		dir  string
+		want map[string]string
+	}{{
+		name: "none",
+		args: nil,
+		dir:  "",
+		want: map[string]string{},
+	}, {
+		name: "dir",
+		args: nil,
+		dir:  "/srv",
+		want: map[string]string{"ROOT": "/srv"},
+	}, {
+		name: "upload",
+		args: []string{"-upload"},
+		dir:  "",
+		want: map[string]string{"UPLOAD": "true"},
+	}, {
+		name: "no_upload",
+		args: []string{"-no-upload"},
+		dir:  "",
+		want: map[string]string{"UPLOAD": "false"},
+	}, {
+		name: "upload_then_no_upload",
+		args: []string{"-upload", "-no-upload"},
+		dir:  "",
+		want: map[string]string{"UPLOAD": "false"},
+	}, {
+		name: "no_upload_then_upload",
+		args: []string{"-no-upload", "-upload"},
+		dir:  "",
+		want: map[string]string{"UPLOAD": "true"},
+	}, {
+		name: "no_upload_false",
+		args: []string{"-no-upload=false"},
+		dir:  "",
+		want: map[string]string{"UPLOAD": "true"},
+	}, {
+		name: "p_then_port",
+		args: []string{"-p", "1", "-port", "2"},
+		dir:  "",
+		want: map[string]string{"PORT": "2"},
+	}, {
+		name: "port_then_p",
+		args: []string{"-port", "2", "-p", "1"},
+		dir:  "",
+		want: map[string]string{"PORT": "1"},
+	}, {
+		name: "strings",
+		args: []string{"-host", "localhost", "-theme", "/themes/dark"},
+		dir:  "",
+		want: map[string]string{"HOST": "localhost", "THEME_PATH": "/themes/dark"},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			cf := newConfigFlags(fs)
+			err := fs.Parse(tc.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := cf.overrides(tc.dir)
+			if !maps.Equal(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMain_exitCodes(t *testing.T) {
+	unsetenv(t, "PORT")
+	unsetenv(t, "ROOT")
+
+	root := t.TempDir()
+	valid := writeConfig(t, "conf.toml", "root = "+strconv.Quote(root)+"\n")
+	missing := filepath.Join(t.TempDir(), "missing.toml")
+
+	// Occupy a port for the server to fail listening on.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	busyPort := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+
+	testCases := []struct {
+		name string
+		args []string
+		want int
+	}{{
+		name: "help",
+		args: []string{"help"},
+		want: exitOK,
+	}, {
+		name: "help_flag",
+		args: []string{"-h"},
+		want: exitOK,
+	}, {
+		name: "version",
+		args: []string{"version"},
+		want: exitOK,
+	}, {
+		name: "command_help",
+		args: []string{"check-config", "-h"},
+		want: exitOK,
+	}, {
+		name: "check_config",
+		args: []string{"check-config", "-config", valid},
+		want: exitOK,
+	}, {
+		name: "unknown_command",
+		args: []string{"unknown"},
+		want: exitUsage,
+	}, {
+		name: "unexpected_argument",
+		args: []string{"version", "extra"},
+		want: exitUsage,
+	}, {
+		name: "unknown_flag",
+		args: []string{"serve", "-unknown"},
+		want: exitUsage,
+	}, {
+		name: "check_config_missing",
+		args: []string{"check-config", "-config", missing},
+		want: exitConfig,
+	}, {
+		name: "default_serve_missing_config",
+		args: []string{"-config", missing},
+		want: exitConfig,
+	}, {
+		name: "serve_port_in_use",
+		args: []string{"serve", "-host", "127.0.0.1", "-p", busyPort, root},
+		want: exitFailure,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			silence(t)
+
+			got := Main(tc.args)
+			if got != tc.want {
+				t.Errorf("Main(%q) = %d, want %d", tc.args, got, tc.want)
+			}
+		})
+	}
+}
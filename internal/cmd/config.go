@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// readConfigFile reads the configuration file at name and returns its values
+// as the environment variables they correspond to.  The format is chosen by
+// the file extension, either TOML or YAML.
+//
+// The keys of the file are the names of the environment variables in any case,
+// with dashes allowed instead of underscores.  Nested tables are flattened by
+// joining the keys with underscores, so that
+//
+//	[access_log]
+//	format = "json"
+//
+// is the same as ACCESS_LOG_FORMAT=json.  Lists are joined with commas.  The
+// tables of the options in mapOptions are their values instead, so that
+//
+//	[spa_fallback]
+//	"/app" = "/app/index.html"
+//
+// is the same as SPA_FALLBACK=/app:/app/index.html.
+func readConfigFile(name string) (vars map[string]string, err error) {
+	// #nosec G304 -- The name is provided by the operator.
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	raw := map[string]any{}
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("config file %q: unsupported extension %q", name, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing config file %q: %w", name, err)
+	}
+
+	vars = map[string]string{}
+	err = flattenConfig(vars, "", raw)
+	if err != nil {
+		return nil, fmt.Errorf("config file %q: %w", name, err)
+	}
+
+	return vars, nil
+}
+
+// mapOptions are the options having the maps of strings as their values.
+var mapOptions = []string{"SPA_FALLBACK"}
+
+// flattenConfig puts the values of raw into vars naming those as environment
+// variables with prefix.
+func flattenConfig(vars map[string]string, prefix string, raw map[string]any) (err error) {
+	for k, v := range raw {
+		key := prefix + strings.ToUpper(strings.ReplaceAll(k, "-", "_"))
+		nested, isTable := v.(map[string]any)
+		if isTable && !slices.Contains(mapOptions, key) {
+			err = flattenConfig(vars, key+"_", nested)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if _, ok := vars[key]; ok {
+			return fmt.Errorf("option %q is set more than once", k)
+		}
+
+		if isTable {
+			vars[key], err = configMap(nested)
+		} else {
+			vars[key], err = configValue(v)
+		}
+		if err != nil {
+			return fmt.Errorf("option %q: %w", k, err)
+		}
+	}
+
+	return nil
+}
+
+// configMap formats the table m of the configuration file the way the
+// environment variable of a map option would contain it, as the sorted
+// comma-separated key:value pairs.
+func configMap(m map[string]any) (s string, err error) {
+	keys := maps.Keys(m)
+	slices.Sort(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		var v string
+		v, err = configValue(m[k])
+		if err != nil {
+			return "", fmt.Errorf("key %q: %w", k, err)
+		} else if strings.ContainsAny(k+v, ",:") {
+			return "", fmt.Errorf("key %q: commas and colons are not allowed", k)
+		}
+
+		pairs = append(pairs, k+":"+v)
+	}
+
+	return strings.Join(pairs, ","), nil
+}
+
+// configValue formats the scalar or list value v of the configuration file the
+// way the environment variable would contain it.
+func configValue(v any) (s string, err error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case time.Time:
+		return v.Format(time.RFC3339), nil
+	case []any:
+		elems := make([]string, 0, len(v))
+		for _, e := range v {
+			var es string
+			es, err = configValue(e)
+			if err != nil {
+				return "", err
+			}
+
+			elems = append(elems, es)
+		}
+
+		return strings.Join(elems, ","), nil
+	case map[string]any:
+		return "", fmt.Errorf("unexpected table")
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// secretSuffixes are the suffixes of the options which values must not be
+// printed.
+var secretSuffixes = []string{"_KEY", "_SECRET", "_PASSWORD", "_TOKEN"}
+
+// printSettings logs the effective settings, redacting the secret ones.
+func printSettings(settings map[string]string) {
+	keys := maps.Keys(settings)
+	slices.Sort(keys)
+
+	b := &strings.Builder{}
+	b.WriteString("effective configuration:")
+	for _, k := range keys {
+		v := settings[k]
+		for _, suf := range secretSuffixes {
+			if strings.HasSuffix(k, suf) && v != "" {
+				v = "<redacted>"
+
+				break
+			}
+		}
+
+		fmt.Fprintf(b, "\n\t%s=%q", k, v)
+	}
+
+	log.Print(b.String())
+}
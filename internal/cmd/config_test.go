@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/exp/maps"
+)
+
+// writeConfig writes content into the file name within a temporary directory
+// and returns its path.
+func writeConfig(t *testing.T, name, content string) (p string) {
+	t.Helper()
+
+	p = filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(p, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestReadConfigFile(t *testing.T) {
+	testCases := []struct {
+		name    string
+		file    string
+		content string
+		want    map[string]string
+		wantErr bool
+	}{{
+		name: "toml",
+		file: "conf.toml",
+		content: `port = 8080
+max-upload-size = "1GB"
+index_files = ["index.html", "index.htm"]
+
+[access_log]
+format = "json"
+FILE = "/var/log/access.log"
+`,
+		want: map[string]string{
+			"PORT":              "8080",
+			"MAX_UPLOAD_SIZE":   "1GB",
+			"INDEX_FILES":       "index.html,index.htm",
+			"ACCESS_LOG_FORMAT": "json",
+			"ACCESS_LOG_FILE":   "/var/log/access.log",
+		},
+		wantErr: false,
+	}, {
+		name: "yaml",
+		file: "conf.yml",
+		content: `port: 8080
+upload: false
+index-files: [index.html, index.htm]
+access-log:
+  format: json
+`,
+		want: map[string]string{
+			"PORT":              "8080",
+			"UPLOAD":            "false",
+			"INDEX_FILES":       "index.html,index.htm",
+			"ACCESS_LOG_FORMAT": "json",
+		},
+		wantErr: false,
+	}, {
+		name: "map_toml",
+		file: "conf.toml",
+		content: `[spa_fallback]
+"/docs" = "/docs/404.html"
+"/app" = "/app/index.html"
+`,
+		want: map[string]string{
+			"SPA_FALLBACK": "/app:/app/index.html,/docs:/docs/404.html",
+		},
+		wantErr: false,
+	}, {
+		name: "map_yaml",
+		file: "conf.yaml",
+		content: `spa-fallback:
+  /app: /app/index.html
+`,
+		want: map[string]string{
+			"SPA_FALLBACK": "/app:/app/index.html",
+		},
+		wantErr: false,
+	}, {
+		name: "map_separator",
+		file: "conf.toml",
+		content: `[spa_fallback]
+"/a,b" = "/index.html"
+`,
+		want:    nil,
+		wantErr: true,
+	}, {
+		name:    "duplicate",
+		file:    "conf.toml",
+		content: "port = 1\nPORT = 2\n",
+		want:    nil,
+		wantErr: true,
+	}, {
+		name:    "table_in_list",
+		file:    "conf.yaml",
+		content: "index_files: [{a: b}]\n",
+		want:    nil,
+		wantErr: true,
+	}, {
+		name:    "unsupported_extension",
+		file:    "conf.json",
+		content: `{"port": 8080}`,
+		want:    nil,
+		wantErr: true,
+	}, {
+		name:    "invalid",
+		file:    "conf.toml",
+		content: "port = ",
+		want:    nil,
+		wantErr: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := readConfigFile(writeConfig(t, tc.file, tc.content))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want error", got)
+				}
+
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if !maps.Equal(got, tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -1,11 +1,65 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"filesrv/internal/fhttp"
+	"filesrv/internal/safefs"
+
 	"github.com/c2h5oh/datasize"
 	"github.com/caarlos0/env/v8"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/exp/maps"
 )
 
+// environments are the configuration options of the server.  Each option is
+// read from the environment variable named in the env tag.
 type environments struct {
+	// Root is the path to the directory to serve.
+	Root string `env:"ROOT" envDefault:"."`
+
+	// Symlinks is the policy of following the symbolic links within Root.
+	Symlinks safefs.SymlinkPolicy `env:"SYMLINKS" envDefault:"within-root"`
+
+	// IndexFiles are the names of the files served instead of the directory
+	// listing, in the order of preference.
+	IndexFiles []string `env:"INDEX_FILES" envDefault:"index.html"`
+
+	// IndexRedirect enables redirecting the requests of the index files to
+	// their directories.
+	IndexRedirect bool `env:"INDEX_REDIRECT" envDefault:"true"`
+
+	// SPAFallbacks maps the URL path prefixes to the files served instead of
+	// the missing files under those.
+	SPAFallbacks map[string]string `env:"SPA_FALLBACK" envDefault:""`
+
+	// StaticSite serves Root as a static site with the clean URLs, the
+	// 404.html page and the _headers and _redirects files.
+	StaticSite bool `env:"STATIC_SITE" envDefault:"false"`
+
+	// DirConfig enables the per-directory configuration files.
+	DirConfig bool `env:"DIR_CONFIG" envDefault:"true"`
+
+	// HideDotfiles hides the files and directories which names begin with a
+	// dot.
+	HideDotfiles bool `env:"HIDE_DOTFILES" envDefault:"false"`
+
+	// HidePatterns are the glob patterns of the hidden files and directories.
+	HidePatterns []string `env:"HIDE_PATTERNS" envDefault:""`
+
+	// HideIgnoreFiles are the names of the files with the .gitignore-like
+	// patterns of the hidden files, e.g. .gitignore.
+	HideIgnoreFiles []string `env:"HIDE_IGNORE_FILES" envDefault:""`
+
+	// Upload allows uploading files.
+	Upload bool `env:"UPLOAD" envDefault:"true"`
+
 	// themePath is the path to the theme assets directory.  If empty, the
 	// embedded theme is used.
 	ThemePath string `env:"THEME_PATH" envDefault:""`
@@ -16,11 +70,299 @@ type environments struct {
 	// listenPort is the port to listen on.
 	ListenPort uint16 `env:"PORT" envDefault:"6060"`
 
+	// BasePath is the URL path prefix the server is mounted at, e.g. when
+	// served by a reverse proxy under a subpath.  Empty means the root.
+	BasePath string `env:"BASE_PATH" envDefault:""`
+
+	// TrustedProxies are the networks of the reverse proxies allowed to set
+	// the client's address, scheme, and host with the Forwarded and the
+	// X-Forwarded-* headers.  If empty, the headers are ignored.
+	TrustedProxies []netip.Prefix `env:"TRUSTED_PROXIES" envDefault:""`
+
 	// MaxUploadSize is the maximum size of a file that can be uploaded.  It's
-	// 4GB by default.
+	// 4GB by default.  Zero disables the limit.
 	MaxUploadSize datasize.ByteSize `env:"MAX_UPLOAD_SIZE" envDefault:"4GB"`
+
+	// MaxRequestSize is the maximum size of an upload request body.  Zero
+	// disables the limit.
+	MaxRequestSize datasize.ByteSize `env:"MAX_REQUEST_SIZE" envDefault:"16GB"`
+
+	// DirQuota is the maximum total size of the files within a directory and
+	// its subdirectories that uploads may reach.  Zero disables the limit.
+	DirQuota datasize.ByteSize `env:"DIR_QUOTA" envDefault:"0"`
+
+	// DirMaxFiles is the maximum number of files within a directory and its
+	// subdirectories that uploads may reach.  Zero disables the limit.
+	DirMaxFiles int `env:"DIR_MAX_FILES" envDefault:"0"`
+
+	// MinFreeSpace is the free disk space uploads must leave.
+	MinFreeSpace datasize.ByteSize `env:"MIN_FREE_SPACE" envDefault:"0"`
+
+	// UploadAllowExts are the only extensions allowed for the uploaded files.
+	// If empty, any extension not in UploadDenyExts is allowed.
+	UploadAllowExts []string `env:"UPLOAD_ALLOW_EXT" envDefault:""`
+
+	// UploadDenyExts are the extensions forbidden for the uploaded files.  By
+	// default, those are the ones browsers execute within the server's origin.
+	UploadDenyExts []string `env:"UPLOAD_DENY_EXT" envDefault:".html,.htm,.shtml,.xhtml,.svg,.js,.mjs"`
+
+	// UploadMaxNameLength is the maximum length of the uploaded file's name
+	// in bytes.  Zero disables the limit.
+	UploadMaxNameLength int `env:"UPLOAD_MAX_NAME_LENGTH" envDefault:"255"`
+
+	// DropBoxDirs are the URL paths of the directories only accepting uploads
+	// under unique names, without listing nor serving their contents.
+	DropBoxDirs []string `env:"DROPBOX_DIRS" envDefault:""`
+
+	// WatchInterval is the interval of polling the watched directories for
+	// changes.  Zero disables watching.
+	WatchInterval time.Duration `env:"WATCH_INTERVAL" envDefault:"2s"`
+
+	// MaxWatchers is the maximum number of clients watching a single
+	// directory.  Zero disables the limit.
+	MaxWatchers int `env:"MAX_WATCHERS" envDefault:"16"`
+
+	// MetricsPath is the URL path to serve the Prometheus metrics on.  It's
+	// within the metadata directory by default, so that it doesn't shadow
+	// any served file.  If empty, the metrics aren't collected.
+	MetricsPath string `env:"METRICS_PATH" envDefault:"/.filesrv/metrics"`
+
+	// AccessLogFormat is the format of the access log records.
+	AccessLogFormat fhttp.AccessLogFormat `env:"ACCESS_LOG_FORMAT" envDefault:"combined"`
+
+	// AccessLogFile is the path to the access log file.  If empty, the
+	// records are written to stdout.
+	AccessLogFile string `env:"ACCESS_LOG_FILE" envDefault:""`
+
+	// AccessLogMaxSize is the size of the access log file to rotate it at.
+	// It's only used with AccessLogFile.  Zero disables rotation.
+	AccessLogMaxSize datasize.ByteSize `env:"ACCESS_LOG_MAX_SIZE" envDefault:"100MB"`
+
+	// AccessLogMaxBackups is the number of rotated access log files to keep.
+	AccessLogMaxBackups int `env:"ACCESS_LOG_MAX_BACKUPS" envDefault:"5"`
+
+	// RateLimitClient is the number of requests per second allowed for a
+	// single client IP.  Zero disables the limit.
+	RateLimitClient float64 `env:"RATE_LIMIT_CLIENT" envDefault:"0"`
+
+	// RateLimitClientBurst is the number of requests a single client IP may
+	// make at once.
+	RateLimitClientBurst int `env:"RATE_LIMIT_CLIENT_BURST" envDefault:"20"`
+
+	// RateLimitGlobal is the number of requests per second allowed for all the
+	// clients.  Zero disables the limit.
+	RateLimitGlobal float64 `env:"RATE_LIMIT_GLOBAL" envDefault:"0"`
+
+	// RateLimitGlobalBurst is the number of requests all the clients may make
+	// at once.
+	RateLimitGlobalBurst int `env:"RATE_LIMIT_GLOBAL_BURST" envDefault:"100"`
+
+	// DownloadRate is the download bandwidth per second allowed for a single
+	// client IP.  Zero disables the limit.
+	DownloadRate datasize.ByteSize `env:"DOWNLOAD_RATE" envDefault:"0"`
+
+	// UploadRate is the upload bandwidth per second allowed for a single
+	// client IP.  Zero disables the limit.
+	UploadRate datasize.ByteSize `env:"UPLOAD_RATE" envDefault:"0"`
+
+	// MaxConnections is the maximum number of simultaneous connections.  At
+	// most as many connections over the limit wait for a free slot, the rest
+	// are left in the backlog.  Zero disables the limit.
+	MaxConnections int `env:"MAX_CONNECTIONS" envDefault:"0"`
+
+	// MaxUploads is the maximum number of simultaneous uploads.  Zero
+	// disables the limit.
+	MaxUploads int `env:"MAX_UPLOADS" envDefault:"0"`
+
+	// MaxUploadWriters was the maximum number of files written simultaneously
+	// within a single upload.  It's ignored, since the files of an upload are
+	// written one by one as they're received.
+	//
+	// Deprecated: Setting it only logs a warning.
+	MaxUploadWriters int `env:"MAX_UPLOAD_WRITERS"`
+
+	// QueueTimeout is the time a connection or an upload over the limit waits
+	// for the free slot before being rejected.
+	QueueTimeout time.Duration `env:"QUEUE_TIMEOUT" envDefault:"30s"`
+
+	// Compress enables compressing the responses on the fly.
+	Compress bool `env:"COMPRESS" envDefault:"true"`
+
+	// Precompressed enables serving the precompressed sidecar files.
+	Precompressed bool `env:"PRECOMPRESSED" envDefault:"true"`
+
+	// Checksums enables serving the digests of the files.
+	Checksums bool `env:"CHECKSUMS" envDefault:"true"`
+
+	// HashCacheSize is the number of file digests to cache.  Zero disables
+	// caching.
+	HashCacheSize int `env:"HASH_CACHE_SIZE" envDefault:"1024"`
+
+	// Dedupe enables storing the identical uploaded files once.
+	Dedupe bool `env:"DEDUPE" envDefault:"false"`
+
+	// DedupeGCInterval is the minimum interval between the collections of the
+	// unreferenced stored files.
+	DedupeGCInterval time.Duration `env:"DEDUPE_GC_INTERVAL" envDefault:"1h"`
+
+	// Versioning enables overwriting and deleting files, retaining the
+	// previous versions.
+	Versioning bool `env:"VERSIONING" envDefault:"false"`
+
+	// MaxVersions is the maximum number of retained versions of a file.  Zero
+	// disables the limit.
+	MaxVersions int `env:"MAX_VERSIONS" envDefault:"10"`
+
+	// MaxVersionAge is the maximum age of a retained version.  Zero disables
+	// the limit.
+	MaxVersionAge time.Duration `env:"MAX_VERSION_AGE" envDefault:"720h"`
+
+	// ShareSecret is the key the share links are signed with.  If empty, the
+	// share links are disabled and all the clients are trusted.
+	ShareSecret string `env:"SHARE_SECRET" envDefault:""`
+
+	// ShareTrustedNets are the networks of clients allowed to access
+	// everything without a share link.  It's empty by default, since behind
+	// a reverse proxy without TRUSTED_PROXIES all the clients would come from
+	// the proxy's, usually private, address.
+	ShareTrustedNets []netip.Prefix `env:"SHARE_TRUSTED_NETS" envDefault:""`
+
+	// ShareAdminUser is the user name for managing the shares over HTTP.
+	ShareAdminUser string `env:"SHARE_ADMIN_USER" envDefault:"admin"`
+
+	// ShareAdminPassword is the bcrypt hash of the password for managing the
+	// shares over HTTP.  If empty, the shares are only managed with the CLI.
+	ShareAdminPassword string `env:"SHARE_ADMIN_PASSWORD" envDefault:""`
 }
 
-func parseEnvs() (envs environments, err error) {
-	return envs, env.Parse(&envs)
+// minShareSecretLen is the minimum length of the share links' secret.
+const minShareSecretLen = 16
+
+// parseEnvs parses the environment variables and the configuration file at
+// configFile, if it's not empty.  overrides are the values set by the
+// command-line flags.  Those take precedence over the environment variables,
+// which take precedence over the file, which takes precedence over the
+// defaults.  settings are the effective values of all the options by their
+// environment variable names.
+func parseEnvs(
+	configFile string,
+	overrides map[string]string,
+) (envs *environments, settings map[string]string, err error) {
+	fileVars := map[string]string{}
+	if configFile != "" {
+		fileVars, err = readConfigFile(configFile)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	vars := maps.Clone(fileVars)
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		vars[k] = v
+	}
+	maps.Copy(vars, overrides)
+
+	envs = &environments{}
+	settings = map[string]string{}
+	err = env.ParseWithOptions(envs, env.Options{
+		Environment: vars,
+		OnSet: func(tag string, value any, _ bool) {
+			settings[tag] = fmt.Sprint(value)
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for k := range fileVars {
+		if _, ok := settings[k]; !ok {
+			return nil, nil, fmt.Errorf("config file %q: unknown option %s", configFile, k)
+		}
+	}
+
+	err = envs.validate()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return envs, settings, nil
+}
+
+// validate returns an error describing all the invalid values of envs.  It
+// also logs the warnings about the deprecated options.
+func (envs *environments) validate() (err error) {
+	var errs []error
+	if fi, statErr := os.Stat(envs.Root); statErr != nil {
+		errs = append(errs, fmt.Errorf("ROOT: %w", statErr))
+	} else if !fi.IsDir() {
+		errs = append(errs, fmt.Errorf("ROOT: %q is not a directory", envs.Root))
+	}
+
+	if p := envs.ThemePath; p != "" {
+		if fi, statErr := os.Stat(p); statErr != nil {
+			errs = append(errs, fmt.Errorf("THEME_PATH: %w", statErr))
+		} else if !fi.IsDir() {
+			errs = append(errs, fmt.Errorf("THEME_PATH: %q is not a directory", p))
+		}
+	}
+
+	if p := envs.BasePath; p != "" && !strings.HasPrefix(p, "/") {
+		errs = append(errs, fmt.Errorf("BASE_PATH: %q must start with a slash", p))
+	}
+
+	if p := envs.MetricsPath; p != "" && !strings.HasPrefix(p, "/") {
+		errs = append(errs, fmt.Errorf("METRICS_PATH: %q must start with a slash", p))
+	}
+
+	errs = appendNegative(errs, "ACCESS_LOG_MAX_BACKUPS", envs.AccessLogMaxBackups)
+	errs = appendNegative(errs, "RATE_LIMIT_CLIENT", envs.RateLimitClient)
+	errs = appendNegative(errs, "RATE_LIMIT_CLIENT_BURST", envs.RateLimitClientBurst)
+	errs = appendNegative(errs, "RATE_LIMIT_GLOBAL", envs.RateLimitGlobal)
+	errs = appendNegative(errs, "RATE_LIMIT_GLOBAL_BURST", envs.RateLimitGlobalBurst)
+	errs = appendNegative(errs, "MAX_CONNECTIONS", envs.MaxConnections)
+	errs = appendNegative(errs, "MAX_UPLOADS", envs.MaxUploads)
+	errs = appendNegative(errs, "DIR_MAX_FILES", envs.DirMaxFiles)
+	errs = appendNegative(errs, "UPLOAD_MAX_NAME_LENGTH", envs.UploadMaxNameLength)
+	errs = appendNegative(errs, "HASH_CACHE_SIZE", envs.HashCacheSize)
+	errs = appendNegative(errs, "DEDUPE_GC_INTERVAL", envs.DedupeGCInterval)
+	errs = appendNegative(errs, "MAX_VERSIONS", envs.MaxVersions)
+	errs = appendNegative(errs, "MAX_VERSION_AGE", envs.MaxVersionAge)
+	errs = appendNegative(errs, "QUEUE_TIMEOUT", envs.QueueTimeout)
+	errs = appendNegative(errs, "WATCH_INTERVAL", envs.WatchInterval)
+	errs = appendNegative(errs, "MAX_WATCHERS", envs.MaxWatchers)
+	errs = envs.appendShareErrs(errs)
+
+	if envs.MaxUploadWriters != 0 {
+		log.Printf("warning: MAX_UPLOAD_WRITERS is deprecated and ignored, the files of an upload are written one by one")
+	}
+
+	return errors.Join(errs...)
+}
+
+// appendShareErrs appends the errors of the share links' options to errs.
+func (envs *environments) appendShareErrs(errs []error) (res []error) {
+	if n := len(envs.ShareSecret); n > 0 && n < minShareSecretLen {
+		errs = append(errs, fmt.Errorf("SHARE_SECRET: must be at least %d bytes long, got %d", minShareSecretLen, n))
+	}
+
+	if h := envs.ShareAdminPassword; h != "" {
+		if envs.ShareSecret == "" {
+			errs = append(errs, errors.New("SHARE_ADMIN_PASSWORD: requires SHARE_SECRET"))
+		} else if _, err := bcrypt.Cost([]byte(h)); err != nil {
+			errs = append(errs, fmt.Errorf("SHARE_ADMIN_PASSWORD: must be a bcrypt hash: %w", err))
+		}
+	}
+
+	return errs
+}
+
+// appendNegative appends an error to errs if the value v of option is
+// negative.
+func appendNegative[T int | float64 | time.Duration](errs []error, option string, v T) (res []error) {
+	if v < 0 {
+		return append(errs, fmt.Errorf("%s: must not be negative, got %v", option, v))
+	}
+
+	return errs
 }
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// unsetenv unsets the environment variable key for the duration of the test.
+func unsetenv(t *testing.T, key string) {
+	t.Helper()
+
+	// Setenv restores the previous value on cleanup.
+	t.Setenv(key, "")
+	err := os.Unsetenv(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseEnvs_precedence(t *testing.T) {
+	testCases := []struct {
+		name      string
+		file      string
+		env       string
+		overrides map[string]string
+		want      uint16
+	}{{
+		name:      "default",
+		file:      "",
+		env:       "",
+		overrides: nil,
+		want:      6060,
+	}, {
+		name:      "file",
+		file:      "port = 1\n",
+		env:       "",
+		overrides: nil,
+		want:      1,
+	}, {
+		name:      "env",
+		file:      "port = 1\n",
+		env:       "2",
+		overrides: nil,
+		want:      2,
+	}, {
+		name:      "flag",
+		file:      "port = 1\n",
+		env:       "2",
+		overrides: map[string]string{"PORT": "3"},
+		want:      3,
+	}, {
+		name:      "flag_over_file",
+		file:      "port = 1\n",
+		env:       "",
+		overrides: map[string]string{"PORT": "3"},
+		want:      3,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			unsetenv(t, "PORT")
+			if tc.env != "" {
+				t.Setenv("PORT", tc.env)
+			}
+
+			configFile := ""
+			if tc.file != "" {
+				configFile = writeConfig(t, "conf.toml", tc.file)
+			}
+
+			envs, settings, err := parseEnvs(configFile, tc.overrides)
+			if err != nil {
+				t.Fatal(err)
+			} else if envs.ListenPort != tc.want {
+				t.Fatalf("got port %d, want %d", envs.ListenPort, tc.want)
+			} else if got := settings["PORT"]; got != strconv.Itoa(int(tc.want)) {
+				t.Fatalf("got setting %q, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseEnvs_unknownKey(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+		wantKey string
+	}{{
+		name:    "top_level",
+		content: "no_such_option = 1\n",
+		wantKey: "NO_SUCH_OPTION",
+	}, {
+		name:    "nested",
+		content: "[access_log]\ncolour = true\n",
+		wantKey: "ACCESS_LOG_COLOUR",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := parseEnvs(writeConfig(t, "conf.toml", tc.content), nil)
+			if err == nil {
+				t.Fatal("no error for unknown key")
+			} else if !strings.Contains(err.Error(), "unknown option "+tc.wantKey) {
+				t.Fatalf("got %v, want unknown option %s", err, tc.wantKey)
+			}
+		})
+	}
+}
+
+func TestParseEnvs_mapOption(t *testing.T) {
+	unsetenv(t, "SPA_FALLBACK")
+
+	configFile := writeConfig(t, "conf.toml", `[spa_fallback]
+"/app" = "/app/index.html"
+"/docs" = "/docs/404.html"
+`)
+
+	envs, _, err := parseEnvs(configFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"/app": "/app/index.html", "/docs": "/docs/404.html"}
+	if !maps.Equal(envs.SPAFallbacks, want) {
+		t.Fatalf("got %v, want %v", envs.SPAFallbacks, want)
+	}
+}
+
+func TestParseEnvs_shareTrustedNets(t *testing.T) {
+	unsetenv(t, "SHARE_TRUSTED_NETS")
+
+	envs, _, err := parseEnvs("", nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(envs.ShareTrustedNets) != 0 {
+		t.Fatalf("got trusted nets %v by default, want none", envs.ShareTrustedNets)
+	}
+
+	t.Setenv("SHARE_TRUSTED_NETS", "127.0.0.0/8,::1/128")
+	envs, _, err = parseEnvs("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+	if !slices.Equal(envs.ShareTrustedNets, want) {
+		t.Fatalf("got trusted nets %v, want %v", envs.ShareTrustedNets, want)
+	}
+}
@@ -11,9 +11,9 @@ import (
 )
 
 // printListenAddrs prints the addresses the server is listening on appending
-// the specified port to each one.  It also prints a QR code for the first
-// found hostname.
-func printListenAddrs(port string) (err error) {
+// the specified port and base path to each one.  It also prints a QR code for
+// the first found hostname.
+func printListenAddrs(port, base string) (err error) {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
 		return fmt.Errorf("getting interface addresses: %w", err)
@@ -35,6 +35,7 @@ func printListenAddrs(port string) (err error) {
 				printQR((&url.URL{
 					Scheme: "http",
 					Host:   net.JoinHostPort(n.IP.String(), port),
+					Path:   base,
 				}).String())
 
 				qrPrinted = true
@@ -43,6 +44,7 @@ func printListenAddrs(port string) (err error) {
 			fmt.Printf("\t%s\n", &url.URL{
 				Scheme: "http",
 				Host:   net.JoinHostPort(n.IP.String(), port),
+				Path:   base,
 			})
 		}
 	}
@@ -59,6 +61,7 @@ func printListenAddrs(port string) (err error) {
 		printQR((&url.URL{
 			Scheme: "http",
 			Host:   net.JoinHostPort(hn, port),
+			Path:   base,
 		}).String())
 	}
 
@@ -1,20 +1,23 @@
 package cmd
 
 import (
-	"log"
-	"net/http"
+	"fmt"
+	"io"
+	"os"
 
 	"filesrv/internal/fhttp"
+	"filesrv/internal/logfile"
 )
 
-// type check
-var _ fhttp.Middleware = withLog
+// newAccessLog creates the access log as configured by envs.
+func newAccessLog(envs *environments) (l *fhttp.AccessLog, err error) {
+	var w io.Writer = os.Stdout
+	if name := envs.AccessLogFile; name != "" {
+		w, err = logfile.Open(name, int64(envs.AccessLogMaxSize.Bytes()), envs.AccessLogMaxBackups)
+		if err != nil {
+			return nil, fmt.Errorf("access log: %w", err)
+		}
+	}
 
-// withLog logs the request path and remote address.
-func withLog(h http.Handler) (wrapped http.Handler) {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("\t%s\tfrom\t%s", r.URL.Path, r.RemoteAddr)
-		h.ServeHTTP(w, r)
-		log.Printf("\t%s\tfrom\t%s: finished", r.URL.Path, r.RemoteAddr)
-	})
+	return fhttp.NewAccessLog(w, envs.AccessLogFormat), nil
 }
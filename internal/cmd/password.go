@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
+)
+
+// runHashPassword is the "hash-password" command.
+func runHashPassword(args []string) (code int) {
+	fs := newFlagSet("hash-password")
+	cost := fs.Int("cost", bcrypt.DefaultCost, fmt.Sprintf(
+		"bcrypt `cost`, from %d to %d",
+		bcrypt.MinCost,
+		bcrypt.MaxCost,
+	))
+	positional, code, ok := parseFlags(fs, args)
+	if !ok {
+		return code
+	} else if len(positional) > 0 {
+		return usageError(fs, "unexpected arguments: %q", positional)
+	} else if *cost < bcrypt.MinCost || *cost > bcrypt.MaxCost {
+		return usageError(fs, "invalid cost %d", *cost)
+	}
+
+	passwd, err := readPassword()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+
+		return exitFailure
+	}
+
+	hash, err := bcrypt.GenerateFromPassword(passwd, *cost)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: hashing password: %s\n", err)
+
+		return exitFailure
+	}
+
+	fmt.Printf("%s\n", hash)
+
+	return exitOK
+}
+
+// readPassword reads the password from stdin.  If stdin is a terminal, it
+// prompts for the password twice without echoing it.  Otherwise, it reads the
+// first line.
+func readPassword() (passwd []byte, err error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return readPasswordLine(os.Stdin)
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	passwd, err = term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("reading password: %w", err)
+	} else if len(passwd) == 0 {
+		return nil, fmt.Errorf("empty password")
+	}
+
+	fmt.Fprint(os.Stderr, "Repeat password: ")
+	repeated, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("reading password: %w", err)
+	} else if !bytes.Equal(passwd, repeated) {
+		return nil, fmt.Errorf("passwords don't match")
+	}
+
+	return passwd, nil
+}
+
+// readPasswordLine reads the password from the first line of r, which may be
+// the last one as well.
+func readPasswordLine(r io.Reader) (passwd []byte, err error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("reading password: %w", err)
+	}
+
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty password")
+	}
+
+	return []byte(line), nil
+}
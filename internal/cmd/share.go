@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"filesrv/internal/dirs"
+	"filesrv/internal/fhttp"
+	"filesrv/internal/safefs"
+	"filesrv/internal/share"
+)
+
+// shareAdminPath is the URL path of the endpoint managing the shares.
+const shareAdminPath = "/" + dirs.MetaDir + "/shares"
+
+// newShareStore returns the store of the shares of the served directory.
+func newShareStore(envs *environments) (s *share.Store) {
+	return share.NewStore(filepath.Join(envs.Root, dirs.MetaDir, "shares"))
+}
+
+// newShareGuard returns the guard of the files within fsys configured by envs.
+func newShareGuard(envs *environments, fsys http.FileSystem, theme dirs.Theme) (g *share.Guard) {
+	return share.NewGuard(&share.Config{
+		FS:      fsys,
+		Store:   newShareStore(envs),
+		OnError: theme.RenderError,
+		DropBox: dirs.WithDropBox,
+		Public: func(r *http.Request) (ok bool) {
+			return (r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+				isStaticFile(fsys, theme, path.Clean("/"+r.URL.Path))
+		},
+		Secret:            []byte(envs.ShareSecret),
+		Trusted:           envs.ShareTrustedNets,
+		AdminPath:         shareAdminPath,
+		AdminUser:         envs.ShareAdminUser,
+		AdminPasswordHash: []byte(envs.ShareAdminPassword),
+	})
+}
+
+// isStaticFile returns true if the cleaned URL path p refers to the theme's
+// static file not shadowed by the one within fsys.
+func isStaticFile(fsys, theme http.FileSystem, p string) (ok bool) {
+	if f, err := fsys.Open(p); err == nil {
+		_ = f.Close()
+
+		return false
+	}
+
+	f, err := theme.Open(p)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = f.Close() }()
+
+	fi, err := f.Stat()
+
+	return err == nil && !fi.IsDir()
+}
+
+// runShare is the "share" command.
+func runShare(args []string) (code int) {
+	fs := newFlagSet("share")
+	cf := newConfigFlags(fs)
+	root := fs.String("root", "", "served `directory`, ROOT by default")
+	ttl := fs.Duration("ttl", share.DefaultTTL, "`duration` the link is valid for")
+	ops := fs.String("ops", share.DefaultOps.String(), "comma-separated `operations` allowed: download, list, upload")
+	maxDownloads := fs.Int("max-downloads", 0, "`number` of downloads allowed, unlimited if zero")
+	baseURL := fs.String("base-url", "", "`URL` of the server for the link, http://localhost:PORT/BASE_PATH by default")
+	list := fs.Bool("list", false, "list the shares")
+	revoke := fs.String("revoke", "", "revoke the share with `id`")
+	positional, code, ok := parseFlags(fs, args)
+	if !ok {
+		return code
+	}
+
+	switch {
+	case *list, *revoke != "":
+		if len(positional) > 0 {
+			return usageError(fs, "unexpected arguments: %q", positional)
+		}
+	case len(positional) != 1:
+		return usageError(fs, "expected a single path, got %q", positional)
+	}
+
+	envs, _, err := parseEnvs(cf.configFile, cf.overrides(*root))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+
+		return exitConfig
+	} else if envs.ShareSecret == "" {
+		fmt.Fprintln(os.Stderr, "error: SHARE_SECRET is not set")
+
+		return exitConfig
+	}
+
+	store := newShareStore(envs)
+	switch {
+	case *list:
+		err = printShares(store)
+	case *revoke != "":
+		err = store.Revoke(*revoke)
+	default:
+		err = createShare(envs, store, positional[0], *ops, *ttl, *maxDownloads, *baseURL)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+
+		return exitFailure
+	}
+
+	return exitOK
+}
+
+// createShare creates the share of the file at the URL path p and prints its
+// link relative to baseURL.
+func createShare(
+	envs *environments,
+	store *share.Store,
+	p string,
+	opsStr string,
+	ttl time.Duration,
+	maxDownloads int,
+	baseURL string,
+) (err error) {
+	ops, err := share.ParseOp(opsStr)
+	if err != nil {
+		return fmt.Errorf("ops: %w", err)
+	}
+
+	if baseURL == "" {
+		host := envs.ListenHost
+		if host == "" {
+			host = "localhost"
+		}
+
+		baseURL = "http://" + net.JoinHostPort(host, strconv.Itoa(int(envs.ListenPort))) +
+			fhttp.CleanBasePath(envs.BasePath)
+	}
+
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return fmt.Errorf("base url: %w", err)
+	}
+
+	fsys, err := safefs.New(envs.Root, envs.Symlinks)
+	if err != nil {
+		return err
+	}
+
+	sh, err := share.New(fsys, p, ops, ttl, maxDownloads)
+	if err != nil {
+		return err
+	}
+
+	c, err := share.Publish(store, []byte(envs.ShareSecret), sh, base)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(c.URL)
+
+	return nil
+}
+
+// printShares prints the shares kept in store as a table.
+func printShares(store *share.Store) (err error) {
+	recs, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tPATH\tOPS\tEXPIRES\tDOWNLOADS\tSTATE")
+	now := time.Now()
+	for _, rec := range recs {
+		state := "active"
+		if rec.Revoked {
+			state = "revoked"
+		} else if !now.Before(rec.Expires) {
+			state = "expired"
+		} else if rec.MaxDownloads > 0 && rec.Downloads >= rec.MaxDownloads {
+			state = "exhausted"
+		}
+
+		downloads := strconv.Itoa(rec.Downloads)
+		if rec.MaxDownloads > 0 {
+			downloads += "/" + strconv.Itoa(rec.MaxDownloads)
+		}
+
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%s\t%s\t%s\n",
+			rec.ID,
+			rec.Path,
+			rec.Ops,
+			rec.Expires.Format(time.RFC3339),
+			downloads,
+			state,
+		)
+	}
+
+	return tw.Flush()
+}
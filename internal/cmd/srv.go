@@ -2,6 +2,8 @@ package cmd
 
 import (
 	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
@@ -11,20 +13,178 @@ import (
 	"filesrv/internal/dirs"
 	"filesrv/internal/dirs/themes"
 	"filesrv/internal/fhttp"
+	"filesrv/internal/safefs"
+	"filesrv/internal/version"
+
+	"golang.org/x/exp/maps"
 )
 
-// dieOnErr logs the error and exits if it is not nil.
-func dieOnErr(err error) {
+// configFlags are the command-line flags overriding the configuration.
+type configFlags struct {
+	fs *flag.FlagSet
+
+	// vars are the environment variables set by the flags.  The flags write
+	// those as parsed, so the last of the flags setting the same variable wins.
+	vars map[string]string
+
+	// configFile is the path to the configuration file.
+	configFile string
+}
+
+// newConfigFlags registers the configuration flags within fs.
+func newConfigFlags(fs *flag.FlagSet) (cf *configFlags) {
+	cf = &configFlags{
+		fs:   fs,
+		vars: map[string]string{},
+	}
+
+	fs.StringVar(&cf.configFile, "config", "", "path to the TOML or YAML configuration `file`")
+	fs.Var(cf.envFlag("PORT", false, false), "p", "shorthand for -`port`")
+	fs.Var(cf.envFlag("PORT", false, false), "port", "`port` to listen on")
+	fs.Var(cf.envFlag("HOST", false, false), "host", "`host` to listen on")
+	fs.Var(cf.envFlag("THEME_PATH", false, false), "theme", "path to the theme `directory`")
+	fs.Var(cf.envFlag("UPLOAD", true, false), "upload", "allow uploads")
+	fs.Var(cf.envFlag("UPLOAD", true, true), "no-upload", "forbid uploads")
+
+	return cf
+}
+
+// envFlag returns the flag setting the environment variable env within
+// cf.vars.  If isBool is true, the flag is boolean, and negate inverts its
+// value.
+func (cf *configFlags) envFlag(env string, isBool, negate bool) (f *envFlag) {
+	return &envFlag{
+		vars:   cf.vars,
+		env:    env,
+		isBool: isBool,
+		negate: negate,
+	}
+}
+
+// envFlag is a [flag.Value] setting an environment variable.
+type envFlag struct {
+	// vars are the variables to set.
+	vars map[string]string
+
+	// env is the name of the variable.
+	env string
+
+	// isBool is true if the flag is boolean.
+	isBool bool
+
+	// negate is true if the boolean flag sets the inverted value.
+	negate bool
+}
+
+// type check
+var _ flag.Value = (*envFlag)(nil)
+
+// String implements the [flag.Value] interface for *envFlag.
+func (f *envFlag) String() (s string) {
+	if f == nil {
+		return ""
+	}
+
+	return f.vars[f.env]
+}
+
+// Set implements the [flag.Value] interface for *envFlag.
+func (f *envFlag) Set(s string) (err error) {
+	if !f.isBool {
+		f.vars[f.env] = s
+
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+
+	f.vars[f.env] = strconv.FormatBool(b != f.negate)
+
+	return nil
+}
+
+// IsBoolFlag makes the boolean flags not require a value.
+func (f *envFlag) IsBoolFlag() (ok bool) {
+	return f.isBool
+}
+
+// overrides returns the configuration values set by the flags as the
+// environment variables.  dir is the directory to serve, if specified.
+func (cf *configFlags) overrides(dir string) (vars map[string]string) {
+	vars = maps.Clone(cf.vars)
+	if dir != "" {
+		vars["ROOT"] = dir
+	}
+
+	return vars
+}
+
+// runCheckConfig is the "check-config" command.
+func runCheckConfig(args []string) (code int) {
+	fs := newFlagSet("check-config")
+	cf := newConfigFlags(fs)
+	positional, code, ok := parseFlags(fs, args)
+	if !ok {
+		return code
+	} else if len(positional) > 0 {
+		return usageError(fs, "unexpected arguments: %q", positional)
+	}
+
+	_, settings, err := parseEnvs(cf.configFile, cf.overrides(""))
 	if err != nil {
-		log.Fatalf("error: %s", err)
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+
+		return exitConfig
 	}
+
+	printSettings(settings)
+	fmt.Println("configuration is valid")
+
+	return exitOK
 }
 
-func Serve() {
-	// Parse.
-	envs, err := parseEnvs()
-	dieOnErr(err)
+// runServe is the "serve" command.
+func runServe(args []string) (code int) {
+	fs := newFlagSet("serve")
+	cf := newConfigFlags(fs)
+	positional, code, ok := parseFlags(fs, args)
+	if !ok {
+		return code
+	} else if len(positional) > 1 {
+		return usageError(fs, "too many directories: %q", positional)
+	}
+
+	dir := ""
+	if len(positional) == 1 {
+		dir = positional[0]
+	}
+
+	envs, settings, err := parseEnvs(cf.configFile, cf.overrides(dir))
+	if err != nil {
+		log.Printf("error: %s", err)
+
+		return exitConfig
+	}
+
+	log.Printf("starting %s", version.Full())
+	printSettings(settings)
+
+	err = serve(envs)
+	if err != nil {
+		log.Printf("error: %s", err)
+
+		return exitFailure
+	}
+
+	return exitOK
+}
 
+// serve serves the files as configured by envs.  It returns when the server
+// fails.
+func serve(envs *environments) (err error) {
 	// Load.
 	var theme dirs.Theme
 	if p := envs.ThemePath; p != "" {
@@ -35,27 +195,128 @@ func Serve() {
 	log.Printf("using theme: %s", theme)
 
 	// Configure.
-	fsys := http.Dir(".")
+	fsys, err := safefs.New(envs.Root, envs.Symlinks)
+	if err != nil {
+		return fmt.Errorf("creating file system: %w", err)
+	}
+
 	h, err := dirs.NewHTTPFSDirs(&dirs.HTTPFSConfig{
-		FS:    fsys,
-		Theme: theme,
+		FS:             fsys,
+		Theme:          theme,
+		Root:           envs.Root,
+		AllowUpload:    envs.Upload,
+		MaxUploadSize:  int64(envs.MaxUploadSize.Bytes()),
+		MaxRequestSize: int64(envs.MaxRequestSize.Bytes()),
+		DirQuota:       int64(envs.DirQuota.Bytes()),
+		DirMaxFiles:    envs.DirMaxFiles,
+		MinFreeSpace:   int64(envs.MinFreeSpace.Bytes()),
+		UploadPolicy: &dirs.UploadPolicy{
+			AllowExts:     envs.UploadAllowExts,
+			DenyExts:      envs.UploadDenyExts,
+			MaxNameLength: envs.UploadMaxNameLength,
+		},
+		MaxUploads:         envs.MaxUploads,
+		UploadQueueTimeout: envs.QueueTimeout,
+		Precompressed:      envs.Precompressed,
+		Checksums:          envs.Checksums,
+		HashCacheSize:      envs.HashCacheSize,
+		Dedupe:             envs.Dedupe,
+		DedupeGCInterval:   envs.DedupeGCInterval,
+		Versioning:         envs.Versioning,
+		MaxVersions:        envs.MaxVersions,
+		MaxVersionAge:      envs.MaxVersionAge,
+		DropBoxes:          envs.DropBoxDirs,
+		WatchInterval:      envs.WatchInterval,
+		MaxWatchers:        envs.MaxWatchers,
+		Symlinks:           envs.Symlinks,
+		DirConfigs:         envs.DirConfig,
+		IndexFiles:         envs.IndexFiles,
+		IndexRedirect:      envs.IndexRedirect,
+		SPAFallbacks:       envs.SPAFallbacks,
+		StaticSite:         envs.StaticSite,
+		Hide: &dirs.HideRules{
+			Patterns:    envs.HidePatterns,
+			IgnoreFiles: envs.HideIgnoreFiles,
+			Dotfiles:    envs.HideDotfiles,
+		},
 	})
-	dieOnErr(err)
+	if err != nil {
+		return fmt.Errorf("creating handler: %w", err)
+	}
 
 	// Wrap.
-	h = fhttp.Wrap(h, withLog)
+	mws := []fhttp.Middleware{}
+	if envs.Compress {
+		mws = append(mws, fhttp.Compress)
+	}
+
+	// Serve the metrics behind the share guard, so that only the trusted
+	// clients see those.
+	var metrics *fhttp.Metrics
+	if p := envs.MetricsPath; p != "" {
+		metrics = fhttp.NewMetrics()
+		mws = append(mws, fhttp.Intercept(p, metrics))
+	}
+
+	if envs.ShareSecret != "" {
+		mws = append(mws, newShareGuard(envs, fsys, theme).Middleware)
+	}
+
+	rlConf := &fhttp.RateLimitConfig{
+		OnLimit:      theme.RenderError,
+		ClientRate:   envs.RateLimitClient,
+		ClientBurst:  envs.RateLimitClientBurst,
+		GlobalRate:   envs.RateLimitGlobal,
+		GlobalBurst:  envs.RateLimitGlobalBurst,
+		DownloadRate: int64(envs.DownloadRate.Bytes()),
+		UploadRate:   int64(envs.UploadRate.Bytes()),
+	}
+	if rlConf.Enabled() {
+		mws = append(mws, fhttp.NewRateLimiter(rlConf).Middleware)
+	}
+
+	if metrics != nil {
+		mws = append(mws, metrics.Middleware)
+	}
+
+	accessLog, err := newAccessLog(envs)
+	if err != nil {
+		return err
+	}
+
+	if base := fhttp.CleanBasePath(envs.BasePath); base != "" {
+		mws = append(mws, fhttp.StripBasePath(base, theme.RenderError))
+	}
+
+	mws = append(mws, accessLog.Middleware)
+
+	if len(envs.TrustedProxies) > 0 {
+		mws = append(mws, fhttp.NewTrustedProxies(envs.TrustedProxies).Middleware)
+	}
+
+	h = fhttp.Wrap(h, mws...)
 
 	// Listen.
 	port := strconv.Itoa(int(envs.ListenPort))
 	ln, err := net.Listen("tcp", net.JoinHostPort(envs.ListenHost, port))
-	dieOnErr(err)
+	if err != nil {
+		return fmt.Errorf("listening: %w", err)
+	}
 
-	err = printListenAddrs(port)
-	dieOnErr(err)
+	if n := envs.MaxConnections; n > 0 {
+		ln = fhttp.LimitListener(ln, n, envs.QueueTimeout)
+	}
+
+	err = printListenAddrs(port, fhttp.CleanBasePath(envs.BasePath))
+	if err != nil {
+		return err
+	}
 
 	// Serve.
 	err = http.Serve(ln, h)
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Printf("serving terminated: %s", err)
+		return fmt.Errorf("serving terminated: %w", err)
 	}
+
+	return nil
 }
@@ -0,0 +1,381 @@
+package dirs
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"filesrv/internal/ferrors"
+	"filesrv/internal/fhttp"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// ErrUnknownHash is returned when the requested hash algorithm isn't
+	// supported.
+	ErrUnknownHash ferrors.Str = "unknown hash algorithm"
+
+	// ErrChecksumMismatch is returned when the uploaded file's digest doesn't
+	// match the one provided by the client.
+	ErrChecksumMismatch ferrors.Str = "checksum mismatch"
+
+	// ErrBadChecksum is returned when the client-provided digest can't be
+	// parsed.
+	ErrBadChecksum ferrors.Str = "malformed checksum"
+)
+
+// ukChecksums is the form field containing the expected checksums of the
+// uploaded files in the format of the sha256sum utility and its siblings.
+const ukChecksums urlKey = "checksums"
+
+// hashAlgos are the supported hash algorithms by their names used in the
+// query and as the extensions of the checksum files.
+var hashAlgos = map[string]func() hash.Hash{
+	"md5":     md5.New,
+	"sha1":    sha1.New,
+	"sha256":  sha256.New,
+	"blake2b": newBlake2b,
+}
+
+// newBlake2b returns the BLAKE2b-512 hash, the same as the b2sum utility uses.
+func newBlake2b() (h hash.Hash) {
+	// The error is only returned for the invalid key.
+	h, _ = blake2b.New512(nil)
+
+	return h
+}
+
+// digestAlgos maps the algorithm names of the Digest header, as registered by
+// RFC 3230, to the supported ones.
+var digestAlgos = map[string]string{
+	"md5":     "md5",
+	"sha":     "sha1",
+	"sha-256": "sha256",
+}
+
+// hashKey identifies the contents of a file for caching its digest.
+type hashKey struct {
+	id    fileID
+	algo  string
+	size  int64
+	mtime int64
+}
+
+// hashCache is a fixed-size LRU cache of the file digests.  A nil *hashCache
+// caches nothing.
+type hashCache struct {
+	mu      *sync.Mutex
+	entries map[hashKey]*list.Element
+	lru     *list.List
+	size    int
+}
+
+// hashEntry is a value of the hashCache's list.
+type hashEntry struct {
+	key hashKey
+	sum []byte
+}
+
+// newHashCache returns a new cache of size entries.  It returns nil if size
+// isn't positive.
+func newHashCache(size int) (c *hashCache) {
+	if size <= 0 {
+		return nil
+	}
+
+	return &hashCache{
+		mu:      &sync.Mutex{},
+		entries: make(map[hashKey]*list.Element, size),
+		lru:     list.New(),
+		size:    size,
+	}
+}
+
+// get returns the cached digest for k, if any.
+func (c *hashCache) get(k hashKey) (sum []byte, ok bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[k]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+
+	return e.Value.(*hashEntry).sum, true
+}
+
+// set caches the digest for k, evicting the least recently used one if the
+// cache is full.
+func (c *hashCache) set(k hashKey, sum []byte) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[k]; ok {
+		c.lru.MoveToFront(e)
+
+		return
+	}
+
+	c.entries[k] = c.lru.PushFront(&hashEntry{key: k, sum: sum})
+	if c.lru.Len() > c.size {
+		last := c.lru.Back()
+		c.lru.Remove(last)
+		delete(c.entries, last.Value.(*hashEntry).key)
+	}
+}
+
+// fileSum returns the digest of the file f located at name and described by
+// d, computed with algo.
+func (h *dirs) fileSum(name string, f io.ReadSeeker, d fs.FileInfo, algo string) (sum []byte, err error) {
+	newHash, ok := hashAlgos[algo]
+	if !ok {
+		return nil, ErrUnknownHash
+	}
+
+	k := hashKey{
+		id:    newFileID(name, d),
+		algo:  algo,
+		size:  d.Size(),
+		mtime: d.ModTime().UnixNano(),
+	}
+	if sum, ok = h.hashes.get(k); ok {
+		return sum, nil
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("seeking: %w", err)
+	}
+
+	hash := newHash()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return nil, fmt.Errorf("hashing: %w", err)
+	}
+
+	sum = hash.Sum(nil)
+	h.hashes.set(k, sum)
+
+	return sum, nil
+}
+
+// serveSum responds with the digest of the regular file f located at name in
+// the format of the sha256sum utility and its siblings.
+func (h *dirs) serveSum(
+	w http.ResponseWriter,
+	r *http.Request,
+	name string,
+	f io.ReadSeeker,
+	d fs.FileInfo,
+	algo string,
+) {
+	sum, err := h.fileSum(name, f, d, algo)
+	if errors.Is(err, ErrUnknownHash) {
+		h.theme.RenderError(w, r, &fhttp.StatusError{
+			Err:  fmt.Errorf("%w %q, supported are %s", err, algo, supportedAlgos()),
+			Code: http.StatusBadRequest,
+		})
+
+		return
+	} else if err != nil {
+		h.theme.RenderError(w, r, fmt.Errorf("computing %s of %q: %w", algo, name, err))
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Last-Modified", d.ModTime().UTC().Format(http.TimeFormat))
+	_, err = fmt.Fprintf(w, "%x  %s\n", sum, d.Name())
+	if err != nil {
+		log.Printf("writing %s of %q: %v", algo, name, err)
+	}
+}
+
+// supportedAlgos returns the comma-separated sorted names of the supported
+// hash algorithms.
+func supportedAlgos() (s string) {
+	algos := maps.Keys(hashAlgos)
+	slices.Sort(algos)
+
+	return strings.Join(algos, ", ")
+}
+
+// serveChecksumFile serves the virtual checksum file at name, e.g.
+// file.sha256 for file, if the hashed file exists.  It returns false if name
+// isn't a checksum file.
+func (h *dirs) serveChecksumFile(w http.ResponseWriter, r *http.Request, name string) (ok bool) {
+	ext := path.Ext(name)
+	algo := strings.TrimPrefix(ext, ".")
+	if _, ok = hashAlgos[algo]; !ok || len(name) == len(ext)+1 {
+		return false
+	}
+
+	target := strings.TrimSuffix(name, ext)
+	if h.isHidden(target) {
+		return false
+	}
+
+	f, err := h.fsys.Open(target)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = f.Close() }()
+
+	d, err := f.Stat()
+	if err != nil || !d.Mode().IsRegular() {
+		return false
+	}
+
+	fhttp.SetRoute(r, fhttp.RouteFile)
+	h.serveSum(w, r, name, f, d, algo)
+
+	return true
+}
+
+// expectedSums returns the digests the uploaded file must match, by the
+// algorithm.  Those are taken from the part's Content-MD5 and Digest headers
+// and the values of the checksums field of the form.
+func expectedSums(part *multipart.Part, checksums []string) (sums map[string][]byte, err error) {
+	sums = map[string][]byte{}
+
+	if v := part.Header.Get("Content-MD5"); v != "" {
+		sums["md5"], err = base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("Content-MD5: %w", ErrBadChecksum)
+		}
+	}
+
+	for _, v := range part.Header.Values("Digest") {
+		for _, d := range strings.Split(v, ",") {
+			name, val, _ := strings.Cut(strings.TrimSpace(d), "=")
+			algo, ok := digestAlgos[strings.ToLower(name)]
+			if !ok {
+				// Ignore the unsupported algorithms as RFC 3230 suggests.
+				continue
+			}
+
+			sums[algo], err = base64.StdEncoding.DecodeString(val)
+			if err != nil {
+				return nil, fmt.Errorf("Digest: %s: %w", name, ErrBadChecksum)
+			}
+		}
+	}
+
+	for _, v := range checksums {
+		err = parseChecksums(v, part.FileName(), sums)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ukChecksums, err)
+		}
+	}
+
+	return sums, nil
+}
+
+// sumLenAlgos are the hash algorithms by the length of the hex-encoded digest.
+var sumLenAlgos = map[int]string{
+	hex.EncodedLen(md5.Size):     "md5",
+	hex.EncodedLen(sha1.Size):    "sha1",
+	hex.EncodedLen(sha256.Size):  "sha256",
+	hex.EncodedLen(blake2b.Size): "blake2b",
+}
+
+// parseChecksums parses the lines of the sha256sum utility's output in s and
+// puts the digests of the file named name into sums.  The algorithm is
+// detected by the length of the digest.
+func parseChecksums(s, name string, sums map[string][]byte) (err error) {
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+
+		hexSum, file, ok := strings.Cut(line, " ")
+		if !ok {
+			return fmt.Errorf("line %q: %w", line, ErrBadChecksum)
+		}
+
+		// The asterisk marks the binary mode.
+		file = strings.TrimPrefix(strings.TrimLeft(file, " "), "*")
+		if path.Base(file) != name {
+			continue
+		}
+
+		algo, ok := sumLenAlgos[len(hexSum)]
+		if !ok {
+			return fmt.Errorf("line %q: %w", line, ErrBadChecksum)
+		}
+
+		sums[algo], err = hex.DecodeString(hexSum)
+		if err != nil {
+			return fmt.Errorf("line %q: %w", line, ErrBadChecksum)
+		}
+	}
+
+	return sc.Err()
+}
+
+// sumWriter computes several digests of the written data at once.
+type sumWriter struct {
+	hashes map[string]hash.Hash
+	io.Writer
+}
+
+// newSumWriter returns a writer computing the digests for the algorithms of
+// sums.
+func newSumWriter(sums map[string][]byte) (sw *sumWriter) {
+	sw = &sumWriter{hashes: make(map[string]hash.Hash, len(sums))}
+
+	writers := make([]io.Writer, 0, len(sums))
+	for algo := range sums {
+		hash := hashAlgos[algo]()
+		sw.hashes[algo] = hash
+		writers = append(writers, hash)
+	}
+	sw.Writer = io.MultiWriter(writers...)
+
+	return sw
+}
+
+// verify returns an error if any of the computed digests doesn't match the
+// expected one in sums.
+func (sw *sumWriter) verify(sums map[string][]byte) (err error) {
+	for algo, want := range sums {
+		if got := sw.hashes[algo].Sum(nil); !bytes.Equal(got, want) {
+			return &fhttp.StatusError{
+				Err:  fmt.Errorf("%w: %s is %x, expected %x", ErrChecksumMismatch, algo, got, want),
+				Code: http.StatusBadRequest,
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package dirs
+
+import (
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+
+	"filesrv/internal/fhttp"
+)
+
+// sidecarExts are the extensions of the precompressed sidecar files by content
+// coding.
+var sidecarExts = map[string]string{
+	fhttp.EncodingBrotli: ".br",
+	fhttp.EncodingZstd:   ".zst",
+	fhttp.EncodingGzip:   ".gz",
+}
+
+// openSidecar opens the precompressed version of the file at name, if there is
+// one in the encoding acceptable for r.  It sets the response headers
+// describing the encoded representation.  sf is nil if there is no such file.
+func (h *dirs) openSidecar(
+	w http.ResponseWriter,
+	r *http.Request,
+	name string,
+) (sf http.File, sd fs.FileInfo) {
+	var available []string
+	for _, enc := range fhttp.Encodings {
+		fi, err := stat(h.fsys, name+sidecarExts[enc])
+		if err == nil && fi.Mode().IsRegular() {
+			available = append(available, enc)
+		}
+	}
+
+	if len(available) == 0 {
+		return nil, nil
+	}
+
+	// The response now depends on the Accept-Encoding, even if it's the
+	// identity.
+	hdr := w.Header()
+	fhttp.AddVary(hdr, "Accept-Encoding")
+
+	enc := fhttp.NegotiateEncoding(r, available...)
+	if enc == "" {
+		return nil, nil
+	}
+
+	sf, err := h.fsys.Open(name + sidecarExts[enc])
+	if err != nil {
+		return nil, nil
+	}
+
+	sd, err = sf.Stat()
+	if err != nil {
+		_ = sf.Close()
+
+		return nil, nil
+	}
+
+	// Set the type of the original file, since [http.ServeContent] would
+	// detect the type of the compressed data otherwise.
+	ct := mime.TypeByExtension(path.Ext(name))
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	hdr.Set("Content-Type", ct)
+	hdr.Set("Content-Encoding", enc)
+
+	return sf, sd
+}
+
+// stat returns the info of the file at name within fsys.
+func stat(fsys http.FileSystem, name string) (fi fs.FileInfo, err error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	return f.Stat()
+}
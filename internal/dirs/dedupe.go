@@ -0,0 +1,309 @@
+package dirs
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"filesrv/internal/safefs"
+)
+
+// MetaDir is the name of the directory within the root containing the
+// server's own data.  It's never served nor listed.
+const MetaDir = ".filesrv"
+
+// dedupeStatsPath is the URL path of the deduplication statistics.
+const dedupeStatsPath = "/" + MetaDir + "/dedupe"
+
+// blobStore is the content-addressed storage of the uploaded files.  Each
+// blob is named after the SHA-256 digest of its content and the uploaded files
+// are hard links to the blobs, so that the blob is unreferenced when it has a
+// single link.
+type blobStore struct {
+	// mu prevents the collection of a blob being linked.
+	mu *sync.RWMutex
+
+	// gcMu protects lastGC and collecting.
+	gcMu       *sync.Mutex
+	lastGC     time.Time
+	collecting bool
+
+	dir        string
+	gcInterval time.Duration
+}
+
+// newBlobStore returns a new store keeping blobs in dir, which is created if
+// needed, and collecting the unreferenced ones once in gcInterval.
+func newBlobStore(dir string, gcInterval time.Duration) (s *blobStore, err error) {
+	if !linksSupported {
+		return nil, errors.New("deduplication is not supported on this platform")
+	}
+
+	err = os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("creating blob store: %w", err)
+	}
+
+	return &blobStore{
+		mu:         &sync.RWMutex{},
+		gcMu:       &sync.Mutex{},
+		dir:        dir,
+		gcInterval: gcInterval,
+	}, nil
+}
+
+// blobPath returns the path of the blob with the SHA-256 digest sum.
+func (s *blobStore) blobPath(sum []byte) (name string) {
+	hexSum := hex.EncodeToString(sum)
+
+	return filepath.Join(s.dir, hexSum[:2], hexSum)
+}
+
+// dedupe replaces the file name within dir having the SHA-256 digest sum with
+// the hard link to the blob of the same content, or stores the file as a new
+// blob.
+// The linked files share a single inode, so the server always replaces them
+// by renaming and never writes in place.  The modification time of the inode
+// is updated when linking, so that the uploaded file doesn't appear as old as
+// the first of its copies.  If linking fails, e.g. across the file systems or
+// when the links aren't permitted, the file is kept as is.
+func (s *blobStore) dedupe(dir *safefs.Dir, name string, sum []byte) (err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	blob := s.blobPath(sum)
+
+	// Link to a temporary name first, so that the file is replaced
+	// atomically.
+	tmpName := name + ".dedupe"
+	err = dir.LinkIn(blob, tmpName)
+	if err == nil {
+		err = dir.Rename(tmpName, name)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		err = os.Chtimes(blob, now, now)
+		if err != nil {
+			log.Printf("dirs: updating modification time of %q: %v", filepath.Join(dir.Name(), name), err)
+		}
+
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Printf("dirs: keeping %q not deduplicated: linking blob: %v", filepath.Join(dir.Name(), name), err)
+
+		return nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(blob), 0o700)
+	if err != nil {
+		return fmt.Errorf("creating blob directory: %w", err)
+	}
+
+	err = dir.LinkOut(name, blob)
+	if errors.Is(err, os.ErrExist) {
+		// Stored concurrently, keep the file as is.
+		return nil
+	} else if err != nil {
+		log.Printf("dirs: keeping %q not deduplicated: storing blob: %v", filepath.Join(dir.Name(), name), err)
+	}
+
+	return nil
+}
+
+// maybeGC starts collecting the unreferenced blobs in the background if the
+// previous collection was more than gcInterval ago.
+func (s *blobStore) maybeGC() {
+	s.gcMu.Lock()
+	defer s.gcMu.Unlock()
+
+	if s.collecting || time.Since(s.lastGC) < s.gcInterval {
+		return
+	}
+
+	s.collecting = true
+	go func() {
+		removed, freed, err := s.gc()
+		if err != nil {
+			log.Printf("dirs: collecting blobs: %v", err)
+		} else if removed > 0 {
+			log.Printf("dirs: collected %d blobs, %d bytes freed", removed, freed)
+		}
+
+		s.gcMu.Lock()
+		defer s.gcMu.Unlock()
+
+		s.collecting = false
+		s.lastGC = time.Now()
+	}()
+}
+
+// gc removes the blobs no longer referenced by any file.
+func (s *blobStore) gc() (removed int, freed int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.walk(func(path string, fi fs.FileInfo, links uint64) (err error) {
+		if links != 1 {
+			return nil
+		}
+
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+
+		removed++
+		freed += fi.Size()
+
+		return nil
+	})
+
+	return removed, freed, err
+}
+
+// walk calls fn for each blob with the number of its hard links.
+func (s *blobStore) walk(fn func(path string, fi fs.FileInfo, links uint64) (err error)) (err error) {
+	return filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if !d.Type().IsRegular() {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		links, _ := linkCount(fi)
+
+		return fn(path, fi, links)
+	})
+}
+
+// DedupeStats are the statistics of the deduplicating store.
+type DedupeStats struct {
+	// Blobs is the number of the stored blobs.
+	Blobs int `json:"blobs"`
+
+	// Files is the number of the files referencing the blobs.
+	Files uint64 `json:"files"`
+
+	// StoredBytes is the total size of the blobs.
+	StoredBytes int64 `json:"stored_bytes"`
+
+	// LogicalBytes is the total size of the files referencing the blobs.
+	LogicalBytes int64 `json:"logical_bytes"`
+
+	// SavedBytes is the difference between LogicalBytes and StoredBytes.
+	SavedBytes int64 `json:"saved_bytes"`
+
+	// Ratio is LogicalBytes divided by StoredBytes.
+	Ratio float64 `json:"ratio"`
+}
+
+// stats returns the statistics of the store.  Only the files served to the
+// clients are counted, so the unreferenced blobs and the ones referenced by
+// the retained versions and deleted files alone aren't.
+func (s *blobStore) stats() (st *DedupeStats, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	metaLinks, err := s.metaLinks()
+	if err != nil {
+		return nil, err
+	}
+
+	st = &DedupeStats{}
+	err = s.walk(func(path string, fi fs.FileInfo, links uint64) (err error) {
+		if links < 2 {
+			return nil
+		}
+
+		// Don't count the blob itself.
+		refs := links - 1
+		meta := metaLinks[newFileID(path, fi)]
+		if meta >= refs {
+			return nil
+		}
+
+		refs -= meta
+
+		st.Blobs++
+		st.Files += refs
+		st.StoredBytes += fi.Size()
+		st.LogicalBytes += fi.Size() * int64(refs)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	st.SavedBytes = st.LogicalBytes - st.StoredBytes
+	if st.StoredBytes > 0 {
+		st.Ratio = float64(st.LogicalBytes) / float64(st.StoredBytes)
+	}
+
+	return st, nil
+}
+
+// metaLinks returns the numbers of the hard links to the blobs from within
+// the metadata directory outside of the store, i.e. from the retained versions
+// and deleted files.  s.mu must be locked.
+func (s *blobStore) metaLinks() (links map[fileID]uint64, err error) {
+	links = map[fileID]uint64{}
+	err = filepath.WalkDir(filepath.Dir(s.dir), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if d.IsDir() && path == s.dir {
+			return fs.SkipDir
+		} else if !d.Type().IsRegular() {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if n, _ := linkCount(fi); n > 1 {
+			links[newFileID(path, fi)]++
+		}
+
+		return nil
+	})
+
+	return links, err
+}
+
+// serveDedupeStats responds with the JSON-encoded statistics of the store.
+func (h *dirs) serveDedupeStats(w http.ResponseWriter, r *http.Request) {
+	st, err := h.blobs.stats()
+	if err != nil {
+		h.theme.RenderError(w, r, fmt.Errorf("dedupe stats: %w", err))
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	err = json.NewEncoder(w).Encode(st)
+	if err != nil {
+		log.Printf("writing dedupe stats: %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package dirs
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"filesrv/internal/safefs"
+)
+
+// writeUploaded writes the file with content into dir and returns its path
+// and the SHA-256 digest.
+func writeUploaded(t *testing.T, dir, name, content string) (p string, sum []byte) {
+	t.Helper()
+
+	p = filepath.Join(dir, name)
+	err := os.WriteFile(p, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := sha256.Sum256([]byte(content))
+
+	return p, h[:]
+}
+
+// openDir opens the directory at the OS path p for changing its entries.
+func openDir(t *testing.T, p string) (d *safefs.Dir) {
+	t.Helper()
+
+	fsys, err := safefs.New(p, safefs.SymlinksDeny)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err = fsys.OpenDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.Close() })
+
+	return d
+}
+
+// sameFile returns true if the files at a and b are the same inode.
+func sameFile(t *testing.T, a, b string) (ok bool) {
+	t.Helper()
+
+	fa, err := os.Stat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fb, err := os.Stat(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return os.SameFile(fa, fb)
+}
+
+func TestBlobStore_dedupe(t *testing.T) {
+	if !linksSupported {
+		t.Skip("deduplication is not supported")
+	}
+
+	tmp := t.TempDir()
+	s, err := newBlobStore(filepath.Join(tmp, "blobs"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := openDir(t, tmp)
+	first, sum := writeUploaded(t, tmp, "first", "content")
+	err = s.dedupe(d, "first", sum)
+	if err != nil {
+		t.Fatal(err)
+	} else if !sameFile(t, first, s.blobPath(sum)) {
+		t.Fatal("first file isn't stored as blob")
+	}
+
+	second, _ := writeUploaded(t, tmp, "second", "content")
+	err = s.dedupe(d, "second", sum)
+	if err != nil {
+		t.Fatal(err)
+	} else if !sameFile(t, first, second) {
+		t.Fatal("second file isn't linked to blob")
+	}
+
+	other, otherSum := writeUploaded(t, tmp, "other", "other")
+	err = s.dedupe(d, "other", otherSum)
+	if err != nil {
+		t.Fatal(err)
+	} else if sameFile(t, first, other) {
+		t.Fatal("different content is linked")
+	}
+}
+
+func TestBlobStore_dedupe_linkFails(t *testing.T) {
+	if !linksSupported {
+		t.Skip("deduplication is not supported")
+	}
+
+	tmp := t.TempDir()
+	s, err := newBlobStore(filepath.Join(tmp, "blobs"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, sum := writeUploaded(t, tmp, "file", "content")
+
+	// A directory can't be hard-linked, so linking the blob fails with
+	// something other than a missing blob.
+	err = os.MkdirAll(s.blobPath(sum), 0o700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.dedupe(openDir(t, tmp), "file", sum)
+	if err != nil {
+		t.Fatalf("got %v, want the file kept", err)
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(data) != "content" {
+		t.Fatalf("got content %q", data)
+	} else if _, err = os.Lstat(name + ".dedupe"); !os.IsNotExist(err) {
+		t.Fatalf("temporary link is left: %v", err)
+	}
+}
+
+func TestBlobStore_dedupe_modTime(t *testing.T) {
+	if !linksSupported {
+		t.Skip("deduplication is not supported")
+	}
+
+	tmp := t.TempDir()
+	s, err := newBlobStore(filepath.Join(tmp, "blobs"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := openDir(t, tmp)
+	first, sum := writeUploaded(t, tmp, "first", "content")
+	err = s.dedupe(d, "first", sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-24 * time.Hour)
+	err = os.Chtimes(first, old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Second)
+	second, _ := writeUploaded(t, tmp, "second", "content")
+	err = s.dedupe(d, "second", sum)
+	if err != nil {
+		t.Fatal(err)
+	} else if !sameFile(t, first, second) {
+		t.Fatal("second file isn't linked to blob")
+	}
+
+	fi, err := os.Stat(second)
+	if err != nil {
+		t.Fatal(err)
+	} else if fi.ModTime().Before(start) {
+		t.Errorf("got modification time %s, want after %s", fi.ModTime(), start)
+	}
+}
+
+func TestBlobStore_stats(t *testing.T) {
+	if !linksSupported {
+		t.Skip("deduplication is not supported")
+	}
+
+	root := t.TempDir()
+	meta := filepath.Join(root, MetaDir)
+	s, err := newBlobStore(filepath.Join(meta, "blobs"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versions := filepath.Join(meta, "versions")
+	err = os.MkdirAll(versions, 0o700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := openDir(t, root)
+	for _, name := range []string{"a", "b"} {
+		_, sum := writeUploaded(t, root, name, "shared")
+		err = s.dedupe(d, name, sum)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	retained, retainedSum := writeUploaded(t, root, "retained", "retained")
+	err = s.dedupe(d, "retained", retainedSum)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Retain a version of a and move the only served copy of retained to the
+	// versions, as deleting does.
+	err = os.Link(filepath.Join(root, "a"), filepath.Join(versions, "a.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Rename(retained, filepath.Join(versions, "retained.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := s.stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := int64(len("shared"))
+	want := &DedupeStats{
+		Blobs:        1,
+		Files:        2,
+		StoredBytes:  size,
+		LogicalBytes: 2 * size,
+		SavedBytes:   size,
+		Ratio:        2,
+	}
+	if *st != *want {
+		t.Errorf("got %+v, want %+v", st, want)
+	}
+}
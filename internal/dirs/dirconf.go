@@ -0,0 +1,297 @@
+package dirs
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"filesrv/internal/ferrors"
+
+	"github.com/BurntSushi/toml"
+	"github.com/c2h5oh/datasize"
+	"golang.org/x/exp/slices"
+)
+
+// DirConfigFile is the name of the per-directory configuration file.  It
+// applies to the directory and all its subdirectories, unless overridden
+// deeper.  The file is never served nor listed.
+const DirConfigFile = ".filesrv.toml"
+
+// ukSortBy is the query parameter with the sorting of the listing, the same as
+// the themes use.
+const ukSortBy urlKey = "sortBy"
+
+// sortValues are the valid values of the sorting of the listing, the same as
+// the themes accept.
+var sortValues = []string{"name", "size", "size_desc", "time", "time_desc"}
+
+// maxDirConfigSize is the maximum size of a per-directory configuration file.
+const maxDirConfigSize = 64 << 10
+
+// ErrInvalidDirConfig is returned when a per-directory configuration file
+// contains an invalid value.
+const ErrInvalidDirConfig ferrors.Str = "invalid directory configuration"
+
+// dirConfig is the contents of a per-directory configuration file.  The
+// omitted options are inherited from the parent directory.
+type dirConfig struct {
+	// SortBy is the default sorting of the listing, like the sortBy query
+	// parameter.
+	SortBy *string `toml:"sort_by"`
+
+	// Upload allows uploading files.
+	Upload *bool `toml:"upload"`
+
+	// DropBox makes the directory a drop box, see [HTTPFSConfig.DropBoxes].
+	DropBox *bool `toml:"drop_box"`
+
+	// MaxUploadSize is the maximum size of an uploaded file.
+	MaxUploadSize *datasize.ByteSize `toml:"max_upload_size"`
+
+	// DirQuota is the maximum total size of the files within a directory
+	// and its subdirectories that uploads may reach.
+	DirQuota *datasize.ByteSize `toml:"dir_quota"`
+
+	// DirMaxFiles is the maximum number of files within a directory and its
+	// subdirectories that uploads may reach.
+	DirMaxFiles *int64 `toml:"dir_max_files"`
+
+	// IndexFiles are the names of the files served instead of the listing,
+	// in the order of preference.
+	IndexFiles *[]string `toml:"index_files"`
+
+	// StaticSite serves the directory as the root of a static site, see
+	// [HTTPFSConfig.StaticSite].
+	StaticSite *bool `toml:"static_site"`
+
+	// HideDotfiles overrides [HideRules.Dotfiles].
+	HideDotfiles *bool `toml:"hide_dotfiles"`
+
+	// HidePatterns overrides [HideRules.Patterns].
+	HidePatterns *[]string `toml:"hide_patterns"`
+
+	// HideIgnoreFiles overrides [HideRules.IgnoreFiles].
+	HideIgnoreFiles *[]string `toml:"hide_ignore_files"`
+}
+
+// validate returns an error if conf contains an invalid value.
+func (conf *dirConfig) validate() (err error) {
+	var names []string
+	if conf.IndexFiles != nil {
+		names = append(names, *conf.IndexFiles...)
+	}
+	if conf.HideIgnoreFiles != nil {
+		names = append(names, *conf.HideIgnoreFiles...)
+	}
+
+	for _, name := range names {
+		if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+			return fmt.Errorf("%w: %q is not a file name", ErrInvalidDirConfig, name)
+		}
+	}
+
+	if sortBy := conf.SortBy; sortBy != nil && !slices.Contains(sortValues, *sortBy) {
+		return fmt.Errorf("%w: sort_by must be one of %q, got %q", ErrInvalidDirConfig, sortValues, *sortBy)
+	}
+
+	if n := conf.DirMaxFiles; n != nil && *n < 0 {
+		return fmt.Errorf("%w: dir_max_files must not be negative, got %d", ErrInvalidDirConfig, *n)
+	}
+
+	return nil
+}
+
+// dirConfigFile is a parsed per-directory configuration file.
+type dirConfigFile struct {
+	modTime time.Time
+	conf    *dirConfig
+	size    int64
+}
+
+// dirConfigs loads the per-directory configuration files.
+type dirConfigs struct {
+	fsys http.FileSystem
+
+	// mu protects files.
+	mu *sync.Mutex
+
+	// files are the parsed configuration files by the cleaned URL paths of
+	// their directories.
+	files map[string]*dirConfigFile
+}
+
+// newDirConfigs returns a new loader of the configuration files within fsys.
+func newDirConfigs(fsys http.FileSystem) (dc *dirConfigs) {
+	return &dirConfigs{
+		fsys:  fsys,
+		mu:    &sync.Mutex{},
+		files: map[string]*dirConfigFile{},
+	}
+}
+
+// load returns the configuration of the directory at the cleaned URL path
+// dir, or nil if there is none.  The parsed files are cached until modified.
+// The invalid files are logged and ignored.  It's safe for use with a nil dc.
+func (dc *dirConfigs) load(dir string) (conf *dirConfig) {
+	if dc == nil {
+		return nil
+	}
+
+	p := path.Join(dir, DirConfigFile)
+	f, err := dc.fsys.Open(p)
+	if err != nil {
+		// Most of the directories have none.
+		return nil
+	}
+	defer func() { _ = f.Close() }()
+
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil
+	}
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	cf := dc.files[dir]
+	if cf != nil && cf.modTime.Equal(fi.ModTime()) && cf.size == fi.Size() {
+		return cf.conf
+	}
+
+	cf = &dirConfigFile{
+		modTime: fi.ModTime(),
+		size:    fi.Size(),
+	}
+
+	cf.conf, err = parseDirConfig(f)
+	if err != nil {
+		// Cache the failure as well to only log it once per modification.
+		log.Printf("dirs: loading %q: %v", p, err)
+	}
+
+	dc.files[dir] = cf
+
+	return cf.conf
+}
+
+// parseDirConfig parses the configuration file from r.  Unknown options are
+// an error, so that the typos don't silently leave the defaults.
+func parseDirConfig(r io.Reader) (conf *dirConfig, err error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxDirConfigSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading: %w", err)
+	} else if len(data) > maxDirConfigSize {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrInvalidDirConfig, maxDirConfigSize)
+	}
+
+	conf = &dirConfig{}
+	md, err := toml.Decode(string(data), conf)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDirConfig, err)
+	} else if undec := md.Undecoded(); len(undec) > 0 {
+		return nil, fmt.Errorf("%w: unknown option %s", ErrInvalidDirConfig, undec[0])
+	}
+
+	err = conf.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// dirSettings are the effective settings of a directory, either the global
+// ones or the ones overridden by the configuration files.
+type dirSettings struct {
+	// sortBy is the default sorting of the listing.
+	sortBy string
+
+	// dropBox is the cleaned URL path of the drop box directory enabled by
+	// the configuration files, if any.
+	dropBox string
+
+	// siteRoot is the cleaned URL path of the static site's root, if any.
+	siteRoot string
+
+	// indexFiles are the names of the files served instead of the listing.
+	indexFiles []string
+
+	maxUploadSize int64
+	dirQuota      int64
+	dirMaxFiles   int64
+	allowUpload   bool
+}
+
+// apply overrides the settings with conf of the directory at the cleaned URL
+// path dir.
+func (s *dirSettings) apply(conf *dirConfig, dir string) {
+	if conf == nil {
+		return
+	}
+
+	if conf.SortBy != nil {
+		s.sortBy = *conf.SortBy
+	}
+
+	if conf.Upload != nil {
+		s.allowUpload = *conf.Upload
+	}
+
+	if conf.DropBox != nil {
+		s.dropBox = ""
+		if *conf.DropBox {
+			s.dropBox = dir
+		}
+	}
+
+	if conf.MaxUploadSize != nil {
+		s.maxUploadSize = int64(conf.MaxUploadSize.Bytes())
+	}
+
+	if conf.DirQuota != nil {
+		s.dirQuota = int64(conf.DirQuota.Bytes())
+	}
+
+	if conf.DirMaxFiles != nil {
+		s.dirMaxFiles = *conf.DirMaxFiles
+	}
+
+	if conf.IndexFiles != nil {
+		s.indexFiles = *conf.IndexFiles
+	}
+
+	if conf.StaticSite != nil {
+		s.siteRoot = ""
+		if *conf.StaticSite {
+			s.siteRoot = dir
+		}
+	}
+}
+
+// settings returns the effective settings of the cleaned URL path p, which is
+// either a directory or a file within one.
+func (h *dirs) settings(p string) (s *dirSettings) {
+	s = &dirSettings{}
+	*s = *h.defaults
+	if h.confs == nil {
+		return s
+	}
+
+	dir := "/"
+	s.apply(h.confs.load(dir), dir)
+	for _, elem := range strings.Split(strings.Trim(p, "/"), "/") {
+		if elem == "" {
+			continue
+		}
+
+		dir = path.Join(dir, elem)
+		s.apply(h.confs.load(dir), dir)
+	}
+
+	return s
+}
@@ -0,0 +1,192 @@
+package dirs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestParseDirConfig(t *testing.T) {
+	testCases := []struct {
+		wantErr error
+		name    string
+		in      string
+	}{{
+		wantErr: nil,
+		name:    "empty",
+		in:      "",
+	}, {
+		wantErr: nil,
+		name:    "valid",
+		in: "sort_by = \"time_desc\"\nupload = true\nmax_upload_size = \"1MB\"\n" +
+			"dir_max_files = 10\nindex_files = [\"index.htm\"]\n",
+	}, {
+		wantErr: ErrInvalidDirConfig,
+		name:    "unknown_option",
+		in:      "uplod = true\n",
+	}, {
+		wantErr: ErrInvalidDirConfig,
+		name:    "unknown_sort_by",
+		in:      "sort_by = \"date\"\n",
+	}, {
+		wantErr: ErrInvalidDirConfig,
+		name:    "empty_sort_by",
+		in:      "sort_by = \"\"\n",
+	}, {
+		wantErr: ErrInvalidDirConfig,
+		name:    "negative_max_files",
+		in:      "dir_max_files = -1\n",
+	}, {
+		wantErr: ErrInvalidDirConfig,
+		name:    "index_path",
+		in:      "index_files = [\"../index.html\"]\n",
+	}, {
+		wantErr: ErrInvalidDirConfig,
+		name:    "ignore_file_dot",
+		in:      "hide_ignore_files = [\"..\"]\n",
+	}, {
+		wantErr: ErrInvalidDirConfig,
+		name:    "syntax",
+		in:      "upload = \n",
+	}, {
+		wantErr: ErrInvalidDirConfig,
+		name:    "too_large",
+		in:      "# " + strings.Repeat("x", maxDirConfigSize),
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf, err := parseDirConfig(strings.NewReader(tc.in))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			} else if err == nil && conf == nil {
+				t.Fatal("no configuration")
+			}
+		})
+	}
+}
+
+func TestDirs_settings(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		DirConfigFile:              "sort_by = \"size\"\ndir_max_files = 10\n",
+		"box/" + DirConfigFile:     "drop_box = true\nupload = true\n",
+		"box/sub/" + DirConfigFile: "sort_by = \"time\"\n",
+		"site/" + DirConfigFile:    "static_site = true\nindex_files = []\n",
+		"bad/" + DirConfigFile:     "sort_by = \"date\"\nupload = true\n",
+		"site/page.html":           "",
+	})
+
+	h := newTestDirs(t, &HTTPFSConfig{
+		Root:        root,
+		DirConfigs:  true,
+		AllowUpload: false,
+	})
+
+	testCases := []struct {
+		name         string
+		path         string
+		wantSortBy   string
+		wantDropBox  string
+		wantSiteRoot string
+		wantIndex    []string
+		wantUpload   bool
+	}{{
+		name:       "root",
+		path:       "/",
+		wantSortBy: "size",
+		wantIndex:  []string{indexFile},
+		wantUpload: false,
+	}, {
+		name:        "drop_box",
+		path:        "/box",
+		wantSortBy:  "size",
+		wantDropBox: "/box",
+		wantIndex:   []string{indexFile},
+		wantUpload:  true,
+	}, {
+		name:        "drop_box_sub",
+		path:        "/box/sub",
+		wantSortBy:  "time",
+		wantDropBox: "/box",
+		wantIndex:   []string{indexFile},
+		wantUpload:  true,
+	}, {
+		name:         "file",
+		path:         "/site/page.html",
+		wantSortBy:   "size",
+		wantSiteRoot: "/site",
+		wantIndex:    []string{},
+		wantUpload:   false,
+	}, {
+		name:       "invalid",
+		path:       "/bad",
+		wantSortBy: "size",
+		wantIndex:  []string{indexFile},
+		wantUpload: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := h.settings(tc.path)
+			if s.sortBy != tc.wantSortBy {
+				t.Errorf("got sorting %q, want %q", s.sortBy, tc.wantSortBy)
+			}
+
+			if s.dropBox != tc.wantDropBox {
+				t.Errorf("got drop box %q, want %q", s.dropBox, tc.wantDropBox)
+			}
+
+			if s.siteRoot != tc.wantSiteRoot {
+				t.Errorf("got site root %q, want %q", s.siteRoot, tc.wantSiteRoot)
+			}
+
+			if !slices.Equal(s.indexFiles, tc.wantIndex) {
+				t.Errorf("got index files %q, want %q", s.indexFiles, tc.wantIndex)
+			}
+
+			if s.allowUpload != tc.wantUpload {
+				t.Errorf("got upload %t, want %t", s.allowUpload, tc.wantUpload)
+			}
+
+			// Inherited from the root.
+			if s.dirMaxFiles != 10 {
+				t.Errorf("got max files %d, want 10", s.dirMaxFiles)
+			}
+		})
+	}
+}
+
+func TestDirConfigs_load_modified(t *testing.T) {
+	root := t.TempDir()
+	name := filepath.Join(root, DirConfigFile)
+	writeTree(t, root, map[string]string{DirConfigFile: "upload = true\n"})
+
+	h := newTestDirs(t, &HTTPFSConfig{Root: root, DirConfigs: true})
+	conf := h.confs.load("/")
+	if conf == nil || conf.Upload == nil || !*conf.Upload {
+		t.Fatalf("got %+v, want upload", conf)
+	}
+
+	err := os.WriteFile(name, []byte("upload = false\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Make sure the modification is noticed on the coarse clocks.
+	mtime := time.Now().Add(time.Minute)
+	err = os.Chtimes(name, mtime, mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf = h.confs.load("/")
+	if conf == nil || conf.Upload == nil || *conf.Upload {
+		t.Fatalf("got %+v, want no upload", conf)
+	}
+}
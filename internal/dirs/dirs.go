@@ -4,12 +4,24 @@ import (
 	"fmt"
 	"io/fs"
 	"net/http"
+	"path/filepath"
+	"time"
+
+	"filesrv/internal/safefs"
 )
 
 // Theme is the interface for the directory listing appearance.
 type Theme interface {
-	// Render renders the HTML page using entries and info from the request.
-	Render(w http.ResponseWriter, r *http.Request, entries []fs.FileInfo)
+	// Render renders the HTML page using the listing and info from the
+	// request.
+	Render(w http.ResponseWriter, r *http.Request, l *Listing)
+
+	// RenderHistory renders the HTML page of the file's history.
+	RenderHistory(w http.ResponseWriter, r *http.Request, h *History)
+
+	// RenderDropBox renders the HTML upload page of a drop box directory,
+	// which contents are never listed.
+	RenderDropBox(w http.ResponseWriter, r *http.Request, d *DropBox)
 
 	// RenderNotFound renders the [http.StatusNotFound] page.  It should be
 	// ready to handle [ErrUnhandled].
@@ -24,11 +36,57 @@ type Theme interface {
 	fmt.Stringer
 }
 
+// Listing is the data for rendering a directory listing.
+type Listing struct {
+	// Entries are the entries of the directory.
+	Entries []fs.FileInfo
+
+	// Upload is true if uploading into the directory is allowed.
+	Upload bool
+
+	// Capacity is the remaining upload capacity of the directory.  It's nil
+	// if uploading isn't allowed.
+	Capacity *Capacity
+
+	// Deleted are the names of the deleted files of the directory having
+	// their history retained.
+	Deleted []string
+
+	// Versioning is true if the history of the files is retained.
+	Versioning bool
+
+	// Watch is true if the changes of the directory are streamed with the
+	// watch query parameter.
+	Watch bool
+
+	// SortBy is the default sorting of the entries, used unless the request
+	// has the sortBy query parameter.
+	SortBy string
+}
+
 // dirs is an [http.Handler] that handles directory listings and file uploads.
 type dirs struct {
-	fsys          http.FileSystem
-	theme         Theme
-	maxUploadSize int64
+	fsys           http.FileSystem
+	theme          Theme
+	policy         *uploadPolicy
+	defaults       *dirSettings
+	confs          *dirConfigs
+	spa            []*spaFallback
+	site           *siteFiles
+	indexRedirect  bool
+	uploads        *semaphore
+	maxRequestSize int64
+	minFreeSpace   int64
+	precompressed  bool
+	checksums      bool
+	hashes         *hashCache
+	blobs          *blobStore
+	versions       *versionStore
+	dropBoxes      []string
+	watches        *watchHub
+	progress       *progressTracker
+	safe           *safefs.FS
+	hide           *hider
 }
 
 // HTTPFSConfig is the configuration for creating file listings handler.
@@ -39,17 +97,202 @@ type HTTPFSConfig struct {
 	// Theme is the theme used to render the directory listings.
 	Theme Theme
 
+	// Root is the path to the served directory in the operating system's
+	// file system.  Uploaded files are written under it.
+	Root string
+
+	// AllowUpload allows uploading files.
+	AllowUpload bool
+
 	// MaxUploadSize is the maximum size of a file that can be uploaded in
-	// bytes.
+	// bytes.  Zero means no limit.
 	MaxUploadSize int64
+
+	// MaxRequestSize is the maximum size of an upload request body in bytes.
+	// Zero means no limit.
+	MaxRequestSize int64
+
+	// DirQuota is the maximum total size of the files within a directory and
+	// its subdirectories that uploads may reach, in bytes.  Zero means no
+	// limit.
+	DirQuota int64
+
+	// DirMaxFiles is the maximum number of files within a directory and its
+	// subdirectories that uploads may reach.  Zero means no limit.
+	DirMaxFiles int
+
+	// MinFreeSpace is the free disk space in bytes that uploads must leave.
+	MinFreeSpace int64
+
+	// Checksums enables serving the digests of files requested with the hash
+	// query parameter and as the virtual checksum files, e.g. file.sha256.
+	Checksums bool
+
+	// HashCacheSize is the number of file digests to cache.  Zero disables
+	// caching.
+	HashCacheSize int
+
+	// Dedupe enables storing the uploaded files once per content within the
+	// metadata directory of Root.  The files are hard links to the stored
+	// content.
+	Dedupe bool
+
+	// DedupeGCInterval is the minimum interval between the collections of
+	// the stored content no longer referenced by any file.
+	DedupeGCInterval time.Duration
+
+	// Versioning enables retaining the overwritten and the deleted files
+	// within the metadata directory of Root.  It also enables overwriting
+	// and deleting files.
+	Versioning bool
+
+	// MaxVersions is the maximum number of versions retained for a file.
+	// Zero means no limit.
+	MaxVersions int
+
+	// MaxVersionAge is the maximum age of a retained version.  Zero means no
+	// limit.
+	MaxVersionAge time.Duration
+
+	// UploadPolicy restricts the names and the types of the uploaded files.
+	// If nil, any valid name and type are allowed.
+	UploadPolicy *UploadPolicy
+
+	// MaxUploads is the maximum number of uploads handled simultaneously.
+	// Zero means no limit.
+	MaxUploads int
+
+	// UploadQueueTimeout is the time an upload waits for the free slot when
+	// MaxUploads are already in progress.  Zero means the upload is rejected
+	// immediately.
+	UploadQueueTimeout time.Duration
+
+	// Precompressed enables serving the precompressed sidecar files, e.g.
+	// file.gz for file, to the clients accepting the encoding.
+	Precompressed bool
+
+	// DropBoxes are the URL paths of the drop box directories.  Those only
+	// accept uploads, never listing nor serving their contents.  The
+	// uploaded files are stored under unique names.
+	DropBoxes []string
+
+	// WatchInterval is the interval of polling the directories watched by the
+	// clients for changes.  Zero disables watching.
+	WatchInterval time.Duration
+
+	// MaxWatchers is the maximum number of clients watching a single
+	// directory.  Zero means no limit.
+	MaxWatchers int
+
+	// IndexFiles are the names of the files served instead of the directory
+	// listing, in the order of preference.  If nil, it's index.html.  Empty
+	// list always serves the listing.
+	IndexFiles []string
+
+	// IndexRedirect enables redirecting the requests of the index files to
+	// their directories, e.g. dir/index.html to dir/.
+	IndexRedirect bool
+
+	// SPAFallbacks maps the URL path prefixes to the URL paths of the files
+	// served instead of the missing files under those, e.g. for the
+	// single-page applications routing on the client side.  The longest
+	// matching prefix is used.
+	SPAFallbacks map[string]string
+
+	// StaticSite serves Root as a static site: the clean URLs like /about
+	// resolve to about.html, the missing files are served as 404.html, and
+	// the _headers and _redirects files in the Netlify format apply.
+	StaticSite bool
+
+	// DirConfigs enables the per-directory configuration files, see
+	// [DirConfigFile].
+	DirConfigs bool
+
+	// Hide are the rules of hiding the files and directories.  If nil,
+	// nothing is hidden except for the metadata directory.
+	Hide *HideRules
+
+	// Symlinks is the policy of following the symbolic links while writing
+	// the uploaded files.  It should be the same as the one of FS.  Empty
+	// policy means [safefs.SymlinksWithinRoot].
+	Symlinks safefs.SymlinkPolicy
 }
 
 // NewHTTPFSDirs creates a new [http.Handler] that handles directory listings
 // and file uploads.
 func NewHTTPFSDirs(conf *HTTPFSConfig) (d http.Handler, err error) {
+	safe, err := safefs.New(conf.Root, conf.Symlinks)
+	if err != nil {
+		return nil, err
+	}
+
+	var blobs *blobStore
+	if conf.Dedupe {
+		blobs, err = newBlobStore(filepath.Join(conf.Root, MetaDir, "blobs"), conf.DedupeGCInterval)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The paths are resolved by safe, which makes them absolute.
+	root, err := filepath.Abs(conf.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	var watches *watchHub
+	if conf.WatchInterval > 0 {
+		watches = newWatchHub(root, conf.WatchInterval, conf.MaxWatchers)
+	}
+
+	var versions *versionStore
+	if conf.Versioning {
+		versions = newVersionStore(root, conf.MaxVersions, conf.MaxVersionAge)
+	}
+
+	indexFiles := conf.IndexFiles
+	if indexFiles == nil {
+		indexFiles = []string{indexFile}
+	}
+
+	var siteRoot string
+	if conf.StaticSite {
+		siteRoot = "/"
+	}
+
+	var confs *dirConfigs
+	if conf.DirConfigs {
+		confs = newDirConfigs(conf.FS)
+	}
+
 	return &dirs{
-		fsys:          conf.FS,
-		theme:         conf.Theme,
-		maxUploadSize: conf.MaxUploadSize,
+		fsys:   conf.FS,
+		theme:  conf.Theme,
+		policy: newUploadPolicy(conf.UploadPolicy),
+		defaults: &dirSettings{
+			siteRoot:      siteRoot,
+			indexFiles:    indexFiles,
+			maxUploadSize: conf.MaxUploadSize,
+			dirQuota:      conf.DirQuota,
+			dirMaxFiles:   int64(conf.DirMaxFiles),
+			allowUpload:   conf.AllowUpload,
+		},
+		confs:          confs,
+		spa:            newSPAFallbacks(conf.SPAFallbacks),
+		site:           newSiteFiles(conf.FS),
+		indexRedirect:  conf.IndexRedirect,
+		uploads:        newSemaphore(conf.MaxUploads, conf.UploadQueueTimeout),
+		maxRequestSize: conf.MaxRequestSize,
+		minFreeSpace:   conf.MinFreeSpace,
+		precompressed:  conf.Precompressed,
+		checksums:      conf.Checksums,
+		hashes:         newHashCache(conf.HashCacheSize),
+		blobs:          blobs,
+		versions:       versions,
+		dropBoxes:      newDropBoxes(conf.DropBoxes),
+		watches:        watches,
+		progress:       newProgressTracker(),
+		safe:           safe,
+		hide:           newHider(conf.FS, conf.Hide, confs, siteRoot),
 	}, nil
 }
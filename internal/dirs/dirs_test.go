@@ -0,0 +1,140 @@
+package dirs
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"filesrv/internal/fhttp"
+	"filesrv/internal/safefs"
+)
+
+// testTheme is the [Theme] for tests.  It writes the names of the rendered
+// entries and the status codes of the errors like the default theme.
+type testTheme struct{}
+
+// type check
+var _ Theme = testTheme{}
+
+// Render implements the [Theme] interface for testTheme.
+func (testTheme) Render(w http.ResponseWriter, _ *http.Request, l *Listing) {
+	for _, e := range l.Entries {
+		_, _ = w.Write([]byte(e.Name() + "\n"))
+	}
+}
+
+// RenderHistory implements the [Theme] interface for testTheme.
+func (testTheme) RenderHistory(w http.ResponseWriter, _ *http.Request, h *History) {
+	for _, v := range h.Versions {
+		_, _ = w.Write([]byte(v.ID + "\n"))
+	}
+}
+
+// RenderDropBox implements the [Theme] interface for testTheme.
+func (testTheme) RenderDropBox(w http.ResponseWriter, _ *http.Request, _ *DropBox) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// RenderError implements the [Theme] interface for testTheme.
+func (testTheme) RenderError(w http.ResponseWriter, _ *http.Request, err error) {
+	code := http.StatusInternalServerError
+	var statusErr *fhttp.StatusError
+	switch {
+	case errors.As(err, &statusErr):
+		code = statusErr.Code
+	case errors.Is(err, fs.ErrNotExist):
+		code = http.StatusNotFound
+	case errors.Is(err, fs.ErrPermission):
+		code = http.StatusForbidden
+	}
+
+	http.Error(w, err.Error(), code)
+}
+
+// Open implements the [Theme] interface for testTheme.
+func (testTheme) Open(_ string) (f http.File, err error) {
+	return nil, fs.ErrNotExist
+}
+
+// String implements the [Theme] interface for testTheme.
+func (testTheme) String() (s string) {
+	return "test"
+}
+
+// newTestDirs creates the handler from conf, serving conf.Root with the
+// default symbolic links policy and [testTheme] unless set.
+func newTestDirs(t *testing.T, conf *HTTPFSConfig) (h *dirs) {
+	t.Helper()
+
+	if conf.FS == nil {
+		fsys, err := safefs.New(conf.Root, conf.Symlinks)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conf.FS = fsys
+	}
+
+	if conf.Theme == nil {
+		conf.Theme = testTheme{}
+	}
+
+	d, err := NewHTTPFSDirs(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d.(*dirs)
+}
+
+func TestDirs_ServeHTTP_basePath(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		siteRedirects: "/old /new 302\n",
+		"sub/a.txt":   "a",
+	})
+
+	h := newTestDirs(t, &HTTPFSConfig{
+		Root:        root,
+		StaticSite:  true,
+		AllowUpload: true,
+	})
+	wrapped := fhttp.StripBasePath("/files", testTheme{}.RenderError)(h)
+
+	testCases := []struct {
+		name         string
+		r            *http.Request
+		wantCode     int
+		wantLocation string
+	}{{
+		name:         "site_redirect",
+		r:            httptest.NewRequest(http.MethodGet, "/files/old?x=1", nil),
+		wantCode:     http.StatusFound,
+		wantLocation: "/files/new?x=1",
+	}, {
+		name:         "dir_slash",
+		r:            httptest.NewRequest(http.MethodGet, "/files/sub", nil),
+		wantCode:     http.StatusMovedPermanently,
+		wantLocation: "sub/",
+	}, {
+		name:         "upload",
+		r:            newUploadRequest(t, "/files/sub/", map[string]string{"b.txt": "b"}),
+		wantCode:     http.StatusSeeOther,
+		wantLocation: "/files/sub/",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			wrapped.ServeHTTP(rw, tc.r)
+
+			if rw.Code != tc.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rw.Code, tc.wantCode, rw.Body)
+			} else if got := rw.Header().Get("Location"); got != tc.wantLocation {
+				t.Fatalf("got location %q, want %q", got, tc.wantLocation)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package dirs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"filesrv/internal/ferrors"
+	"filesrv/internal/fhttp"
+)
+
+// ErrDropBox is returned when the contents of a drop box are requested.
+const ErrDropBox ferrors.Str = "the contents of a drop box are not accessible"
+
+// dropBoxIDLen is the length of the random part of the prefix of the files
+// stored within a drop box in bytes.
+const dropBoxIDLen = 4
+
+// dropBoxTimeLayout is the layout of the time part of the prefix of the files
+// stored within a drop box.
+const dropBoxTimeLayout = "20060102T150405Z"
+
+// ukReceived is the query parameter with the number of files received by the
+// drop box.
+const ukReceived urlKey = "received"
+
+// DropBox is the data for rendering the upload page of a drop box directory.
+type DropBox struct {
+	// Capacity is the remaining upload capacity of the directory.  It's nil
+	// if uploading isn't allowed.
+	Capacity *Capacity
+
+	// Received is the number of files received by the last upload, if any.
+	Received int
+}
+
+// dropBoxCtxKey is the context key for marking the requests to be served as
+// a drop box.
+type dropBoxCtxKey struct{}
+
+// WithDropBox returns a shallow copy of r which directory is served as a drop
+// box, e.g. to the holders of upload-only share links.
+func WithDropBox(r *http.Request) (withDB *http.Request) {
+	return r.WithContext(context.WithValue(r.Context(), dropBoxCtxKey{}, true))
+}
+
+// dropBoxOf returns the cleaned URL path of the drop box directory containing
+// the cleaned URL path name with settings s, if any.
+func (h *dirs) dropBoxOf(r *http.Request, s *dirSettings, name string) (box string, ok bool) {
+	if marked, _ := r.Context().Value(dropBoxCtxKey{}).(bool); marked {
+		return name, true
+	}
+
+	for _, box = range h.dropBoxes {
+		if name == box || box == "/" || strings.HasPrefix(name, box+"/") {
+			return box, true
+		}
+	}
+
+	box = s.dropBox
+
+	return box, box != ""
+}
+
+// newDropBoxes returns the cleaned URL paths of dirs.
+func newDropBoxes(dirs []string) (boxes []string) {
+	for _, d := range dirs {
+		boxes = append(boxes, path.Clean("/"+d))
+	}
+
+	return boxes
+}
+
+// serveDropBox renders the upload page of the drop box directory at the
+// cleaned URL path name with settings s instead of its listing.
+func (h *dirs) serveDropBox(w http.ResponseWriter, r *http.Request, s *dirSettings, name string) {
+	if !s.allowUpload {
+		h.theme.RenderError(w, r, &fhttp.StatusError{
+			Err:  ErrUploadForbidden,
+			Code: http.StatusForbidden,
+		})
+
+		return
+	}
+
+	db := &DropBox{}
+	db.Received, _ = strconv.Atoi(r.URL.Query().Get(ukReceived))
+
+	var err error
+	db.Capacity, err = h.capacity(s, name)
+	if err != nil {
+		log.Printf("dirs: getting capacity: %v", err)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.theme.RenderDropBox(w, r, db)
+}
+
+// receivedURL returns the URL to redirect to after n files are uploaded into
+// the drop box at the URL path p.
+func receivedURL(p string, n int) (u string) {
+	return p + "?" + url.Values{ukReceived: []string{strconv.Itoa(n)}}.Encode()
+}
+
+// dropBoxPrefix returns the prefix of the names of the files and folders
+// uploaded into a drop box by a single request.  The prefix keeps those in the
+// order of uploading and prevents the uploads from colliding with, and thus
+// revealing, the existing files.
+func dropBoxPrefix() (prefix string, err error) {
+	id := make([]byte, dropBoxIDLen)
+	_, err = rand.Read(id)
+	if err != nil {
+		return "", fmt.Errorf("generating name: %w", err)
+	}
+
+	return time.Now().UTC().Format(dropBoxTimeLayout) + "-" + hex.EncodeToString(id) + "-", nil
+}
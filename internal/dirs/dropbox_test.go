@@ -0,0 +1,169 @@
+package dirs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDirs_ServeHTTP_dropBox(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"box/secret.txt":     "secret",
+		"box/sub/nested.txt": "nested",
+		"open/public.txt":    "public",
+	})
+
+	h := newTestDirs(t, &HTTPFSConfig{
+		Root:        root,
+		AllowUpload: true,
+		DropBoxes:   []string{"box"},
+	})
+
+	testCases := []struct {
+		name         string
+		r            *http.Request
+		wantCode     int
+		wantBody     string
+		wantNoBody   string
+		wantLocation string
+	}{{
+		name:         "listing",
+		r:            httptest.NewRequest(http.MethodGet, "/box/", nil),
+		wantCode:     http.StatusOK,
+		wantBody:     "",
+		wantNoBody:   "secret.txt",
+		wantLocation: "",
+	}, {
+		name:         "listing_marked",
+		r:            WithDropBox(httptest.NewRequest(http.MethodGet, "/open/", nil)),
+		wantCode:     http.StatusOK,
+		wantBody:     "",
+		wantNoBody:   "public.txt",
+		wantLocation: "",
+	}, {
+		name:         "listing_not_box",
+		r:            httptest.NewRequest(http.MethodGet, "/open/", nil),
+		wantCode:     http.StatusOK,
+		wantBody:     "public.txt",
+		wantNoBody:   "",
+		wantLocation: "",
+	}, {
+		name:         "file",
+		r:            httptest.NewRequest(http.MethodGet, "/box/secret.txt", nil),
+		wantCode:     http.StatusForbidden,
+		wantBody:     string(ErrDropBox),
+		wantNoBody:   "secret\n",
+		wantLocation: "",
+	}, {
+		name:         "subdirectory",
+		r:            httptest.NewRequest(http.MethodGet, "/box/sub/", nil),
+		wantCode:     http.StatusForbidden,
+		wantBody:     string(ErrDropBox),
+		wantNoBody:   "nested.txt",
+		wantLocation: "",
+	}, {
+		name:         "nested_file",
+		r:            httptest.NewRequest(http.MethodGet, "/box/sub/nested.txt", nil),
+		wantCode:     http.StatusForbidden,
+		wantBody:     string(ErrDropBox),
+		wantNoBody:   "",
+		wantLocation: "",
+	}, {
+		name:         "upload",
+		r:            newUploadRequest(t, "/box/", map[string]string{"new.txt": "new"}),
+		wantCode:     http.StatusSeeOther,
+		wantBody:     "",
+		wantNoBody:   "",
+		wantLocation: "/box/?received=1",
+	}, {
+		name: "overwrite",
+		r: func() (r *http.Request) {
+			r = newUploadRequest(t, "/box/", map[string]string{"secret.txt": "overwritten"})
+			r.URL.RawQuery += "&" + string(ukOverwrite)
+
+			return r
+		}(),
+		wantCode:     http.StatusForbidden,
+		wantBody:     string(ErrDropBox),
+		wantNoBody:   "",
+		wantLocation: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, tc.r)
+
+			body := rw.Body.String()
+			if rw.Code != tc.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rw.Code, tc.wantCode, body)
+			} else if !strings.Contains(body, tc.wantBody) {
+				t.Errorf("got body %q, want it to contain %q", body, tc.wantBody)
+			} else if tc.wantNoBody != "" && strings.Contains(body, tc.wantNoBody) {
+				t.Errorf("got body %q, want it to not contain %q", body, tc.wantNoBody)
+			}
+
+			if got := rw.Header().Get("Location"); got != tc.wantLocation {
+				t.Errorf("got location %q, want %q", got, tc.wantLocation)
+			}
+		})
+	}
+
+	t.Run("stored", func(t *testing.T) {
+		secret, err := os.ReadFile(filepath.Join(root, "box", "secret.txt"))
+		if err != nil {
+			t.Fatal(err)
+		} else if string(secret) != "secret" {
+			t.Errorf("got existing file %q, want it unchanged", secret)
+		}
+
+		entries, err := os.ReadDir(filepath.Join(root, "box"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var uploaded []string
+		for _, e := range entries {
+			if strings.HasSuffix(e.Name(), "-new.txt") {
+				uploaded = append(uploaded, e.Name())
+			}
+		}
+
+		if len(uploaded) != 1 {
+			t.Fatalf("got uploaded files %q, want one prefixed new.txt", uploaded)
+		}
+
+		content, err := os.ReadFile(filepath.Join(root, "box", uploaded[0]))
+		if err != nil {
+			t.Fatal(err)
+		} else if string(content) != "new" {
+			t.Errorf("got uploaded content %q, want %q", content, "new")
+		}
+	})
+}
+
+func TestDirs_ServeHTTP_dropBoxNoUpload(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"box/secret.txt": "secret",
+	})
+
+	h := newTestDirs(t, &HTTPFSConfig{
+		Root:        root,
+		AllowUpload: false,
+		DropBoxes:   []string{"box"},
+	})
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/box/", nil))
+
+	if rw.Code != http.StatusForbidden {
+		t.Fatalf("got status %d, want %d", rw.Code, http.StatusForbidden)
+	} else if body := rw.Body.String(); strings.Contains(body, "secret.txt") {
+		t.Errorf("got body %q, want the listing hidden", body)
+	}
+}
@@ -0,0 +1,109 @@
+package dirs
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// listingETag returns the weak entity tag of the directory listing l rendered
+// according to query.  dropBox is true if the directory is a drop box.  Along
+// with the entries, which order doesn't matter, the tag covers the rest of the
+// rendered state, e.g. the remaining upload capacity.
+func listingETag(l *Listing, query url.Values, dropBox bool) (etag string) {
+	sorted := slices.Clone(l.Entries)
+	slices.SortFunc(sorted, func(a, b fs.FileInfo) bool { return a.Name() < b.Name() })
+
+	hash := sha256.New()
+	buf := make([]byte, 8)
+	for _, e := range sorted {
+		_, _ = hash.Write([]byte(e.Name()))
+		_, _ = hash.Write([]byte{0})
+
+		binary.LittleEndian.PutUint64(buf, uint64(e.Size()))
+		_, _ = hash.Write(buf)
+
+		binary.LittleEndian.PutUint64(buf, uint64(e.ModTime().UnixNano()))
+		_, _ = hash.Write(buf)
+
+		binary.LittleEndian.PutUint32(buf, uint32(e.Mode()))
+		_, _ = hash.Write(buf[:4])
+	}
+
+	deleted := slices.Clone(l.Deleted)
+	slices.Sort(deleted)
+	for _, name := range deleted {
+		_, _ = hash.Write([]byte(name))
+		_, _ = hash.Write([]byte{0})
+	}
+
+	// Marshaling a struct never fails.
+	capacity, _ := json.Marshal(l.Capacity)
+	_, _ = hash.Write(capacity)
+
+	_, _ = hash.Write([]byte{
+		boolByte(l.Upload),
+		boolByte(l.Versioning),
+		boolByte(l.Watch),
+		boolByte(dropBox),
+	})
+
+	// Encode sorts the values by key.
+	_, _ = hash.Write([]byte(query.Encode()))
+
+	return `W/"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// boolByte returns 1 if b is true and 0 otherwise.
+func boolByte(b bool) (res byte) {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// fileETag returns the strong entity tag of the file described by fi served
+// in the content coding enc, which is empty for the identity.  It's empty if
+// fi has no meaningful modification time, which is the case for the embedded
+// static files.
+//
+// The tag is strong, so that [http.ServeContent] resumes the downloads with
+// If-Range.  The modification time with nanoseconds along with the size tell
+// the contents apart well enough, the same way the other servers do.  The
+// responses compressed on the fly get the weak tag, see [fhttp.Compress].
+func fileETag(fi fs.FileInfo, enc string) (etag string) {
+	mtime := fi.ModTime()
+	if isZeroTime(mtime) {
+		return ""
+	} else if enc != "" {
+		return fmt.Sprintf(`"%x-%x-%s"`, mtime.UnixNano(), fi.Size(), enc)
+	}
+
+	return fmt.Sprintf(`"%x-%x"`, mtime.UnixNano(), fi.Size())
+}
+
+// etagWeakMatch reports whether the If-None-Match header value inm matches
+// etag using the weak comparison function from RFC 9110 Section 8.8.3.2.
+func etagWeakMatch(inm, etag string) (ok bool) {
+	if etag == "" {
+		return false
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(inm, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,188 @@
+package dirs
+
+import (
+	"io/fs"
+	"net/url"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// fileInfos returns the infos of the files within fsys sorted by name.
+func fileInfos(t *testing.T, fsys fstest.MapFS) (infos []fs.FileInfo) {
+	t.Helper()
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range entries {
+		fi, infoErr := e.Info()
+		if infoErr != nil {
+			t.Fatal(infoErr)
+		}
+
+		infos = append(infos, fi)
+	}
+
+	return infos
+}
+
+func TestFileETag(t *testing.T) {
+	mtime := time.Unix(1700000000, 123)
+	fsys := fstest.MapFS{
+		"file":   {Data: []byte("12345"), ModTime: mtime},
+		"static": {Data: []byte("12345")},
+	}
+
+	testCases := []struct {
+		name string
+		file string
+		enc  string
+		want string
+	}{{
+		name: "identity",
+		file: "file",
+		enc:  "",
+		want: `"17979cfe362a007b-5"`,
+	}, {
+		name: "sidecar",
+		file: "file",
+		enc:  "br",
+		want: `"17979cfe362a007b-5-br"`,
+	}, {
+		name: "no_mtime",
+		file: "static",
+		enc:  "",
+		want: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fi, err := fs.Stat(fsys, tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := fileETag(fi, tc.enc); got != tc.want {
+				t.Fatalf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestListingETag(t *testing.T) {
+	mtime := time.Unix(1700000000, 0)
+	fsys := fstest.MapFS{
+		"a": {Data: []byte("a"), ModTime: mtime},
+		"b": {Data: []byte("bb"), ModTime: mtime},
+	}
+	infos := fileInfos(t, fsys)
+	reversed := []fs.FileInfo{infos[1], infos[0]}
+
+	etag := listingETag(&Listing{Entries: infos}, url.Values{}, false)
+	if etag[:3] != `W/"` {
+		t.Fatalf("got %s, want weak tag", etag)
+	} else if got := listingETag(&Listing{Entries: reversed}, url.Values{}, false); got != etag {
+		t.Fatalf("order changes tag: %s and %s", got, etag)
+	} else if got = listingETag(&Listing{Entries: infos}, url.Values{"sortBy": {"size"}}, false); got == etag {
+		t.Fatal("query doesn't change tag")
+	}
+
+	one, two := int64(1), int64(2)
+	testCases := []struct {
+		name    string
+		l       *Listing
+		dropBox bool
+	}{{
+		name:    "upload",
+		l:       &Listing{Entries: infos, Upload: true},
+		dropBox: false,
+	}, {
+		name:    "capacity",
+		l:       &Listing{Entries: infos, Upload: true, Capacity: &Capacity{Bytes: &one}},
+		dropBox: false,
+	}, {
+		name:    "capacity_changed",
+		l:       &Listing{Entries: infos, Upload: true, Capacity: &Capacity{Bytes: &two}},
+		dropBox: false,
+	}, {
+		name:    "deleted",
+		l:       &Listing{Entries: infos, Deleted: []string{"c"}},
+		dropBox: false,
+	}, {
+		name:    "drop_box",
+		l:       &Listing{Entries: infos},
+		dropBox: true,
+	}}
+
+	seen := map[string]string{etag: "plain"}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := listingETag(tc.l, url.Values{}, tc.dropBox)
+			if prev, ok := seen[got]; ok {
+				t.Fatalf("got the same tag as %s", prev)
+			}
+
+			seen[got] = tc.name
+		})
+	}
+
+	fsys["b"].ModTime = mtime.Add(time.Nanosecond)
+	if got := listingETag(&Listing{Entries: fileInfos(t, fsys)}, url.Values{}, false); got == etag {
+		t.Fatal("modification doesn't change tag")
+	}
+}
+
+func TestETagWeakMatch(t *testing.T) {
+	testCases := []struct {
+		name string
+		inm  string
+		etag string
+		want bool
+	}{{
+		name: "same",
+		inm:  `"abc"`,
+		etag: `"abc"`,
+		want: true,
+	}, {
+		name: "weak_both",
+		inm:  `W/"abc"`,
+		etag: `W/"abc"`,
+		want: true,
+	}, {
+		name: "weak_one",
+		inm:  `W/"abc"`,
+		etag: `"abc"`,
+		want: true,
+	}, {
+		name: "list",
+		inm:  `"x", W/"abc" ,"y"`,
+		etag: `W/"abc"`,
+		want: true,
+	}, {
+		name: "star",
+		inm:  `*`,
+		etag: `"abc"`,
+		want: true,
+	}, {
+		name: "different",
+		inm:  `"abd"`,
+		etag: `"abc"`,
+		want: false,
+	}, {
+		name: "no_etag",
+		inm:  `*`,
+		etag: "",
+		want: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := etagWeakMatch(tc.inm, tc.etag); got != tc.want {
+				t.Fatalf("got %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
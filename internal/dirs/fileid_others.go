@@ -0,0 +1,15 @@
+//go:build !unix
+
+package dirs
+
+import "io/fs"
+
+// fileID identifies a file within the operating system.
+type fileID struct {
+	name string
+}
+
+// newFileID returns the identifier of the file at name described by d.
+func newFileID(name string, _ fs.FileInfo) (id fileID) {
+	return fileID{name: name}
+}
@@ -0,0 +1,30 @@
+//go:build unix
+
+package dirs
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileID identifies a file within the operating system.
+type fileID struct {
+	name string
+	dev  uint64
+	ino  uint64
+}
+
+// newFileID returns the identifier of the file at name described by d.  It
+// uses the device and the inode numbers when available, so that the renamed
+// file keeps its identifier.
+func newFileID(name string, d fs.FileInfo) (id fileID) {
+	st, ok := d.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{name: name}
+	}
+
+	return fileID{
+		dev: uint64(st.Dev),
+		ino: uint64(st.Ino),
+	}
+}
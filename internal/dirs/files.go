@@ -1,14 +1,20 @@
 package dirs
 
 import (
+	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"path"
 	"strings"
+
+	"filesrv/internal/fhttp"
+
+	"golang.org/x/exp/slices"
 )
 
-// indexPage is the suffix of the index file's name.
-const indexPage = "/index.html"
+// indexFile is the default name of the file served instead of the listing.
+const indexFile = "index.html"
 
 // ServeHTTP implements the [http.Handler] interface for *dirs.
 func (h *dirs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -16,28 +22,88 @@ func (h *dirs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		r.URL.Path = "/" + r.URL.Path
 	}
 
+	name := path.Clean(r.URL.Path)
+
+	// Loading the configuration files of each directory is far from free, so
+	// do that once per request.
+	s := h.settings(name)
+
 	// Redirect .../index.html to .../.
-	if strings.HasSuffix(r.URL.Path, indexPage) {
+	if h.indexRedirect && !strings.HasSuffix(r.URL.Path, "/") && h.isIndexFile(s, name) {
 		localRedirect(w, r, "./")
 
 		return
 	}
 
-	h.serveFile(w, r, path.Clean(r.URL.Path))
+	if isMetaPath(name) {
+		if name == dedupeStatsPath && h.blobs != nil {
+			h.serveDedupeStats(w, r)
+		} else {
+			h.renderError(w, r, s, fs.ErrNotExist)
+		}
+
+		return
+	}
+
+	if h.isHidden(name) {
+		h.renderError(w, r, s, fs.ErrNotExist)
+
+		return
+	}
+
+	if box, ok := h.dropBoxOf(r, s, name); ok && name != box {
+		h.theme.RenderError(w, r, &fhttp.StatusError{
+			Err:  ErrDropBox,
+			Code: http.StatusForbidden,
+		})
+
+		return
+	}
+
+	if s.siteRoot != "" && h.serveSite(w, r, s, name) {
+		return
+	}
+
+	if h.versions != nil && h.handleVersions(w, r, s, name) {
+		return
+	}
+
+	h.serveFile(w, r, s, name)
+}
+
+// isMetaPath returns true if the cleaned URL path p points to the metadata
+// directory or its contents.
+func isMetaPath(p string) (ok bool) {
+	root, _, _ := strings.Cut(strings.TrimPrefix(p, "/"), "/")
+
+	return strings.EqualFold(root, MetaDir)
 }
 
-// serveFile serves the file under name to w.
-func (h *dirs) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+// serveFile serves the file under name with settings s to w.
+func (h *dirs) serveFile(w http.ResponseWriter, r *http.Request, s *dirSettings, name string) {
 	f, err := h.fsys.Open(name)
 	if err != nil {
+		if cf, cleanName := h.openCleanURL(s, name); cf != nil {
+			f, name, err = cf, cleanName, nil
+		}
+	}
+
+	if err != nil {
+		if h.checksums && h.serveChecksumFile(w, r, name) {
+			return
+		}
+
 		staticFile, staticErr := h.theme.Open(name)
 		if staticErr != nil {
-			h.theme.RenderError(w, r, err)
+			if !h.serveFallback(w, r, name, err) {
+				h.renderError(w, r, s, err)
+			}
 
 			return
 		}
 
 		f = staticFile
+		fhttp.SetRoute(r, fhttp.RouteStatic)
 	}
 	defer func() {
 		err = f.Close()
@@ -63,15 +129,12 @@ func (h *dirs) serveFile(w http.ResponseWriter, r *http.Request, name string) {
 			return
 		}
 
-		// Use contents of index.html for directory, if present.
-		ff, err := h.fsys.Open(strings.TrimSuffix(name, "/") + indexPage)
-		if err == nil {
-			defer ff.Close()
+		// Use contents of the index file for directory, if present.
+		ff, dd, indexName := h.openIndex(s, name)
+		if ff != nil {
+			defer func() { _ = ff.Close() }()
 
-			dd, err := ff.Stat()
-			if err == nil {
-				f, d = ff, dd
-			}
+			f, d, name = ff, dd, indexName
 		}
 	} else if strings.HasSuffix(p, "/") {
 		localRedirect(w, r, "../"+path.Base(p))
@@ -80,13 +143,99 @@ func (h *dirs) serveFile(w http.ResponseWriter, r *http.Request, name string) {
 	}
 
 	if d.IsDir() {
-		// Still a directory, no index.html.
-		h.handleDir(w, r, f, d)
+		// Still a directory, no index file.
+		h.handleDir(w, r, s, f, d)
 	} else {
-		http.ServeContent(w, r, d.Name(), d.ModTime(), f)
+		h.serveContent(w, r, name, f, d)
 	}
 }
 
+// openIndex opens the first present index file of the directory at the
+// cleaned URL path dir with settings s.  f is nil if there is none.
+func (h *dirs) openIndex(s *dirSettings, dir string) (f http.File, fi fs.FileInfo, name string) {
+	for _, base := range s.indexFiles {
+		name = path.Join(dir, base)
+		if h.isHidden(name) {
+			continue
+		}
+
+		var err error
+		f, err = h.fsys.Open(name)
+		if err != nil {
+			continue
+		}
+
+		fi, err = f.Stat()
+		if err == nil && !fi.IsDir() {
+			return f, fi, name
+		}
+
+		_ = f.Close()
+	}
+
+	return nil, nil, ""
+}
+
+// isIndexFile returns true if the cleaned URL path p with settings s is the
+// index file served for its directory.  The settings of a file are the ones of
+// its directory.
+func (h *dirs) isIndexFile(s *dirSettings, p string) (ok bool) {
+	dir := path.Dir(p)
+	if p == "/" || !slices.Contains(s.indexFiles, path.Base(p)) {
+		return false
+	}
+
+	f, _, name := h.openIndex(s, dir)
+	if f == nil {
+		return false
+	}
+	_ = f.Close()
+
+	return name == p
+}
+
+// serveContent serves the contents of the regular file f located at name.  It
+// prefers the precompressed sidecar file if there is an acceptable one.
+func (h *dirs) serveContent(
+	w http.ResponseWriter,
+	r *http.Request,
+	name string,
+	f http.File,
+	d fs.FileInfo,
+) {
+	isStatic := fhttp.Route(r) == fhttp.RouteStatic
+	if !isStatic {
+		fhttp.SetRoute(r, fhttp.RouteFile)
+
+		if q := r.URL.Query(); h.checksums && q.Has("hash") {
+			h.serveSum(w, r, name, f, d, q.Get("hash"))
+
+			return
+		}
+	}
+
+	var content io.ReadSeeker = f
+	info := d
+	enc := ""
+
+	// Don't look for sidecars of the theme's files.
+	if h.precompressed && !isStatic {
+		sf, sd := h.openSidecar(w, r, name)
+		if sf != nil {
+			defer func() { _ = sf.Close() }()
+
+			content, info = sf, sd
+			enc = w.Header().Get("Content-Encoding")
+		}
+	}
+
+	if etag := fileETag(info, enc); etag != "" {
+		w.Header().Set("Etag", etag)
+	}
+
+	http.ServeContent(w, r, d.Name(), info.ModTime(), content)
+}
+
 // localRedirect gives an [http.StatusMovedPermanently] response.  It does not
 // convert relative paths to absolute paths like [http.Redirect] does, for
 // example when [http.StripPrefix] is used.
@@ -0,0 +1,79 @@
+package dirs
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"syscall"
+
+	"filesrv/internal/ferrors"
+	"filesrv/internal/fhttp"
+	"filesrv/internal/safefs"
+)
+
+// ErrNotDir is returned when the directory of an uploaded file exists but is
+// not a directory.
+const ErrNotDir ferrors.Str = "not a directory"
+
+// maxUploadDepth is the maximum number of the directories within the relative
+// path of an uploaded file.
+const maxUploadDepth = 32
+
+// uploadPath returns the slash-separated path of the uploaded file relative
+// to the destination directory, as sent by the client.  The multipart reader
+// only returns the base name from [multipart.Part.FileName], so the path is
+// taken from the raw Content-Disposition header.
+func uploadPath(part *multipart.Part) (p string) {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil || params["filename"] == "" {
+		return part.FileName()
+	}
+
+	return params["filename"]
+}
+
+// mkdirs creates the slash-separated relative path of directories rel within
+// dir, if needed, and opens the resulting directory.  res is dir itself if rel
+// is empty, otherwise the caller must close it.  The existing path components
+// must be directories, not symbolic links, so that the files never end up
+// outside of dir.
+func mkdirs(dir *safefs.Dir, rel string) (res *safefs.Dir, err error) {
+	res = dir
+	for _, elem := range strings.Split(strings.Trim(rel, "/"), "/") {
+		if elem == "" {
+			continue
+		}
+
+		var sub *safefs.Dir
+		sub, err = mkdir(res, elem)
+		if res != dir {
+			_ = res.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		res = sub
+	}
+
+	return res, nil
+}
+
+// mkdir creates the directory name within dir unless it exists, and opens it.
+func mkdir(dir *safefs.Dir, name string) (sub *safefs.Dir, err error) {
+	sub, err = dir.Mkdir(name)
+	if errors.Is(err, syscall.ENOTDIR) {
+		// Either a file or a symbolic link.
+		return nil, &fhttp.StatusError{
+			Err:  fmt.Errorf("%q: %w", name, ErrNotDir),
+			Code: http.StatusConflict,
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("creating directory: %w", err)
+	}
+
+	return sub, nil
+}
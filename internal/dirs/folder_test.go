@@ -0,0 +1,98 @@
+package dirs
+
+import (
+	"errors"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadPath(t *testing.T) {
+	testCases := []struct {
+		name   string
+		header string
+		want   string
+	}{{
+		name:   "base",
+		header: `form-data; name="files"; filename="file.txt"`,
+		want:   "file.txt",
+	}, {
+		name:   "relative",
+		header: `form-data; name="files"; filename="dir/file.txt"`,
+		want:   "dir/file.txt",
+	}, {
+		name:   "malformed",
+		header: `form-data; filename=`,
+		want:   "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			part := &multipart.Part{
+				Header: textproto.MIMEHeader{"Content-Disposition": []string{tc.header}},
+			}
+
+			if got := uploadPath(part); got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMkdirs(t *testing.T) {
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "root")
+	outside := filepath.Join(tmp, "outside")
+	for _, d := range []string{root, outside} {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Skipf("creating symlink: %v", err)
+	} else if err = os.WriteFile(filepath.Join(root, "file"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rootDir := openDir(t, root)
+	got, err := mkdirs(rootDir, "a/b/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = got.Close()
+
+	if want := filepath.Join(root, "a", "b"); got.Name() != want {
+		t.Fatalf("got %q, want %q", got.Name(), want)
+	}
+
+	// Existing directories are reused.
+	got, err = mkdirs(rootDir, "a/b/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = got.Close()
+
+	got, err = mkdirs(rootDir, "")
+	if err != nil {
+		t.Fatal(err)
+	} else if got != rootDir {
+		t.Fatalf("got %q, want the same directory", got.Name())
+	}
+
+	for _, rel := range []string{"link/x", "file/x"} {
+		_, err = mkdirs(rootDir, rel)
+		if !errors.Is(err, ErrNotDir) {
+			t.Fatalf("%q: got %v, want %v", rel, err, ErrNotDir)
+		}
+	}
+
+	entries, err := os.ReadDir(outside)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(entries) > 0 {
+		t.Fatalf("created %q outside of root", entries[0].Name())
+	}
+}
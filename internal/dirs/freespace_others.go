@@ -0,0 +1,9 @@
+//go:build !(darwin || freebsd || linux || windows)
+
+package dirs
+
+// freeSpace always returns false since checking the free space isn't
+// supported on this platform.
+func freeSpace(_ string) (free int64, ok bool, err error) {
+	return 0, false, nil
+}
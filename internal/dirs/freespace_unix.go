@@ -0,0 +1,17 @@
+//go:build darwin || freebsd || linux
+
+package dirs
+
+import "golang.org/x/sys/unix"
+
+// freeSpace returns the number of bytes available to the unprivileged user on
+// the file system containing dir.
+func freeSpace(dir string) (free int64, ok bool, err error) {
+	st := &unix.Statfs_t{}
+	err = unix.Statfs(dir, st)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return int64(st.Bavail) * int64(st.Bsize), true, nil
+}
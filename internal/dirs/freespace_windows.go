@@ -0,0 +1,22 @@
+//go:build windows
+
+package dirs
+
+import "golang.org/x/sys/windows"
+
+// freeSpace returns the number of bytes available to the current user on the
+// volume containing dir.
+func freeSpace(dir string) (free int64, ok bool, err error) {
+	p, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var avail uint64
+	err = windows.GetDiskFreeSpaceEx(p, &avail, nil, nil)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return int64(avail), true, nil
+}
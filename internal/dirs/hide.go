@@ -0,0 +1,509 @@
+package dirs
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"filesrv/internal/ferrors"
+	"filesrv/internal/fhttp"
+
+	"golang.org/x/exp/slices"
+)
+
+// ErrHiddenName is returned when the uploaded file's name is hidden by the
+// hide rules.
+const ErrHiddenName ferrors.Str = "file name is hidden"
+
+// HideRules are the rules of hiding the files and directories.  The hidden
+// ones are neither listed nor served, as if those don't exist.
+type HideRules struct {
+	// Patterns are the glob patterns of the hidden names, in the syntax of
+	// [path.Match].  The patterns containing a slash are matched against the
+	// slash-separated paths relative to the root instead.
+	Patterns []string
+
+	// IgnoreFiles are the names of the files within the directories listing
+	// the patterns in the .gitignore format, e.g. .gitignore.  Those apply to
+	// the directory and all its subdirectories.  The files are hidden
+	// themselves.
+	IgnoreFiles []string
+
+	// Dotfiles hides the files and the directories which names begin with a
+	// dot.
+	Dotfiles bool
+}
+
+// hider applies the [HideRules] to the files within the served file system.
+type hider struct {
+	fsys        http.FileSystem
+	patterns    []string
+	ignoreFiles []string
+	dotfiles    bool
+
+	// siteRoot is the cleaned URL path of the static site's root, which
+	// special files are hidden, if any.
+	siteRoot string
+
+	// confs are the per-directory configuration files overriding the rules.
+	// It's nil if those are disabled.
+	confs *dirConfigs
+
+	// mu protects ignores.
+	mu *sync.Mutex
+
+	// ignores are the parsed ignore files by their cleaned URL paths.
+	ignores map[string]*ignoreFile
+}
+
+// newHider returns a new hider of the files within fsys.  confs override
+// rules and siteRoot, the root of the static site, per directory, if not nil.
+// It returns nil if nothing is hidden.
+func newHider(fsys http.FileSystem, rules *HideRules, confs *dirConfigs, siteRoot string) (hd *hider) {
+	if rules == nil {
+		rules = &HideRules{}
+	}
+
+	if confs == nil &&
+		siteRoot == "" &&
+		!rules.Dotfiles &&
+		len(rules.Patterns) == 0 &&
+		len(rules.IgnoreFiles) == 0 {
+		return nil
+	}
+
+	return &hider{
+		fsys:        fsys,
+		patterns:    rules.Patterns,
+		ignoreFiles: rules.IgnoreFiles,
+		dotfiles:    rules.Dotfiles,
+		siteRoot:    siteRoot,
+		confs:       confs,
+		mu:          &sync.Mutex{},
+		ignores:     map[string]*ignoreFile{},
+	}
+}
+
+// dirRules are the rules applying to the entries of a single directory.  A nil
+// *dirRules hides nothing.
+type dirRules struct {
+	hd *hider
+
+	// rel is the slash-separated path of the directory relative to the root,
+	// empty for the root itself.
+	rel string
+
+	// ignores are the ignore files of the directory and its parents, from the
+	// root down.
+	ignores []*ignoreFile
+
+	// patterns, ignoreFiles and dotfiles are the rules of the directory,
+	// possibly overridden by the configuration files.
+	patterns    []string
+	ignoreFiles []string
+	dotfiles    bool
+
+	// siteRoot is the cleaned URL path of the root of the static site the
+	// directory belongs to, if any.
+	siteRoot string
+}
+
+// apply overrides the rules of dr with conf of the directory and loads its
+// ignore files.
+func (dr *dirRules) apply(conf *dirConfig) {
+	if conf != nil {
+		if conf.HideDotfiles != nil {
+			dr.dotfiles = *conf.HideDotfiles
+		}
+
+		if conf.HidePatterns != nil {
+			dr.patterns = *conf.HidePatterns
+		}
+
+		if conf.HideIgnoreFiles != nil {
+			dr.ignoreFiles = *conf.HideIgnoreFiles
+		}
+
+		if conf.StaticSite != nil {
+			dr.siteRoot = ""
+			if *conf.StaticSite {
+				dr.siteRoot = "/" + dr.rel
+			}
+		}
+	}
+
+	dr.ignores = dr.hd.loadIgnores("/"+dr.rel, dr.ignoreFiles, dr.ignores)
+}
+
+// dir returns the rules of the directory at the cleaned URL path p.  hidden
+// is true if the directory is hidden itself.  It's safe for use with a nil hd.
+func (hd *hider) dir(p string) (dr *dirRules, hidden bool) {
+	if hd == nil {
+		return nil, false
+	}
+
+	dr = &dirRules{
+		hd:          hd,
+		patterns:    hd.patterns,
+		ignoreFiles: hd.ignoreFiles,
+		dotfiles:    hd.dotfiles,
+		siteRoot:    hd.siteRoot,
+	}
+	dr.apply(hd.confs.load("/"))
+	for _, elem := range strings.Split(strings.Trim(p, "/"), "/") {
+		if elem == "" {
+			continue
+		} else if dr.hides(elem, true) {
+			return nil, true
+		}
+
+		dr = dr.child(elem)
+	}
+
+	return dr, false
+}
+
+// child returns the rules of the subdirectory name of dr.
+func (dr *dirRules) child(name string) (sub *dirRules) {
+	sub = &dirRules{}
+	*sub = *dr
+	sub.rel = path.Join(dr.rel, name)
+	sub.ignores = slices.Clip(dr.ignores)
+	sub.apply(dr.hd.confs.load("/" + sub.rel))
+
+	return sub
+}
+
+// hides returns true if the entry name of the directory is hidden.  isDir is
+// true if the entry is a directory.
+func (dr *dirRules) hides(name string, isDir bool) (ok bool) {
+	if dr == nil {
+		return false
+	}
+
+	rel := path.Join(dr.rel, name)
+	if (dr.dotfiles && strings.HasPrefix(name, ".")) || slices.Contains(dr.ignoreFiles, name) {
+		return true
+	} else if dr.hd.confs != nil && name == DirConfigFile {
+		return true
+	} else if dr.siteRoot != "" && isSiteFile(dr.siteRoot, "/"+rel) {
+		return true
+	}
+
+	for _, pat := range dr.patterns {
+		subj := name
+		if strings.Contains(pat, "/") {
+			pat, subj = strings.TrimPrefix(pat, "/"), rel
+		}
+
+		if matched, _ := path.Match(pat, subj); matched {
+			return true
+		}
+	}
+
+	// The last matching pattern decides, and the deeper files go later.
+	for _, ign := range dr.ignores {
+		for _, rule := range ign.rules {
+			if rule.match(ign.dir, rel, name, isDir) {
+				ok = !rule.negate
+			}
+		}
+	}
+
+	return ok
+}
+
+// hidesPath returns true if the slash-separated path rel of a file relative to
+// the directory is hidden, or any of the directories leading to it.
+func (dr *dirRules) hidesPath(rel string) (ok bool) {
+	if dr == nil {
+		return false
+	}
+
+	dir, name := path.Split(rel)
+	for _, elem := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if elem == "" {
+			continue
+		} else if dr.hides(elem, true) {
+			return true
+		}
+
+		dr = dr.child(elem)
+	}
+
+	return dr.hides(name, false)
+}
+
+// isHidden returns true if the cleaned URL path p is hidden.  The missing
+// paths are checked as files.
+func (h *dirs) isHidden(p string) (ok bool) {
+	if h.hide == nil || p == "/" {
+		return false
+	}
+
+	dr, hidden := h.hide.dir(path.Dir(p))
+	if hidden {
+		return true
+	}
+
+	isDir := false
+	if f, err := h.fsys.Open(p); err == nil {
+		fi, statErr := f.Stat()
+		isDir = statErr == nil && fi.IsDir()
+		_ = f.Close()
+	}
+
+	return dr.hides(path.Base(p), isDir)
+}
+
+// visible returns entries of the directory at the cleaned URL path p without
+// the hidden ones.  It modifies entries.
+func (h *dirs) visible(p string, entries []fs.FileInfo) (res []fs.FileInfo) {
+	dr, _ := h.hide.dir(p)
+	if dr == nil {
+		return entries
+	}
+
+	res = entries[:0]
+	for _, e := range entries {
+		if !dr.hides(e.Name(), e.IsDir()) {
+			res = append(res, e)
+		}
+	}
+
+	return res
+}
+
+// visibleNames is like [dirs.visible] but for the names of files.
+func (h *dirs) visibleNames(p string, names []string) (res []string) {
+	dr, _ := h.hide.dir(p)
+	if dr == nil {
+		return names
+	}
+
+	res = names[:0]
+	for _, name := range names {
+		if !dr.hides(name, false) {
+			res = append(res, name)
+		}
+	}
+
+	return res
+}
+
+// visibleEvents is like [dirs.visible] but for the events of changes.
+func (h *dirs) visibleEvents(p string, batch []*WatchEvent) (res []*WatchEvent) {
+	dr, _ := h.hide.dir(p)
+	if dr == nil {
+		return batch
+	}
+
+	for _, e := range batch {
+		if !dr.hides(e.Name, e.Dir) {
+			res = append(res, e)
+		}
+	}
+
+	return res
+}
+
+// ignoreFile is a parsed ignore file.
+type ignoreFile struct {
+	modTime time.Time
+	rules   []*ignoreRule
+
+	// dir is the slash-separated path of the file's directory relative to the
+	// root, empty for the root itself.
+	dir  string
+	size int64
+}
+
+// loadIgnores appends the ignore files with names of the directory at the
+// cleaned URL path dir to ignores.
+func (hd *hider) loadIgnores(dir string, names []string, ignores []*ignoreFile) (res []*ignoreFile) {
+	for _, name := range names {
+		ign, err := hd.loadIgnore(path.Join(dir, name))
+		if err != nil {
+			log.Printf("dirs: loading ignore file: %v", err)
+		} else if ign != nil {
+			ignores = append(ignores, ign)
+		}
+	}
+
+	return ignores
+}
+
+// loadIgnore returns the ignore file at the cleaned URL path p, if any.  The
+// parsed files are cached until modified.
+func (hd *hider) loadIgnore(p string) (ign *ignoreFile, err error) {
+	f, err := hd.fsys.Open(p)
+	if err != nil {
+		// Most of the directories have none.
+		return nil, nil
+	}
+	defer func() { _ = f.Close() }()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", p, err)
+	} else if !fi.Mode().IsRegular() {
+		return nil, nil
+	}
+
+	hd.mu.Lock()
+	defer hd.mu.Unlock()
+
+	ign = hd.ignores[p]
+	if ign != nil && ign.modTime.Equal(fi.ModTime()) && ign.size == fi.Size() {
+		return ign, nil
+	}
+
+	ign = &ignoreFile{
+		modTime: fi.ModTime(),
+		dir:     strings.Trim(path.Dir(p), "/"),
+		size:    fi.Size(),
+	}
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		rule, ok := parseIgnoreRule(sc.Text())
+		if ok {
+			ign.rules = append(ign.rules, rule)
+		}
+	}
+
+	err = sc.Err()
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", p, err)
+	}
+
+	hd.ignores[p] = ign
+
+	return ign, nil
+}
+
+// ignoreRule is a single pattern of an ignore file.
+type ignoreRule struct {
+	re *regexp.Regexp
+
+	// anchored is true if the pattern is matched against the path relative to
+	// the ignore file's directory instead of the name.
+	anchored bool
+
+	// dirOnly is true if the pattern only matches directories.
+	dirOnly bool
+
+	// negate is true if the pattern re-includes the matching entries.
+	negate bool
+}
+
+// parseIgnoreRule parses the line of an ignore file.  ok is false if the line
+// is blank, a comment or an invalid pattern.
+func parseIgnoreRule(line string) (rule *ignoreRule, ok bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, false
+	}
+
+	rule = &ignoreRule{}
+	if strings.HasPrefix(line, "!") {
+		rule.negate, line = true, line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		// Escapes the leading "!" and "#".
+		line = line[1:]
+	}
+
+	line, rule.dirOnly = strings.CutSuffix(line, "/")
+	rule.anchored = strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return nil, false
+	}
+
+	re, err := regexp.Compile("^" + globRegexp(line) + "$")
+	if err != nil {
+		return nil, false
+	}
+
+	rule.re = re
+
+	return rule, true
+}
+
+// globRegexp translates the .gitignore glob pattern into a regular expression.
+func globRegexp(pat string) (re string) {
+	b := &strings.Builder{}
+	for i := 0; i < len(pat); i++ {
+		switch c := pat[i]; {
+		case strings.HasPrefix(pat[i:], "**/") && (i == 0 || pat[i-1] == '/'):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pat[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '\\' && i+1 < len(pat):
+			i++
+			b.WriteString(regexp.QuoteMeta(pat[i : i+1]))
+		case c == '[':
+			end := strings.IndexByte(pat[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+
+				continue
+			}
+
+			class := pat[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(pat[i : i+1]))
+		}
+	}
+
+	return b.String()
+}
+
+// match returns true if rule matches the entry with name at the slash-separated
+// path rel relative to the root.  dir is the path of the ignore file's
+// directory relative to the root.
+func (rule *ignoreRule) match(dir, rel, name string, isDir bool) (ok bool) {
+	if rule.dirOnly && !isDir {
+		return false
+	} else if !rule.anchored {
+		return rule.re.MatchString(name)
+	}
+
+	if dir != "" {
+		var found bool
+		rel, found = strings.CutPrefix(rel, dir+"/")
+		if !found {
+			return false
+		}
+	}
+
+	return rule.re.MatchString(rel)
+}
+
+// hiddenError returns the error of uploading the file at the slash-separated
+// path rel hidden by the rules.
+func hiddenError(rel string) (err error) {
+	return &fhttp.StatusError{
+		Err:  fmt.Errorf("%q: %w", rel, ErrHiddenName),
+		Code: http.StatusForbidden,
+	}
+}
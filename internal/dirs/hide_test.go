@@ -0,0 +1,277 @@
+package dirs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnoreRule_match(t *testing.T) {
+	testCases := []struct {
+		name  string
+		line  string
+		dir   string
+		rel   string
+		isDir bool
+		want  bool
+	}{{
+		name: "name",
+		line: "*.log",
+		rel:  "a/b/x.log",
+		want: true,
+	}, {
+		name: "name_other",
+		line: "*.log",
+		rel:  "a/b/x.txt",
+		want: false,
+	}, {
+		name: "anchored",
+		line: "/top.txt",
+		rel:  "top.txt",
+		want: true,
+	}, {
+		name: "anchored_deeper",
+		line: "/top.txt",
+		rel:  "a/top.txt",
+		want: false,
+	}, {
+		name: "anchored_subdir",
+		line: "b/*.txt",
+		dir:  "a",
+		rel:  "a/b/x.txt",
+		want: true,
+	}, {
+		name: "anchored_outside",
+		line: "b/*.txt",
+		dir:  "a",
+		rel:  "c/b/x.txt",
+		want: false,
+	}, {
+		name:  "dir_only",
+		line:  "build/",
+		rel:   "a/build",
+		isDir: true,
+		want:  true,
+	}, {
+		name:  "dir_only_file",
+		line:  "build/",
+		rel:   "a/build",
+		isDir: false,
+		want:  false,
+	}, {
+		name: "double_star",
+		line: "**/cache/*.tmp",
+		rel:  "a/b/cache/x.tmp",
+		want: true,
+	}, {
+		name: "double_star_root",
+		line: "**/cache/*.tmp",
+		rel:  "cache/x.tmp",
+		want: true,
+	}, {
+		name: "star_no_slash",
+		line: "a/*.txt",
+		rel:  "a/b/x.txt",
+		want: false,
+	}, {
+		name: "class",
+		line: "x[0-9].txt",
+		rel:  "x1.txt",
+		want: true,
+	}, {
+		name: "class_negated",
+		line: "x[!0-9].txt",
+		rel:  "x1.txt",
+		want: false,
+	}, {
+		name: "escaped",
+		line: `\#x`,
+		rel:  "#x",
+		want: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule, ok := parseIgnoreRule(tc.line)
+			if !ok {
+				t.Fatalf("%q isn't parsed", tc.line)
+			}
+
+			if got := rule.match(tc.dir, tc.rel, filepath.Base(tc.rel), tc.isDir); got != tc.want {
+				t.Fatalf("got %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseIgnoreRule(t *testing.T) {
+	testCases := []struct {
+		name       string
+		line       string
+		wantOK     bool
+		wantNegate bool
+	}{{
+		name:   "blank",
+		line:   "  ",
+		wantOK: false,
+	}, {
+		name:   "comment",
+		line:   "# *.log",
+		wantOK: false,
+	}, {
+		name:   "root",
+		line:   "/",
+		wantOK: false,
+	}, {
+		name:       "negate",
+		line:       "!keep.log",
+		wantOK:     true,
+		wantNegate: true,
+	}, {
+		name:       "escaped_negate",
+		line:       `\!x`,
+		wantOK:     true,
+		wantNegate: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule, ok := parseIgnoreRule(tc.line)
+			if ok != tc.wantOK {
+				t.Fatalf("got ok %t, want %t", ok, tc.wantOK)
+			} else if ok && rule.negate != tc.wantNegate {
+				t.Fatalf("got negate %t, want %t", rule.negate, tc.wantNegate)
+			}
+		})
+	}
+}
+
+// writeTree creates the files with contents by their slash-separated paths
+// within root.
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+
+	for rel, content := range files {
+		name := filepath.Join(root, filepath.FromSlash(rel))
+		err := os.MkdirAll(filepath.Dir(name), 0o700)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(name, []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDirs_isHidden(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".gitignore":         "*.log\n!keep.log\nbuild/\n",
+		".env":               "",
+		"a.txt":              "",
+		"a.bak":              "",
+		"x.log":              "",
+		"keep.log":           "",
+		"build/out":          "",
+		"sub/.gitignore":     "/local.txt\n!x.log\n",
+		"sub/local.txt":      "",
+		"sub/x.log":          "",
+		"sub/y.log":          "",
+		"sub/deep/local.txt": "",
+		"secret/file":        "",
+		"other/secret/file":  "",
+	})
+
+	h := newTestDirs(t, &HTTPFSConfig{
+		Root: root,
+		Hide: &HideRules{
+			Patterns:    []string{"*.bak", "/secret"},
+			IgnoreFiles: []string{".gitignore"},
+			Dotfiles:    true,
+		},
+	})
+
+	testCases := []struct {
+		path string
+		want bool
+	}{
+		{path: "/", want: false},
+		{path: "/a.txt", want: false},
+		{path: "/.env", want: true},
+		{path: "/.gitignore", want: true},
+		{path: "/a.bak", want: true},
+		{path: "/x.log", want: true},
+		{path: "/keep.log", want: false},
+		{path: "/build", want: true},
+		{path: "/build/out", want: true},
+		{path: "/sub/local.txt", want: true},
+		{path: "/sub/x.log", want: false},
+		{path: "/sub/y.log", want: true},
+		{path: "/sub/deep/local.txt", want: false},
+		{path: "/secret/file", want: true},
+		{path: "/other/secret/file", want: false},
+		{path: "/missing.log", want: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			if got := h.isHidden(tc.path); got != tc.want {
+				t.Fatalf("got %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDirs_ServeHTTP_hidden(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".env":     "secret",
+		"404.html": "not found page",
+		"a.txt":    "a",
+	})
+
+	h := newTestDirs(t, &HTTPFSConfig{
+		Root:       root,
+		StaticSite: true,
+		Hide:       &HideRules{Dotfiles: true},
+	})
+
+	testCases := []struct {
+		name     string
+		target   string
+		wantCode int
+		wantBody string
+	}{{
+		name:     "visible",
+		target:   "/a.txt",
+		wantCode: http.StatusOK,
+		wantBody: "a",
+	}, {
+		name:     "hidden",
+		target:   "/.env",
+		wantCode: http.StatusNotFound,
+		wantBody: "not found page",
+	}, {
+		name:     "meta",
+		target:   "/" + MetaDir + "/file",
+		wantCode: http.StatusNotFound,
+		wantBody: "not found page",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, tc.target, nil))
+
+			if rw.Code != tc.wantCode {
+				t.Fatalf("got status %d, want %d", rw.Code, tc.wantCode)
+			} else if got := rw.Body.String(); got != tc.wantBody {
+				t.Fatalf("got body %q, want %q", got, tc.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package dirs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+
+	"filesrv/internal/ferrors"
+	"filesrv/internal/fhttp"
+
+	"golang.org/x/exp/slices"
+)
+
+// ErrReadOnly is returned when a modification is requested while those are
+// disabled.
+const ErrReadOnly ferrors.Str = "modifications are disabled"
+
+// versionsKeys are the query parameters of the requests to the file's history.
+var versionsKeys = []string{"history", "versions", "version", "delete", "restore", "purge"}
+
+// History is the data for rendering the history of a file.
+type History struct {
+	// Current describes the current content of the file.  It's nil if the
+	// file is deleted.
+	Current fs.FileInfo `json:"-"`
+
+	// Name is the base name of the file.
+	Name string `json:"name"`
+
+	// Versions are the retained versions of the file, the latest first.
+	Versions []*Version `json:"versions"`
+
+	// Modify is true if the file may be deleted and its versions may be
+	// restored and purged.
+	Modify bool `json:"-"`
+}
+
+// handleVersions handles the requests to the file's history under the cleaned
+// URL path name with settings s.  It returns false if r isn't such a request.
+func (h *dirs) handleVersions(w http.ResponseWriter, r *http.Request, s *dirSettings, name string) (ok bool) {
+	q := r.URL.Query()
+	if r.Method != http.MethodDelete && !slices.ContainsFunc(versionsKeys, q.Has) {
+		return false
+	}
+
+	// The files are moved by the path in the operating system's file system,
+	// so resolve its links according to the policy, like uploads do.
+	osName, err := h.safe.Resolve(name)
+	if err != nil {
+		h.theme.RenderError(w, r, fmt.Errorf("dirs: %w", err))
+
+		return true
+	}
+
+	fi, err := os.Stat(osName)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false
+	} else if fi != nil && fi.IsDir() {
+		// Directories have no history, but may contain the deleted files.
+		if r.Method == http.MethodDelete {
+			h.theme.RenderError(w, r, &fhttp.StatusError{
+				Err:  errors.New("only files can be deleted"),
+				Code: http.StatusBadRequest,
+			})
+
+			return true
+		}
+
+		return false
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		switch {
+		case q.Has("history"):
+			h.serveHistory(w, r, s, name, osName, fi, false)
+		case q.Has("versions"):
+			h.serveHistory(w, r, s, name, osName, fi, true)
+		case q.Has("version"):
+			h.serveVersion(w, r, name, osName, q.Get("version"))
+		default:
+			return false
+		}
+	case http.MethodPost, http.MethodDelete:
+		var action func() error
+		switch {
+		case r.Method == http.MethodDelete, q.Has("delete"):
+			if fi == nil {
+				action = func() error { return fs.ErrNotExist }
+			} else {
+				action = func() error { return h.deleteFile(name) }
+			}
+		case q.Has("restore"):
+			action = func() error { return h.restoreFile(name, osName, q.Get("restore")) }
+		case q.Has("purge"):
+			action = func() error { return h.versions.purge(osName, q.Get("purge")) }
+		default:
+			return false
+		}
+
+		h.modifyVersions(w, r, s, name, action)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// deleteFile moves the file at the cleaned URL path name into the trash.  Like
+// the uploaded files, it's moved relative to its opened directory.
+func (h *dirs) deleteFile(name string) (err error) {
+	dir, base, err := h.safe.OpenParent(name, false)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dir.Close() }()
+
+	return h.versions.retain(dir, base, true)
+}
+
+// restoreFile replaces the file at the cleaned URL path name and the OS path
+// osName with its version id.  The directories deleted along with the file are
+// created again.
+func (h *dirs) restoreFile(name, osName, id string) (err error) {
+	_, _, err = h.versions.path(osName, id)
+	if err != nil {
+		return err
+	}
+
+	dir, base, err := h.safe.OpenParent(name, true)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dir.Close() }()
+
+	return h.versions.restore(dir, base, id)
+}
+
+// modifyVersions performs the action changing the file at the cleaned URL path
+// name with settings s or its versions and redirects the client to the file's
+// history.
+func (h *dirs) modifyVersions(
+	w http.ResponseWriter,
+	r *http.Request,
+	s *dirSettings,
+	name string,
+	action func() error,
+) {
+	fhttp.SetRoute(r, fhttp.RouteUpload)
+
+	if !s.allowUpload {
+		h.theme.RenderError(w, r, &fhttp.StatusError{
+			Err:  ErrReadOnly,
+			Code: http.StatusForbidden,
+		})
+
+		return
+	}
+
+	err := action()
+	if errors.Is(err, ErrNoVersion) {
+		h.theme.RenderError(w, r, &fhttp.StatusError{Err: err, Code: http.StatusNotFound})
+
+		return
+	} else if err != nil {
+		h.theme.RenderError(w, r, fmt.Errorf("dirs: %s: %w", name, err))
+
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+
+		return
+	}
+
+	http.Redirect(w, r, fhttp.BasePath(r)+name+"?history", http.StatusSeeOther)
+}
+
+// serveHistory renders the history of the file at the cleaned URL path name
+// with settings s resolved into osName, described by fi, which is nil if the
+// file is deleted.  If asJSON is true, it responds with JSON instead.
+func (h *dirs) serveHistory(
+	w http.ResponseWriter,
+	r *http.Request,
+	s *dirSettings,
+	name string,
+	osName string,
+	fi fs.FileInfo,
+	asJSON bool,
+) {
+	fhttp.SetRoute(r, fhttp.RouteFile)
+	h.versions.maybeSweep()
+
+	versions, err := h.versions.list(osName)
+	if err != nil {
+		h.theme.RenderError(w, r, fmt.Errorf("dirs: listing versions: %w", err))
+
+		return
+	} else if fi == nil && len(versions) == 0 {
+		h.theme.RenderError(w, r, fs.ErrNotExist)
+
+		return
+	}
+
+	hist := &History{
+		Current:  fi,
+		Name:     path.Base(name),
+		Versions: versions,
+		Modify:   s.allowUpload,
+	}
+	if !asJSON {
+		h.theme.RenderHistory(w, r, hist)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	err = json.NewEncoder(w).Encode(hist)
+	if err != nil {
+		log.Printf("writing versions of %q: %v", name, err)
+	}
+}
+
+// serveVersion serves the content of the version id of the file at the
+// cleaned URL path name resolved into osName.
+func (h *dirs) serveVersion(w http.ResponseWriter, r *http.Request, name, osName, id string) {
+	fhttp.SetRoute(r, fhttp.RouteFile)
+
+	f, err := h.versions.open(osName, id)
+	if errors.Is(err, ErrNoVersion) {
+		h.theme.RenderError(w, r, &fhttp.StatusError{Err: err, Code: http.StatusNotFound})
+
+		return
+	} else if err != nil {
+		h.theme.RenderError(w, r, err)
+
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	d, err := f.Stat()
+	if err != nil {
+		h.theme.RenderError(w, r, err)
+
+		return
+	}
+
+	http.ServeContent(w, r, path.Base(name), d.ModTime(), f)
+}
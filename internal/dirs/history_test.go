@@ -0,0 +1,158 @@
+package dirs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDirs_handleVersions_symlinkEscape(t *testing.T) {
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "root")
+	outside := filepath.Join(tmp, "outside")
+	for _, dir := range []string{root, outside} {
+		err := os.Mkdir(dir, 0o700)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := os.Symlink(filepath.Join("..", "outside"), filepath.Join(root, "out"))
+	if err != nil {
+		t.Skipf("symbolic links aren't supported: %v", err)
+	}
+
+	secret := filepath.Join(outside, "secret.txt")
+	_, _ = writeUploaded(t, outside, "secret.txt", "secret")
+	_, _ = writeUploaded(t, root, "inside.txt", "inside")
+
+	h := newTestDirs(t, &HTTPFSConfig{
+		Root:        root,
+		Versioning:  true,
+		AllowUpload: true,
+	})
+
+	// The version of the file mirroring the escaping path.
+	at := time.Now().Add(-time.Hour)
+	retainAt(t, h.versions.trash, "out/secret.txt", at)
+	id := at.UTC().Format(versionIDLayout)
+
+	testCases := []struct {
+		name     string
+		method   string
+		target   string
+		wantCode int
+	}{{
+		name:     "delete_inside",
+		method:   http.MethodDelete,
+		target:   "/inside.txt",
+		wantCode: http.StatusNoContent,
+	}, {
+		name:     "delete",
+		method:   http.MethodDelete,
+		target:   "/out/secret.txt",
+		wantCode: http.StatusNotFound,
+	}, {
+		name:     "delete_form",
+		method:   http.MethodPost,
+		target:   "/out/secret.txt?delete",
+		wantCode: http.StatusNotFound,
+	}, {
+		name:     "restore",
+		method:   http.MethodPost,
+		target:   "/out/secret.txt?restore=" + id,
+		wantCode: http.StatusNotFound,
+	}, {
+		name:     "history",
+		method:   http.MethodGet,
+		target:   "/out/secret.txt?history",
+		wantCode: http.StatusNotFound,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, httptest.NewRequest(tc.method, tc.target, nil))
+
+			if rw.Code != tc.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rw.Code, tc.wantCode, rw.Body)
+			}
+
+			data, err := os.ReadFile(secret)
+			if err != nil {
+				t.Fatal(err)
+			} else if string(data) != "secret" {
+				t.Fatalf("file outside of the root is replaced with %q", data)
+			}
+		})
+	}
+
+	if got := len(retainedAges(t, h.versions.trash, "out/secret.txt", at)); got != 1 {
+		t.Fatalf("got %d versions through the link, want 1", got)
+	}
+}
+
+func TestDirs_handleVersions_deleteRestore(t *testing.T) {
+	root := t.TempDir()
+	_, _ = writeUploaded(t, root, "file.txt", "file")
+
+	h := newTestDirs(t, &HTTPFSConfig{
+		Root:        root,
+		Versioning:  true,
+		AllowUpload: true,
+	})
+
+	// The file deleted along with its directory.
+	at := time.Now().Add(-time.Hour)
+	retainAt(t, h.versions.trash, "gone/deleted.txt", at)
+	id := at.UTC().Format(versionIDLayout)
+
+	testCases := []struct {
+		name     string
+		method   string
+		target   string
+		file     string
+		wantCode int
+		wantFile bool
+	}{{
+		name:     "delete",
+		method:   http.MethodDelete,
+		target:   "/file.txt",
+		file:     "file.txt",
+		wantCode: http.StatusNoContent,
+		wantFile: false,
+	}, {
+		name:     "restore_unknown",
+		method:   http.MethodPost,
+		target:   "/other/deleted.txt?restore=" + id,
+		file:     "other",
+		wantCode: http.StatusNotFound,
+		wantFile: false,
+	}, {
+		name:     "restore_into_deleted_dir",
+		method:   http.MethodPost,
+		target:   "/gone/deleted.txt?restore=" + id,
+		file:     "gone/deleted.txt",
+		wantCode: http.StatusSeeOther,
+		wantFile: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, httptest.NewRequest(tc.method, tc.target, nil))
+
+			if rw.Code != tc.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rw.Code, tc.wantCode, rw.Body)
+			}
+
+			_, err := os.Lstat(filepath.Join(root, filepath.FromSlash(tc.file)))
+			if exists := err == nil; exists != tc.wantFile {
+				t.Fatalf("file exists: %t, want %t", exists, tc.wantFile)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package dirs
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"filesrv/internal/ferrors"
+	"filesrv/internal/fhttp"
+)
+
+// ErrUploadsBusy is returned when the upload can't be started since too many
+// uploads are already in progress.
+const ErrUploadsBusy ferrors.Str = "too many uploads in progress, try again later"
+
+// retryAfterSec is the value of the Retry-After header sent along with
+// [ErrUploadsBusy], in seconds.
+const retryAfterSec = 10
+
+// semaphore limits the number of concurrent operations.  A nil *semaphore
+// doesn't limit anything.
+type semaphore struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+// newSemaphore returns a new semaphore with n slots waiting for a free slot at
+// most timeout.  It returns nil if n isn't positive.
+func newSemaphore(n int, timeout time.Duration) (s *semaphore) {
+	if n <= 0 {
+		return nil
+	}
+
+	return &semaphore{
+		slots:   make(chan struct{}, n),
+		timeout: timeout,
+	}
+}
+
+// acquire takes a slot, waiting for a free one at most s.timeout.  release
+// must be called to free the slot.  err is a *fhttp.StatusError if no slot is
+// available in time.
+func (s *semaphore) acquire(ctx context.Context) (release func(), err error) {
+	if s == nil {
+		return func() {}, nil
+	}
+
+	release = func() { <-s.slots }
+
+	select {
+	case s.slots <- struct{}{}:
+		return release, nil
+	default:
+		if s.timeout <= 0 {
+			return nil, errBusy()
+		}
+	}
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+
+	select {
+	case s.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, errBusy()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// errBusy returns the error for the case when no upload slot is available.
+func errBusy() (err error) {
+	return &fhttp.StatusError{
+		Err:  ErrUploadsBusy,
+		Code: http.StatusServiceUnavailable,
+	}
+}
@@ -0,0 +1,96 @@
+package dirs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"filesrv/internal/fhttp"
+)
+
+func TestSemaphore_acquire(t *testing.T) {
+	testCases := []struct {
+		wantErr error
+		name    string
+		n       int
+		held    int
+		timeout time.Duration
+	}{{
+		wantErr: nil,
+		name:    "unlimited",
+		n:       0,
+		held:    10,
+	}, {
+		wantErr: nil,
+		name:    "free",
+		n:       2,
+		held:    1,
+	}, {
+		wantErr: ErrUploadsBusy,
+		name:    "busy",
+		n:       1,
+		held:    1,
+	}, {
+		wantErr: ErrUploadsBusy,
+		name:    "busy_timeout",
+		n:       1,
+		held:    1,
+		timeout: 10 * time.Millisecond,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newSemaphore(tc.n, tc.timeout)
+			for i := 0; i < tc.held; i++ {
+				if _, err := s.acquire(context.Background()); err != nil {
+					t.Fatalf("holding slot %d: %v", i, err)
+				}
+			}
+
+			release, err := s.acquire(context.Background())
+			if tc.wantErr == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				release()
+
+				return
+			}
+
+			var statusErr *fhttp.StatusError
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got %v, want %v", err, tc.wantErr)
+			} else if !errors.As(err, &statusErr) || statusErr.Code != http.StatusServiceUnavailable {
+				t.Fatalf("got %v, want status %d", err, http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
+
+func TestSemaphore_acquire_released(t *testing.T) {
+	s := newSemaphore(1, 5*time.Second)
+	release, err := s.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		release()
+	}()
+
+	_, err = s.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("waiting for the released slot: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = s.acquire(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package dirs
+
+import "io/fs"
+
+// linksSupported is true if the number of hard links can be retrieved.
+const linksSupported = false
+
+// linkCount returns false since the number of hard links isn't available on
+// this platform.
+func linkCount(_ fs.FileInfo) (n uint64, ok bool) {
+	return 0, false
+}
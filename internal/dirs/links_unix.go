@@ -0,0 +1,21 @@
+//go:build unix
+
+package dirs
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// linksSupported is true if the number of hard links can be retrieved.
+const linksSupported = true
+
+// linkCount returns the number of hard links to the file described by fi.
+func linkCount(fi fs.FileInfo) (n uint64, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+
+	return uint64(st.Nlink), true
+}
@@ -0,0 +1,227 @@
+package dirs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"unicode"
+
+	"filesrv/internal/ferrors"
+	"filesrv/internal/fhttp"
+
+	"golang.org/x/exp/slices"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// ErrInvalidName is returned when the uploaded file's name is empty or
+	// reserved.
+	ErrInvalidName ferrors.Str = "invalid file name"
+
+	// ErrNameTooLong is returned when the uploaded file's name exceeds the
+	// configured length.
+	ErrNameTooLong ferrors.Str = "file name is too long"
+
+	// ErrTypeForbidden is returned when the uploaded file's extension or
+	// content type isn't allowed.
+	ErrTypeForbidden ferrors.Str = "file type is not allowed"
+)
+
+// sniffLen is the number of bytes used to detect the content type.
+const sniffLen = 512
+
+// UploadPolicy restricts the names and the types of the uploaded files.
+type UploadPolicy struct {
+	// AllowExts are the only allowed extensions of the uploaded files, with
+	// the leading dot.  Empty list allows any extension not in DenyExts.
+	AllowExts []string
+
+	// DenyExts are the forbidden extensions of the uploaded files, with the
+	// leading dot.
+	DenyExts []string
+
+	// MaxNameLength is the maximum length of the uploaded file's name in
+	// bytes.  Zero means no limit.
+	MaxNameLength int
+}
+
+// uploadPolicy is the prepared [UploadPolicy].
+type uploadPolicy struct {
+	allowExts  []string
+	denyExts   []string
+	allowTypes []string
+	denyTypes  []string
+	maxNameLen int
+}
+
+// newUploadPolicy prepares conf for checking the files.  conf may be nil.
+func newUploadPolicy(conf *UploadPolicy) (p *uploadPolicy) {
+	p = &uploadPolicy{}
+	if conf == nil {
+		return p
+	}
+
+	p.maxNameLen = conf.MaxNameLength
+	p.allowExts, p.allowTypes = prepareExts(conf.AllowExts)
+	p.denyExts, p.denyTypes = prepareExts(conf.DenyExts)
+
+	return p
+}
+
+// prepareExts returns the lower-cased exts and the media types known for
+// those.
+func prepareExts(exts []string) (lowered, types []string) {
+	for _, ext := range exts {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		} else if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+
+		lowered = append(lowered, ext)
+		mt := mediaType(mime.TypeByExtension(ext))
+		if mt != "" && !isGenericType(mt) && !slices.Contains(types, mt) {
+			types = append(types, mt)
+		}
+	}
+
+	return lowered, types
+}
+
+// mediaType returns the media type of the Content-Type header value ct
+// without parameters.
+func mediaType(ct string) (mt string) {
+	mt, _, _ = strings.Cut(ct, ";")
+
+	return strings.ToLower(strings.TrimSpace(mt))
+}
+
+// normalizeName returns the normalized name of the uploaded file.  It's
+// composed into NFC, stripped of the control characters and the surrounding
+// spaces, and checked to be valid and short enough.
+func (p *uploadPolicy) normalizeName(name string) (normalized string, err error) {
+	normalized = strings.Map(func(r rune) (res rune) {
+		if unicode.IsControl(r) || unicode.Is(unicode.Bidi_Control, r) || r == unicode.ReplacementChar {
+			return -1
+		}
+
+		return r
+	}, norm.NFC.String(name))
+
+	// Windows silently drops the trailing dots and spaces.
+	normalized = strings.TrimRight(strings.TrimSpace(normalized), ". ")
+
+	switch {
+	case normalized == "", strings.ContainsAny(normalized, `/\`), isReservedName(normalized):
+		return "", fmt.Errorf("%q: %w", name, ErrInvalidName)
+	case p.maxNameLen > 0 && len(normalized) > p.maxNameLen:
+		return "", fmt.Errorf("%q: %w, the limit is %d bytes", name, ErrNameTooLong, p.maxNameLen)
+	default:
+		return normalized, nil
+	}
+}
+
+// normalizePath returns the normalized slash-separated relative path of the
+// uploaded file.  Each of its elements is normalized as a name, so the path
+// can't be absolute or lead outside of the destination directory.
+func (p *uploadPolicy) normalizePath(rel string) (normalized string, err error) {
+	elems := strings.Split(rel, "/")
+	if len(elems) > maxUploadDepth+1 {
+		return "", fmt.Errorf("%q: %w, the limit is %d directories", rel, ErrInvalidName, maxUploadDepth)
+	}
+
+	for i, elem := range elems {
+		elems[i], err = p.normalizeName(elem)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return strings.Join(elems, "/"), nil
+}
+
+// reservedNames are the device names reserved on Windows regardless of the
+// extension.
+var reservedNames = []string{
+	"con", "prn", "aux", "nul",
+	"com1", "com2", "com3", "com4", "com5", "com6", "com7", "com8", "com9",
+	"lpt1", "lpt2", "lpt3", "lpt4", "lpt5", "lpt6", "lpt7", "lpt8", "lpt9",
+}
+
+// isReservedName returns true if name can't be used for a file.
+func isReservedName(name string) (ok bool) {
+	base, _, _ := strings.Cut(strings.ToLower(name), ".")
+
+	return name == "." ||
+		name == ".." ||
+		strings.EqualFold(name, MetaDir) ||
+		slices.Contains(reservedNames, strings.TrimSpace(base))
+}
+
+// checkType returns an error if the extension of name or the content of body
+// isn't allowed.  The content type is detected from the beginning of body,
+// which is left unread.
+func (p *uploadPolicy) checkType(name string, body *bufio.Reader) (err error) {
+	ext := strings.ToLower(path.Ext(name))
+	if slices.Contains(p.denyExts, ext) || (len(p.allowExts) > 0 && !slices.Contains(p.allowExts, ext)) {
+		return errTypeForbidden(fmt.Errorf("%q: %w", name, ErrTypeForbidden))
+	}
+
+	if len(p.denyTypes) == 0 && len(p.allowTypes) == 0 {
+		return nil
+	}
+
+	head, err := body.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("detecting content type: %w", err)
+	}
+
+	mt := mediaType(http.DetectContentType(head))
+	if slices.Contains(p.denyTypes, mt) || !p.typeAllowed(mt) {
+		return errTypeForbidden(fmt.Errorf("%q: %w, detected %s", name, ErrTypeForbidden, mt))
+	}
+
+	return nil
+}
+
+// errTypeForbidden returns the [http.StatusUnsupportedMediaType] error
+// wrapping err.
+func errTypeForbidden(err error) (res error) {
+	return &fhttp.StatusError{Err: err, Code: http.StatusUnsupportedMediaType}
+}
+
+// typeAllowed returns true if the detected media type mt conforms the allowed
+// extensions.  The generic types are always allowed since the detection
+// isn't precise.
+func (p *uploadPolicy) typeAllowed(mt string) (ok bool) {
+	return len(p.allowExts) == 0 || isGenericType(mt) || slices.Contains(p.allowTypes, mt)
+}
+
+// isGenericType returns true if the media type mt says nothing certain about
+// the content.
+func isGenericType(mt string) (ok bool) {
+	return mt == "application/octet-stream" || mt == "text/plain"
+}
+
+// checkFile normalizes the slash-separated relative path of the uploaded file
+// of part and checks it against the policy.  body is the buffered content of
+// part.  The errors are reported to the client.
+func (p *uploadPolicy) checkFile(part *multipart.Part, body *bufio.Reader) (name string, err error) {
+	name, err = p.normalizePath(uploadPath(part))
+	if err != nil {
+		return "", &fhttp.StatusError{Err: err, Code: http.StatusBadRequest}
+	}
+
+	err = p.checkType(name, body)
+	if err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
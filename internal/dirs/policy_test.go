@@ -0,0 +1,303 @@
+package dirs
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filesrv/internal/fhttp"
+)
+
+func TestUploadPolicy_normalizeName(t *testing.T) {
+	p := newUploadPolicy(&UploadPolicy{MaxNameLength: 8})
+
+	testCases := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr error
+	}{{
+		name: "plain",
+		in:   "file.txt",
+		want: "file.txt",
+	}, {
+		name: "nfc",
+		in:   "cafe\u0301",
+		want: "caf\u00e9",
+	}, {
+		name: "control",
+		in:   "a\x00b\u202ec\tx",
+		want: "abcx",
+	}, {
+		name: "trailing_dots_and_spaces",
+		in:   " file. . ",
+		want: "file",
+	}, {
+		name:    "empty",
+		in:      " . ",
+		wantErr: ErrInvalidName,
+	}, {
+		name:    "separator",
+		in:      `a\b`,
+		wantErr: ErrInvalidName,
+	}, {
+		name:    "reserved",
+		in:      "COM1.log",
+		wantErr: ErrInvalidName,
+	}, {
+		name:    "reserved_spaced",
+		in:      "aux .txt",
+		wantErr: ErrInvalidName,
+	}, {
+		name:    "meta_dir",
+		in:      ".FILESRV",
+		wantErr: ErrInvalidName,
+	}, {
+		name:    "too_long",
+		in:      "123456789",
+		wantErr: ErrNameTooLong,
+	}, {
+		name:    "too_long_bytes",
+		in:      "\u00e9\u00e9\u00e9\u00e9\u00e9",
+		wantErr: ErrNameTooLong,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := p.normalizeName(tc.in)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("got %q, %v, want error %v", got, err, tc.wantErr)
+				}
+
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			} else if got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUploadPolicy_checkType(t *testing.T) {
+	const html = "<!DOCTYPE html><html><body>page</body></html>"
+
+	png := string([]byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0, 0, 0, 0})
+
+	testCases := []struct {
+		conf    *UploadPolicy
+		name    string
+		file    string
+		content string
+		wantErr bool
+	}{{
+		conf:    nil,
+		name:    "no_policy",
+		file:    "page.html",
+		content: html,
+		wantErr: false,
+	}, {
+		conf:    &UploadPolicy{DenyExts: []string{".HTML"}},
+		name:    "denied_ext",
+		file:    "page.Html",
+		content: "text",
+		wantErr: true,
+	}, {
+		conf:    &UploadPolicy{DenyExts: []string{"html"}},
+		name:    "denied_content",
+		file:    "page.txt",
+		content: html,
+		wantErr: true,
+	}, {
+		conf:    &UploadPolicy{DenyExts: []string{".html"}},
+		name:    "other_content",
+		file:    "notes.txt",
+		content: "just text",
+		wantErr: false,
+	}, {
+		conf:    &UploadPolicy{AllowExts: []string{".png", ".txt"}},
+		name:    "allowed",
+		file:    "image.png",
+		content: png,
+		wantErr: false,
+	}, {
+		conf:    &UploadPolicy{AllowExts: []string{".png"}},
+		name:    "not_allowed_ext",
+		file:    "image.gif",
+		content: png,
+		wantErr: true,
+	}, {
+		conf:    &UploadPolicy{AllowExts: []string{".png", ".txt"}},
+		name:    "not_allowed_content",
+		file:    "image.png",
+		content: html,
+		wantErr: true,
+	}, {
+		conf:    &UploadPolicy{AllowExts: []string{".png"}},
+		name:    "generic_content",
+		file:    "image.png",
+		content: "\x00\x01\x02",
+		wantErr: false,
+	}, {
+		conf:    &UploadPolicy{DenyExts: []string{".html"}},
+		name:    "empty",
+		file:    "empty.txt",
+		content: "",
+		wantErr: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := bufio.NewReader(strings.NewReader(tc.content))
+			err := newUploadPolicy(tc.conf).checkType(tc.file, body)
+
+			var statusErr *fhttp.StatusError
+			if !tc.wantErr {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if !errors.Is(err, ErrTypeForbidden) {
+				t.Fatalf("got %v, want %v", err, ErrTypeForbidden)
+			} else if !errors.As(err, &statusErr) || statusErr.Code != http.StatusUnsupportedMediaType {
+				t.Fatalf("got %v, want status %d", err, http.StatusUnsupportedMediaType)
+			}
+
+			// The sniffed content must be left for saving.
+			if got, _ := io.ReadAll(body); string(got) != tc.content {
+				t.Fatalf("got content %q, want %q", got, tc.content)
+			}
+		})
+	}
+}
+
+func TestUploadPolicy_normalizePath(t *testing.T) {
+	p := newUploadPolicy(&UploadPolicy{MaxNameLength: 16})
+
+	testCases := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr error
+	}{{
+		name: "plain",
+		in:   "file.txt",
+		want: "file.txt",
+	}, {
+		name: "nested",
+		in:   "dir/sub/file.txt",
+		want: "dir/sub/file.txt",
+	}, {
+		name: "spaces_and_dots",
+		in:   " dir. /file.txt ",
+		want: "dir/file.txt",
+	}, {
+		name:    "dot_dot",
+		in:      "../file.txt",
+		wantErr: ErrInvalidName,
+	}, {
+		name:    "dot_dot_inner",
+		in:      "dir/../../file.txt",
+		wantErr: ErrInvalidName,
+	}, {
+		name:    "dot_dot_spaced",
+		in:      "dir/ .. /file.txt",
+		wantErr: ErrInvalidName,
+	}, {
+		name:    "dots_trimmed_to_empty",
+		in:      ".../file.txt",
+		wantErr: ErrInvalidName,
+	}, {
+		name:    "absolute",
+		in:      "/etc/passwd",
+		wantErr: ErrInvalidName,
+	}, {
+		name:    "empty_element",
+		in:      "dir//file.txt",
+		wantErr: ErrInvalidName,
+	}, {
+		name:    "backslash",
+		in:      `..\file.txt`,
+		wantErr: ErrInvalidName,
+	}, {
+		name:    "windows_drive",
+		in:      `C:\file.txt`,
+		wantErr: ErrInvalidName,
+	}, {
+		name:    "meta_dir",
+		in:      ".filesrv/shares/x.json",
+		wantErr: ErrInvalidName,
+	}, {
+		name:    "reserved",
+		in:      "dir/nul.txt",
+		wantErr: ErrInvalidName,
+	}, {
+		name:    "too_long",
+		in:      "dir/" + strings.Repeat("a", 17),
+		wantErr: ErrNameTooLong,
+	}, {
+		name:    "too_deep",
+		in:      strings.Repeat("d/", maxUploadDepth+1) + "file.txt",
+		wantErr: ErrInvalidName,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := p.normalizePath(tc.in)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("got %q, %v, want error %v", got, err, tc.wantErr)
+				}
+
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			} else if got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// FuzzUploadPolicy_normalizePath checks that no uploaded file's path leads
+// outside of the destination directory.
+func FuzzUploadPolicy_normalizePath(f *testing.F) {
+	for _, seed := range []string{
+		"file.txt",
+		"dir/file.txt",
+		"../file.txt",
+		"dir/../../file.txt",
+		"/abs",
+		`..\..\file.txt`,
+		"C:/file.txt",
+		" ../file.txt",
+		"\u202e../file.txt",
+		"..\x00/file.txt",
+		"./file.txt",
+		"dir/./file.txt",
+	} {
+		f.Add(seed)
+	}
+
+	p := newUploadPolicy(nil)
+	f.Fuzz(func(t *testing.T, in string) {
+		got, err := p.normalizePath(in)
+		if err != nil {
+			return
+		}
+
+		for _, elem := range strings.Split(got, "/") {
+			if elem == "" || elem == "." || elem == ".." || strings.ContainsRune(elem, '\\') {
+				t.Fatalf("%q normalized to %q with element %q", in, got, elem)
+			}
+		}
+
+		if !filepath.IsLocal(filepath.FromSlash(got)) {
+			t.Fatalf("%q normalized to non-local %q", in, got)
+		}
+	})
+}
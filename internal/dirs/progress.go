@@ -0,0 +1,400 @@
+package dirs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"filesrv/internal/ferrors"
+	"filesrv/internal/fhttp"
+)
+
+// Errors of the upload progress tracking.
+const (
+	ErrUploadCanceled ferrors.Str = "upload canceled"
+	ErrBadUploadID    ferrors.Str = "invalid upload id"
+	ErrUploadIDInUse  ferrors.Str = "upload id is already in use"
+	ErrUnknownUpload  ferrors.Str = "no such upload"
+)
+
+const (
+	ukProgress urlKey = "progress"
+	ukCancel   urlKey = "cancel"
+)
+
+// States of an upload.
+const (
+	UploadReceiving = "receiving"
+	UploadSaving    = "saving"
+	UploadDone      = "done"
+	UploadFailed    = "failed"
+	UploadCanceled  = "canceled"
+)
+
+const (
+	// maxUploadIDLen is the maximum length of the upload ID chosen by the
+	// client.
+	maxUploadIDLen = 64
+
+	// progressRetention is the time the progress of the finished upload is
+	// kept for.
+	progressRetention = time.Minute
+)
+
+// UploadProgress is the progress of an upload reported to the client.
+type UploadProgress struct {
+	// State is the state of the upload, one of [UploadReceiving],
+	// [UploadSaving], [UploadDone], [UploadFailed] and [UploadCanceled].
+	State string `json:"state"`
+
+	// Error is the error the upload failed with, if any.
+	Error string `json:"error,omitempty"`
+
+	// Files are the progresses of saving the files, added as those are
+	// received.  It's empty until the first file is received.
+	Files []*FileProgress `json:"files,omitempty"`
+
+	// Received is the number of request body bytes received.
+	Received int64 `json:"received"`
+
+	// Total is the size of the request body or -1 if it's unknown.
+	Total int64 `json:"total"`
+}
+
+// FileProgress is the progress of saving a single uploaded file.
+type FileProgress struct {
+	// Name is the name of the file as sent by the client.
+	Name string `json:"name"`
+
+	// Size is the size of the file in bytes or -1 until it's saved.
+	Size int64 `json:"size"`
+
+	// Written is the number of bytes saved so far.
+	Written int64 `json:"written"`
+}
+
+// upload is the tracked upload.
+type upload struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	// mu protects state, err, finished and files.
+	mu       *sync.Mutex
+	state    string
+	err      string
+	finished time.Time
+	files    []*fileProgress
+
+	// dir is the cleaned URL path of the destination directory.
+	dir string
+
+	// client is the address of the client that started the upload.  Only
+	// that client sees and cancels the upload.
+	client string
+
+	received *atomic.Int64
+	total    int64
+}
+
+// fileProgress is the progress of saving a single file of the upload.
+type fileProgress struct {
+	ctx     context.Context
+	written *atomic.Int64
+	size    *atomic.Int64
+	name    string
+}
+
+// progressTracker keeps the progress of the uploads by their IDs.
+type progressTracker struct {
+	// mu protects uploads.
+	mu      *sync.Mutex
+	uploads map[string]*upload
+}
+
+// newProgressTracker returns a new empty tracker.
+func newProgressTracker() (t *progressTracker) {
+	return &progressTracker{
+		mu:      &sync.Mutex{},
+		uploads: map[string]*upload{},
+	}
+}
+
+// start starts tracking the upload request r with id into the directory at
+// the cleaned URL path dir.  It replaces the body of r with the one counting
+// the received bytes and failing once the upload is canceled.
+func (t *progressTracker) start(r *http.Request, dir, id string) (up *upload, err error) {
+	if !validUploadID(id) {
+		return nil, &fhttp.StatusError{Err: ErrBadUploadID, Code: http.StatusBadRequest}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(time.Now())
+	if _, ok := t.uploads[id]; ok {
+		return nil, &fhttp.StatusError{Err: ErrUploadIDInUse, Code: http.StatusConflict}
+	}
+
+	ctx, cancel := context.WithCancelCause(r.Context())
+	up = &upload{
+		ctx:      ctx,
+		cancel:   cancel,
+		mu:       &sync.Mutex{},
+		state:    UploadReceiving,
+		dir:      dir,
+		client:   clientAddr(r),
+		received: &atomic.Int64{},
+		total:    r.ContentLength,
+	}
+	t.uploads[id] = up
+
+	r.Body = &progressBody{
+		ReadCloser: r.Body,
+		ctx:        ctx,
+		n:          up.received,
+	}
+
+	return up, nil
+}
+
+// validUploadID returns true if id consists of at most [maxUploadIDLen] ASCII
+// letters, digits, dashes and underscores.
+func validUploadID(id string) (ok bool) {
+	if id == "" || len(id) > maxUploadIDLen {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case
+			c >= 'a' && c <= 'z',
+			c >= 'A' && c <= 'Z',
+			c >= '0' && c <= '9',
+			c == '-',
+			c == '_':
+			// Go on.
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// sweep forgets the uploads finished before the retention period.  t.mu must
+// be locked.
+func (t *progressTracker) sweep(now time.Time) {
+	for id, up := range t.uploads {
+		up.mu.Lock()
+		expired := !up.finished.IsZero() && now.Sub(up.finished) > progressRetention
+		up.mu.Unlock()
+
+		if expired {
+			delete(t.uploads, id)
+		}
+	}
+}
+
+// get returns the upload with id into the directory at the cleaned URL path
+// dir started by the client of r, if any.
+func (t *progressTracker) get(r *http.Request, dir, id string) (up *upload, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	up, ok = t.uploads[id]
+	if !ok || up.dir != dir || up.client != clientAddr(r) {
+		return nil, false
+	}
+
+	return up, true
+}
+
+// clientAddr returns the host part of the remote address of r, which is the
+// forwarded one behind the trusted proxies.
+func clientAddr(r *http.Request) (addr string) {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return addr
+}
+
+// saving switches up to saving files and adds the progress fp of the file
+// named name as sent by the client.  It's safe for use with a nil up, in which
+// case fp is nil.
+func (up *upload) saving(name string) (fp *fileProgress) {
+	if up == nil {
+		return nil
+	}
+
+	fp = &fileProgress{
+		ctx:     up.ctx,
+		written: &atomic.Int64{},
+		size:    &atomic.Int64{},
+		name:    name,
+	}
+	fp.size.Store(-1)
+
+	up.mu.Lock()
+	defer up.mu.Unlock()
+
+	up.state = UploadSaving
+	up.files = append(up.files, fp)
+
+	return fp
+}
+
+// saved records the size of the saved file.  It's safe for use with a nil fp.
+func (fp *fileProgress) saved(size int64) {
+	if fp == nil {
+		return
+	}
+
+	fp.size.Store(size)
+}
+
+// finish marks up as finished with err.  It's safe for use with a nil up.
+func (up *upload) finish(err error) {
+	if up == nil {
+		return
+	}
+
+	up.mu.Lock()
+	defer up.mu.Unlock()
+
+	up.finished = time.Now()
+	switch {
+	case errors.Is(context.Cause(up.ctx), ErrUploadCanceled):
+		up.state = UploadCanceled
+	case err != nil:
+		up.state = UploadFailed
+		up.err = err.Error()
+	default:
+		up.state = UploadDone
+	}
+
+	up.cancel(nil)
+}
+
+// progress returns the current progress of up.
+func (up *upload) progress() (p *UploadProgress) {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+
+	p = &UploadProgress{
+		State:    up.state,
+		Error:    up.err,
+		Received: up.received.Load(),
+		Total:    up.total,
+	}
+	for _, fp := range up.files {
+		p.Files = append(p.Files, &FileProgress{
+			Name:    fp.name,
+			Size:    fp.size.Load(),
+			Written: fp.written.Load(),
+		})
+	}
+
+	return p
+}
+
+// reader returns src counting the bytes read into fp and failing once the
+// upload is canceled.  It returns src itself for a nil fp, to keep the copying
+// optimizations.
+func (fp *fileProgress) reader(src io.Reader) (r io.Reader) {
+	if fp == nil {
+		return src
+	}
+
+	return &progressReader{
+		Reader: src,
+		ctx:    fp.ctx,
+		n:      fp.written,
+	}
+}
+
+// progressReader counts the bytes read and fails once ctx is canceled.
+type progressReader struct {
+	io.Reader
+	ctx context.Context
+	n   *atomic.Int64
+}
+
+// Read implements the [io.Reader] interface for *progressReader.
+func (r *progressReader) Read(p []byte) (n int, err error) {
+	if r.ctx.Err() != nil {
+		return 0, context.Cause(r.ctx)
+	}
+
+	n, err = r.Reader.Read(p)
+	r.n.Add(int64(n))
+
+	return n, err
+}
+
+// progressBody is the request body counting the bytes read and failing once
+// ctx is canceled.
+type progressBody struct {
+	io.ReadCloser
+	ctx context.Context
+	n   *atomic.Int64
+}
+
+// Read implements the [io.Reader] interface for *progressBody.
+func (b *progressBody) Read(p []byte) (n int, err error) {
+	if b.ctx.Err() != nil {
+		return 0, context.Cause(b.ctx)
+	}
+
+	n, err = b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+
+	return n, err
+}
+
+// serveProgress writes the progress of the upload with id into the directory
+// at the cleaned URL path dir as JSON.
+func (h *dirs) serveProgress(w http.ResponseWriter, r *http.Request, dir, id string) {
+	up, ok := h.progress.get(r, dir, id)
+	if !ok {
+		h.theme.RenderError(w, r, &fhttp.StatusError{Err: ErrUnknownUpload, Code: http.StatusNotFound})
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	err := json.NewEncoder(w).Encode(up.progress())
+	if err != nil {
+		log.Printf("dirs: writing upload progress: %v", err)
+	}
+}
+
+// cancelUpload cancels the upload with id into the directory at the cleaned
+// URL path dir with settings s.  The partially written files are removed.
+func (h *dirs) cancelUpload(w http.ResponseWriter, r *http.Request, s *dirSettings, dir, id string) {
+	if !s.allowUpload {
+		h.theme.RenderError(w, r, &fhttp.StatusError{Err: ErrUploadForbidden, Code: http.StatusForbidden})
+
+		return
+	}
+
+	up, ok := h.progress.get(r, dir, id)
+	if !ok {
+		h.theme.RenderError(w, r, &fhttp.StatusError{Err: ErrUnknownUpload, Code: http.StatusNotFound})
+
+		return
+	}
+
+	up.cancel(&fhttp.StatusError{Err: ErrUploadCanceled, Code: http.StatusConflict})
+	w.WriteHeader(http.StatusNoContent)
+}
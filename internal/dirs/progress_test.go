@@ -0,0 +1,203 @@
+package dirs
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"filesrv/internal/fhttp"
+)
+
+// newClientRequest returns the request with method to target sent from the
+// remote address addr.
+func newClientRequest(method, target, addr string) (r *http.Request) {
+	r = httptest.NewRequest(method, target, nil)
+	r.RemoteAddr = addr
+
+	return r
+}
+
+func TestProgressTracker(t *testing.T) {
+	const (
+		id     = "f00d"
+		dir    = "/up"
+		client = "192.0.2.1:1234"
+	)
+
+	tr := newProgressTracker()
+	up, err := tr.start(newClientRequest(http.MethodPost, dir+"/?upload", client), dir, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name   string
+		dir    string
+		id     string
+		client string
+		wantOK bool
+	}{{
+		name:   "same_client",
+		dir:    dir,
+		id:     id,
+		client: client,
+		wantOK: true,
+	}, {
+		name:   "same_host",
+		dir:    dir,
+		id:     id,
+		client: "192.0.2.1:4321",
+		wantOK: true,
+	}, {
+		name:   "other_client",
+		dir:    dir,
+		id:     id,
+		client: "192.0.2.2:1234",
+		wantOK: false,
+	}, {
+		name:   "other_dir",
+		dir:    "/other",
+		id:     id,
+		client: client,
+		wantOK: false,
+	}, {
+		name:   "unknown_id",
+		dir:    dir,
+		id:     "beef",
+		client: client,
+		wantOK: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := tr.get(newClientRequest(http.MethodGet, tc.dir+"/", tc.client), tc.dir, tc.id)
+			if ok != tc.wantOK {
+				t.Fatalf("got ok %t, want %t", ok, tc.wantOK)
+			} else if ok && got != up {
+				t.Errorf("got upload %p, want %p", got, up)
+			}
+		})
+	}
+
+	t.Run("in_use", func(t *testing.T) {
+		_, err = tr.start(newClientRequest(http.MethodPost, dir+"/?upload", client), dir, id)
+
+		var statusErr *fhttp.StatusError
+		if !errors.As(err, &statusErr) || statusErr.Code != http.StatusConflict {
+			t.Errorf("got error %v, want conflict", err)
+		}
+	})
+
+	t.Run("invalid_id", func(t *testing.T) {
+		_, err = tr.start(newClientRequest(http.MethodPost, dir+"/?upload", client), dir, "no/slashes")
+
+		var statusErr *fhttp.StatusError
+		if !errors.As(err, &statusErr) || statusErr.Code != http.StatusBadRequest {
+			t.Errorf("got error %v, want bad request", err)
+		}
+	})
+
+	t.Run("cleanup", func(t *testing.T) {
+		up.finish(nil)
+		if p := up.progress(); p.State != UploadDone {
+			t.Fatalf("got state %q, want %q", p.State, UploadDone)
+		}
+
+		tr.mu.Lock()
+		tr.sweep(time.Now())
+		_, kept := tr.uploads[id]
+		tr.sweep(time.Now().Add(progressRetention + time.Second))
+		_, left := tr.uploads[id]
+		tr.mu.Unlock()
+
+		if !kept {
+			t.Error("got upload forgotten within the retention period")
+		} else if left {
+			t.Error("got upload kept after the retention period")
+		}
+
+		// The ID is free again.
+		_, err = tr.start(newClientRequest(http.MethodPost, dir+"/?upload", client), dir, id)
+		if err != nil {
+			t.Errorf("starting again: %v", err)
+		}
+	})
+}
+
+func TestDirs_serveProgress(t *testing.T) {
+	const client = "192.0.2.1:1234"
+
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"up/.keep": "",
+	})
+
+	h := newTestDirs(t, &HTTPFSConfig{
+		Root:        root,
+		AllowUpload: true,
+	})
+
+	r := newUploadRequest(t, "/up/", map[string]string{"a.txt": "abc"})
+	r.URL.RawQuery += "&" + string(ukProgress) + "=f00d"
+	r.RemoteAddr = client
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, r)
+	if rw.Code != http.StatusSeeOther {
+		t.Fatalf("uploading: got status %d, want %d: %s", rw.Code, http.StatusSeeOther, rw.Body)
+	}
+
+	testCases := []struct {
+		name     string
+		r        *http.Request
+		wantCode int
+	}{{
+		name:     "owner",
+		r:        newClientRequest(http.MethodGet, "/up/?progress=f00d", client),
+		wantCode: http.StatusOK,
+	}, {
+		name:     "other_client",
+		r:        newClientRequest(http.MethodGet, "/up/?progress=f00d", "192.0.2.2:1234"),
+		wantCode: http.StatusNotFound,
+	}, {
+		name:     "other_client_cancel",
+		r:        newClientRequest(http.MethodPost, "/up/?cancel=f00d", "192.0.2.2:1234"),
+		wantCode: http.StatusNotFound,
+	}, {
+		name:     "other_dir",
+		r:        newClientRequest(http.MethodGet, "/?progress=f00d", client),
+		wantCode: http.StatusNotFound,
+	}, {
+		name:     "unknown_id",
+		r:        newClientRequest(http.MethodGet, "/up/?progress=beef", client),
+		wantCode: http.StatusNotFound,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rw = httptest.NewRecorder()
+			h.ServeHTTP(rw, tc.r)
+
+			if rw.Code != tc.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rw.Code, tc.wantCode, rw.Body)
+			} else if rw.Code != http.StatusOK {
+				return
+			}
+
+			p := &UploadProgress{}
+			err := json.NewDecoder(rw.Body).Decode(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if p.State != UploadDone {
+				t.Errorf("got state %q, want %q", p.State, UploadDone)
+			} else if len(p.Files) != 1 || p.Files[0].Name != "a.txt" || p.Files[0].Size != 3 {
+				t.Errorf("got files %+v, want a.txt of 3 bytes", p.Files)
+			}
+		})
+	}
+}
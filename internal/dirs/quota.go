@@ -0,0 +1,367 @@
+package dirs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"filesrv/internal/ferrors"
+	"filesrv/internal/fhttp"
+
+	"github.com/c2h5oh/datasize"
+)
+
+const (
+	// ErrRequestTooLarge is returned when the upload request body exceeds
+	// the configured limit.
+	ErrRequestTooLarge ferrors.Str = "upload request is too large"
+
+	// ErrFileTooLarge is returned when an uploaded file exceeds the
+	// configured limit.
+	ErrFileTooLarge ferrors.Str = "file is too large"
+
+	// ErrQuotaExceeded is returned when the upload would exceed the quota of
+	// the destination directory.
+	ErrQuotaExceeded ferrors.Str = "directory quota exceeded"
+
+	// ErrTooManyFiles is returned when the upload would exceed the maximum
+	// number of files in the destination directory.
+	ErrTooManyFiles ferrors.Str = "too many files in directory"
+
+	// ErrNoSpace is returned when the upload would leave less free space on
+	// the disk than configured.
+	ErrNoSpace ferrors.Str = "not enough free disk space"
+)
+
+// maxFormMemory is the number of bytes the non-file fields of the multipart
+// form may take in memory.  It's the same as the default one of
+// [http.Request.FormFile].
+const maxFormMemory = 32 << 20
+
+// Capacity is the remaining upload capacity of a directory.
+type Capacity struct {
+	// Bytes is the number of bytes that may still be uploaded into the
+	// directory.  It's nil if there is no limit or it's unknown.
+	Bytes *int64 `json:"bytes,omitempty"`
+
+	// Files is the number of files that may still be uploaded into the
+	// directory.  It's nil if there is no limit.
+	Files *int64 `json:"files,omitempty"`
+
+	// MaxFileSize is the maximum size of a single uploaded file.  Zero means
+	// no limit.
+	MaxFileSize int64 `json:"max_file_size,omitempty"`
+}
+
+// errTooLarge returns the [http.StatusRequestEntityTooLarge] error wrapping
+// err with the human-readable limit appended.
+func errTooLarge(err error, limit int64) (res error) {
+	return &fhttp.StatusError{
+		Err:  fmt.Errorf("%w, the limit is %s", err, datasize.ByteSize(limit).HumanReadable()),
+		Code: http.StatusRequestEntityTooLarge,
+	}
+}
+
+// errNoSpace returns the [http.StatusInsufficientStorage] error for the case
+// when only left bytes may be written.
+func errNoSpace(left int64) (err error) {
+	return &fhttp.StatusError{
+		Err:  fmt.Errorf("%w, %s left", ErrNoSpace, datasize.ByteSize(left).HumanReadable()),
+		Code: http.StatusInsufficientStorage,
+	}
+}
+
+// limitBody limits the body of the upload request r to the configured size.
+// It fails early if the declared content length already exceeds the limit.
+func (h *dirs) limitBody(w http.ResponseWriter, r *http.Request) (err error) {
+	if h.maxRequestSize <= 0 {
+		return nil
+	} else if r.ContentLength > h.maxRequestSize {
+		return errTooLarge(ErrRequestTooLarge, h.maxRequestSize)
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxRequestSize)
+
+	return nil
+}
+
+// asTooLarge converts the error of reading the limited request body into the
+// [http.StatusRequestEntityTooLarge] error, if it's the case.
+func asTooLarge(err error) (res error) {
+	var mbErr *http.MaxBytesError
+	if errors.As(err, &mbErr) {
+		return errTooLarge(ErrRequestTooLarge, mbErr.Limit)
+	}
+
+	return err
+}
+
+// dirUsageCount is the usage of a directory with quotas, counted from the
+// start of an upload.
+type dirUsageCount struct {
+	size  int64
+	files int64
+}
+
+// newDirUsageCount returns the current usage of the directory dir with
+// settings s.  It returns nil if the directory has no quotas.
+func newDirUsageCount(s *dirSettings, dir string) (c *dirUsageCount, err error) {
+	if s.dirQuota <= 0 && s.dirMaxFiles <= 0 {
+		return nil, nil
+	}
+
+	size, n, err := dirUsage(dir)
+	if err != nil {
+		return nil, fmt.Errorf("checking quota: %w", err)
+	}
+
+	return &dirUsageCount{size: size, files: n}, nil
+}
+
+// add counts the saved file of size.  It's safe for use with a nil c.
+func (c *dirUsageCount) add(size int64) {
+	if c == nil {
+		return
+	}
+
+	c.size += size
+	c.files++
+}
+
+// spaceCheckInterval is the number of bytes of an uploaded file written
+// between the checks of the free disk space.
+const spaceCheckInterval = 8 << 20
+
+// limitReader reads an uploaded file and fails with err once it exceeds left
+// bytes.  It also fails once the free disk space of dir drops below minFree,
+// checking it every [spaceCheckInterval] bytes, since the other uploads may be
+// written simultaneously.
+type limitReader struct {
+	io.Reader
+	err       error
+	dir       string
+	left      int64
+	minFree   int64
+	unchecked int64
+}
+
+// newLimitReader returns the reader of the file of the upload u from src
+// limited by the per-file limit, the quotas of the destination directory and
+// the free disk space, whichever is the least.  It fails right away if the
+// directory already has the maximum number of files.
+func (h *dirs) newLimitReader(u *fileUpload, src io.Reader) (lr *limitReader, err error) {
+	s := u.settings
+	lr = &limitReader{
+		Reader:  src,
+		dir:     u.dst.Name(),
+		left:    math.MaxInt64,
+		minFree: h.minFreeSpace,
+	}
+
+	if s.maxUploadSize > 0 {
+		lr.left, lr.err = s.maxUploadSize, errTooLarge(ErrFileTooLarge, s.maxUploadSize)
+	}
+
+	if c := u.usage; c != nil {
+		if s.dirMaxFiles > 0 && c.files >= s.dirMaxFiles {
+			return nil, &fhttp.StatusError{
+				Err:  fmt.Errorf("%w, the limit is %d", ErrTooManyFiles, s.dirMaxFiles),
+				Code: http.StatusRequestEntityTooLarge,
+			}
+		}
+
+		if left := nonNegative(s.dirQuota - c.size); s.dirQuota > 0 && left < lr.left {
+			lr.left, lr.err = left, errTooLarge(ErrQuotaExceeded, s.dirQuota)
+		}
+	}
+
+	left, ok, err := h.spaceLeft(u.dst.Name())
+	if err != nil {
+		return nil, fmt.Errorf("checking free space: %w", err)
+	} else if ok && left < lr.left {
+		lr.left, lr.err = left, errNoSpace(left)
+	}
+
+	return lr, nil
+}
+
+// Read implements the [io.Reader] interface for *limitReader.
+func (lr *limitReader) Read(p []byte) (n int, err error) {
+	// Read a single byte over the limit to tell if it's exceeded.
+	if int64(len(p)) > lr.left {
+		p = p[:lr.left+1]
+	}
+
+	n, err = lr.Reader.Read(p)
+	if int64(n) > lr.left {
+		return int(lr.left), lr.err
+	}
+
+	lr.left -= int64(n)
+	lr.unchecked += int64(n)
+	if lr.unchecked >= spaceCheckInterval {
+		lr.unchecked = 0
+
+		spaceErr := checkMinFree(lr.dir, lr.minFree)
+		if spaceErr != nil {
+			return n, spaceErr
+		}
+	}
+
+	return n, err
+}
+
+// checkMinFree returns an error if the free disk space of dir is less than
+// minFree.
+func checkMinFree(dir string, minFree int64) (err error) {
+	free, ok, err := freeSpace(dir)
+	if err != nil {
+		return fmt.Errorf("checking free space: %w", err)
+	} else if ok && free < minFree {
+		return errNoSpace(0)
+	}
+
+	return nil
+}
+
+// spaceLeft returns the number of bytes that may be written into dir without
+// violating the minimum free disk space.  ok is false if it's unknown.
+func (h *dirs) spaceLeft(dir string) (left int64, ok bool, err error) {
+	free, ok, err := freeSpace(dir)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+
+	left = free - h.minFreeSpace
+	if left < 0 {
+		left = 0
+	}
+
+	return left, true, nil
+}
+
+// capacity returns the remaining upload capacity of the directory at the
+// cleaned URL path name with settings s.  The directory is resolved like the
+// uploads resolve their destination, so that the capacity is of the same
+// directory the files are written into.
+func (h *dirs) capacity(s *dirSettings, name string) (c *Capacity, err error) {
+	dir, err := h.safe.Resolve(name)
+	if err != nil {
+		return nil, err
+	}
+
+	c = &Capacity{
+		MaxFileSize: s.maxUploadSize,
+	}
+
+	left, ok, err := h.spaceLeft(dir)
+	if err != nil {
+		return nil, fmt.Errorf("checking free space: %w", err)
+	} else if ok {
+		c.Bytes = &left
+	}
+
+	if s.dirQuota <= 0 && s.dirMaxFiles <= 0 {
+		return c, nil
+	}
+
+	size, n, err := dirUsage(dir)
+	if err != nil {
+		return nil, fmt.Errorf("checking quota: %w", err)
+	}
+
+	if s.dirQuota > 0 {
+		quotaLeft := nonNegative(s.dirQuota - size)
+		if c.Bytes == nil || quotaLeft < *c.Bytes {
+			c.Bytes = &quotaLeft
+		}
+	}
+
+	if s.dirMaxFiles > 0 {
+		filesLeft := nonNegative(s.dirMaxFiles - n)
+		c.Files = &filesLeft
+	}
+
+	return c, nil
+}
+
+// nonNegative returns n or zero if n is negative.
+func nonNegative(n int64) (res int64) {
+	if n < 0 {
+		return 0
+	}
+
+	return n
+}
+
+// serveCapacity responds with the JSON-encoded remaining upload capacity of
+// the directory at the cleaned URL path name with settings s.
+func (h *dirs) serveCapacity(w http.ResponseWriter, r *http.Request, s *dirSettings, name string) {
+	if !s.allowUpload {
+		h.theme.RenderError(w, r, &fhttp.StatusError{
+			Err:  ErrUploadForbidden,
+			Code: http.StatusForbidden,
+		})
+
+		return
+	}
+
+	c, err := h.capacity(s, name)
+	if err != nil {
+		h.theme.RenderError(w, r, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	err = json.NewEncoder(w).Encode(c)
+	if err != nil {
+		log.Printf("writing capacity of %q: %v", name, err)
+	}
+}
+
+// dirUsage returns the total size and the number of the regular files within
+// the directory dir and its subdirectories, so that the folder uploads creating
+// new subdirectories are charged to dir as well.  The metadata directory isn't
+// counted, and the symbolic links aren't followed.
+func dirUsage(dir string) (size, n int64, err error) {
+	err = filepath.WalkDir(dir, func(p string, e fs.DirEntry, walkErr error) (err error) {
+		if walkErr != nil {
+			if p != dir && errors.Is(walkErr, fs.ErrNotExist) {
+				// Removed meanwhile.
+				return nil
+			}
+
+			return walkErr
+		} else if e.IsDir() && p != dir && strings.EqualFold(e.Name(), MetaDir) {
+			return fs.SkipDir
+		} else if !e.Type().IsRegular() {
+			return nil
+		}
+
+		fi, err := e.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		size += fi.Size()
+		n++
+
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return size, n, nil
+}
@@ -0,0 +1,199 @@
+package dirs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filesrv/internal/ferrors"
+)
+
+func TestLimitReader(t *testing.T) {
+	const errLimit ferrors.Str = "limit"
+
+	testCases := []struct {
+		wantErr     error
+		name        string
+		size        int
+		left        int64
+		minFree     int64
+		wantWritten int64
+	}{{
+		wantErr:     nil,
+		name:        "under",
+		size:        10,
+		left:        11,
+		wantWritten: 10,
+	}, {
+		wantErr:     nil,
+		name:        "exact",
+		size:        10,
+		left:        10,
+		wantWritten: 10,
+	}, {
+		wantErr:     errLimit,
+		name:        "over",
+		size:        11,
+		left:        10,
+		wantWritten: 10,
+	}, {
+		wantErr:     errLimit,
+		name:        "zero",
+		size:        1,
+		left:        0,
+		wantWritten: 0,
+	}, {
+		wantErr:     nil,
+		name:        "unlimited",
+		size:        spaceCheckInterval + 1,
+		left:        math.MaxInt64,
+		wantWritten: spaceCheckInterval + 1,
+	}, {
+		wantErr:     ErrNoSpace,
+		name:        "no_space",
+		size:        spaceCheckInterval + 1,
+		left:        math.MaxInt64,
+		minFree:     math.MaxInt64,
+		wantWritten: spaceCheckInterval,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lr := &limitReader{
+				Reader:  bytes.NewReader(make([]byte, tc.size)),
+				err:     errLimit,
+				dir:     t.TempDir(),
+				left:    tc.left,
+				minFree: tc.minFree,
+			}
+
+			if tc.minFree > 0 {
+				if _, ok, _ := freeSpace(lr.dir); !ok {
+					t.Skip("free space is unknown")
+				}
+			}
+
+			written, err := io.Copy(io.Discard, lr)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			} else if written != tc.wantWritten {
+				t.Fatalf("got %d bytes written, want %d", written, tc.wantWritten)
+			}
+		})
+	}
+}
+
+func TestDirUsage(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"a.txt":                 "12",
+		"sub/b.txt":             "123",
+		"sub/deep/c.txt":        "1234",
+		MetaDir + "/blobs/x":    "12345",
+		"sub/" + MetaDir + "/y": "123456",
+	})
+
+	outside := t.TempDir()
+	writeTree(t, outside, map[string]string{"big.txt": "1234567"})
+	err := os.Symlink(outside, filepath.Join(dir, "link"))
+	if err != nil {
+		t.Logf("symbolic links aren't supported: %v", err)
+	}
+
+	size, n, err := dirUsage(dir)
+	if err != nil {
+		t.Fatal(err)
+	} else if size != 9 || n != 3 {
+		t.Fatalf("got %d bytes in %d files, want 9 bytes in 3 files", size, n)
+	}
+}
+
+func TestDirs_handleUpload_folderQuota(t *testing.T) {
+	testCases := []struct {
+		name     string
+		conf     *HTTPFSConfig
+		files    map[string]string
+		wantCode int
+		wantN    int64
+	}{{
+		name:     "max_files",
+		conf:     &HTTPFSConfig{DirMaxFiles: 2},
+		files:    map[string]string{"new/x/1.txt": "1", "new/y/2.txt": "2"},
+		wantCode: http.StatusRequestEntityTooLarge,
+		wantN:    2,
+	}, {
+		name:     "quota",
+		conf:     &HTTPFSConfig{DirQuota: 4},
+		files:    map[string]string{"new/x/1.txt": "12", "new/y/2.txt": "12"},
+		wantCode: http.StatusRequestEntityTooLarge,
+		wantN:    2,
+	}, {
+		name:     "within",
+		conf:     &HTTPFSConfig{DirMaxFiles: 3, DirQuota: 5},
+		files:    map[string]string{"new/x/1.txt": "1", "new/y/2.txt": "2"},
+		wantCode: http.StatusSeeOther,
+		wantN:    3,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeTree(t, root, map[string]string{"box/sub/a.txt": "12"})
+
+			tc.conf.Root = root
+			tc.conf.AllowUpload = true
+			h := newTestDirs(t, tc.conf)
+
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, newUploadRequest(t, "/box/", tc.files))
+			if rw.Code != tc.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rw.Code, tc.wantCode, rw.Body)
+			}
+
+			_, n, err := dirUsage(filepath.Join(root, "box"))
+			if err != nil {
+				t.Fatal(err)
+			} else if n != tc.wantN {
+				t.Fatalf("got %d files, want %d", n, tc.wantN)
+			}
+		})
+	}
+}
+
+func TestDirs_serveCapacity_symlink(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"real/a.txt": "12345"})
+	err := os.Symlink("real", filepath.Join(root, "link"))
+	if err != nil {
+		t.Skipf("symbolic links aren't supported: %v", err)
+	}
+
+	h := newTestDirs(t, &HTTPFSConfig{
+		Root:        root,
+		AllowUpload: true,
+		DirQuota:    10,
+	})
+
+	for _, target := range []string{"/real/?capacity", "/link/?capacity"} {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, target, nil))
+		if rw.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d: %s", target, rw.Code, rw.Body)
+		}
+
+		c := &Capacity{}
+		err = json.Unmarshal(rw.Body.Bytes(), c)
+		if err != nil {
+			t.Fatal(err)
+		} else if c.Bytes == nil || *c.Bytes != 5 {
+			t.Fatalf("%s: got capacity %s, want 5 bytes", target, rw.Body)
+		}
+	}
+}
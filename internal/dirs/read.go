@@ -3,50 +3,157 @@ package dirs
 import (
 	"fmt"
 	"io/fs"
+	"log"
 	"net/http"
 	"path"
 	"strings"
 	"time"
+
+	"filesrv/internal/fhttp"
 )
 
-// handleDir reads the directory and marshals the entries via the template.
-func (h *dirs) handleDir(w http.ResponseWriter, r *http.Request, f http.File, d fs.FileInfo) {
-	mtime := d.ModTime()
+// handleDir reads the directory with settings s and marshals the entries via
+// the template.
+func (h *dirs) handleDir(w http.ResponseWriter, r *http.Request, s *dirSettings, f http.File, d fs.FileInfo) {
+	name := path.Clean(r.URL.Path)
+	_, isDropBox := h.dropBoxOf(r, s, name)
 
 	switch r.Method {
 	case http.MethodGet, http.MethodHead:
-		ims := r.Header.Get("If-Modified-Since")
-		if ims != "" && !isZeroTime(mtime) {
-			t, err := http.ParseTime(ims)
-			if err == nil && !mtime.After(t) && mtime.Truncate(time.Second).Compare(t) <= 0 {
-				writeUnmodified(w)
+		fhttp.SetRoute(r, fhttp.RouteListing)
+		if r.URL.Query().Has("capacity") {
+			h.serveCapacity(w, r, s, name)
 
-				return
-			}
+			return
+		} else if r.URL.Query().Has(ukProgress) {
+			h.serveProgress(w, r, name, r.URL.Query().Get(ukProgress))
+
+			return
+		} else if isDropBox {
+			h.serveDropBox(w, r, s, name)
+
+			return
+		} else if h.watches != nil && r.URL.Query().Has("watch") {
+			h.serveWatch(w, r, name)
+
+			return
 		}
 	case http.MethodPost:
-		err := h.handleUpload(w, r, d.Name())
+		fhttp.SetRoute(r, fhttp.RouteUpload)
+		if r.URL.Query().Has(ukCancel) {
+			h.cancelUpload(w, r, s, name, r.URL.Query().Get(ukCancel))
+
+			return
+		}
+
+		n, err := h.handleUpload(w, r, s, name, isDropBox)
 		if err != nil {
 			h.theme.RenderError(w, r, err)
+		} else if isDropBox {
+			http.Redirect(w, r, receivedURL(fhttp.BasePath(r)+r.URL.Path, n), http.StatusSeeOther)
 		} else {
-			http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+			http.Redirect(w, r, fhttp.BasePath(r)+r.URL.Path, http.StatusSeeOther)
 		}
 
 		return
 	}
 
+	entries, err := h.readdir(r, s, f)
+	if err != nil {
+		h.theme.RenderError(w, r, fmt.Errorf("reading directory: %w", err))
+
+		return
+	}
+
+	l := &Listing{
+		Entries:    entries,
+		Upload:     s.allowUpload,
+		Versioning: h.versions != nil,
+		Watch:      h.watches != nil,
+		SortBy:     s.sortBy,
+	}
+	if l.Upload {
+		l.Capacity, err = h.capacity(s, r.URL.Path)
+		if err != nil {
+			log.Printf("dirs: getting capacity: %v", err)
+		}
+	}
+
+	if l.Versioning {
+		h.versions.maybeSweep()
+		var osDir string
+		osDir, err = h.safe.Resolve(r.URL.Path)
+		if err == nil {
+			l.Deleted, err = h.versions.deleted(osDir)
+		}
+
+		if err != nil {
+			log.Printf("dirs: listing deleted files: %v", err)
+		}
+
+		l.Deleted = h.visibleNames(name, l.Deleted)
+	}
+
+	// The directory's modification time doesn't change when the contents of
+	// its files do, so use the latest of all.
+	mtime := latestModTime(d, entries)
 	if !isZeroTime(mtime) {
 		w.Header().Set("Last-Modified", mtime.UTC().Format(http.TimeFormat))
 	}
+	// The default sorting changes the rendered listing as well.
+	query := r.URL.Query()
+	if !query.Has(ukSortBy) && s.sortBy != "" {
+		query.Set(ukSortBy, s.sortBy)
+	}
 
-	entries, err := h.readdir(r, f)
-	if err != nil {
-		h.theme.RenderError(w, r, fmt.Errorf("reading directory: %w", err))
+	etag := listingETag(l, query, isDropBox)
+	w.Header().Set("Etag", etag)
+
+	if isUnmodified(r, etag, mtime) {
+		writeUnmodified(w)
 
 		return
 	}
 
-	h.theme.Render(w, r, entries)
+	h.theme.Render(w, r, l)
+}
+
+// latestModTime returns the latest modification time of d and entries.
+func latestModTime(d fs.FileInfo, entries []fs.FileInfo) (mtime time.Time) {
+	mtime = d.ModTime()
+	for _, e := range entries {
+		if emt := e.ModTime(); emt.After(mtime) {
+			mtime = emt
+		}
+	}
+
+	return mtime
+}
+
+// isUnmodified returns true if the conditional request r should be responded
+// with [http.StatusNotModified] given the entity tag and the modification time
+// of the resource.  It follows the precedence described in RFC 9110 Section
+// 13.2.2.
+func isUnmodified(r *http.Request, etag string, mtime time.Time) (ok bool) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		// Go on.
+	default:
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagWeakMatch(inm, etag)
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || isZeroTime(mtime) {
+		return false
+	}
+
+	t, err := http.ParseTime(ims)
+
+	return err == nil && !mtime.Truncate(time.Second).After(t)
 }
 
 type doubleDot struct {
@@ -62,12 +169,19 @@ func (c *doubleDot) ModTime() time.Time { return c.modTime }
 func (c *doubleDot) IsDir() bool        { return true }
 func (c *doubleDot) Sys() any           { return nil }
 
-func (h *dirs) readdir(r *http.Request, f http.File) (entries []fs.FileInfo, err error) {
+func (h *dirs) readdir(r *http.Request, s *dirSettings, f http.File) (entries []fs.FileInfo, err error) {
 	entries, err = f.Readdir(-1)
 	if err != nil {
 		return nil, fmt.Errorf("reading directory: %w", err)
 	}
 
+	name := path.Clean(r.URL.Path)
+	if name == "/" {
+		entries = withoutMetaDir(entries)
+	}
+	entries = h.visible(name, entries)
+	entries = withoutSiteFiles(s.siteRoot, name, entries)
+
 	if parentPath := path.Dir(strings.TrimRight(r.URL.Path, "/")); parentPath != "." {
 		var parent http.File
 		parent, err = h.fsys.Open(parentPath)
@@ -97,6 +211,19 @@ func (h *dirs) readdir(r *http.Request, f http.File) (entries []fs.FileInfo, err
 	return entries, nil
 }
 
+// withoutMetaDir returns entries of the root directory without the metadata
+// directory.  It modifies entries.
+func withoutMetaDir(entries []fs.FileInfo) (res []fs.FileInfo) {
+	res = entries[:0]
+	for _, e := range entries {
+		if !strings.EqualFold(e.Name(), MetaDir) {
+			res = append(res, e)
+		}
+	}
+
+	return res
+}
+
 // writeUnmodified writes a [http.StatusNotModified] response.
 func writeUnmodified(w http.ResponseWriter) {
 	// RFC 7232 section 4.1:
@@ -0,0 +1,487 @@
+package dirs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"filesrv/internal/fhttp"
+
+	"golang.org/x/exp/slices"
+)
+
+// Names of the special files of a static site, looked up in its root.
+const (
+	// siteNotFound is the page served with [http.StatusNotFound] for the
+	// missing files.
+	siteNotFound = "404.html"
+
+	// siteHeaders lists the custom response headers in the Netlify format.
+	siteHeaders = "_headers"
+
+	// siteRedirects lists the redirects and rewrites in the Netlify format.
+	siteRedirects = "_redirects"
+)
+
+// maxSiteFileSize is the maximum size of the site's _headers and _redirects
+// files.
+const maxSiteFileSize = 1 << 20
+
+// siteExt is the extension of the files the clean URLs resolve to.
+const siteExt = ".html"
+
+// sitePattern is the pattern of the URL paths within a site, e.g.
+// /blog/:year/*.  The :name elements match any single element, and the
+// trailing * matches the rest of the path, which is then called splat.
+type sitePattern []string
+
+// newSitePattern returns the pattern parsed from s.
+func newSitePattern(s string) (p sitePattern) {
+	return splitPath(path.Clean("/" + s))
+}
+
+// match returns the values of the placeholders of p by their names if it
+// matches the elements of the cleaned URL path within the site.
+func (p sitePattern) match(elems []string) (params map[string]string, ok bool) {
+	params = map[string]string{}
+	for i, pe := range p {
+		if pe == "*" && i == len(p)-1 {
+			params["splat"] = strings.Join(elems[i:], "/")
+
+			return params, true
+		} else if i >= len(elems) {
+			return nil, false
+		} else if strings.HasPrefix(pe, ":") {
+			params[pe[1:]] = elems[i]
+		} else if pe != elems[i] {
+			return nil, false
+		}
+	}
+
+	return params, len(p) == len(elems)
+}
+
+// splitPath returns the elements of the cleaned URL path p, none for the root.
+func splitPath(p string) (elems []string) {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+
+	return strings.Split(p, "/")
+}
+
+// headerRule is a rule of a _headers file.
+type headerRule struct {
+	pattern sitePattern
+	headers http.Header
+}
+
+// parseHeaders parses the _headers file from r.  The unindented lines start a
+// rule with a path pattern, and the indented ones add the headers to it.
+func parseHeaders(r io.Reader) (rules []*headerRule, err error) {
+	var cur *headerRule
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			cur = &headerRule{pattern: newSitePattern(trimmed), headers: http.Header{}}
+			rules = append(rules, cur)
+
+			continue
+		}
+
+		name, value, ok := strings.Cut(trimmed, ":")
+		if !ok || cur == nil || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("line %d: invalid header %q", n, trimmed)
+		}
+
+		cur.headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	return rules, sc.Err()
+}
+
+// redirectRule is a rule of a _redirects file.
+type redirectRule struct {
+	from sitePattern
+
+	// to is either a URL path within the site or an absolute URL.  It may
+	// contain the placeholders of from.
+	to string
+
+	// code is the status of the response.  [http.StatusOK] rewrites the
+	// response with the contents of to, and [http.StatusNotFound] does the
+	// same with its own status.
+	code int
+
+	// force applies the rule even if the requested file exists.
+	force bool
+}
+
+// parseRedirects parses the _redirects file from r.  Each line consists of the
+// path pattern, the target and optionally the status code, which is 301 by
+// default, with the ! suffix forcing it.
+func parseRedirects(r io.Reader) (rules []*redirectRule, err error) {
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		} else if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: want from, to and optional status, got %d fields", n, len(fields))
+		}
+
+		rule := &redirectRule{
+			from: newSitePattern(fields[0]),
+			to:   fields[1],
+			code: http.StatusMovedPermanently,
+		}
+
+		if len(fields) == 3 {
+			var status string
+			status, rule.force = strings.CutSuffix(fields[2], "!")
+			rule.code, err = strconv.Atoi(status)
+			if err != nil || !validRedirectCode(rule.code) {
+				return nil, fmt.Errorf("line %d: unsupported status %q", n, fields[2])
+			}
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, sc.Err()
+}
+
+// validRedirectCode returns true if code is supported by the _redirects files.
+func validRedirectCode(code int) (ok bool) {
+	switch code {
+	case
+		http.StatusOK,
+		http.StatusNotFound,
+		http.StatusMovedPermanently,
+		http.StatusFound,
+		http.StatusSeeOther,
+		http.StatusTemporaryRedirect,
+		http.StatusPermanentRedirect:
+		return true
+	default:
+		return false
+	}
+}
+
+// target returns the target of rule with the placeholders replaced by
+// params.
+func (rule *redirectRule) target(params map[string]string) (to string) {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+
+	// Replace the longer names first, so that :id doesn't break :idx.
+	slices.SortFunc(names, func(a, b string) bool { return len(a) > len(b) })
+
+	oldnew := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		oldnew = append(oldnew, ":"+name, params[name])
+	}
+
+	return strings.NewReplacer(oldnew...).Replace(rule.to)
+}
+
+// siteFile is a parsed _headers or _redirects file.
+type siteFile struct {
+	modTime   time.Time
+	headers   []*headerRule
+	redirects []*redirectRule
+	size      int64
+}
+
+// siteFiles loads the _headers and _redirects files of the static sites.
+type siteFiles struct {
+	fsys http.FileSystem
+
+	// mu protects files.
+	mu *sync.Mutex
+
+	// files are the parsed files by their cleaned URL paths.
+	files map[string]*siteFile
+}
+
+// newSiteFiles returns a new loader of the files within fsys.
+func newSiteFiles(fsys http.FileSystem) (sf *siteFiles) {
+	return &siteFiles{
+		fsys:  fsys,
+		mu:    &sync.Mutex{},
+		files: map[string]*siteFile{},
+	}
+}
+
+// load returns the file at the cleaned URL path p parsed with parse, or nil if
+// there is none.  The parsed files are cached until modified.  The invalid
+// files are logged and ignored.
+func (sf *siteFiles) load(p string, parse func(file *siteFile, r io.Reader) error) (file *siteFile) {
+	f, err := sf.fsys.Open(p)
+	if err != nil {
+		return nil
+	}
+	defer func() { _ = f.Close() }()
+
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil
+	}
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	file = sf.files[p]
+	if file != nil && file.modTime.Equal(fi.ModTime()) && file.size == fi.Size() {
+		return file
+	}
+
+	file = &siteFile{
+		modTime: fi.ModTime(),
+		size:    fi.Size(),
+	}
+
+	if fi.Size() > maxSiteFileSize {
+		err = fmt.Errorf("larger than %d bytes", maxSiteFileSize)
+	} else {
+		err = parse(file, f)
+	}
+
+	if err != nil {
+		// Cache the failure as well to only log it once per modification.
+		log.Printf("dirs: loading %q: %v", p, err)
+		file.headers, file.redirects = nil, nil
+	}
+
+	sf.files[p] = file
+
+	return file
+}
+
+// headers returns the rules of the _headers file of the site at the cleaned
+// URL path root.
+func (sf *siteFiles) headers(root string) (rules []*headerRule) {
+	file := sf.load(path.Join(root, siteHeaders), func(file *siteFile, r io.Reader) (err error) {
+		file.headers, err = parseHeaders(r)
+
+		return err
+	})
+	if file == nil {
+		return nil
+	}
+
+	return file.headers
+}
+
+// redirects returns the rules of the _redirects file of the site at the
+// cleaned URL path root.
+func (sf *siteFiles) redirects(root string) (rules []*redirectRule) {
+	file := sf.load(path.Join(root, siteRedirects), func(file *siteFile, r io.Reader) (err error) {
+		file.redirects, err = parseRedirects(r)
+
+		return err
+	})
+	if file == nil {
+		return nil
+	}
+
+	return file.redirects
+}
+
+// siteRel returns the elements of the cleaned URL path name relative to the
+// site at the cleaned URL path root.
+func siteRel(root, name string) (elems []string) {
+	return splitPath(strings.TrimPrefix(name, strings.TrimSuffix(root, "/")))
+}
+
+// isSiteFile returns true if the cleaned URL path name is one of the special
+// files of the site at the cleaned URL path root, which are never served.
+func isSiteFile(root, name string) (ok bool) {
+	return path.Dir(name) == root && (path.Base(name) == siteHeaders || path.Base(name) == siteRedirects)
+}
+
+// withoutSiteFiles returns entries of the directory at the cleaned URL path
+// dir without the special files of the site at the cleaned URL path root.  It
+// modifies entries.
+func withoutSiteFiles(root, dir string, entries []fs.FileInfo) (res []fs.FileInfo) {
+	if root == "" || dir != root {
+		return entries
+	}
+
+	res = entries[:0]
+	for _, e := range entries {
+		if !isSiteFile(root, path.Join(dir, e.Name())) {
+			res = append(res, e)
+		}
+	}
+
+	return res
+}
+
+// serveSite applies the rules of the static site, which root is in s, to the
+// request of the cleaned URL path name.  It returns true if the response is
+// already written.
+func (h *dirs) serveSite(w http.ResponseWriter, r *http.Request, s *dirSettings, name string) (done bool) {
+	root := s.siteRoot
+	if isSiteFile(root, name) {
+		h.renderError(w, r, s, fs.ErrNotExist)
+
+		return true
+	}
+
+	elems := siteRel(root, name)
+	for _, rule := range h.site.headers(root) {
+		if _, ok := rule.pattern.match(elems); ok {
+			for k, vals := range rule.headers {
+				w.Header()[k] = append(w.Header()[k], vals...)
+			}
+		}
+	}
+
+	for _, rule := range h.site.redirects(root) {
+		params, ok := rule.from.match(elems)
+		if !ok || (!rule.force && h.siteFileExists(name)) {
+			continue
+		}
+
+		h.applyRedirect(w, r, s, rule.target(params), rule.code)
+
+		return true
+	}
+
+	return false
+}
+
+// siteFileExists returns true if the cleaned URL path name is served as a
+// file or a directory of a static site.
+func (h *dirs) siteFileExists(name string) (ok bool) {
+	for _, p := range []string{name, name + siteExt} {
+		f, err := h.fsys.Open(p)
+		if err == nil {
+			_ = f.Close()
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyRedirect responds according to the redirect rule with the target to
+// and the status code within the site, which root is in s.
+func (h *dirs) applyRedirect(w http.ResponseWriter, r *http.Request, s *dirSettings, to string, code int) {
+	isURL := strings.Contains(to, "://")
+	if !isURL {
+		to = path.Join(s.siteRoot, to)
+	}
+
+	switch code {
+	case http.StatusOK, http.StatusNotFound:
+		if isURL || h.isHidden(to) || !h.serveWithStatus(w, r, to, code) {
+			h.renderError(w, r, s, fs.ErrNotExist)
+		}
+	default:
+		if !isURL {
+			to = fhttp.BasePath(r) + to
+		}
+
+		if r.URL.RawQuery != "" && !strings.Contains(to, "?") {
+			to += "?" + r.URL.RawQuery
+		}
+
+		http.Redirect(w, r, to, code)
+	}
+}
+
+// openCleanURL opens the file of a static site the clean URL path name with
+// settings s resolves to, e.g. about.html for about.  f is nil if there is
+// none.
+func (h *dirs) openCleanURL(s *dirSettings, name string) (f http.File, fileName string) {
+	if name == "/" || s.siteRoot == "" {
+		return nil, ""
+	}
+
+	fileName = name + siteExt
+	if h.isHidden(fileName) {
+		return nil, ""
+	}
+
+	f, err := h.fsys.Open(fileName)
+	if err != nil {
+		return nil, ""
+	}
+
+	return f, fileName
+}
+
+// serveWithStatus serves the regular file at the cleaned URL path name with
+// code instead of [http.StatusOK].  It returns false if there is no such file.
+func (h *dirs) serveWithStatus(w http.ResponseWriter, r *http.Request, name string, code int) (ok bool) {
+	f, err := h.fsys.Open(name)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = f.Close() }()
+
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+
+	if code == http.StatusOK {
+		h.serveContent(w, r, name, f, fi)
+
+		return true
+	}
+
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+
+	hdr := w.Header()
+	hdr.Set("Content-Type", ctype)
+	hdr.Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	hdr.Set("Cache-Control", "no-cache")
+	w.WriteHeader(code)
+	if r.Method != http.MethodHead {
+		_, err = io.Copy(w, f)
+		if err != nil {
+			log.Printf("dirs: serving %q: %v", name, err)
+		}
+	}
+
+	return true
+}
+
+// renderError renders err, preferring the 404.html page of the static site in
+// the settings s of the request for the missing files.
+func (h *dirs) renderError(w http.ResponseWriter, r *http.Request, s *dirSettings, err error) {
+	if s.siteRoot != "" && errors.Is(err, fs.ErrNotExist) {
+		page := path.Join(s.siteRoot, siteNotFound)
+		if !h.isHidden(page) && h.serveWithStatus(w, r, page, http.StatusNotFound) {
+			return
+		}
+	}
+
+	h.theme.RenderError(w, r, err)
+}
@@ -0,0 +1,425 @@
+package dirs
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestSitePattern_match(t *testing.T) {
+	testCases := []struct {
+		name       string
+		pattern    string
+		path       string
+		wantParams map[string]string
+		wantOK     bool
+	}{{
+		name:       "exact",
+		pattern:    "/old",
+		path:       "/old",
+		wantParams: map[string]string{},
+		wantOK:     true,
+	}, {
+		name:    "longer",
+		pattern: "/old",
+		path:    "/old/page",
+		wantOK:  false,
+	}, {
+		name:    "shorter",
+		pattern: "/old/page",
+		path:    "/old",
+		wantOK:  false,
+	}, {
+		name:       "placeholder",
+		pattern:    "/blog/:year/:slug",
+		path:       "/blog/2024/post",
+		wantParams: map[string]string{"year": "2024", "slug": "post"},
+		wantOK:     true,
+	}, {
+		name:       "splat",
+		pattern:    "/docs/*",
+		path:       "/docs/a/b",
+		wantParams: map[string]string{"splat": "a/b"},
+		wantOK:     true,
+	}, {
+		name:       "splat_empty",
+		pattern:    "/docs/*",
+		path:       "/docs",
+		wantParams: map[string]string{"splat": ""},
+		wantOK:     true,
+	}, {
+		name:       "root_splat",
+		pattern:    "/*",
+		path:       "/",
+		wantParams: map[string]string{"splat": ""},
+		wantOK:     true,
+	}, {
+		name:    "other",
+		pattern: "/blog/:year",
+		path:    "/news/2024",
+		wantOK:  false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params, ok := newSitePattern(tc.pattern).match(splitPath(tc.path))
+			if ok != tc.wantOK {
+				t.Fatalf("got ok %t, want %t", ok, tc.wantOK)
+			} else if !ok {
+				return
+			}
+
+			if len(params) != len(tc.wantParams) {
+				t.Fatalf("got params %q, want %q", params, tc.wantParams)
+			}
+
+			for k, v := range tc.wantParams {
+				if params[k] != v {
+					t.Fatalf("got params %q, want %q", params, tc.wantParams)
+				}
+			}
+		})
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	testCases := []struct {
+		name      string
+		in        string
+		wantRules []string
+		wantErr   bool
+	}{{
+		name: "valid",
+		in: "# comment\n/*\n  X-Frame-Options: DENY\n\n/assets/*\n" +
+			"\tCache-Control: max-age=60\n\tX-A: 1\n",
+		wantRules: []string{"/* 1", "/assets/* 2"},
+		wantErr:   false,
+	}, {
+		name:      "no_headers",
+		in:        "/a\n",
+		wantRules: []string{"/a 0"},
+		wantErr:   false,
+	}, {
+		name:    "no_pattern",
+		in:      "  X-A: 1\n",
+		wantErr: true,
+	}, {
+		name:    "no_colon",
+		in:      "/*\n  X-A\n",
+		wantErr: true,
+	}, {
+		name:    "empty_name",
+		in:      "/*\n  : 1\n",
+		wantErr: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rules, err := parseHeaders(strings.NewReader(tc.in))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("no error")
+				}
+
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, rule := range rules {
+				p := "/" + strings.Join(rule.pattern, "/")
+				got = append(got, p+" "+strconv.Itoa(len(rule.headers)))
+			}
+
+			if !slices.Equal(got, tc.wantRules) {
+				t.Fatalf("got %q, want %q", got, tc.wantRules)
+			}
+		})
+	}
+}
+
+func TestParseRedirects(t *testing.T) {
+	testCases := []struct {
+		name      string
+		in        string
+		wantCode  int
+		wantForce bool
+		wantErr   bool
+	}{{
+		name:     "default",
+		in:       "/old /new\n",
+		wantCode: http.StatusMovedPermanently,
+	}, {
+		name:     "found",
+		in:       "# comment\n\n/old /new 302\n",
+		wantCode: http.StatusFound,
+	}, {
+		name:     "rewrite",
+		in:       "/app/* /app/index.html 200\n",
+		wantCode: http.StatusOK,
+	}, {
+		name:      "forced",
+		in:        "/secret /404.html 404!\n",
+		wantCode:  http.StatusNotFound,
+		wantForce: true,
+	}, {
+		name:    "no_target",
+		in:      "/old\n",
+		wantErr: true,
+	}, {
+		name:    "extra_field",
+		in:      "/old /new 301 x\n",
+		wantErr: true,
+	}, {
+		name:    "not_number",
+		in:      "/old /new moved\n",
+		wantErr: true,
+	}, {
+		name:    "unsupported",
+		in:      "/old /new 500\n",
+		wantErr: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rules, err := parseRedirects(strings.NewReader(tc.in))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("no error")
+				}
+
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			} else if len(rules) != 1 {
+				t.Fatalf("got %d rules, want 1", len(rules))
+			}
+
+			if rule := rules[0]; rule.code != tc.wantCode {
+				t.Fatalf("got status %d, want %d", rule.code, tc.wantCode)
+			} else if rule.force != tc.wantForce {
+				t.Fatalf("got force %t, want %t", rule.force, tc.wantForce)
+			}
+		})
+	}
+}
+
+func TestRedirectRule_target(t *testing.T) {
+	rule := &redirectRule{to: "/posts/:idx/:id/:splat"}
+	got := rule.target(map[string]string{"id": "1", "idx": "2", "splat": "a/b"})
+	if want := "/posts/2/1/a/b"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestDirs_ServeHTTP_site(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		siteHeaders: "/*\n  X-Frame-Options: DENY\n/assets/*\n  Cache-Control: max-age=60\n",
+		siteRedirects: "/old /new\n" +
+			"/blog/:year/* /posts/:year/:splat 302\n" +
+			"/app/* /app/index.html 200\n" +
+			"/secret /404.html 404!\n" +
+			"/page.txt /new\n",
+		"404.html":       "not found page",
+		"about.html":     "about",
+		"app/index.html": "app",
+		"assets/a.css":   "css",
+		"page.txt":       "page",
+		"secret":         "secret",
+	})
+
+	h := newTestDirs(t, &HTTPFSConfig{
+		Root:       root,
+		StaticSite: true,
+	})
+
+	testCases := []struct {
+		name         string
+		target       string
+		wantCode     int
+		wantBody     string
+		wantLocation string
+		wantCache    string
+		hidden       bool
+	}{{
+		name:     "clean_url",
+		target:   "/about",
+		wantCode: http.StatusOK,
+		wantBody: "about",
+	}, {
+		name:         "redirect",
+		target:       "/old?x=1",
+		wantCode:     http.StatusMovedPermanently,
+		wantLocation: "/new?x=1",
+	}, {
+		name:         "placeholders",
+		target:       "/blog/2024/a/b",
+		wantCode:     http.StatusFound,
+		wantLocation: "/posts/2024/a/b",
+	}, {
+		name:     "rewrite",
+		target:   "/app/users/1",
+		wantCode: http.StatusOK,
+		wantBody: "app",
+	}, {
+		name:     "forced",
+		target:   "/secret",
+		wantCode: http.StatusNotFound,
+		wantBody: "not found page",
+	}, {
+		name:     "existing",
+		target:   "/page.txt",
+		wantCode: http.StatusOK,
+		wantBody: "page",
+	}, {
+		name:     "missing",
+		target:   "/missing",
+		wantCode: http.StatusNotFound,
+		wantBody: "not found page",
+	}, {
+		name:     "headers_file",
+		target:   "/" + siteHeaders,
+		wantCode: http.StatusNotFound,
+		wantBody: "not found page",
+		hidden:   true,
+	}, {
+		name:     "redirects_file",
+		target:   "/" + siteRedirects,
+		wantCode: http.StatusNotFound,
+		wantBody: "not found page",
+		hidden:   true,
+	}, {
+		name:      "headers",
+		target:    "/assets/a.css",
+		wantCode:  http.StatusOK,
+		wantBody:  "css",
+		wantCache: "max-age=60",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, tc.target, nil))
+
+			if rw.Code != tc.wantCode {
+				t.Fatalf("got status %d, want %d", rw.Code, tc.wantCode)
+			} else if got := rw.Header().Get("Location"); got != tc.wantLocation {
+				t.Fatalf("got location %q, want %q", got, tc.wantLocation)
+			} else if tc.wantBody != "" && rw.Body.String() != tc.wantBody {
+				t.Fatalf("got body %q, want %q", rw.Body, tc.wantBody)
+			}
+
+			// The special files are hidden before the rules apply.
+			if got := rw.Header().Get("X-Frame-Options"); got != "DENY" && !tc.hidden {
+				t.Errorf("got frame options %q, want DENY", got)
+			}
+
+			if got := rw.Header().Get("Cache-Control"); tc.wantCache != "" && got != tc.wantCache {
+				t.Errorf("got cache control %q, want %q", got, tc.wantCache)
+			}
+		})
+	}
+}
+
+// newUploadRequest returns the request uploading the files with contents by
+// their slash-separated paths into the directory at the URL path dir.
+func newUploadRequest(t *testing.T, dir string, files map[string]string) (r *http.Request) {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for name, content := range files {
+		fw, err := mw.CreateFormFile(string(ukFiles), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = fw.Write([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := mw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, dir+"?upload", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	return r
+}
+
+func TestDirs_handleUpload_siteFiles(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"docs/" + DirConfigFile: "static_site = true\n",
+		"docs/sub/a.txt":        "",
+	})
+
+	h := newTestDirs(t, &HTTPFSConfig{
+		Root:        root,
+		AllowUpload: true,
+		DirConfigs:  true,
+	})
+
+	testCases := []struct {
+		name     string
+		dir      string
+		file     string
+		wantCode int
+	}{{
+		name:     "headers",
+		dir:      "/docs/",
+		file:     siteHeaders,
+		wantCode: http.StatusForbidden,
+	}, {
+		name:     "redirects",
+		dir:      "/docs/",
+		file:     siteRedirects,
+		wantCode: http.StatusForbidden,
+	}, {
+		name:     "folder",
+		dir:      "/",
+		file:     "docs/" + siteRedirects,
+		wantCode: http.StatusForbidden,
+	}, {
+		name:     "subdirectory",
+		dir:      "/docs/sub/",
+		file:     siteHeaders,
+		wantCode: http.StatusSeeOther,
+	}, {
+		name:     "outside",
+		dir:      "/",
+		file:     siteHeaders,
+		wantCode: http.StatusSeeOther,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, newUploadRequest(t, tc.dir, map[string]string{tc.file: "/* /x 200\n"}))
+
+			if rw.Code != tc.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rw.Code, tc.wantCode, rw.Body)
+			}
+
+			rel := filepath.FromSlash(strings.TrimPrefix(tc.dir, "/") + tc.file)
+			_, err := os.Stat(filepath.Join(root, rel))
+			if saved := err == nil; saved != (tc.wantCode == http.StatusSeeOther) {
+				t.Fatalf("got saved %t: %v", saved, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package dirs
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// spaFallback is the file served instead of the missing ones under the prefix,
+// so that a single-page application handles its routes itself.
+type spaFallback struct {
+	// prefix is the cleaned URL path the missing files are under.
+	prefix string
+
+	// file is the cleaned URL path of the served file.
+	file string
+}
+
+// newSPAFallbacks returns the fallbacks of fallbacks, which maps the URL path
+// prefixes to the URL paths of the served files.  The longer prefixes go
+// first.
+func newSPAFallbacks(fallbacks map[string]string) (spa []*spaFallback) {
+	for prefix, file := range fallbacks {
+		spa = append(spa, &spaFallback{
+			prefix: path.Clean("/" + prefix),
+			file:   path.Clean("/" + file),
+		})
+	}
+
+	slices.SortFunc(spa, func(a, b *spaFallback) bool {
+		if len(a.prefix) != len(b.prefix) {
+			return len(a.prefix) > len(b.prefix)
+		}
+
+		return a.prefix < b.prefix
+	})
+
+	return spa
+}
+
+// fallbackOf returns the cleaned URL path of the file to serve instead of the
+// missing file at the cleaned URL path name, if any.
+func (h *dirs) fallbackOf(name string) (file string, ok bool) {
+	for _, fb := range h.spa {
+		if name == fb.prefix || fb.prefix == "/" || strings.HasPrefix(name, fb.prefix+"/") {
+			return fb.file, true
+		}
+	}
+
+	return "", false
+}
+
+// serveFallback serves the fallback file instead of the file at the cleaned
+// URL path name, which failed to open with err.  It returns false if there is
+// no fallback for it, so that the error should be rendered as usual.
+func (h *dirs) serveFallback(w http.ResponseWriter, r *http.Request, name string, err error) (ok bool) {
+	if !errors.Is(err, fs.ErrNotExist) || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+
+	file, ok := h.fallbackOf(name)
+	if !ok || file == name || h.isHidden(file) {
+		return false
+	}
+
+	f, err := h.fsys.Open(file)
+	if err != nil {
+		log.Printf("dirs: opening fallback of %q: %v", name, err)
+
+		return false
+	}
+	defer func() { _ = f.Close() }()
+
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		return false
+	}
+
+	h.serveContent(w, r, file, f, fi)
+
+	return true
+}
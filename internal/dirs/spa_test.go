@@ -0,0 +1,167 @@
+package dirs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestNewSPAFallbacks(t *testing.T) {
+	spa := newSPAFallbacks(map[string]string{
+		"/":         "index.html",
+		"app/":      "/app/index.html",
+		"/app/docs": "/app/docs/index.html",
+		"/b":        "/b.html",
+	})
+
+	var got []string
+	for _, fb := range spa {
+		got = append(got, fb.prefix+" "+fb.file)
+	}
+
+	want := []string{
+		"/app/docs /app/docs/index.html",
+		"/app /app/index.html",
+		"/b /b.html",
+		"/ /index.html",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestDirs_fallbackOf(t *testing.T) {
+	h := &dirs{
+		spa: newSPAFallbacks(map[string]string{
+			"/app":      "/app/index.html",
+			"/app/docs": "/app/docs/index.html",
+		}),
+	}
+
+	testCases := []struct {
+		name     string
+		path     string
+		wantFile string
+		wantOK   bool
+	}{{
+		name:     "prefix",
+		path:     "/app",
+		wantFile: "/app/index.html",
+		wantOK:   true,
+	}, {
+		name:     "under",
+		path:     "/app/users/1",
+		wantFile: "/app/index.html",
+		wantOK:   true,
+	}, {
+		name:     "longest",
+		path:     "/app/docs/intro",
+		wantFile: "/app/docs/index.html",
+		wantOK:   true,
+	}, {
+		name:     "same_start",
+		path:     "/apple",
+		wantFile: "",
+		wantOK:   false,
+	}, {
+		name:     "outside",
+		path:     "/other/app",
+		wantFile: "",
+		wantOK:   false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			file, ok := h.fallbackOf(tc.path)
+			if ok != tc.wantOK {
+				t.Fatalf("got ok %t, want %t", ok, tc.wantOK)
+			} else if file != tc.wantFile {
+				t.Fatalf("got %q, want %q", file, tc.wantFile)
+			}
+		})
+	}
+}
+
+func TestDirs_ServeHTTP_spa(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"app/index.html":     "app",
+		"app/main.js":        "main",
+		"hidden/.index.html": "hidden",
+	})
+
+	h := newTestDirs(t, &HTTPFSConfig{
+		Root: root,
+		SPAFallbacks: map[string]string{
+			"/app":    "/app/index.html",
+			"/hidden": "/hidden/.index.html",
+			"/none":   "/none/index.html",
+		},
+		Hide: &HideRules{Dotfiles: true},
+	})
+
+	testCases := []struct {
+		name     string
+		method   string
+		target   string
+		wantCode int
+		wantBody string
+	}{{
+		name:     "route",
+		method:   http.MethodGet,
+		target:   "/app/users/1",
+		wantCode: http.StatusOK,
+		wantBody: "app",
+	}, {
+		name:     "head",
+		method:   http.MethodHead,
+		target:   "/app/users/1",
+		wantCode: http.StatusOK,
+		wantBody: "",
+	}, {
+		name:     "existing",
+		method:   http.MethodGet,
+		target:   "/app/main.js",
+		wantCode: http.StatusOK,
+		wantBody: "main",
+	}, {
+		name:     "post",
+		method:   http.MethodPost,
+		target:   "/app/users/1",
+		wantCode: http.StatusNotFound,
+	}, {
+		name:     "hidden_fallback",
+		method:   http.MethodGet,
+		target:   "/hidden/route",
+		wantCode: http.StatusNotFound,
+	}, {
+		name:     "missing_fallback",
+		method:   http.MethodGet,
+		target:   "/none/route",
+		wantCode: http.StatusNotFound,
+	}, {
+		name:     "no_prefix",
+		method:   http.MethodGet,
+		target:   "/apple",
+		wantCode: http.StatusNotFound,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, httptest.NewRequest(tc.method, tc.target, nil))
+
+			if rw.Code != tc.wantCode {
+				t.Fatalf("got status %d, want %d", rw.Code, tc.wantCode)
+			} else if tc.wantCode != http.StatusOK {
+				return
+			}
+
+			if got := rw.Body.String(); got != tc.wantBody {
+				t.Fatalf("got body %q, want %q", got, tc.wantBody)
+			}
+		})
+	}
+}
@@ -11,13 +11,16 @@ import (
 	"net/url"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"filesrv/internal/dirs"
+	"filesrv/internal/fhttp"
 
 	"github.com/c2h5oh/datasize"
 )
 
-//go:embed css/* assets/* html/*
+//go:embed css/* assets/* html/* js/*
 var static embed.FS
 
 type defaultTheme struct {
@@ -32,26 +35,28 @@ type pathPart struct {
 	// Dir is the directory name with no slashes.  The root directory is
 	// represented by an empty string.
 	Dir string
-	// Path is the full path containing slashes on both ends.  The root
-	// directory is represented by a single slash.
+	// Path is the full path containing slashes on both ends, including the
+	// base path.  The root directory is represented by the base path with a
+	// single slash.
 	Path string
 }
 
-// pathParts returns the base directory name and a list of path parts.  The
-// parts are in reversed order so that the root directory is the last element.
-func pathParts(p string) (current string, parts []pathPart) {
+// pathParts returns the base directory name and a list of path parts of the URL
+// path p served under the base path, see [fhttp.BasePath].  The parts are in
+// reversed order so that the root directory is the last element.
+func pathParts(base, p string) (current string, parts []pathPart) {
 	dirs := strings.Split(strings.TrimSuffix(p, "/"), "/")
 	parts = make([]pathPart, 0, len(dirs))
 	parts = append(parts, pathPart{
 		Dir:  "",
-		Path: "/",
+		Path: base + "/",
 	})
 	current = "/"
 
 	for i := range dirs[1:] {
 		parts = append(parts, pathPart{
 			Dir:  dirs[i+1],
-			Path: strings.Join(dirs[:i+2], "/") + "/",
+			Path: base + strings.Join(dirs[:i+2], "/") + "/",
 		})
 		current = parts[i+1].Dir
 	}
@@ -63,7 +68,8 @@ func pathParts(p string) (current string, parts []pathPart) {
 	return current, parts
 }
 
-func (t *defaultTheme) Render(w http.ResponseWriter, r *http.Request, entries []fs.FileInfo) {
+// Render implements the [dirs.Theme] interface for *defaultTheme.
+func (t *defaultTheme) Render(w http.ResponseWriter, r *http.Request, l *dirs.Listing) {
 	templData := struct {
 		CurrentDir string
 		PathParts  []pathPart
@@ -71,12 +77,29 @@ func (t *defaultTheme) Render(w http.ResponseWriter, r *http.Request, entries []
 		Params     url.Values
 		Dirs       []fs.FileInfo
 		Files      []fs.FileInfo
+		Upload     bool
+		Capacity   *dirs.Capacity
+		Deleted    []string
+		Versioning bool
+		Watch      bool
+		SortBy     string
+		BasePath   string
 	}{
-		Path:   r.URL.Path,
-		Params: r.URL.Query(),
+		Path:       fhttp.BasePath(r) + r.URL.Path,
+		Params:     r.URL.Query(),
+		Upload:     l.Upload,
+		Capacity:   l.Capacity,
+		Deleted:    l.Deleted,
+		Versioning: l.Versioning,
+		Watch:      l.Watch,
+		SortBy:     l.SortBy,
+		BasePath:   fhttp.BasePath(r),
 	}
-	templData.Dirs, templData.Files = sortBy(r.URL.Query().Get(paramSort), entries)
-	templData.CurrentDir, templData.PathParts = pathParts(r.URL.Path)
+	if r.URL.Query().Has(paramSort) {
+		templData.SortBy = r.URL.Query().Get(paramSort)
+	}
+	templData.Dirs, templData.Files = sortBy(templData.SortBy, l.Entries)
+	templData.CurrentDir, templData.PathParts = pathParts(templData.BasePath, r.URL.Path)
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	err := t.templ.Lookup("dir.gohtml").Execute(w, templData)
@@ -85,16 +108,71 @@ func (t *defaultTheme) Render(w http.ResponseWriter, r *http.Request, entries []
 	}
 }
 
+// RenderHistory implements the [dirs.Theme] interface for *defaultTheme.
+func (t *defaultTheme) RenderHistory(w http.ResponseWriter, r *http.Request, hist *dirs.History) {
+	templData := struct {
+		*dirs.History
+		BasePath string
+	}{
+		History:  hist,
+		BasePath: fhttp.BasePath(r),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := t.templ.Lookup("history.gohtml").Execute(w, templData)
+	if err != nil {
+		log.Printf("%s: executing template: %v", t, err)
+	}
+}
+
+// RenderDropBox implements the [dirs.Theme] interface for *defaultTheme.
+func (t *defaultTheme) RenderDropBox(w http.ResponseWriter, r *http.Request, db *dirs.DropBox) {
+	templData := struct {
+		CurrentDir string
+		Path       string
+		BasePath   string
+		Capacity   *dirs.Capacity
+		Received   int
+	}{
+		Path:     fhttp.BasePath(r) + r.URL.Path,
+		BasePath: fhttp.BasePath(r),
+		Capacity: db.Capacity,
+		Received: db.Received,
+	}
+	templData.CurrentDir, _ = pathParts(templData.BasePath, r.URL.Path)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := t.templ.Lookup("dropbox.gohtml").Execute(w, templData)
+	if err != nil {
+		log.Printf("%s: executing template: %v", t, err)
+	}
+}
+
 // RenderError implements the [dirs.Theme] interface for *defaultTheme.
 func (t *defaultTheme) RenderError(w http.ResponseWriter, r *http.Request, err error) {
 	templData := struct {
 		Title      string
 		Message    string
 		Favicon    string
+		BasePath   string
+		Details    []string
 		StatusCode int
-	}{}
+	}{
+		BasePath: fhttp.BasePath(r),
+	}
 
+	var statusErr *fhttp.StatusError
 	switch {
+	case errors.As(err, &statusErr):
+		templData.Message = fmt.Sprintf("%s.", capitalize(statusErr.Error()))
+		if templData.Details = errorDetails(err); templData.Details != nil {
+			templData.Message = "Several errors occurred."
+		}
+		templData.Favicon = "⛔"
+		templData.StatusCode = statusErr.Code
+		if statusErr.Code >= http.StatusInternalServerError {
+			templData.Favicon = "❌"
+		}
 	case errors.Is(err, fs.ErrNotExist):
 		templData.Message = "Requested resource isn't found."
 		templData.Favicon = "🌚"
@@ -111,12 +189,46 @@ func (t *defaultTheme) RenderError(w http.ResponseWriter, r *http.Request, err e
 	templData.Title = http.StatusText(templData.StatusCode)
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(templData.StatusCode)
 	err = t.templ.Lookup("err.gohtml").Execute(w, templData)
 	if err != nil {
 		log.Printf("%s: executing template: %v", t, err)
 	}
 }
 
+// errorDetails returns the messages of each error joined into err, if there
+// are several of them, e.g. one per uploaded file.
+func errorDetails(err error) (details []string) {
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) || len(joined.Unwrap()) < 2 {
+		return nil
+	}
+
+	for _, e := range joined.Unwrap() {
+		var statusErr *fhttp.StatusError
+		if e == nil {
+			continue
+		} else if errors.As(e, &statusErr) {
+			details = append(details, fmt.Sprintf("%s.", capitalize(statusErr.Error())))
+		} else {
+			details = append(details, fmt.Sprintf("Something went wrong: %v.", e))
+		}
+	}
+
+	return details
+}
+
+// capitalize returns s with the first letter in upper case.
+func capitalize(s string) (res string) {
+	if s == "" {
+		return s
+	}
+
+	r, size := utf8.DecodeRuneInString(s)
+
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
 var funcMap = template.FuncMap{
 	"formatTime": func(t time.Time) string {
 		return t.Format(time.RFC1123)
@@ -143,7 +255,13 @@ var funcMap = template.FuncMap{
 
 // DefaultEmbedded returns a new theme based on the embedded assets.
 func DefaultEmbedded() (theme dirs.Theme) {
-	t, err := template.New(".").Funcs(funcMap).ParseFS(static, "html/dir.gohtml", "html/err.gohtml")
+	t, err := template.New(".").Funcs(funcMap).ParseFS(
+		static,
+		"html/dir.gohtml",
+		"html/err.gohtml",
+		"html/history.gohtml",
+		"html/dropbox.gohtml",
+	)
 	if err != nil {
 		// This should never happen since the whole content is embedded.
 		panic(err)
@@ -182,14 +300,36 @@ func DefaultDynamic(fsys fs.FS) (theme dirs.Theme) {
 }
 
 // Render implements the [dirs.Theme] interface for *defaultDynamic.
-func (d *defaultDynamic) Render(w http.ResponseWriter, r *http.Request, entries []fs.FileInfo) {
+func (d *defaultDynamic) Render(w http.ResponseWriter, r *http.Request, l *dirs.Listing) {
 	(&defaultTheme{
 		templ: template.Must(template.New(r.Host).
 			Funcs(funcMap).
 			ParseFS(d.static, "html/dir.gohtml"),
 		),
 		static: d.static,
-	}).Render(w, r, entries)
+	}).Render(w, r, l)
+}
+
+// RenderHistory implements the [dirs.Theme] interface for *defaultDynamic.
+func (d *defaultDynamic) RenderHistory(w http.ResponseWriter, r *http.Request, hist *dirs.History) {
+	(&defaultTheme{
+		templ: template.Must(template.New(r.Host).
+			Funcs(funcMap).
+			ParseFS(d.static, "html/history.gohtml"),
+		),
+		static: d.static,
+	}).RenderHistory(w, r, hist)
+}
+
+// RenderDropBox implements the [dirs.Theme] interface for *defaultDynamic.
+func (d *defaultDynamic) RenderDropBox(w http.ResponseWriter, r *http.Request, db *dirs.DropBox) {
+	(&defaultTheme{
+		templ: template.Must(template.New(r.Host).
+			Funcs(funcMap).
+			ParseFS(d.static, "html/dropbox.gohtml"),
+		),
+		static: d.static,
+	}).RenderDropBox(w, r, db)
 }
 
 // RenderError implements the [dirs.Theme] interface for *defaultDynamic.
@@ -0,0 +1,259 @@
+// upload.js sends the upload forms marked with data-progress in background and
+// shows the progress of each file, first of sending it and then of saving it on
+// the server.  The upload can be canceled, in which case the server removes the
+// partially saved files.  Files and folders may also be dropped onto the page,
+// the folders are uploaded with their structure.
+(function () {
+    'use strict';
+
+    // pollInterval is the interval between the requests of the saving
+    // progress, in milliseconds.
+    const pollInterval = 500;
+
+    // newID returns a random ID of the upload.
+    function newID() {
+        const buf = new Uint8Array(16);
+        crypto.getRandomValues(buf);
+
+        return Array.from(buf, (b) => b.toString(16).padStart(2, '0')).join('');
+    }
+
+    // readEntries returns all the entries of the directory reader, which
+    // returns those in batches.
+    async function readEntries(reader) {
+        const all = [];
+        for (;;) {
+            const batch = await new Promise((resolve, reject) => reader.readEntries(resolve, reject));
+            if (batch.length === 0) {
+                return all;
+            }
+
+            all.push(...batch);
+        }
+    }
+
+    // walk appends the files within the dropped entry to files, each with
+    // its path relative to the drop.
+    async function walk(entry, files) {
+        if (entry.isFile) {
+            const file = await new Promise((resolve, reject) => entry.file(resolve, reject));
+            files.push({ file: file, path: entry.fullPath.replace(/^\//, '') });
+        } else if (entry.isDirectory) {
+            for (const child of await readEntries(entry.createReader())) {
+                await walk(child, files);
+            }
+        }
+    }
+
+    // droppedFiles returns the files of the drop event, including the
+    // contents of the dropped folders.
+    async function droppedFiles(e) {
+        const files = [];
+        const entries = Array.from(e.dataTransfer.items, (item) => item.webkitGetAsEntry && item.webkitGetAsEntry());
+        if (entries.some((entry) => !entry)) {
+            // Folders aren't supported by the browser.
+            return Array.from(e.dataTransfer.files, (file) => ({ file: file, path: file.name }));
+        }
+
+        for (const entry of entries) {
+            await walk(entry, files);
+        }
+
+        return files;
+    }
+
+    // selectedFiles returns the files to upload with form, either dropped or
+    // chosen with its inputs.  The files chosen within a folder keep the path
+    // relative to it.
+    function selectedFiles(form) {
+        if (form.dropped) {
+            return form.dropped;
+        }
+
+        const files = [];
+        for (const input of form.querySelectorAll('input[type=file]')) {
+            for (const file of input.files) {
+                files.push({ file: file, path: file.webkitRelativePath || file.name });
+            }
+        }
+
+        return files;
+    }
+
+    // showSelected shows the number of files chosen for form and lets it be
+    // submitted without the required input.
+    function showSelected(form, n) {
+        const drop = form.querySelector('.upload-drop');
+        if (drop) {
+            drop.textContent = n + ' file(s) selected.';
+        }
+
+        for (const input of form.querySelectorAll('input[type=file]')) {
+            input.required = n === 0;
+        }
+    }
+
+    // newBars fills list with a progress bar for each of files and returns
+    // the bars in the same order.
+    function newBars(list, files) {
+        list.replaceChildren();
+
+        return files.map((file) => {
+            const row = document.createElement('li');
+            const name = document.createElement('span');
+            name.textContent = file.path;
+            const bar = document.createElement('progress');
+            bar.max = file.file.size || 1;
+            bar.value = 0;
+            row.append(name, bar);
+            list.append(row);
+
+            return bar;
+        });
+    }
+
+    // showSent spreads the number of bytes sent over the bars of files in the
+    // order they're sent.  The multipart overhead is ignored.
+    function showSent(bars, files, sent) {
+        let left = sent;
+        files.forEach((file, i) => {
+            bars[i].value = Math.max(0, Math.min(left, file.file.size));
+            left -= file.file.size;
+        });
+    }
+
+    // upload sends form in background.
+    function upload(form) {
+        const files = selectedFiles(form);
+        if (files.length === 0) {
+            return;
+        }
+
+        const data = new FormData(form);
+        data.delete('files');
+        for (const f of files) {
+            data.append('files', f.file, f.path);
+        }
+
+        const id = newID();
+        const action = new URL(form.action);
+        action.searchParams.set('progress', id);
+        const base = new URL(action.pathname, location.href);
+
+        const status = form.querySelector('.upload-status');
+        const list = form.querySelector('.upload-progress');
+        const cancel = form.querySelector('.upload-cancel');
+        const submit = form.querySelector('[type=submit]');
+        const bars = newBars(list, files);
+
+        let poller = null;
+        const stopPolling = () => {
+            clearInterval(poller);
+            poller = null;
+        };
+
+        const poll = async () => {
+            const url = new URL(base);
+            url.searchParams.set('progress', id);
+
+            const resp = await fetch(url, { cache: 'no-store' });
+            if (!resp.ok || poller === null) {
+                return;
+            }
+
+            const p = await resp.json();
+            status.textContent = p.state;
+            (p.files || []).forEach((f, i) => {
+                if (bars[i]) {
+                    bars[i].value = f.written;
+                }
+            });
+        };
+
+        const xhr = new XMLHttpRequest();
+        xhr.upload.addEventListener('progress', (e) => showSent(bars, files, e.loaded));
+        xhr.upload.addEventListener('load', () => {
+            status.textContent = 'saving';
+            bars.forEach((bar) => { bar.value = 0; });
+            poller = setInterval(poll, pollInterval);
+        });
+        xhr.addEventListener('load', () => {
+            stopPolling();
+            if (xhr.status < 400) {
+                location.assign(xhr.responseURL);
+
+                return;
+            }
+
+            document.open();
+            document.write(xhr.responseText);
+            document.close();
+        });
+        xhr.addEventListener('error', () => {
+            stopPolling();
+            status.textContent = 'failed';
+            submit.disabled = false;
+            cancel.hidden = true;
+        });
+        xhr.addEventListener('abort', () => {
+            stopPolling();
+            status.textContent = 'canceled';
+            submit.disabled = false;
+            cancel.hidden = true;
+        });
+
+        cancel.onclick = () => {
+            const url = new URL(base);
+            url.searchParams.set('cancel', id);
+            fetch(url, { method: 'POST' }).catch(() => {});
+            xhr.abort();
+        };
+
+        status.textContent = 'sending';
+        submit.disabled = true;
+        cancel.hidden = false;
+        list.hidden = false;
+
+        xhr.open('POST', action);
+        xhr.send(data);
+    }
+
+    for (const form of document.querySelectorAll('form[data-progress]')) {
+        form.addEventListener('submit', (e) => {
+            e.preventDefault();
+            upload(form);
+        });
+        form.addEventListener('change', () => {
+            form.dropped = null;
+            showSelected(form, selectedFiles(form).length);
+        });
+
+        const drop = form.querySelector('.upload-drop');
+        if (!drop) {
+            continue;
+        }
+
+        drop.hidden = false;
+        document.addEventListener('dragover', (e) => {
+            e.preventDefault();
+            drop.classList.add('dragover');
+
+            // Open the upload dialog, if any.
+            const toggle = document.getElementById('toggle-upload-modal');
+            if (toggle) {
+                toggle.checked = true;
+            }
+        });
+        document.addEventListener('dragleave', (e) => {
+            if (e.relatedTarget === null) {
+                drop.classList.remove('dragover');
+            }
+        });
+        document.addEventListener('drop', async (e) => {
+            e.preventDefault();
+            drop.classList.remove('dragover');
+            form.dropped = await droppedFiles(e);
+            showSelected(form, form.dropped.length);
+        });
+    }
+})();
@@ -0,0 +1,69 @@
+// watch.js keeps the directory listing up to date using the server-sent events
+// of the directory's changes.
+(function () {
+    'use strict';
+
+    // refreshDelay is the time to wait for more events before refreshing, in
+    // milliseconds.
+    const refreshDelay = 300;
+
+    let timer = null;
+    let changed = new Set();
+    let broken = false;
+
+    // refresh replaces the listing with the current one and highlights the
+    // changed entries.
+    async function refresh() {
+        timer = null;
+        const names = changed;
+        changed = new Set();
+
+        const resp = await fetch(location.href, { cache: 'no-store' });
+        if (!resp.ok) {
+            return;
+        }
+
+        const doc = new DOMParser().parseFromString(await resp.text(), 'text/html');
+        const fresh = doc.getElementById('scroller');
+        const scroller = document.getElementById('scroller');
+        if (!fresh || !scroller) {
+            return;
+        }
+
+        scroller.innerHTML = fresh.innerHTML;
+        for (const th of scroller.querySelectorAll('table.files th[title]')) {
+            if (names.has(th.title)) {
+                th.parentElement.classList.add('changed');
+            }
+        }
+    }
+
+    // schedule refreshes the listing after the burst of events.
+    function schedule(name) {
+        if (name !== undefined) {
+            changed.add(name);
+        }
+
+        if (timer === null) {
+            timer = setTimeout(refresh, refreshDelay);
+        }
+    }
+
+    const url = new URL(location.href);
+    url.search = 'watch';
+
+    const source = new EventSource(url);
+    for (const type of ['add', 'modify', 'remove']) {
+        source.addEventListener(type, (e) => schedule(JSON.parse(e.data).name));
+    }
+
+    // The changes made while reconnecting are missed, so refresh once the
+    // stream is restored.
+    source.addEventListener('error', () => { broken = true; });
+    source.addEventListener('open', () => {
+        if (broken) {
+            broken = false;
+            schedule();
+        }
+    });
+})();
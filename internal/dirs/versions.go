@@ -0,0 +1,490 @@
+package dirs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"filesrv/internal/ferrors"
+	"filesrv/internal/safefs"
+
+	"golang.org/x/exp/slices"
+)
+
+// ErrNoVersion is returned when the requested version of a file doesn't
+// exist.
+const ErrNoVersion ferrors.Str = "no such version"
+
+// versionIDLayout is the layout of the version identifiers.  Those are the
+// fixed-width UTC times of retaining the version, so that they sort
+// chronologically.
+const versionIDLayout = "20060102T150405.000000000Z"
+
+// versionSep separates the name of the file and the version identifier in the
+// names of the retained files.
+const versionSep = "~"
+
+// versionSweepInterval is the minimum interval between the sweeps applying the
+// retention policy to all the retained versions.
+const versionSweepInterval = time.Hour
+
+// Version is a retained version of a file.
+type Version struct {
+	// ID identifies the version within the file's history.
+	ID string `json:"id"`
+
+	// Size is the size of the version's content.
+	Size int64 `json:"size"`
+
+	// ModTime is the modification time of the version's content.
+	ModTime time.Time `json:"mod_time"`
+
+	// Deleted is true if the version is the content of the deleted file.
+	Deleted bool `json:"deleted"`
+}
+
+// versionStore retains the overwritten and the deleted files within the
+// metadata directory.  Each retained file is placed into the directory
+// mirroring the original location, under the original name suffixed with the
+// version identifier.  The overwritten files are stored in the versions
+// directory and the deleted ones in the trash directory.
+type versionStore struct {
+	// mu serializes the changes of the retained files.
+	mu *sync.Mutex
+
+	// sweepMu protects lastSweep and sweeping.
+	sweepMu   *sync.Mutex
+	lastSweep time.Time
+	sweeping  bool
+
+	root     string
+	versions string
+	trash    string
+	maxCount int
+	maxAge   time.Duration
+}
+
+// newVersionStore returns a new store of the versions of the files within
+// root keeping at most maxCount versions of a file, each at most maxAge.  Zero
+// values mean no limit.
+func newVersionStore(root string, maxCount int, maxAge time.Duration) (s *versionStore) {
+	return &versionStore{
+		mu:       &sync.Mutex{},
+		sweepMu:  &sync.Mutex{},
+		root:     root,
+		versions: filepath.Join(root, MetaDir, "versions"),
+		trash:    filepath.Join(root, MetaDir, "trash"),
+		maxCount: maxCount,
+		maxAge:   maxAge,
+	}
+}
+
+// location returns the directory mirroring the directory of the file at the
+// OS path name within the store's directory base, and the file's base name.
+func (s *versionStore) location(base, name string) (dir, file string, err error) {
+	rel, err := filepath.Rel(s.root, name)
+	if err != nil {
+		return "", "", err
+	} else if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", "", fmt.Errorf("%q is outside of the root", name)
+	}
+
+	return filepath.Join(base, filepath.Dir(rel)), filepath.Base(rel), nil
+}
+
+// retain moves the file name within dir into the versions directory, or into
+// the trash if deleted is true.  It does nothing if there is no such file.
+func (s *versionStore) retain(dir *safefs.Dir, name string, deleted bool) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.retainLocked(dir, name, deleted)
+	if err != nil {
+		return err
+	}
+
+	return s.prune(filepath.Join(dir.Name(), name), deleted)
+}
+
+// retainLocked is the implementation of retain, which doesn't apply the
+// retention policy.  s.mu must be locked.
+func (s *versionStore) retainLocked(d *safefs.Dir, name string, deleted bool) (err error) {
+	osName := filepath.Join(d.Name(), name)
+	fi, err := d.Lstat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%q is not a regular file", osName)
+	}
+
+	base := s.versions
+	if deleted {
+		base = s.trash
+	}
+
+	dir, file, err := s.location(base, osName)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return fmt.Errorf("creating versions directory: %w", err)
+	}
+
+	// Avoid overwriting the version retained at the same moment.
+	t := time.Now().UTC()
+	for {
+		retained := filepath.Join(dir, file+versionSep+t.Format(versionIDLayout))
+		_, err = os.Lstat(retained)
+		if errors.Is(err, fs.ErrNotExist) {
+			err = d.RenameOut(name, retained)
+
+			break
+		} else if err != nil {
+			return err
+		}
+
+		t = t.Add(time.Nanosecond)
+	}
+	if err != nil {
+		return fmt.Errorf("retaining version: %w", err)
+	}
+
+	return nil
+}
+
+// list returns the retained versions of the file at the OS path name, the
+// latest first.
+func (s *versionStore) list(name string) (versions []*Version, err error) {
+	versions = []*Version{}
+	for _, base := range []string{s.versions, s.trash} {
+		var dir, file string
+		dir, file, err = s.location(base, name)
+		if err != nil {
+			return nil, err
+		}
+
+		var found []*Version
+		found, err = readVersions(dir, file, base == s.trash)
+		if err != nil {
+			return nil, err
+		}
+
+		versions = append(versions, found...)
+	}
+
+	slices.SortFunc(versions, func(a, b *Version) bool { return a.ID > b.ID })
+
+	return versions, nil
+}
+
+// deleted returns the names of the files within the directory at the OS path
+// name which have retained versions in the trash, sorted.
+func (s *versionStore) deleted(name string) (names []string, err error) {
+	rel, err := filepath.Rel(s.root, name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.trash, rel))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		file, _, ok := parseVersionName(e.Name())
+		if ok && e.Type().IsRegular() && !slices.Contains(names, file) {
+			names = append(names, file)
+		}
+	}
+
+	slices.Sort(names)
+
+	return names, nil
+}
+
+// readVersions returns the versions of file retained within dir.
+func readVersions(dir, file string, deleted bool) (versions []*Version, err error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		f, id, ok := parseVersionName(e.Name())
+		if !ok || f != file || !e.Type().IsRegular() {
+			continue
+		}
+
+		var fi fs.FileInfo
+		fi, err = e.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		versions = append(versions, &Version{
+			ID:      id,
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+			Deleted: deleted,
+		})
+	}
+
+	return versions, nil
+}
+
+// parseVersionName splits the name of the retained file into the original
+// file name and the version identifier.
+func parseVersionName(name string) (file, id string, ok bool) {
+	i := strings.LastIndex(name, versionSep)
+	if i <= 0 {
+		return "", "", false
+	}
+
+	file, id = name[:i], name[i+len(versionSep):]
+
+	return file, id, isVersionID(id)
+}
+
+// isVersionID returns true if id is a valid version identifier.
+func isVersionID(id string) (ok bool) {
+	_, err := time.Parse(versionIDLayout, id)
+
+	return err == nil && len(id) == len(versionIDLayout)
+}
+
+// path returns the OS path of the version id of the file at the OS path name.
+func (s *versionStore) path(name, id string) (retained string, deleted bool, err error) {
+	if !isVersionID(id) {
+		return "", false, ErrNoVersion
+	}
+
+	for _, base := range []string{s.versions, s.trash} {
+		var dir, file string
+		dir, file, err = s.location(base, name)
+		if err != nil {
+			return "", false, err
+		}
+
+		retained = filepath.Join(dir, file+versionSep+id)
+		_, err = os.Lstat(retained)
+		if err == nil {
+			return retained, base == s.trash, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", false, err
+		}
+	}
+
+	return "", false, ErrNoVersion
+}
+
+// open opens the version id of the file at the OS path name.
+func (s *versionStore) open(name, id string) (f *os.File, err error) {
+	retained, _, err := s.path(name, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(retained)
+}
+
+// restore replaces the file name within dir with its version id.  The current
+// content of the file, if any, is retained as a version.
+func (s *versionStore) restore(dir *safefs.Dir, name, id string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	osName := filepath.Join(dir.Name(), name)
+	retained, _, err := s.path(osName, id)
+	if err != nil {
+		return err
+	}
+
+	err = s.retainLocked(dir, name, false)
+	if err != nil {
+		return fmt.Errorf("retaining current version: %w", err)
+	}
+
+	err = dir.RenameIn(retained, name)
+	if err != nil {
+		return err
+	}
+
+	// Prune only now, since the restored version could be the one to prune.
+	return s.prune(osName, false)
+}
+
+// purge removes the version id of the file at the OS path name.  If id is
+// empty, it removes all the retained versions of the file.
+func (s *versionStore) purge(name, id string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id != "" {
+		var retained string
+		retained, _, err = s.path(name, id)
+		if err != nil {
+			return err
+		}
+
+		return os.Remove(retained)
+	}
+
+	versions, err := s.list(name)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, v := range versions {
+		var retained string
+		retained, _, err = s.path(name, v.ID)
+		if err == nil {
+			err = os.Remove(retained)
+		}
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// prune removes the retained versions of the file at the OS path name
+// exceeding the retention policy, either from the trash if deleted is true or
+// from the versions directory.  s.mu must be locked.
+func (s *versionStore) prune(name string, deleted bool) (err error) {
+	if s.maxCount <= 0 && s.maxAge <= 0 {
+		return nil
+	}
+
+	base := s.versions
+	if deleted {
+		base = s.trash
+	}
+
+	dir, file, err := s.location(base, name)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pruneDir(dir, file, time.Now())
+	if err != nil {
+		return fmt.Errorf("pruning versions: %w", err)
+	}
+
+	return nil
+}
+
+// pruneDir removes the versions retained within dir exceeding the retention
+// policy at now.  Only the versions of file are pruned, unless it's empty.
+// s.mu must be locked.
+func (s *versionStore) pruneDir(dir, file string, now time.Time) (removed int, err error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	ids := map[string][]string{}
+	for _, e := range entries {
+		f, id, ok := parseVersionName(e.Name())
+		if ok && e.Type().IsRegular() && (file == "" || f == file) {
+			ids[f] = append(ids[f], id)
+		}
+	}
+
+	var errs []error
+	for f, fileIDs := range ids {
+		slices.SortFunc(fileIDs, func(a, b string) bool { return a > b })
+
+		for i, id := range fileIDs {
+			retainedAt, _ := time.Parse(versionIDLayout, id)
+			if (s.maxCount > 0 && i >= s.maxCount) || (s.maxAge > 0 && now.Sub(retainedAt) > s.maxAge) {
+				err = os.Remove(filepath.Join(dir, f+versionSep+id))
+				if err == nil {
+					removed++
+				}
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return removed, errors.Join(errs...)
+}
+
+// maybeSweep starts applying the retention policy to all the retained
+// versions in the background if the previous sweep was more than
+// [versionSweepInterval] ago.  So the expired versions are removed even if
+// their files are never changed again.
+func (s *versionStore) maybeSweep() {
+	if s.maxCount <= 0 && s.maxAge <= 0 {
+		return
+	}
+
+	s.sweepMu.Lock()
+	defer s.sweepMu.Unlock()
+
+	if s.sweeping || time.Since(s.lastSweep) < versionSweepInterval {
+		return
+	}
+
+	s.sweeping = true
+	go func() {
+		removed, err := s.sweep(time.Now())
+		if err != nil {
+			log.Printf("dirs: sweeping versions: %v", err)
+		} else if removed > 0 {
+			log.Printf("dirs: swept %d expired versions", removed)
+		}
+
+		s.sweepMu.Lock()
+		defer s.sweepMu.Unlock()
+
+		s.sweeping = false
+		s.lastSweep = time.Now()
+	}()
+}
+
+// sweep removes all the retained versions exceeding the retention policy at
+// now, both from the versions directory and from the trash.
+func (s *versionStore) sweep(now time.Time) (removed int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for _, base := range []string{s.versions, s.trash} {
+		err = filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			} else if err != nil {
+				return err
+			} else if !d.IsDir() {
+				return nil
+			}
+
+			n, pruneErr := s.pruneDir(path, "", now)
+			removed += n
+			errs = append(errs, pruneErr)
+
+			return nil
+		})
+		errs = append(errs, err)
+	}
+
+	return removed, errors.Join(errs...)
+}
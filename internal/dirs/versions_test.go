@@ -0,0 +1,127 @@
+package dirs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// retainAt creates the version of the file at the slash-separated path rel
+// retained within base at t.
+func retainAt(t *testing.T, base, rel string, at time.Time) {
+	t.Helper()
+
+	name := filepath.Join(base, filepath.FromSlash(rel)) + versionSep + at.UTC().Format(versionIDLayout)
+	err := os.MkdirAll(filepath.Dir(name), 0o700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(name, []byte(rel), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// retainedAges returns the ages of the versions of the file at the
+// slash-separated path rel retained within base at now, the latest first.
+func retainedAges(t *testing.T, base, rel string, now time.Time) (ages []time.Duration) {
+	t.Helper()
+
+	dir, file := filepath.Split(filepath.Join(base, filepath.FromSlash(rel)))
+	versions, err := readVersions(dir, file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range versions {
+		at, _ := time.Parse(versionIDLayout, v.ID)
+		ages = append(ages, now.Sub(at))
+	}
+
+	slices.Sort(ages)
+
+	return ages
+}
+
+func TestVersionStore_sweep(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	hours := func(n int) (d time.Duration) { return time.Duration(n) * time.Hour }
+
+	testCases := []struct {
+		name     string
+		maxCount int
+		maxAge   time.Duration
+		wantAges []time.Duration
+	}{{
+		name:     "no_policy",
+		maxCount: 0,
+		maxAge:   0,
+		wantAges: []time.Duration{hours(1), hours(2), hours(30), hours(50)},
+	}, {
+		name:     "count",
+		maxCount: 2,
+		maxAge:   0,
+		wantAges: []time.Duration{hours(1), hours(2)},
+	}, {
+		name:     "age",
+		maxCount: 0,
+		maxAge:   hours(24),
+		wantAges: []time.Duration{hours(1), hours(2)},
+	}, {
+		name:     "both",
+		maxCount: 1,
+		maxAge:   hours(24),
+		wantAges: []time.Duration{hours(1)},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newVersionStore(t.TempDir(), tc.maxCount, tc.maxAge)
+			for _, base := range []string{s.versions, s.trash} {
+				for _, age := range []int{1, 2, 30, 50} {
+					retainAt(t, base, "dir/file.txt", now.Add(-hours(age)))
+					retainAt(t, base, "other.txt", now.Add(-hours(age)))
+				}
+			}
+
+			_, err := s.sweep(now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, base := range []string{s.versions, s.trash} {
+				for _, rel := range []string{"dir/file.txt", "other.txt"} {
+					got := retainedAges(t, base, rel, now)
+					if !slices.Equal(got, tc.wantAges) {
+						t.Errorf("%s: got ages %v, want %v", rel, got, tc.wantAges)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestVersionStore_prune(t *testing.T) {
+	now := time.Now()
+	s := newVersionStore(t.TempDir(), 1, 0)
+	for _, rel := range []string{"a.txt", "b.txt"} {
+		retainAt(t, s.versions, rel, now.Add(-2*time.Hour))
+		retainAt(t, s.versions, rel, now.Add(-time.Hour))
+	}
+
+	// Pruning a single file keeps the versions of the others.
+	err := s.prune(filepath.Join(s.root, "a.txt"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(retainedAges(t, s.versions, "a.txt", now)); got != 1 {
+		t.Fatalf("got %d versions of a.txt, want 1", got)
+	} else if got = len(retainedAges(t, s.versions, "b.txt", now)); got != 2 {
+		t.Fatalf("got %d versions of b.txt, want 2", got)
+	}
+}
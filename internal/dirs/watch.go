@@ -0,0 +1,362 @@
+package dirs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"filesrv/internal/ferrors"
+	"filesrv/internal/fhttp"
+)
+
+// ErrTooManyWatchers is returned when the directory is already watched by the
+// maximum number of clients.
+const ErrTooManyWatchers ferrors.Str = "too many clients watch the directory, try again later"
+
+// Types of the watch events.
+const (
+	WatchAdd    = "add"
+	WatchRemove = "remove"
+	WatchModify = "modify"
+)
+
+const (
+	// watchPingInterval is the interval between the comments sent to keep the
+	// idle event streams open through the proxies.
+	watchPingInterval = 30 * time.Second
+
+	// watchBuffer is the number of batches of events buffered for a client.
+	// The clients not reading fast enough are disconnected, so that those
+	// reconnect and reload the listing instead of missing the events.
+	watchBuffer = 16
+)
+
+// WatchEvent is a change of an entry within a watched directory.
+type WatchEvent struct {
+	// ModTime is the modification time of the entry.  It's nil for the
+	// removed entries.
+	ModTime *time.Time `json:"mtime,omitempty"`
+
+	// Type is the type of the event, one of [WatchAdd], [WatchRemove] and
+	// [WatchModify].
+	Type string `json:"-"`
+
+	// Name is the base name of the entry.
+	Name string `json:"name"`
+
+	// Size is the size of the entry in bytes.  It's zero for the removed
+	// entries.
+	Size int64 `json:"size"`
+
+	// Dir is true if the entry is a directory.
+	Dir bool `json:"dir"`
+}
+
+// entryState is the state of a directory entry compared between the polls.
+type entryState struct {
+	modTime time.Time
+	size    int64
+	dir     bool
+}
+
+// dirWatch is the polling of a single directory shared by its clients.
+type dirWatch struct {
+	// clients receive the batches of events.  Those are closed when the
+	// directory can no longer be read or the client is too slow.
+	clients map[chan []*WatchEvent]struct{}
+
+	// stop is closed when the last client unsubscribes.
+	stop chan struct{}
+}
+
+// watchHub polls the watched directories and sends their changes to the
+// clients.  Each directory is polled once regardless of the number of its
+// clients, and only while it has any.
+type watchHub struct {
+	// mu protects dirs and the clients of each.
+	mu         *sync.Mutex
+	dirs       map[string]*dirWatch
+	root       string
+	interval   time.Duration
+	maxClients int
+}
+
+// newWatchHub returns a new hub polling each directory within root once in
+// interval for at most maxClients each.  maxClients of zero means no limit.
+func newWatchHub(root string, interval time.Duration, maxClients int) (hub *watchHub) {
+	return &watchHub{
+		mu:         &sync.Mutex{},
+		dirs:       map[string]*dirWatch{},
+		root:       filepath.Clean(root),
+		interval:   interval,
+		maxClients: maxClients,
+	}
+}
+
+// subscribe starts sending the changes of the directory dir to events, until
+// unsubscribe is called.
+func (hub *watchHub) subscribe(dir string) (events <-chan []*WatchEvent, unsubscribe func(), err error) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	dw, ok := hub.dirs[dir]
+	if !ok {
+		var prev map[string]entryState
+		prev, err = hub.snapshot(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		dw = &dirWatch{
+			clients: map[chan []*WatchEvent]struct{}{},
+			stop:    make(chan struct{}),
+		}
+		hub.dirs[dir] = dw
+		go hub.poll(dir, dw, prev)
+	} else if hub.maxClients > 0 && len(dw.clients) >= hub.maxClients {
+		return nil, nil, &fhttp.StatusError{
+			Err:  ErrTooManyWatchers,
+			Code: http.StatusServiceUnavailable,
+		}
+	}
+
+	ch := make(chan []*WatchEvent, watchBuffer)
+	dw.clients[ch] = struct{}{}
+
+	return ch, func() { hub.unsubscribe(dir, dw, ch) }, nil
+}
+
+// unsubscribe stops sending the changes of dir watched by dw to ch and stops
+// polling it if there are no clients left.
+func (hub *watchHub) unsubscribe(dir string, dw *dirWatch, ch chan []*WatchEvent) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if _, ok := dw.clients[ch]; !ok {
+		// Already closed by poll.
+		return
+	}
+
+	hub.removeLocked(dir, dw, ch)
+}
+
+// removeLocked closes ch and removes it from the clients of dir watched by dw.
+// It stops polling dir if there are no clients left.  hub.mu must be locked.
+func (hub *watchHub) removeLocked(dir string, dw *dirWatch, ch chan []*WatchEvent) {
+	close(ch)
+	delete(dw.clients, ch)
+	if len(dw.clients) == 0 {
+		close(dw.stop)
+		delete(hub.dirs, dir)
+	}
+}
+
+// poll compares the snapshots of dir, starting with prev, once in the
+// interval and sends the changes to the clients of dw.
+func (hub *watchHub) poll(dir string, dw *dirWatch, prev map[string]entryState) {
+	ticker := time.NewTicker(hub.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-dw.stop:
+			return
+		case <-ticker.C:
+			// Go on.
+		}
+
+		cur, err := hub.snapshot(dir)
+		if err != nil {
+			log.Printf("dirs: watching %q: %v", dir, err)
+			hub.closeAll(dir, dw)
+
+			return
+		}
+
+		events := diff(prev, cur)
+		prev = cur
+		if len(events) > 0 {
+			hub.send(dir, dw, events)
+		}
+	}
+}
+
+// send sends events to the clients of dir watched by dw without blocking.
+// The clients with the full buffer are disconnected, since the events they
+// miss wouldn't be repeated.
+func (hub *watchHub) send(dir string, dw *dirWatch, events []*WatchEvent) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	for ch := range dw.clients {
+		select {
+		case ch <- events:
+		default:
+			hub.removeLocked(dir, dw, ch)
+		}
+	}
+}
+
+// closeAll disconnects all the clients of dir watched by dw.
+func (hub *watchHub) closeAll(dir string, dw *dirWatch) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	for ch := range dw.clients {
+		close(ch)
+		delete(dw.clients, ch)
+	}
+
+	if hub.dirs[dir] == dw {
+		delete(hub.dirs, dir)
+	}
+}
+
+// snapshot returns the states of the entries of dir by their names.  The
+// metadata directory within the root is omitted.
+func (hub *watchHub) snapshot(dir string) (states map[string]entryState, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading directory: %w", err)
+	}
+
+	states = make(map[string]entryState, len(entries))
+	for _, e := range entries {
+		if dir == hub.root && strings.EqualFold(e.Name(), MetaDir) {
+			continue
+		}
+
+		var fi fs.FileInfo
+		fi, err = e.Info()
+		if err != nil {
+			// Removed since reading the directory.
+			continue
+		}
+
+		states[e.Name()] = entryState{
+			modTime: fi.ModTime(),
+			size:    fi.Size(),
+			dir:     fi.IsDir(),
+		}
+	}
+
+	return states, nil
+}
+
+// diff returns the events turning prev into cur.
+func diff(prev, cur map[string]entryState) (events []*WatchEvent) {
+	for name, st := range cur {
+		old, ok := prev[name]
+		switch {
+		case !ok:
+			events = append(events, st.event(WatchAdd, name))
+		case !old.equal(st):
+			events = append(events, st.event(WatchModify, name))
+		}
+	}
+
+	for name, st := range prev {
+		if _, ok := cur[name]; !ok {
+			events = append(events, &WatchEvent{
+				Type: WatchRemove,
+				Name: name,
+				Dir:  st.dir,
+			})
+		}
+	}
+
+	return events
+}
+
+// equal returns true if st and other are the same state.
+func (st entryState) equal(other entryState) (ok bool) {
+	return st.modTime.Equal(other.modTime) && st.size == other.size && st.dir == other.dir
+}
+
+// event returns the event of type typ of the entry with name in st.
+func (st entryState) event(typ, name string) (e *WatchEvent) {
+	return &WatchEvent{
+		ModTime: &st.modTime,
+		Type:    typ,
+		Name:    name,
+		Size:    st.size,
+		Dir:     st.dir,
+	}
+}
+
+// serveWatch streams the changes of the directory at the URL path p to the
+// client as the server-sent events until the client disconnects.
+func (h *dirs) serveWatch(w http.ResponseWriter, r *http.Request, p string) {
+	dir, err := h.safe.Resolve(p)
+	if err != nil {
+		h.theme.RenderError(w, r, err)
+
+		return
+	}
+
+	events, unsubscribe, err := h.watches.subscribe(dir)
+	if err != nil {
+		h.theme.RenderError(w, r, err)
+
+		return
+	}
+	defer unsubscribe()
+
+	rc := http.NewResponseController(w)
+	hdr := w.Header()
+	hdr.Set("Content-Type", "text/event-stream")
+	hdr.Set("Cache-Control", "no-store")
+	// Disable the buffering within nginx.
+	hdr.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	ping := time.NewTicker(watchPingInterval)
+	defer ping.Stop()
+
+	for err == nil {
+		err = rc.Flush()
+		if err != nil {
+			break
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ping.C:
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		case batch, ok := <-events:
+			if !ok {
+				return
+			}
+
+			err = writeEvents(w, h.visibleEvents(p, batch))
+		}
+	}
+
+	log.Printf("dirs: streaming changes of %q: %v", p, err)
+}
+
+// writeEvents writes the batch of events in the event stream format.
+func writeEvents(w http.ResponseWriter, batch []*WatchEvent) (err error) {
+	for _, e := range batch {
+		var data []byte
+		data, err = json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("encoding event: %w", err)
+		}
+
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		if err != nil {
+			return fmt.Errorf("writing event: %w", err)
+		}
+	}
+
+	return nil
+}
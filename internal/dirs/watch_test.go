@@ -0,0 +1,163 @@
+package dirs
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestDiff(t *testing.T) {
+	mtime := time.Unix(1700000000, 0)
+	file := entryState{modTime: mtime, size: 1}
+	dir := entryState{modTime: mtime, dir: true}
+
+	testCases := []struct {
+		prev map[string]entryState
+		cur  map[string]entryState
+		name string
+		want []string
+	}{{
+		prev: map[string]entryState{"a": file},
+		cur:  map[string]entryState{"a": file},
+		name: "same",
+		want: nil,
+	}, {
+		prev: map[string]entryState{},
+		cur:  map[string]entryState{"a": file, "d": dir},
+		name: "add",
+		want: []string{"add a", "add d"},
+	}, {
+		prev: map[string]entryState{"a": file, "d": dir},
+		cur:  map[string]entryState{},
+		name: "remove",
+		want: []string{"remove a", "remove d"},
+	}, {
+		prev: map[string]entryState{"a": file, "b": file, "c": file},
+		cur: map[string]entryState{
+			"a": {modTime: mtime.Add(time.Second), size: 1},
+			"b": {modTime: mtime, size: 2},
+			"c": {modTime: mtime, size: 1, dir: true},
+		},
+		name: "modify",
+		want: []string{"modify a", "modify b", "modify c"},
+	}, {
+		prev: map[string]entryState{"a": file},
+		cur:  map[string]entryState{"b": file},
+		name: "rename",
+		want: []string{"add b", "remove a"},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, e := range diff(tc.prev, tc.cur) {
+				got = append(got, e.Type+" "+e.Name)
+
+				if e.Type == WatchRemove && e.ModTime != nil {
+					t.Errorf("%s: removed entry has mtime", e.Name)
+				} else if e.Type != WatchRemove && !e.ModTime.Equal(tc.cur[e.Name].modTime) {
+					t.Errorf("%s: got mtime %s", e.Name, e.ModTime)
+				}
+			}
+
+			slices.Sort(got)
+			if !slices.Equal(got, tc.want) {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWatchHub_send_slow(t *testing.T) {
+	dir := t.TempDir()
+	hub := newWatchHub(dir, time.Hour, 0)
+
+	fast, unsubscribeFast, err := hub.subscribe(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribeFast()
+
+	slow, unsubscribeSlow, err := hub.subscribe(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribeSlow()
+
+	hub.mu.Lock()
+	dw := hub.dirs[dir]
+	hub.mu.Unlock()
+
+	batch := []*WatchEvent{{Type: WatchAdd, Name: "a"}}
+	for i := 0; i <= watchBuffer; i++ {
+		hub.send(dir, dw, batch)
+		<-fast
+	}
+
+	// The slow client gets the buffered batches and then the end of the
+	// stream.
+	for i := 0; i < watchBuffer; i++ {
+		if _, ok := <-slow; !ok {
+			t.Fatalf("closed after %d batches, want %d", i, watchBuffer)
+		}
+	}
+
+	if _, ok := <-slow; ok {
+		t.Fatal("slow client isn't disconnected")
+	}
+
+	// The fast client keeps receiving.
+	hub.send(dir, dw, batch)
+	if _, ok := <-fast; !ok {
+		t.Fatal("fast client is disconnected")
+	}
+
+	// Unsubscribing the disconnected client is safe.
+	unsubscribeSlow()
+}
+
+func TestWatchHub_send_lastSlow(t *testing.T) {
+	dir := t.TempDir()
+	hub := newWatchHub(dir, time.Hour, 0)
+
+	events, unsubscribe, err := hub.subscribe(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	hub.mu.Lock()
+	dw := hub.dirs[dir]
+	hub.mu.Unlock()
+
+	for i := 0; i <= watchBuffer; i++ {
+		hub.send(dir, dw, []*WatchEvent{{Type: WatchAdd, Name: "a"}})
+	}
+
+	select {
+	case <-dw.stop:
+		// Go on.
+	default:
+		t.Fatal("polling isn't stopped without clients")
+	}
+
+	hub.mu.Lock()
+	_, ok := hub.dirs[dir]
+	hub.mu.Unlock()
+
+	if ok {
+		t.Fatal("directory is still watched")
+	}
+
+	// The next client starts watching anew.
+	_, unsubscribeNext, err := hub.subscribe(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsubscribeNext()
+
+	for range events {
+		// Drain the buffer up to the end of the stream.
+	}
+}
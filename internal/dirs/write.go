@@ -1,123 +1,379 @@
 package dirs
 
 import (
+	"bufio"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
-	"sync"
+	"strconv"
 
 	"filesrv/internal/ferrors"
+	"filesrv/internal/fhttp"
+	"filesrv/internal/safefs"
 )
 
 // ErrUnhandled is returned when the request is not handled by the upload
 // handler.
 const ErrUnhandled ferrors.Str = "unhandled request"
 
+// ErrUploadForbidden is returned when uploads are disabled.
+const ErrUploadForbidden ferrors.Str = "uploads are disabled"
+
+// ErrOverwriteForbidden is returned when overwriting is requested while the
+// versioning is disabled.
+const ErrOverwriteForbidden ferrors.Str = "overwriting requires versioning"
+
 type urlKey = string
 
-const ukFiles urlKey = "files"
+const (
+	ukFiles     urlKey = "files"
+	ukOverwrite urlKey = "overwrite"
+)
+
+// fileUpload is the state of an upload request shared by its files.
+type fileUpload struct {
+	r        *http.Request
+	settings *dirSettings
+	progress *upload
+	rules    *dirRules
+
+	// values are the form fields received so far.  Those only apply to the
+	// files following them.
+	values url.Values
+
+	// usage is the usage of dst updated with each saved file.  It's nil unless
+	// dst has quotas.
+	usage *dirUsageCount
 
-// handleUpload handles the upload of a multipart file from r.  It uses the
-// URL's path as the directory for storing the file.
-func (h *dirs) handleUpload(w http.ResponseWriter, r *http.Request, dstDir string) (err error) {
+	// dst is the destination directory.  The files are written relative to it,
+	// so that the links replacing its path can't lead them outside of the
+	// root.
+	dst *safefs.Dir
+
+	prefix    string
+	overwrite bool
+}
+
+// handleUpload handles the upload of a multipart file from r.  dir is the
+// slash-separated path of the destination directory relative to the root, and
+// s are its settings.  If dropBox is true, the files are stored under unique
+// names.  n is the number of the files in the request.  The parts are streamed
+// to the disk as they're received, so the limits are checked before and while
+// writing each file.
+func (h *dirs) handleUpload(
+	w http.ResponseWriter,
+	r *http.Request,
+	s *dirSettings,
+	dir string,
+	dropBox bool,
+) (n int, err error) {
 	if !r.URL.Query().Has("upload") {
-		return fmt.Errorf("dirs: upload: %w", ErrUnhandled)
+		return 0, fmt.Errorf("dirs: upload: %w", ErrUnhandled)
+	} else if !s.allowUpload {
+		return 0, fmt.Errorf("dirs: upload: %w", &fhttp.StatusError{
+			Err:  ErrUploadForbidden,
+			Code: http.StatusForbidden,
+		})
+	}
+
+	err = h.limitBody(w, r)
+	if err != nil {
+		return 0, fmt.Errorf("dirs: upload: %w", err)
+	}
+
+	dst, err := h.safe.OpenDir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("dirs: upload: %w", err)
+	}
+	defer func() { _ = dst.Close() }()
+
+	var up *upload
+	if id := r.URL.Query().Get(ukProgress); id != "" {
+		up, err = h.progress.start(r, dir, id)
+		if err != nil {
+			return 0, fmt.Errorf("dirs: upload: %w", err)
+		}
+		defer func() { up.finish(err) }()
+	}
+
+	release, err := h.uploads.acquire(r.Context())
+	if err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSec))
+
+		return 0, fmt.Errorf("dirs: upload: %w", err)
 	}
+	defer release()
 
-	err = r.ParseMultipartForm(h.maxUploadSize)
+	mr, err := r.MultipartReader()
 	if err != nil {
-		return fmt.Errorf("dirs: parsing multipart form: %w", err)
+		return 0, fmt.Errorf("dirs: parsing multipart form: %w", err)
 	}
 
-	files, ok := r.MultipartForm.File[ukFiles]
-	if !ok {
-		return fmt.Errorf("dirs: no files to upload: %w", ErrUnhandled)
+	u := &fileUpload{
+		r:        r,
+		settings: s,
+		progress: up,
+		values:   url.Values{},
+		dst:      dst,
 	}
+	u.rules, _ = h.hide.dir(dir)
+	u.usage, err = newDirUsageCount(s, dst.Name())
+	if err != nil {
+		return 0, fmt.Errorf("dirs: upload: %w", err)
+	}
+
+	if dropBox {
+		u.prefix, err = dropBoxPrefix()
+		if err != nil {
+			return 0, fmt.Errorf("dirs: upload: %w", err)
+		}
+	}
+
+	n, err = h.handleParts(mr, u, dropBox)
+	if h.blobs != nil {
+		h.blobs.maybeGC()
+	}
+	if h.versions != nil {
+		h.versions.maybeSweep()
+	}
+
+	return n, err
+}
+
+// handleParts saves the files of mr one by one as they're received.  The
+// rejected files are skipped and reported along with the others, but the
+// errors of reading the request stop the upload.
+func (h *dirs) handleParts(mr *multipart.Reader, u *fileUpload, dropBox bool) (n int, err error) {
+	var errs []error
+	formLeft := int64(maxFormMemory)
+	for {
+		var part *multipart.Part
+		part, err = mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			errs = append(errs, fmt.Errorf("dirs: parsing multipart form: %w", asTooLarge(err)))
 
-	wg := &sync.WaitGroup{}
-	wg.Add(len(files))
+			return n, errors.Join(errs...)
+		}
+
+		if part.FileName() == "" {
+			err = readFormValue(part, u.values, &formLeft)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("dirs: parsing multipart form: %w", asTooLarge(err)))
+
+				return n, errors.Join(errs...)
+			}
 
-	for _, handler := range files {
-		go handleFile(wg, handler, dstDir)
+			continue
+		} else if part.FormName() != ukFiles {
+			continue
+		}
+
+		if n == 0 {
+			err = checkOverwrite(u, h.versions != nil, dropBox)
+			if err != nil {
+				return 0, fmt.Errorf("dirs: upload: %w", err)
+			}
+		}
+		n++
+
+		err = h.handleFile(u, part)
+		if err != nil {
+			errs = append(errs, err)
+		}
 	}
-	wg.Wait()
+
+	if n == 0 {
+		return 0, fmt.Errorf("dirs: no files to upload: %w", ErrUnhandled)
+	}
+
+	return n, errors.Join(errs...)
+}
+
+// readFormValue reads the non-file part into values.  left is the number of
+// bytes the form fields may still take, it's decreased by the part's size.
+func readFormValue(part *multipart.Part, values url.Values, left *int64) (err error) {
+	b, err := io.ReadAll(io.LimitReader(part, *left+1))
+	if err != nil {
+		return err
+	}
+
+	*left -= int64(len(b))
+	if *left < 0 {
+		return &fhttp.StatusError{
+			Err:  multipart.ErrMessageTooLarge,
+			Code: http.StatusRequestEntityTooLarge,
+		}
+	}
+
+	values.Add(part.FormName(), string(b))
 
 	return nil
 }
 
-func handleFile(wg *sync.WaitGroup, handler *multipart.FileHeader, dstDir string) (err error) {
-	defer wg.Done()
+// checkOverwrite sets whether u overwrites the existing files, as requested
+// by the query or the form fields preceding the files.  Overwriting requires
+// versioning and isn't possible in drop boxes.
+func checkOverwrite(u *fileUpload, versioning, dropBox bool) (err error) {
+	u.overwrite = u.r.URL.Query().Has(ukOverwrite) || u.values.Get(ukOverwrite) != ""
+	if !u.overwrite || (versioning && !dropBox) {
+		return nil
+	}
+
+	overwriteErr := ErrOverwriteForbidden
+	if dropBox {
+		overwriteErr = ErrDropBox
+	}
+
+	return &fhttp.StatusError{
+		Err:  overwriteErr,
+		Code: http.StatusForbidden,
+	}
+}
 
-	err = saveFile(handler, dstDir)
+// handleFile saves the single file of the upload u from part to u.dst.  The
+// directories of the file's relative path are created within u.dst, and the
+// topmost element of the path is prefixed with u.prefix, if any.  The files
+// hidden by u.rules are refused.
+func (h *dirs) handleFile(u *fileUpload, part *multipart.Part) (err error) {
+	body := bufio.NewReader(part)
+	rel, err := h.policy.checkFile(part, body)
 	if err != nil {
 		return fmt.Errorf("dirs: %w", err)
 	}
 
-	// TODO(e.burkov):  Think of reasonability.
-	log.Printf("Uploaded File: %q", handler.Filename)
-	log.Printf("File Size:     %d", handler.Size)
-	log.Printf("MIME Header:   %s", handler.Header)
+	rel = u.prefix + rel
+	if u.rules.hidesPath(rel) {
+		return fmt.Errorf("dirs: %w", hiddenError(rel))
+	}
+
+	subDir, name := path.Split(rel)
+
+	lr, err := h.newLimitReader(u, body)
+	if err != nil {
+		return fmt.Errorf("dirs: %q: %w", name, err)
+	}
+
+	sums, err := expectedSums(part, u.values[ukChecksums])
+	if err != nil {
+		return fmt.Errorf("dirs: %q: %w", name, &fhttp.StatusError{
+			Err:  err,
+			Code: http.StatusBadRequest,
+		})
+	}
+
+	fileDir, err := mkdirs(u.dst, subDir)
+	if err != nil {
+		return fmt.Errorf("dirs: %q: %w", rel, err)
+	} else if fileDir != u.dst {
+		defer func() { _ = fileDir.Close() }()
+	}
+
+	fp := u.progress.saving(uploadPath(part))
+	size, err := h.saveFile(lr, fp, fileDir, name, sums, u.overwrite)
+	if err != nil {
+		return fmt.Errorf("dirs: %q: %w", rel, err)
+	}
+
+	fp.saved(size)
+	u.usage.add(size)
+	fhttp.RecordUpload(u.r, fhttp.UploadedFile{
+		Name: rel,
+		Size: size,
+	})
 
 	return nil
 }
 
-// saveFile saves the uploaded file to the given directory path dst.
-func saveFile(handler *multipart.FileHeader, dst string) (err error) {
-	defer log.Printf("saving file to %q", path.Join(dst, handler.Filename))
+// saveFile saves the uploaded file read from src to the directory dir under
+// name.  The file is removed if its digests don't match the expected sums, if
+// any, or if src fails.  If overwrite is true, the existing file is replaced
+// and retained as a version.  The partially written file is removed if the
+// tracked upload is canceled.
+func (h *dirs) saveFile(
+	src io.Reader,
+	fp *fileProgress,
+	dir *safefs.Dir,
+	name string,
+	sums map[string][]byte,
+	overwrite bool,
+) (written int64, err error) {
+	defer log.Printf("saving file to %q", filepath.Join(dir.Name(), name))
 
 	var tmpName string
-	if ext := filepath.Ext(handler.Filename); ext != "" {
-		tmpName = handler.Filename[:len(handler.Filename)-len(ext)] + "_*" + ext
+	if ext := filepath.Ext(name); ext != "" {
+		tmpName = name[:len(name)-len(ext)] + "_*" + ext
 	} else {
-		tmpName = handler.Filename + "_*"
+		tmpName = name + "_*"
 	}
 
-	var file multipart.File
-	file, err = handler.Open()
+	f, err := dir.CreateTemp(tmpName)
 	if err != nil {
-		return fmt.Errorf("opening file: %w", err)
+		return 0, err
 	}
+	defer closeAndRename(&err, dir, f, name)
 
-	dstDir := filepath.Join(".", dst)
-	f, err := os.CreateTemp(dstDir, tmpName)
+	var dst io.Writer = f
+	sw := newSumWriter(sums)
+	blobHash := sha256.New()
+	switch {
+	case h.blobs != nil:
+		dst = io.MultiWriter(f, sw, blobHash)
+	case len(sums) > 0:
+		dst = io.MultiWriter(f, sw)
+	}
+
+	written, err = io.Copy(dst, fp.reader(src))
 	if err != nil {
-		return err
+		return written, fmt.Errorf("writing file: %w", asTooLarge(err))
 	}
-	defer closeAndRename(&err, f, filepath.Join(dstDir, handler.Filename))
 
-	written, err := io.Copy(f, file)
+	err = sw.verify(sums)
 	if err != nil {
-		return fmt.Errorf("writing file: %w", err)
-	} else if written != handler.Size {
-		return fmt.Errorf("wrote %d bytes, expected %d", written, handler.Size)
+		return written, err
 	}
 
-	return nil
+	if h.blobs != nil {
+		err = h.blobs.dedupe(dir, filepath.Base(f.Name()), blobHash.Sum(nil))
+		if err != nil {
+			return written, fmt.Errorf("deduplicating: %w", err)
+		}
+	}
+
+	if overwrite {
+		err = h.versions.retain(dir, name, false)
+		if err != nil {
+			return written, fmt.Errorf("retaining overwritten file: %w", err)
+		}
+	}
+
+	return written, nil
 }
 
-// closeAndRename renames the temporary file f to the final name if the caller
-// succeeded.  Otherwise, it deletes the temporary file.  In both cases, it adds
+// closeAndRename renames the temporary file f within dir to the final name if
+// the caller succeeded.  Otherwise, it deletes the temporary file.  In both cases, it adds
 // the own error to the caller's error.  Note that it closes the file even if
 // the caller failed.  callerErr must not be nil (*callerErr could).
-func closeAndRename(callerErr *error, f *os.File, finalName string) {
+func closeAndRename(callerErr *error, dir *safefs.Dir, f *os.File, finalName string) {
 	// It's required on Windows to close the file before renaming it.
 	err := f.Close()
-	if err != nil {
-		err = errors.Join(*callerErr, err)
-	}
+	tmpName := filepath.Base(f.Name())
 
 	var action string
-	if err != nil {
-		err = errors.Join(os.Remove(f.Name()), err)
+	if err != nil || *callerErr != nil {
+		err = errors.Join(dir.Remove(tmpName), err)
 		action = "removing temporary file"
 	} else if finalName != "" {
-		switch _, err = os.Lstat(finalName); {
+		switch _, err = dir.Lstat(finalName); {
 		case err == nil:
 			// File exists, leave the name as is.
 		case !errors.Is(err, os.ErrNotExist):
@@ -125,7 +381,7 @@ func closeAndRename(callerErr *error, f *os.File, finalName string) {
 			action = "checking file existence"
 		default:
 			// File doesn't exist, rename the temporary file.
-			err = os.Rename(f.Name(), finalName)
+			err = dir.Rename(tmpName, finalName)
 			action = "renaming temporary file"
 		}
 	} else {
@@ -0,0 +1,190 @@
+package fhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat is the format of the access log records.
+type AccessLogFormat string
+
+// Supported access log formats.
+const (
+	// AccessLogCombined is the NCSA Combined Log Format.
+	AccessLogCombined AccessLogFormat = "combined"
+
+	// AccessLogJSON writes each record as a JSON object on a separate line.
+	AccessLogJSON AccessLogFormat = "json"
+)
+
+// UnmarshalText implements the [encoding.TextUnmarshaler] interface for
+// *AccessLogFormat.
+func (f *AccessLogFormat) UnmarshalText(b []byte) (err error) {
+	switch lf := AccessLogFormat(b); lf {
+	case AccessLogCombined, AccessLogJSON:
+		*f = lf
+
+		return nil
+	default:
+		return fmt.Errorf("unsupported access log format %q", b)
+	}
+}
+
+// accessRecord is a single access log record.
+type accessRecord struct {
+	Time      time.Time      `json:"time"`
+	Remote    string         `json:"remote"`
+	Method    string         `json:"method"`
+	URI       string         `json:"uri"`
+	Proto     string         `json:"proto"`
+	Referer   string         `json:"referer,omitempty"`
+	UserAgent string         `json:"user_agent,omitempty"`
+	Route     RouteType      `json:"route"`
+	Uploads   []UploadedFile `json:"uploads,omitempty"`
+	Status    int            `json:"status"`
+	Bytes     int64          `json:"bytes"`
+	Duration  float64        `json:"duration"`
+}
+
+// AccessLog writes the records of handled requests.  It must be created with
+// [NewAccessLog].
+type AccessLog struct {
+	// mu protects w.
+	mu     *sync.Mutex
+	w      io.Writer
+	format AccessLogFormat
+}
+
+// NewAccessLog returns a new *AccessLog writing the records in format to w.
+func NewAccessLog(w io.Writer, format AccessLogFormat) (l *AccessLog) {
+	return &AccessLog{
+		mu:     &sync.Mutex{},
+		w:      w,
+		format: format,
+	}
+}
+
+// type check
+var _ Middleware = (*AccessLog)(nil).Middleware
+
+// Middleware is the [Middleware] that writes a record for each request handled
+// by h.
+func (l *AccessLog) Middleware(h http.Handler) (wrapped http.Handler) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		r, ri := withRequestInfo(r)
+		rw := NewRecordingWriter(w)
+		h.ServeHTTP(rw, r)
+
+		rec := &accessRecord{
+			Time:      start,
+			Remote:    remoteHost(r),
+			Method:    r.Method,
+			URI:       r.RequestURI,
+			Proto:     r.Proto,
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+			Route:     ri.getRoute(),
+			Uploads:   ri.getUploads(),
+			Status:    rw.Status(),
+			Bytes:     rw.Written(),
+			Duration:  time.Since(start).Seconds(),
+		}
+
+		err := l.write(rec)
+		if err != nil {
+			log.Printf("writing access log: %s", err)
+		}
+	})
+}
+
+// write formats rec and writes it to the underlying writer.
+func (l *AccessLog) write(rec *accessRecord) (err error) {
+	buf := &bytes.Buffer{}
+	switch l.format {
+	case AccessLogJSON:
+		err = json.NewEncoder(buf).Encode(rec)
+		if err != nil {
+			return fmt.Errorf("encoding record: %w", err)
+		}
+	default:
+		writeCombined(buf, rec)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.w.Write(buf.Bytes())
+
+	return err
+}
+
+// combinedTimeFormat is the time layout used by the Combined Log Format.
+const combinedTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// writeCombined writes rec to buf in the Combined Log Format.  The uploaded
+// files, if any, are appended as the quoted query string of their escaped names
+// and sizes, e.g. "a.txt=3&b+c.txt=4".
+func writeCombined(buf *bytes.Buffer, rec *accessRecord) {
+	size := "-"
+	if rec.Bytes > 0 {
+		size = strconv.FormatInt(rec.Bytes, 10)
+	}
+
+	fmt.Fprintf(
+		buf,
+		"%s - - [%s] %s %d %s %s %s",
+		rec.Remote,
+		rec.Time.Format(combinedTimeFormat),
+		strconv.Quote(rec.Method+" "+rec.URI+" "+rec.Proto),
+		rec.Status,
+		size,
+		quoteOrDash(rec.Referer),
+		quoteOrDash(rec.UserAgent),
+	)
+
+	if len(rec.Uploads) > 0 {
+		buf.WriteString(` "`)
+		for i, u := range rec.Uploads {
+			if i > 0 {
+				buf.WriteByte('&')
+			}
+
+			buf.WriteString(url.QueryEscape(u.Name))
+			buf.WriteByte('=')
+			buf.WriteString(strconv.FormatInt(u.Size, 10))
+		}
+		buf.WriteByte('"')
+	}
+
+	buf.WriteByte('\n')
+}
+
+// quoteOrDash returns the quoted s or a quoted dash if s is empty.
+func quoteOrDash(s string) (q string) {
+	if s == "" {
+		return `"-"`
+	}
+
+	return strconv.Quote(s)
+}
+
+// remoteHost returns the host part of the remote address of r.
+func remoteHost(r *http.Request) (host string) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,159 @@
+package fhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAccessLogFormat_UnmarshalText(t *testing.T) {
+	testCases := []struct {
+		name    string
+		in      string
+		want    AccessLogFormat
+		wantErr bool
+	}{{
+		name: "combined",
+		in:   "combined",
+		want: AccessLogCombined,
+	}, {
+		name: "json",
+		in:   "json",
+		want: AccessLogJSON,
+	}, {
+		name:    "unknown",
+		in:      "common",
+		wantErr: true,
+	}, {
+		name:    "empty",
+		in:      "",
+		wantErr: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got AccessLogFormat
+			err := got.UnmarshalText([]byte(tc.in))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got %q, want error", got)
+				}
+
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			} else if got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// serveLogged serves r with h wrapped into the access log in format and
+// returns the written record.
+func serveLogged(t *testing.T, format AccessLogFormat, h http.Handler, r *http.Request) (rec string) {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	NewAccessLog(buf, format).Middleware(h).ServeHTTP(httptest.NewRecorder(), r)
+
+	return buf.String()
+}
+
+func TestAccessLog_combined(t *testing.T) {
+	testCases := []struct {
+		name    string
+		req     func() (r *http.Request)
+		want    *regexp.Regexp
+		uploads []UploadedFile
+	}{{
+		name: "full",
+		req: func() (r *http.Request) {
+			r = httptest.NewRequest(http.MethodGet, "/dir/file.txt?x=1", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			r.Header.Set("Referer", "http://example.com/")
+			r.Header.Set("User-Agent", "test/1.0")
+
+			return r
+		},
+		want: regexp.MustCompile(`^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] ` +
+			`"GET /dir/file\.txt\?x=1 HTTP/1\.1" 200 8 "http://example\.com/" "test/1\.0"\n$`),
+		uploads: nil,
+	}, {
+		name: "no_headers",
+		req: func() (r *http.Request) {
+			r = httptest.NewRequest(http.MethodHead, "/", nil)
+			r.RemoteAddr = "[2001:db8::1]:1234"
+
+			return r
+		},
+		want:    regexp.MustCompile(`^2001:db8::1 - - \[.+\] "HEAD / HTTP/1\.1" 200 8 "-" "-"\n$`),
+		uploads: nil,
+	}, {
+		name: "uploads",
+		req: func() (r *http.Request) {
+			r = httptest.NewRequest(http.MethodPost, "/dir/?upload", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+
+			return r
+		},
+		want: regexp.MustCompile(`^192\.0\.2\.1 - - \[.+\] "POST /dir/\?upload HTTP/1\.1" 200 8 "-" "-" ` +
+			`"a\.txt=3&b\+%22%26%3D%22\.txt=4"\n$`),
+		uploads: []UploadedFile{{Name: "a.txt", Size: 3}, {Name: `b "&=".txt`, Size: 4}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for _, u := range tc.uploads {
+					RecordUpload(r, u)
+				}
+
+				_, _ = io.WriteString(w, "contents")
+			})
+
+			got := serveLogged(t, AccessLogCombined, h, tc.req())
+			if !tc.want.MatchString(got) {
+				t.Fatalf("got %q, want matching %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAccessLog_json(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetRoute(r, RouteUpload)
+		RecordUpload(r, UploadedFile{Name: "a.txt", Size: 3})
+		RecordUpload(r, UploadedFile{Name: "b.txt", Size: 4})
+		w.WriteHeader(http.StatusSeeOther)
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/dir/?upload", strings.NewReader("body"))
+	r.RemoteAddr = "192.0.2.1:1234"
+	line := serveLogged(t, AccessLogJSON, h, r)
+
+	got := &accessRecord{}
+	err := json.Unmarshal([]byte(line), got)
+	if err != nil {
+		t.Fatalf("decoding %q: %v", line, err)
+	}
+
+	switch {
+	case got.Remote != "192.0.2.1",
+		got.Method != http.MethodPost,
+		got.URI != "/dir/?upload",
+		got.Route != RouteUpload,
+		got.Status != http.StatusSeeOther,
+		got.Bytes != 0,
+		got.Duration < 0,
+		len(got.Uploads) != 2,
+		got.Uploads[0] != UploadedFile{Name: "a.txt", Size: 3},
+		got.Uploads[1] != UploadedFile{Name: "b.txt", Size: 4}:
+		t.Fatalf("unexpected record %q", line)
+	}
+}
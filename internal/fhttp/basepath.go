@@ -0,0 +1,66 @@
+package fhttp
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// basePathCtxKey is the context key for the base path of the request.
+type basePathCtxKey struct{}
+
+// CleanBasePath returns the normalized URL path p to mount the server at, with
+// the leading slash and without the trailing one.  The root is represented by
+// an empty string.
+func CleanBasePath(p string) (base string) {
+	base = path.Clean("/" + p)
+	if base == "/" {
+		return ""
+	}
+
+	return base
+}
+
+// BasePath returns the URL path prefix r has been received under, as stripped
+// by [StripBasePath].  It's empty if the server is mounted at the root.
+func BasePath(r *http.Request) (base string) {
+	base, _ = r.Context().Value(basePathCtxKey{}).(string)
+
+	return base
+}
+
+// StripBasePath returns a [Middleware] that serves the requests under the
+// cleaned URL path base, see [CleanBasePath], with the prefix removed from the
+// URL path.  The request of base itself is redirected to base with the
+// trailing slash, the rest are rejected with onError as not found.  base
+// must not be empty.
+func StripBasePath(base string, onError ErrorHandler) (mw Middleware) {
+	return func(h http.Handler) (wrapped http.Handler) {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = r.WithContext(context.WithValue(r.Context(), basePathCtxKey{}, base))
+
+			p, ok := strings.CutPrefix(r.URL.Path, base)
+			switch {
+			case ok && p == "":
+				u := base + "/"
+				if q := r.URL.RawQuery; q != "" {
+					u += "?" + q
+				}
+				http.Redirect(w, r, u, http.StatusMovedPermanently)
+			case ok && strings.HasPrefix(p, "/"):
+				u := *r.URL
+				u.Path = p
+				u.RawPath = ""
+				if rp, rawOK := strings.CutPrefix(r.URL.RawPath, base); rawOK {
+					u.RawPath = rp
+				}
+				r.URL = &u
+				h.ServeHTTP(w, r)
+			default:
+				onError(w, r, fs.ErrNotExist)
+			}
+		})
+	}
+}
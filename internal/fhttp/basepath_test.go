@@ -0,0 +1,112 @@
+package fhttp
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCleanBasePath(t *testing.T) {
+	testCases := []struct {
+		in   string
+		want string
+	}{
+		{in: "", want: ""},
+		{in: "/", want: ""},
+		{in: "files", want: "/files"},
+		{in: "/files/", want: "/files"},
+		{in: "//files/../srv/./x", want: "/srv/x"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.in, func(t *testing.T) {
+			if got := CleanBasePath(tc.in); got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStripBasePath(t *testing.T) {
+	onError := func(w http.ResponseWriter, _ *http.Request, err error) {
+		code := http.StatusInternalServerError
+		if errors.Is(err, fs.ErrNotExist) {
+			code = http.StatusNotFound
+		}
+
+		w.WriteHeader(code)
+	}
+
+	h := StripBasePath("/files", onError)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Path", r.URL.Path)
+		w.Header().Set("X-Raw-Path", r.URL.RawPath)
+		w.Header().Set("X-Base", BasePath(r))
+	}))
+
+	testCases := []struct {
+		name         string
+		target       string
+		wantCode     int
+		wantPath     string
+		wantRawPath  string
+		wantLocation string
+	}{{
+		name:     "root",
+		target:   "/files/",
+		wantCode: http.StatusOK,
+		wantPath: "/",
+	}, {
+		name:     "file",
+		target:   "/files/a/b.txt?x=1",
+		wantCode: http.StatusOK,
+		wantPath: "/a/b.txt",
+	}, {
+		name:        "escaped",
+		target:      "/files/a%2Fb.txt",
+		wantCode:    http.StatusOK,
+		wantPath:    "/a/b.txt",
+		wantRawPath: "/a%2Fb.txt",
+	}, {
+		name:         "base",
+		target:       "/files?x=1",
+		wantCode:     http.StatusMovedPermanently,
+		wantLocation: "/files/?x=1",
+	}, {
+		name:     "same_start",
+		target:   "/filesystem",
+		wantCode: http.StatusNotFound,
+	}, {
+		name:     "outside",
+		target:   "/other/",
+		wantCode: http.StatusNotFound,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, tc.target, nil))
+
+			if rw.Code != tc.wantCode {
+				t.Fatalf("got status %d, want %d", rw.Code, tc.wantCode)
+			} else if got := rw.Header().Get("Location"); got != tc.wantLocation {
+				t.Fatalf("got location %q, want %q", got, tc.wantLocation)
+			} else if tc.wantCode != http.StatusOK {
+				return
+			}
+
+			if got := rw.Header().Get("X-Path"); got != tc.wantPath {
+				t.Errorf("got path %q, want %q", got, tc.wantPath)
+			}
+
+			if got := rw.Header().Get("X-Raw-Path"); got != tc.wantRawPath {
+				t.Errorf("got raw path %q, want %q", got, tc.wantRawPath)
+			}
+
+			if got := rw.Header().Get("X-Base"); got != "/files" {
+				t.Errorf("got base path %q, want /files", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package fhttp
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported content codings.
+const (
+	EncodingBrotli = "br"
+	EncodingZstd   = "zstd"
+	EncodingGzip   = "gzip"
+)
+
+// Encodings are the supported content codings in the order of preference.
+var Encodings = []string{EncodingBrotli, EncodingZstd, EncodingGzip}
+
+// minCompressSize is the minimum size of the response body worth compressing.
+const minCompressSize = 1024
+
+// NegotiateEncoding returns the content coding out of available to use for
+// the response to r according to its Accept-Encoding header.  available must
+// be in the order of preference.  It returns an empty string if the response
+// shouldn't be encoded.
+func NegotiateEncoding(r *http.Request, available ...string) (enc string) {
+	accepted := map[string]float64{}
+	for _, field := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(field, ",") {
+			coding, q := parseQuality(part)
+			if coding != "" {
+				accepted[coding] = q
+			}
+		}
+	}
+
+	best := 0.0
+	for _, a := range available {
+		q, ok := accepted[a]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+
+		if ok && q > best {
+			enc, best = a, q
+		}
+	}
+
+	return enc
+}
+
+// parseQuality parses a single element of the Accept-Encoding header.  The
+// quality defaults to 1.
+func parseQuality(elem string) (coding string, q float64) {
+	coding, params, _ := strings.Cut(elem, ";")
+	coding = strings.ToLower(strings.TrimSpace(coding))
+	q = 1
+
+	for _, param := range strings.Split(params, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok || strings.ToLower(strings.TrimSpace(k)) != "q" {
+			continue
+		}
+
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err == nil {
+			q = parsed
+		}
+	}
+
+	return coding, q
+}
+
+// incompressibleTypes are the media types that don't benefit from compression
+// despite matching the compressible prefixes.
+var incompressibleTypes = map[string]struct{}{
+	"application/gzip":   {},
+	"application/x-gzip": {},
+	"application/zip":    {},
+	"application/zstd":   {},
+	"font/woff":          {},
+	"font/woff2":         {},
+
+	// The events are flushed one by one, which defeats the compression.
+	"text/event-stream": {},
+}
+
+// IsCompressible returns true if the content of the media type ct is worth
+// compressing.  Images, audio, video and archives are usually compressed
+// already.
+func IsCompressible(ct string) (ok bool) {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	} else if _, ok = incompressibleTypes[mt]; ok {
+		return false
+	}
+
+	switch {
+	case
+		strings.HasPrefix(mt, "text/"),
+		strings.HasPrefix(mt, "font/"),
+		strings.HasSuffix(mt, "+json"),
+		strings.HasSuffix(mt, "+xml"),
+		mt == "application/json",
+		mt == "application/javascript",
+		mt == "application/xml",
+		mt == "application/wasm",
+		mt == "application/x-sh",
+		mt == "image/svg+xml",
+		mt == "image/bmp":
+		return true
+	default:
+		return false
+	}
+}
+
+// encoder is a compressing writer that can be reused.
+type encoder interface {
+	io.WriteCloser
+
+	// Flush writes the pending data to the underlying writer.
+	Flush() (err error)
+
+	// Reset discards the state and makes the encoder write to w.
+	Reset(w io.Writer)
+}
+
+// encoderPools are the pools of the encoders by content coding.
+var encoderPools = map[string]*sync.Pool{
+	EncodingBrotli: {New: func() any {
+		return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+	}},
+	EncodingZstd: {New: func() any {
+		// The error is only returned for invalid options.
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+
+		return enc
+	}},
+	EncodingGzip: {New: func() any {
+		return gzip.NewWriter(nil)
+	}},
+}
+
+// Compress is the [Middleware] that compresses the responses of h on the fly
+// using the content coding negotiated with the client.  It leaves untouched
+// the responses which are already encoded, are partial, have incompressible
+// media type, or are too small.
+//
+// The entity tag of a compressed response is made weak and gets the content
+// coding appended, since its bytes differ from the identity representation.
+// The tags in the If-None-Match header are changed back before h sees them.
+func Compress(h http.Handler) (wrapped http.Handler) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := NegotiateEncoding(r, Encodings...)
+		if enc == "" || r.Header.Get("Range") != "" {
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			encoding:       enc,
+		}
+		defer cw.close()
+
+		if inm := r.Header.Get("If-None-Match"); inm != "" {
+			var identity string
+			identity, cw.encodedMatch = identityETags(inm, enc)
+			if cw.encodedMatch {
+				r = r.WithContext(r.Context())
+				r.Header = r.Header.Clone()
+				r.Header.Set("If-None-Match", identity)
+			}
+		}
+
+		h.ServeHTTP(cw, r)
+	})
+}
+
+// encodedETag returns the entity tag of the representation with the content
+// coding enc made out of the identity one's etag.  It's empty if etag is
+// malformed.
+func encodedETag(etag, enc string) (encoded string) {
+	opaque := strings.TrimPrefix(etag, "W/")
+	if len(opaque) < 2 || opaque[0] != '"' || opaque[len(opaque)-1] != '"' {
+		return ""
+	}
+
+	return `W/` + opaque[:len(opaque)-1] + "-" + enc + `"`
+}
+
+// identityETags returns the list of entity tags inm with the tags made by
+// [encodedETag] for enc changed back to the identity ones.  ok is false if
+// there are none of those.
+func identityETags(inm, enc string) (identity string, ok bool) {
+	suffix := "-" + enc + `"`
+	tags := strings.Split(inm, ",")
+	for i, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, `W/"`) && strings.HasSuffix(tag, suffix) {
+			tags[i] = tag[:len(tag)-len(suffix)] + `"`
+			ok = true
+		}
+	}
+
+	return strings.Join(tags, ","), ok
+}
+
+// compressWriter is an [http.ResponseWriter] that decides whether to compress
+// the response once the header is written.
+type compressWriter struct {
+	http.ResponseWriter
+
+	// enc is nil unless the response is being compressed.
+	enc encoder
+
+	encoding string
+
+	// encodedMatch is true if the request's If-None-Match contains the
+	// entity tags of the compressed representation.
+	encodedMatch bool
+
+	wroteHeader bool
+}
+
+// type check
+var _ http.Flusher = (*compressWriter)(nil)
+
+// WriteHeader implements the [http.ResponseWriter] interface for
+// *compressWriter.
+func (w *compressWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeader(code)
+
+		return
+	}
+	w.wroteHeader = true
+
+	hdr := w.Header()
+	if code == http.StatusNotModified && w.encodedMatch {
+		// Confirm the compressed representation the client has.
+		setEncodedETag(hdr, w.encoding)
+	}
+
+	if code != http.StatusOK ||
+		hdr.Get("Content-Encoding") != "" ||
+		!IsCompressible(hdr.Get("Content-Type")) {
+		w.ResponseWriter.WriteHeader(code)
+
+		return
+	}
+	AddVary(hdr, "Accept-Encoding")
+
+	if shouldCompress(hdr) {
+		hdr.Set("Content-Encoding", w.encoding)
+		hdr.Del("Content-Length")
+		setEncodedETag(hdr, w.encoding)
+
+		// The byte ranges of the identity representation don't apply to
+		// the encoded one.
+		hdr.Del("Accept-Ranges")
+
+		w.enc = encoderPools[w.encoding].Get().(encoder)
+		w.enc.Reset(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// AddVary adds the field name to the Vary header of hdr unless it's already
+// there.
+func AddVary(hdr http.Header, name string) {
+	for _, v := range hdr.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f == "*" || strings.EqualFold(f, name) {
+				return
+			}
+		}
+	}
+
+	hdr.Add("Vary", name)
+}
+
+// setEncodedETag replaces the entity tag in hdr, if any, with the one of the
+// representation with the content coding enc.
+func setEncodedETag(hdr http.Header, enc string) {
+	if etag := hdr.Get("Etag"); etag != "" {
+		if encoded := encodedETag(etag, enc); encoded != "" {
+			hdr.Set("Etag", encoded)
+		} else {
+			hdr.Del("Etag")
+		}
+	}
+}
+
+// shouldCompress returns true if the response with hdr should be compressed.
+func shouldCompress(hdr http.Header) (ok bool) {
+	if hdr.Get("Content-Range") != "" {
+		return false
+	}
+
+	cl := hdr.Get("Content-Length")
+	if cl == "" {
+		return true
+	}
+
+	n, err := strconv.ParseInt(cl, 10, 64)
+
+	return err == nil && n >= minCompressSize
+}
+
+// Write implements the [http.ResponseWriter] interface for *compressWriter.
+func (w *compressWriter) Write(b []byte) (n int, err error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.enc == nil {
+		return w.ResponseWriter.Write(b)
+	}
+
+	return w.enc.Write(b)
+}
+
+// Flush implements the [http.Flusher] interface for *compressWriter.
+func (w *compressWriter) Flush() {
+	if w.enc != nil {
+		_ = w.enc.Flush()
+	}
+
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying writer.  It's used by
+// [http.ResponseController].
+func (w *compressWriter) Unwrap() (rw http.ResponseWriter) {
+	return w.ResponseWriter
+}
+
+// close finishes the compressed stream, if any, and returns the encoder to the
+// pool.
+func (w *compressWriter) close() {
+	if w.enc == nil {
+		return
+	}
+
+	_ = w.enc.Close()
+	w.enc.Reset(nil)
+	encoderPools[w.encoding].Put(w.enc)
+	w.enc = nil
+}
@@ -0,0 +1,355 @@
+package fhttp
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestEncodedETag(t *testing.T) {
+	testCases := []struct {
+		name string
+		etag string
+		want string
+	}{{
+		name: "strong",
+		etag: `"abc"`,
+		want: `W/"abc-gzip"`,
+	}, {
+		name: "weak",
+		etag: `W/"abc"`,
+		want: `W/"abc-gzip"`,
+	}, {
+		name: "malformed",
+		etag: `abc`,
+		want: "",
+	}, {
+		name: "quote",
+		etag: `"`,
+		want: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := encodedETag(tc.etag, EncodingGzip); got != tc.want {
+				t.Fatalf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIdentityETags(t *testing.T) {
+	testCases := []struct {
+		name   string
+		inm    string
+		want   string
+		wantOK bool
+	}{{
+		name:   "encoded",
+		inm:    `W/"abc-gzip"`,
+		want:   `W/"abc"`,
+		wantOK: true,
+	}, {
+		name:   "list",
+		inm:    `"x", W/"abc-gzip"`,
+		want:   `"x",W/"abc"`,
+		wantOK: true,
+	}, {
+		name:   "other_encoding",
+		inm:    `W/"abc-br"`,
+		want:   `W/"abc-br"`,
+		wantOK: false,
+	}, {
+		name:   "strong",
+		inm:    `"abc-gzip"`,
+		want:   `"abc-gzip"`,
+		wantOK: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := identityETags(tc.inm, EncodingGzip)
+			if ok != tc.wantOK {
+				t.Fatalf("got ok %t, want %t", ok, tc.wantOK)
+			} else if ok && got != tc.want {
+				t.Fatalf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// etagHandler serves a compressible body with the strong entity tag etag and
+// handles If-None-Match with the weak comparison.
+func etagHandler(etag string) (h http.Handler) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", etag)
+		if strings.TrimPrefix(r.Header.Get("If-None-Match"), "W/") == etag {
+			w.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(strings.Repeat("compressible ", 200)))
+	})
+}
+
+func TestCompress_etag(t *testing.T) {
+	const etag = `"abc"`
+
+	h := Compress(etagHandler(etag))
+
+	testCases := []struct {
+		name     string
+		encoding string
+		inm      string
+		wantCode int
+		wantTag  string
+	}{{
+		name:     "identity",
+		encoding: "",
+		wantCode: http.StatusOK,
+		wantTag:  etag,
+	}, {
+		name:     "compressed",
+		encoding: EncodingGzip,
+		wantCode: http.StatusOK,
+		wantTag:  `W/"abc-gzip"`,
+	}, {
+		name:     "compressed_not_modified",
+		encoding: EncodingGzip,
+		inm:      `W/"abc-gzip"`,
+		wantCode: http.StatusNotModified,
+		wantTag:  `W/"abc-gzip"`,
+	}, {
+		name:     "identity_not_modified",
+		encoding: "",
+		inm:      etag,
+		wantCode: http.StatusNotModified,
+		wantTag:  etag,
+	}, {
+		name:     "changed",
+		encoding: EncodingGzip,
+		inm:      `W/"old-gzip"`,
+		wantCode: http.StatusOK,
+		wantTag:  `W/"abc-gzip"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.encoding != "" {
+				r.Header.Set("Accept-Encoding", tc.encoding)
+			}
+			if tc.inm != "" {
+				r.Header.Set("If-None-Match", tc.inm)
+			}
+
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, r)
+
+			if rw.Code != tc.wantCode {
+				t.Fatalf("got status %d, want %d", rw.Code, tc.wantCode)
+			} else if got := rw.Header().Get("Etag"); got != tc.wantTag {
+				t.Fatalf("got tag %s, want %s", got, tc.wantTag)
+			} else if got = r.Header.Get("If-None-Match"); got != tc.inm {
+				t.Fatalf("request header changed to %s", got)
+			}
+		})
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	testCases := []struct {
+		name   string
+		accept []string
+		want   string
+	}{{
+		name:   "none",
+		accept: nil,
+		want:   "",
+	}, {
+		name:   "single",
+		accept: []string{"gzip"},
+		want:   EncodingGzip,
+	}, {
+		name:   "preference",
+		accept: []string{"gzip, br, zstd"},
+		want:   EncodingBrotli,
+	}, {
+		name:   "quality",
+		accept: []string{"br;q=0.5, gzip;q=0.8"},
+		want:   EncodingGzip,
+	}, {
+		name:   "refused",
+		accept: []string{"br;q=0, gzip;q=0"},
+		want:   "",
+	}, {
+		name:   "star",
+		accept: []string{"*"},
+		want:   EncodingBrotli,
+	}, {
+		name:   "star_refused",
+		accept: []string{"*;q=0.5, br;q=0"},
+		want:   EncodingZstd,
+	}, {
+		name:   "case_and_spaces",
+		accept: []string{" GZIP ; Q=1 "},
+		want:   EncodingGzip,
+	}, {
+		name:   "several_fields",
+		accept: []string{"deflate", "zstd"},
+		want:   EncodingZstd,
+	}, {
+		name:   "unsupported",
+		accept: []string{"deflate, compress"},
+		want:   "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, v := range tc.accept {
+				r.Header.Add("Accept-Encoding", v)
+			}
+
+			if got := NegotiateEncoding(r, Encodings...); got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIsCompressible(t *testing.T) {
+	testCases := []struct {
+		ct   string
+		want bool
+	}{
+		{ct: "text/html; charset=utf-8", want: true},
+		{ct: "application/json", want: true},
+		{ct: "application/ld+json", want: true},
+		{ct: "image/svg+xml", want: true},
+		{ct: "text/event-stream", want: false},
+		{ct: "application/gzip", want: false},
+		{ct: "image/png", want: false},
+		{ct: "application/octet-stream", want: false},
+		{ct: "", want: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.ct, func(t *testing.T) {
+			if got := IsCompressible(tc.ct); got != tc.want {
+				t.Fatalf("got %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat("compressible ", 200)
+
+	testCases := []struct {
+		name     string
+		ct       string
+		body     string
+		encoding string
+		rng      string
+		code     int
+		wantEnc  string
+	}{{
+		name:     "compressed",
+		ct:       "text/plain",
+		body:     large,
+		encoding: EncodingGzip,
+		code:     http.StatusOK,
+		wantEnc:  EncodingGzip,
+	}, {
+		name:     "small",
+		ct:       "text/plain",
+		body:     "small",
+		encoding: EncodingGzip,
+		code:     http.StatusOK,
+		wantEnc:  "",
+	}, {
+		name:     "incompressible",
+		ct:       "image/png",
+		body:     large,
+		encoding: EncodingGzip,
+		code:     http.StatusOK,
+		wantEnc:  "",
+	}, {
+		name:     "range",
+		ct:       "text/plain",
+		body:     large,
+		encoding: EncodingGzip,
+		rng:      "bytes=0-9",
+		code:     http.StatusOK,
+		wantEnc:  "",
+	}, {
+		name:     "error",
+		ct:       "text/plain",
+		body:     large,
+		encoding: EncodingGzip,
+		code:     http.StatusNotFound,
+		wantEnc:  "",
+	}, {
+		name:     "not_accepted",
+		ct:       "text/plain",
+		body:     large,
+		encoding: "",
+		code:     http.StatusOK,
+		wantEnc:  "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := Compress(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", tc.ct)
+				w.Header().Set("Content-Length", strconv.Itoa(len(tc.body)))
+				w.WriteHeader(tc.code)
+				_, _ = io.WriteString(w, tc.body)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.encoding != "" {
+				r.Header.Set("Accept-Encoding", tc.encoding)
+			}
+			if tc.rng != "" {
+				r.Header.Set("Range", tc.rng)
+			}
+
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, r)
+
+			if got := rw.Header().Get("Content-Encoding"); got != tc.wantEnc {
+				t.Fatalf("got encoding %q, want %q", got, tc.wantEnc)
+			} else if tc.wantEnc == "" {
+				if rw.Body.String() != tc.body {
+					t.Fatal("body changed")
+				}
+
+				return
+			}
+
+			zr, err := gzip.NewReader(rw.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			} else if string(got) != tc.body {
+				t.Fatal("decompressed body differs")
+			} else if rw.Header().Get("Content-Length") != "" {
+				t.Fatal("content length of identity is kept")
+			} else if !strings.Contains(rw.Header().Get("Vary"), "Accept-Encoding") {
+				t.Fatal("no vary")
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package fhttp
+
+import (
+	"net/http"
+)
+
+// StatusError is an error that should be reported to the client with the
+// specific HTTP status code.
+type StatusError struct {
+	// Err is the underlying error.  It may be nil.
+	Err error
+
+	// Code is the HTTP status code.
+	Code int
+}
+
+// type check
+var _ error = (*StatusError)(nil)
+
+// Error implements the [error] interface for *StatusError.
+func (e *StatusError) Error() (msg string) {
+	if e.Err == nil {
+		return http.StatusText(e.Code)
+	}
+
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *StatusError) Unwrap() (err error) {
+	return e.Err
+}
+
+// ErrorHandler writes err to w as the response to r.
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
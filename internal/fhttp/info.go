@@ -0,0 +1,136 @@
+package fhttp
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// RouteType is the kind of resource the request has been routed to.
+type RouteType string
+
+// Known route types.
+const (
+	RouteUnknown RouteType = "unknown"
+	RouteListing RouteType = "listing"
+	RouteFile    RouteType = "file"
+	RouteStatic  RouteType = "static"
+	RouteUpload  RouteType = "upload"
+)
+
+// UploadedFile describes a single file received within an upload request.
+type UploadedFile struct {
+	// Name is the name of the file as sent by the client.
+	Name string `json:"name"`
+
+	// Size is the size of the file in bytes.
+	Size int64 `json:"size"`
+}
+
+// requestInfo is the mutable request data shared between the handler and the
+// middlewares through the request context.
+type requestInfo struct {
+	// mu protects the fields below, since the handler may fill those
+	// concurrently.
+	mu *sync.Mutex
+
+	// onRoute are called each time the route is set.
+	onRoute []func(rt RouteType)
+
+	// uploads are the files received within the request.
+	uploads []UploadedFile
+
+	route RouteType
+}
+
+// requestInfoCtxKey is the context key for *requestInfo.
+type requestInfoCtxKey struct{}
+
+// withRequestInfo returns a copy of r with a new request info attached to its
+// context unless it already has one.  ri is never nil.
+func withRequestInfo(r *http.Request) (withRI *http.Request, ri *requestInfo) {
+	ri, ok := r.Context().Value(requestInfoCtxKey{}).(*requestInfo)
+	if ok {
+		return r, ri
+	}
+
+	ri = &requestInfo{
+		mu:    &sync.Mutex{},
+		route: RouteUnknown,
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), requestInfoCtxKey{}, ri)), ri
+}
+
+// requestInfoFrom returns the request info attached to r, if any.
+func requestInfoFrom(r *http.Request) (ri *requestInfo, ok bool) {
+	ri, ok = r.Context().Value(requestInfoCtxKey{}).(*requestInfo)
+
+	return ri, ok
+}
+
+// onRouteSet registers f to be called each time the route of the request is set.
+func (ri *requestInfo) onRouteSet(f func(rt RouteType)) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	ri.onRoute = append(ri.onRoute, f)
+}
+
+// getRoute returns the route type set so far.
+func (ri *requestInfo) getRoute() (rt RouteType) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	return ri.route
+}
+
+// getUploads returns a copy of the uploaded files recorded so far.
+func (ri *requestInfo) getUploads() (uploads []UploadedFile) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	return append(uploads, ri.uploads...)
+}
+
+// SetRoute marks r as routed to the resource of type rt.  It does nothing if
+// no middleware is interested in the route type.
+func SetRoute(r *http.Request, rt RouteType) {
+	ri, ok := requestInfoFrom(r)
+	if !ok {
+		return
+	}
+
+	ri.mu.Lock()
+	ri.route = rt
+	onRoute := ri.onRoute
+	ri.mu.Unlock()
+
+	for _, f := range onRoute {
+		f(rt)
+	}
+}
+
+// Route returns the route type r has been routed to so far.
+func Route(r *http.Request) (rt RouteType) {
+	ri, ok := requestInfoFrom(r)
+	if !ok {
+		return RouteUnknown
+	}
+
+	return ri.getRoute()
+}
+
+// RecordUpload records the file received within r.  It's safe for concurrent
+// use.  It does nothing if no middleware is interested in the uploads.
+func RecordUpload(r *http.Request, f UploadedFile) {
+	ri, ok := requestInfoFrom(r)
+	if !ok {
+		return
+	}
+
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	ri.uploads = append(ri.uploads, f)
+}
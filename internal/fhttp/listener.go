@@ -0,0 +1,186 @@
+package fhttp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// rejectResponse is written to the connections which can't be served due to
+// the limit of simultaneous connections.
+const rejectResponse = "HTTP/1.1 503 Service Unavailable\r\n" +
+	"Retry-After: 10\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Length: 31\r\n" +
+	"Connection: close\r\n" +
+	"\r\n" +
+	"too many connections, try later"
+
+// rejectWriteTimeout is the timeout for writing [rejectResponse].
+const rejectWriteTimeout = 5 * time.Second
+
+// acceptResult is the result of accepting a connection.
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// limitListener is a [net.Listener] that limits the number of simultaneously
+// open connections.
+type limitListener struct {
+	net.Listener
+
+	// slots are taken by the accepted connections until they're closed.
+	slots chan struct{}
+
+	// queue is taken by the connections waiting for a slot.  The underlying
+	// listener isn't accepted from while it's full, so that the waiting
+	// connections don't take an unbounded number of file descriptors and
+	// goroutines.
+	queue chan struct{}
+
+	results chan acceptResult
+	done    chan struct{}
+
+	closeOnce *sync.Once
+	timeout   time.Duration
+}
+
+// LimitListener returns a listener that accepts at most n simultaneous
+// connections from ln.  At most n connections over the limit wait for the free
+// slot, each at most timeout, and get the [http.StatusServiceUnavailable]
+// response after that.  The rest are left in the operating system's backlog
+// until one of those is done waiting.
+func LimitListener(ln net.Listener, n int, timeout time.Duration) (limited net.Listener) {
+	l := &limitListener{
+		Listener:  ln,
+		slots:     make(chan struct{}, n),
+		queue:     make(chan struct{}, n),
+		results:   make(chan acceptResult),
+		done:      make(chan struct{}),
+		closeOnce: &sync.Once{},
+		timeout:   timeout,
+	}
+
+	go l.acceptLoop()
+
+	return l
+}
+
+// acceptLoop accepts the connections from the underlying listener until it's
+// closed.  It only accepts a connection when there is a place in the queue for
+// it.
+func (l *limitListener) acceptLoop() {
+	for {
+		select {
+		case l.queue <- struct{}{}:
+			// Go on.
+		case <-l.done:
+			return
+		}
+
+		c, err := l.Listener.Accept()
+		if err != nil {
+			<-l.queue
+
+			select {
+			case l.results <- acceptResult{err: err}:
+			case <-l.done:
+				return
+			}
+
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+
+			return
+		}
+
+		go l.admit(c)
+	}
+}
+
+// admit waits for the free slot for c and passes it to Accept or rejects it.
+// It frees the place of c in the queue when done.
+func (l *limitListener) admit(c net.Conn) {
+	defer func() { <-l.queue }()
+
+	select {
+	case l.slots <- struct{}{}:
+		l.deliver(c)
+
+		return
+	default:
+		// Go on.
+	}
+
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		l.deliver(c)
+	case <-timer.C:
+		reject(c)
+	case <-l.done:
+		_ = c.Close()
+	}
+}
+
+// deliver passes c, which has already taken a slot, to Accept.
+func (l *limitListener) deliver(c net.Conn) {
+	lc := &limitedConn{
+		Conn:        c,
+		releaseOnce: &sync.Once{},
+		release:     func() { <-l.slots },
+	}
+
+	select {
+	case l.results <- acceptResult{conn: lc}:
+	case <-l.done:
+		_ = lc.Close()
+	}
+}
+
+// reject writes the rejection response to c and closes it.
+func reject(c net.Conn) {
+	_ = c.SetWriteDeadline(time.Now().Add(rejectWriteTimeout))
+	_, _ = c.Write([]byte(rejectResponse))
+	_ = c.Close()
+}
+
+// Accept implements the [net.Listener] interface for *limitListener.
+func (l *limitListener) Accept() (c net.Conn, err error) {
+	select {
+	case res := <-l.results:
+		return res.conn, res.err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements the [net.Listener] interface for *limitListener.
+func (l *limitListener) Close() (err error) {
+	err = l.Listener.Close()
+	l.closeOnce.Do(func() { close(l.done) })
+
+	return err
+}
+
+// limitedConn is a [net.Conn] that frees the listener's slot on close.
+type limitedConn struct {
+	net.Conn
+
+	releaseOnce *sync.Once
+	release     func()
+}
+
+// Close implements the [net.Conn] interface for *limitedConn.
+func (c *limitedConn) Close() (err error) {
+	err = c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+
+	return err
+}
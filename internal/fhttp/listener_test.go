@@ -0,0 +1,189 @@
+package fhttp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newLimitListener returns a listener on the loopback accepting at most n
+// simultaneous connections, closed on the test's cleanup.
+func newLimitListener(t *testing.T, n int, timeout time.Duration) (ln net.Listener) {
+	t.Helper()
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln = LimitListener(inner, n, timeout)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	return ln
+}
+
+// dial connects to ln, closing the connection on the test's cleanup.
+func dial(t *testing.T, ln net.Listener) (c net.Conn) {
+	t.Helper()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+
+	return c
+}
+
+// acceptAsync accepts a connection from ln in the background.
+func acceptAsync(ln net.Listener) (res <-chan acceptResult) {
+	ch := make(chan acceptResult, 1)
+	go func() {
+		c, err := ln.Accept()
+		ch <- acceptResult{conn: c, err: err}
+	}()
+
+	return ch
+}
+
+func TestLimitListener_reject(t *testing.T) {
+	ln := newLimitListener(t, 1, 50*time.Millisecond)
+
+	_ = dial(t, ln)
+	first := <-acceptAsync(ln)
+	if first.err != nil {
+		t.Fatal(first.err)
+	}
+	defer func() { _ = first.conn.Close() }()
+
+	// The second connection waits for the slot and gets rejected.
+	c := dial(t, ln)
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.HasPrefix(string(resp), "HTTP/1.1 503 ") {
+		t.Fatalf("got response %q", resp)
+	}
+}
+
+func TestLimitListener_queue(t *testing.T) {
+	ln := newLimitListener(t, 1, 5*time.Second)
+
+	_ = dial(t, ln)
+	first := <-acceptAsync(ln)
+	if first.err != nil {
+		t.Fatal(first.err)
+	}
+
+	_ = dial(t, ln)
+	second := acceptAsync(ln)
+	select {
+	case res := <-second:
+		t.Fatalf("accepted %v over the limit", res)
+	case <-time.After(50 * time.Millisecond):
+		// Go on.
+	}
+
+	// Closing the first connection frees the slot for the queued one.
+	_ = first.conn.Close()
+	select {
+	case res := <-second:
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		_ = res.conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued connection isn't accepted")
+	}
+}
+
+// countingListener is a [net.Listener] counting the accepted connections.
+type countingListener struct {
+	net.Listener
+
+	accepted *atomic.Int64
+}
+
+// Accept implements the [net.Listener] interface for *countingListener.
+func (l *countingListener) Accept() (c net.Conn, err error) {
+	c, err = l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+
+	return c, err
+}
+
+// waitAccepted waits until l has accepted exactly want connections and stays
+// there for a while.
+func waitAccepted(t *testing.T, l *countingListener, want int64) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for l.accepted.Load() < want && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if got := l.accepted.Load(); got != want {
+		t.Fatalf("got %d accepted connections, want %d", got, want)
+	}
+}
+
+func TestLimitListener_queueLimit(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counting := &countingListener{Listener: inner, accepted: &atomic.Int64{}}
+	ln := LimitListener(counting, 1, 5*time.Second)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	_ = dial(t, ln)
+	first := <-acceptAsync(ln)
+	if first.err != nil {
+		t.Fatal(first.err)
+	}
+
+	// Only a single connection waits for the slot, the rest stay in the
+	// backlog.
+	for i := 0; i < 5; i++ {
+		_ = dial(t, ln)
+	}
+	waitAccepted(t, counting, 2)
+
+	// Admitting the waiting connection makes room for the next one.
+	_ = first.conn.Close()
+	select {
+	case res := <-acceptAsync(ln):
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		defer func() { _ = res.conn.Close() }()
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued connection isn't accepted")
+	}
+	waitAccepted(t, counting, 3)
+}
+
+func TestLimitListener_Close(t *testing.T) {
+	ln := newLimitListener(t, 1, time.Second)
+
+	res := acceptAsync(ln)
+	_ = ln.Close()
+
+	select {
+	case r := <-res:
+		if !errors.Is(r.err, net.ErrClosed) {
+			t.Fatalf("got %v, want %v", r.err, net.ErrClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("accept isn't unblocked by close")
+	}
+}
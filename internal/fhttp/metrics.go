@@ -0,0 +1,351 @@
+package fhttp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// metricsPrefix is the common prefix for all the exported metric names.
+const metricsPrefix = "filesrv_"
+
+// defaultDurationBuckets are the upper bounds of the request duration
+// histogram buckets in seconds.
+var defaultDurationBuckets = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300,
+}
+
+// Metrics collects the HTTP server metrics and exposes them in the Prometheus
+// text exposition format.  It must be created with [NewMetrics].
+type Metrics struct {
+	// requests is the number of handled requests by method, status, and
+	// route type.
+	requests *counterVec
+
+	// durations is the request durations by route type.
+	durations *histogramVec
+
+	// served is the number of response body bytes by route type.
+	served *counterVec
+
+	// uploaded is the number of request body bytes received by uploads.
+	uploaded atomic.Int64
+
+	// activeUploads is the number of uploads in progress.
+	activeUploads atomic.Int64
+
+	// uploadFailures is the number of uploads that resulted in an error
+	// response.
+	uploadFailures atomic.Int64
+}
+
+// NewMetrics returns a new properly initialized *Metrics.
+func NewMetrics() (m *Metrics) {
+	return &Metrics{
+		requests:  newCounterVec(),
+		durations: newHistogramVec(defaultDurationBuckets),
+		served:    newCounterVec(),
+	}
+}
+
+// type check
+var _ Middleware = (*Metrics)(nil).Middleware
+
+// Middleware is the [Middleware] that records the metrics of the requests
+// handled by h.
+func (m *Metrics) Middleware(h http.Handler) (wrapped http.Handler) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		r, ri := withRequestInfo(r)
+		isUpload := false
+		ri.onRouteSet(func(rt RouteType) {
+			if rt == RouteUpload && !isUpload {
+				isUpload = true
+				m.activeUploads.Add(1)
+			}
+		})
+
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+
+		rw := NewRecordingWriter(w)
+		h.ServeHTTP(rw, r)
+
+		status := rw.Status()
+		route := string(ri.getRoute())
+		m.requests.add(1, "method", methodLabel(r.Method), "route", route, "status", strconv.Itoa(status))
+		m.durations.observe(time.Since(start).Seconds(), "route", route)
+		m.served.add(float64(rw.Written()), "route", route)
+
+		if isUpload {
+			m.activeUploads.Add(-1)
+			m.uploaded.Add(body.n)
+			if status >= http.StatusBadRequest {
+				m.uploadFailures.Add(1)
+			}
+		}
+	})
+}
+
+// type check
+var _ http.Handler = (*Metrics)(nil)
+
+// ServeHTTP implements the [http.Handler] interface for *Metrics.  It writes
+// the collected metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		// Go on.
+	default:
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	_, _ = m.WriteTo(w)
+}
+
+// type check
+var _ io.WriterTo = (*Metrics)(nil)
+
+// WriteTo implements the [io.WriterTo] interface for *Metrics.  It writes the
+// metrics to w in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (n int64, err error) {
+	b := &strings.Builder{}
+
+	m.requests.writeTo(b, metricsPrefix+"http_requests_total", "counter",
+		"Total number of handled HTTP requests.")
+	m.durations.writeTo(b, metricsPrefix+"http_request_duration_seconds",
+		"Duration of HTTP requests.")
+	m.served.writeTo(b, metricsPrefix+"http_response_bytes_total", "counter",
+		"Total number of response body bytes served.")
+	writeScalar(b, metricsPrefix+"upload_bytes_total", "counter",
+		"Total number of request body bytes received by uploads.", float64(m.uploaded.Load()))
+	writeScalar(b, metricsPrefix+"uploads_active", "gauge",
+		"Number of uploads in progress.", float64(m.activeUploads.Load()))
+	writeScalar(b, metricsPrefix+"upload_failures_total", "counter",
+		"Total number of failed uploads.", float64(m.uploadFailures.Load()))
+
+	written, err := io.WriteString(w, b.String())
+
+	return int64(written), err
+}
+
+// methodLabel returns the value of the method label for the request method.
+// The non-standard methods are all labeled "other", so that the clients can't
+// create an unbounded number of series.
+func methodLabel(method string) (label string) {
+	switch method {
+	case
+		http.MethodConnect,
+		http.MethodDelete,
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodOptions,
+		http.MethodPatch,
+		http.MethodPost,
+		http.MethodPut,
+		http.MethodTrace:
+		return method
+	default:
+		return "other"
+	}
+}
+
+// countingReader is an [io.ReadCloser] that counts the bytes read.
+type countingReader struct {
+	io.ReadCloser
+
+	n int64
+}
+
+// Read implements the [io.Reader] interface for *countingReader.
+func (r *countingReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	r.n += int64(n)
+
+	return n, err
+}
+
+// labelsKey formats the label pairs kv as the Prometheus label set.  kv must
+// have an even length.
+func labelsKey(kv []string) (key string) {
+	if len(kv) == 0 {
+		return ""
+	}
+
+	b := &strings.Builder{}
+	b.WriteByte('{')
+	for i := 0; i < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		b.WriteString(formatLabel(kv[i], kv[i+1]))
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+// withLabel adds the label name with value to the label set key.
+func withLabel(key, name, value string) (res string) {
+	l := formatLabel(name, value)
+	if key == "" {
+		return "{" + l + "}"
+	}
+
+	return key[:len(key)-1] + "," + l + "}"
+}
+
+// labelValueEscaper escapes the label values as the Prometheus text exposition
+// format requires.  Unlike Go's quoting, it only escapes the backslashes, the
+// double quotes, and the line feeds.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabel formats the label pair of name and value.
+func formatLabel(name, value string) (l string) {
+	return name + `="` + labelValueEscaper.Replace(value) + `"`
+}
+
+// writeHeader writes the HELP and TYPE lines for the metric name.
+func writeHeader(b *strings.Builder, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// writeScalar writes a single unlabeled metric.
+func writeScalar(b *strings.Builder, name, typ, help string, val float64) {
+	writeHeader(b, name, typ, help)
+	fmt.Fprintf(b, "%s %s\n", name, formatFloat(val))
+}
+
+// formatFloat formats v the way Prometheus expects.
+func formatFloat(v float64) (s string) {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// counterVec is a set of counters partitioned by labels.
+type counterVec struct {
+	mu   *sync.Mutex
+	vals map[string]float64
+}
+
+// newCounterVec returns a new properly initialized *counterVec.
+func newCounterVec() (c *counterVec) {
+	return &counterVec{
+		mu:   &sync.Mutex{},
+		vals: map[string]float64{},
+	}
+}
+
+// add adds v to the counter with labels kv.
+func (c *counterVec) add(v float64, kv ...string) {
+	key := labelsKey(kv)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.vals[key] += v
+}
+
+// writeTo writes the counters to b sorted by their labels.
+func (c *counterVec) writeTo(b *strings.Builder, name, typ, help string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(b, name, typ, help)
+	for _, key := range sortedKeys(c.vals) {
+		fmt.Fprintf(b, "%s%s %s\n", name, key, formatFloat(c.vals[key]))
+	}
+}
+
+// histogram is a single Prometheus histogram.
+type histogram struct {
+	// counts are the non-cumulative counts of observations per bucket.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// histogramVec is a set of histograms with the same buckets partitioned by
+// labels.
+type histogramVec struct {
+	mu      *sync.Mutex
+	vals    map[string]*histogram
+	buckets []float64
+}
+
+// newHistogramVec returns a new properly initialized *histogramVec.  buckets
+// must be sorted in ascending order.
+func newHistogramVec(buckets []float64) (h *histogramVec) {
+	return &histogramVec{
+		mu:      &sync.Mutex{},
+		vals:    map[string]*histogram{},
+		buckets: buckets,
+	}
+}
+
+// observe adds the observation v to the histogram with labels kv.
+func (h *histogramVec) observe(v float64, kv ...string) {
+	key := labelsKey(kv)
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hist, ok := h.vals[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.vals[key] = hist
+	}
+
+	if i < len(h.buckets) {
+		hist.counts[i]++
+	}
+	hist.sum += v
+	hist.count++
+}
+
+// writeTo writes the histograms to b sorted by their labels.
+func (h *histogramVec) writeTo(b *strings.Builder, name, help string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(b, name, "histogram", help)
+	for _, key := range sortedKeys(h.vals) {
+		hist := h.vals[key]
+
+		var cum uint64
+		for i, le := range h.buckets {
+			cum += hist.counts[i]
+			fmt.Fprintf(b, "%s_bucket%s %d\n", name, withLabel(key, "le", formatFloat(le)), cum)
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", name, withLabel(key, "le", "+Inf"), hist.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", name, key, formatFloat(hist.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", name, key, hist.count)
+	}
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys[V any](m map[string]V) (keys []string) {
+	keys = maps.Keys(m)
+	slices.Sort(keys)
+
+	return keys
+}
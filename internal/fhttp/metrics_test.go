@@ -0,0 +1,167 @@
+package fhttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape returns the metrics exposed by m.
+func scrape(t *testing.T, m *Metrics) (body string) {
+	t.Helper()
+
+	rw := httptest.NewRecorder()
+	m.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("scraping: got status %d", rw.Code)
+	} else if ct := rw.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("scraping: got content type %q", ct)
+	}
+
+	return rw.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file":
+			SetRoute(r, RouteFile)
+			_, _ = io.WriteString(w, "contents")
+		case "/upload":
+			SetRoute(r, RouteUpload)
+			_, _ = io.Copy(io.Discard, r.Body)
+			w.WriteHeader(http.StatusInsufficientStorage)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/file", nil),
+		httptest.NewRequest(http.MethodGet, "/file", nil),
+		httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("12345")),
+		httptest.NewRequest(http.MethodGet, "/missing", nil),
+		httptest.NewRequest("BREW", "/missing", nil),
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	body := scrape(t, m)
+	for _, want := range []string{
+		"# TYPE filesrv_http_requests_total counter\n",
+		`filesrv_http_requests_total{method="GET",route="file",status="200"} 2` + "\n",
+		`filesrv_http_requests_total{method="GET",route="unknown",status="404"} 1` + "\n",
+		`filesrv_http_requests_total{method="POST",route="upload",status="507"} 1` + "\n",
+		`filesrv_http_requests_total{method="other",route="unknown",status="404"} 1` + "\n",
+		"# TYPE filesrv_http_request_duration_seconds histogram\n",
+		`filesrv_http_request_duration_seconds_bucket{route="file",le="+Inf"} 2` + "\n",
+		`filesrv_http_request_duration_seconds_count{route="file"} 2` + "\n",
+		`filesrv_http_response_bytes_total{route="file"} 16` + "\n",
+		"filesrv_upload_bytes_total 5\n",
+		"filesrv_uploads_active 0\n",
+		"filesrv_upload_failures_total 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("no %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetrics_ServeHTTP_method(t *testing.T) {
+	rw := httptest.NewRecorder()
+	NewMetrics().ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("got status %d, want %d", rw.Code, http.StatusMethodNotAllowed)
+	} else if allow := rw.Header().Get("Allow"); allow != "GET, HEAD" {
+		t.Fatalf("got Allow %q", allow)
+	}
+}
+
+func TestLabelsKey(t *testing.T) {
+	testCases := []struct {
+		name string
+		kv   []string
+		want string
+	}{{
+		name: "empty",
+		kv:   nil,
+		want: "",
+	}, {
+		name: "plain",
+		kv:   []string{"route", "file", "status", "200"},
+		want: `{route="file",status="200"}`,
+	}, {
+		name: "escaped",
+		kv:   []string{"path", "a\\b\"c\nd"},
+		want: `{path="a\\b\"c\nd"}`,
+	}, {
+		name: "not_escaped",
+		kv:   []string{"path", "tab\tüñí\x01"},
+		want: "{path=\"tab\tüñí\x01\"}",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := labelsKey(tc.kv); got != tc.want {
+				t.Fatalf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	testCases := []struct {
+		name string
+		obs  []float64
+		want []string
+	}{{
+		name: "empty",
+		obs:  nil,
+		want: nil,
+	}, {
+		name: "buckets",
+		obs:  []float64{0.5, 1, 1.5, 3},
+		want: []string{
+			`h_bucket{route="file",le="1"} 2`,
+			`h_bucket{route="file",le="2"} 3`,
+			`h_bucket{route="file",le="+Inf"} 4`,
+			`h_sum{route="file"} 6`,
+			`h_count{route="file"} 4`,
+		},
+	}, {
+		name: "above_all",
+		obs:  []float64{10},
+		want: []string{
+			`h_bucket{route="file",le="1"} 0`,
+			`h_bucket{route="file",le="2"} 0`,
+			`h_bucket{route="file",le="+Inf"} 1`,
+			`h_sum{route="file"} 10`,
+			`h_count{route="file"} 1`,
+		},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newHistogramVec([]float64{1, 2})
+			for _, v := range tc.obs {
+				h.observe(v, "route", "file")
+			}
+
+			b := &strings.Builder{}
+			h.writeTo(b, "h", "Help.")
+
+			want := "# HELP h Help.\n# TYPE h histogram\n"
+			for _, l := range tc.want {
+				want += l + "\n"
+			}
+
+			if got := b.String(); got != want {
+				t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
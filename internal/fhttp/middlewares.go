@@ -16,3 +16,17 @@ func Wrap(h http.Handler, mws ...Middleware) (wrapped http.Handler) {
 
 	return wrapped
 }
+
+// Intercept returns a [Middleware] that routes the requests with URL path
+// equal to p to h, leaving the rest to the wrapped handler.
+func Intercept(p string, h http.Handler) (mw Middleware) {
+	return func(next http.Handler) (wrapped http.Handler) {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == p {
+				h.ServeHTTP(w, r)
+			} else {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
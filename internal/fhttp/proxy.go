@@ -0,0 +1,254 @@
+package fhttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// schemeCtxKey is the context key for the scheme forwarded by the trusted
+// proxy.
+type schemeCtxKey struct{}
+
+// Scheme returns the scheme the client has sent r with, either forwarded by
+// the trusted proxy, see [TrustedProxies], or the one of the connection.
+func Scheme(r *http.Request) (scheme string) {
+	if scheme, _ = r.Context().Value(schemeCtxKey{}).(string); scheme != "" {
+		return scheme
+	} else if r.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+// TrustedProxies takes the client's address, the scheme, and the host of the
+// requests from the headers set by the trusted reverse proxies.  It must be
+// created with [NewTrustedProxies].
+type TrustedProxies struct {
+	nets []netip.Prefix
+}
+
+// NewTrustedProxies returns a new *TrustedProxies trusting the proxies within
+// nets.
+func NewTrustedProxies(nets []netip.Prefix) (p *TrustedProxies) {
+	return &TrustedProxies{nets: nets}
+}
+
+// forwardedHop is the information about a single hop of the request.
+type forwardedHop struct {
+	// addr is the address of the client of the hop.  It's invalid if unknown
+	// or obfuscated.
+	addr netip.AddrPort
+
+	// proto is the scheme the hop has been received with, if known.
+	proto string
+
+	// host is the Host header the hop has been received with, if known.
+	host string
+}
+
+// type check
+var _ Middleware = (*TrustedProxies)(nil).Middleware
+
+// Middleware is the [Middleware] that replaces the remote address and the host
+// of the requests from the trusted proxies with the forwarded ones, so that
+// the wrapped handlers see the actual client.  The Forwarded header takes
+// precedence over the X-Forwarded-For, X-Forwarded-Proto, and
+// X-Forwarded-Host ones.  The client is the rightmost forwarded address not
+// within the trusted networks.  Requests from anyone else are left as is.
+func (p *TrustedProxies) Middleware(h http.Handler) (wrapped http.Handler) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer, ok := parseNode(r.RemoteAddr)
+		if !ok || !p.trusts(peer.Addr()) {
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		hop := p.forwarded(r.Header)
+		if hop == nil {
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		// Copy the request to not modify the one of the caller.
+		ctx := r.Context()
+		if hop.proto != "" {
+			ctx = context.WithValue(ctx, schemeCtxKey{}, hop.proto)
+		}
+		r = r.WithContext(ctx)
+
+		if hop.addr.IsValid() {
+			r.RemoteAddr = hop.addr.String()
+		}
+
+		if hop.host != "" {
+			r.Host = hop.host
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// trusts returns true if addr is within the trusted networks.
+func (p *TrustedProxies) trusts(addr netip.Addr) (ok bool) {
+	for _, n := range p.nets {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forwarded returns the hop of the client described by the forwarding headers
+// in h, or nil if there are none.
+func (p *TrustedProxies) forwarded(h http.Header) (hop *forwardedHop) {
+	if vals := h.Values("Forwarded"); len(vals) > 0 {
+		return p.client(parseForwarded(vals))
+	}
+
+	hop = p.client(parseXForwardedFor(h.Values("X-Forwarded-For")))
+	proto := normalizeProto(lastValue(h.Values("X-Forwarded-Proto")))
+	host := lastValue(h.Values("X-Forwarded-Host"))
+	if hop == nil && proto == "" && host == "" {
+		return nil
+	} else if hop == nil {
+		hop = &forwardedHop{}
+	}
+
+	hop.proto = proto
+	if isValidHost(host) {
+		hop.host = host
+	}
+
+	return hop
+}
+
+// client returns the rightmost hop of hops which client isn't a trusted
+// proxy, or the leftmost one if all of them are.  hop is nil if hops is empty.
+func (p *TrustedProxies) client(hops []*forwardedHop) (hop *forwardedHop) {
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop = hops[i]
+		if i == 0 || !hop.addr.IsValid() || !p.trusts(hop.addr.Addr()) {
+			return hop
+		}
+	}
+
+	return nil
+}
+
+// parseForwarded parses the values of the Forwarded header as defined by
+// RFC 7239.  The malformed pairs are ignored.
+func parseForwarded(vals []string) (hops []*forwardedHop) {
+	for _, v := range vals {
+		for _, elem := range strings.Split(v, ",") {
+			hop := &forwardedHop{}
+			for _, pair := range strings.Split(elem, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+
+				val = unquote(strings.TrimSpace(val))
+				switch strings.ToLower(strings.TrimSpace(key)) {
+				case "for":
+					hop.addr, _ = parseNode(val)
+				case "proto":
+					hop.proto = normalizeProto(val)
+				case "host":
+					if isValidHost(val) {
+						hop.host = val
+					}
+				}
+			}
+
+			hops = append(hops, hop)
+		}
+	}
+
+	return hops
+}
+
+// parseXForwardedFor parses the values of the X-Forwarded-For header.
+func parseXForwardedFor(vals []string) (hops []*forwardedHop) {
+	for _, v := range vals {
+		for _, node := range strings.Split(v, ",") {
+			addr, _ := parseNode(strings.TrimSpace(node))
+			hops = append(hops, &forwardedHop{addr: addr})
+		}
+	}
+
+	return hops
+}
+
+// parseNode parses the address with an optional port, e.g. 192.0.2.1,
+// 192.0.2.1:4711, or [2001:db8::1]:4711.  The port is zero if omitted.
+func parseNode(s string) (addr netip.AddrPort, ok bool) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		host, port = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"), "0"
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+
+	return netip.AddrPortFrom(ip.Unmap(), uint16(n)), true
+}
+
+// unquote returns s without the surrounding quotes, if any.
+func unquote(s string) (res string) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+
+	res, err := strconv.Unquote(s)
+	if err != nil {
+		return s[1 : len(s)-1]
+	}
+
+	return res
+}
+
+// normalizeProto returns the lower-cased proto if it's either http or https,
+// and an empty string otherwise.
+func normalizeProto(proto string) (res string) {
+	switch res = strings.ToLower(proto); res {
+	case "http", "https":
+		return res
+	default:
+		return ""
+	}
+}
+
+// isValidHost returns true if host may be used as the Host header.
+func isValidHost(host string) (ok bool) {
+	return host != "" && !strings.ContainsAny(host, "/\\@ \t")
+}
+
+// lastValue returns the last of the comma-separated values of vals, which is
+// the one set by the nearest proxy.
+func lastValue(vals []string) (v string) {
+	if len(vals) == 0 {
+		return ""
+	}
+
+	last := vals[len(vals)-1]
+	if i := strings.LastIndexByte(last, ','); i >= 0 {
+		last = last[i+1:]
+	}
+
+	return strings.TrimSpace(last)
+}
@@ -0,0 +1,274 @@
+package fhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestParseNode(t *testing.T) {
+	testCases := []struct {
+		name   string
+		in     string
+		want   string
+		wantOK bool
+	}{{
+		name:   "ipv4",
+		in:     "192.0.2.1",
+		want:   "192.0.2.1:0",
+		wantOK: true,
+	}, {
+		name:   "ipv4_port",
+		in:     "192.0.2.1:4711",
+		want:   "192.0.2.1:4711",
+		wantOK: true,
+	}, {
+		name:   "ipv6",
+		in:     "2001:db8::1",
+		want:   "[2001:db8::1]:0",
+		wantOK: true,
+	}, {
+		name:   "ipv6_brackets",
+		in:     "[2001:db8::1]",
+		want:   "[2001:db8::1]:0",
+		wantOK: true,
+	}, {
+		name:   "ipv6_port",
+		in:     "[2001:db8::1]:4711",
+		want:   "[2001:db8::1]:4711",
+		wantOK: true,
+	}, {
+		name:   "mapped",
+		in:     "[::ffff:192.0.2.1]:80",
+		want:   "192.0.2.1:80",
+		wantOK: true,
+	}, {
+		name:   "obfuscated",
+		in:     "_hidden",
+		wantOK: false,
+	}, {
+		name:   "unknown",
+		in:     "unknown",
+		wantOK: false,
+	}, {
+		name:   "bad_port",
+		in:     "192.0.2.1:port",
+		wantOK: false,
+	}, {
+		name:   "large_port",
+		in:     "192.0.2.1:65536",
+		wantOK: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			addr, ok := parseNode(tc.in)
+			if ok != tc.wantOK {
+				t.Fatalf("got ok %t, want %t", ok, tc.wantOK)
+			} else if ok && addr.String() != tc.want {
+				t.Fatalf("got %s, want %s", addr, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseForwarded(t *testing.T) {
+	testCases := []struct {
+		name      string
+		vals      []string
+		wantAddrs []string
+		wantProto string
+		wantHost  string
+	}{{
+		name:      "single",
+		vals:      []string{"for=192.0.2.1;proto=https;host=example.com"},
+		wantAddrs: []string{"192.0.2.1:0"},
+		wantProto: "https",
+		wantHost:  "example.com",
+	}, {
+		name:      "quoted",
+		vals:      []string{`For="[2001:db8::1]:4711"; Proto=HTTP`},
+		wantAddrs: []string{"[2001:db8::1]:4711"},
+		wantProto: "http",
+	}, {
+		name:      "list",
+		vals:      []string{"for=192.0.2.1, for=198.51.100.1", "for=198.51.100.2"},
+		wantAddrs: []string{"192.0.2.1:0", "198.51.100.1:0", "198.51.100.2:0"},
+	}, {
+		name:      "obfuscated",
+		vals:      []string{"for=_hidden;proto=ftp"},
+		wantAddrs: []string{"invalid AddrPort"},
+		wantProto: "",
+	}, {
+		name:      "malformed",
+		vals:      []string{"for;host=bad/host;by=192.0.2.2"},
+		wantAddrs: []string{"invalid AddrPort"},
+		wantHost:  "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hops := parseForwarded(tc.vals)
+			if len(hops) != len(tc.wantAddrs) {
+				t.Fatalf("got %d hops, want %d", len(hops), len(tc.wantAddrs))
+			}
+
+			for i, hop := range hops {
+				if got := hop.addr.String(); got != tc.wantAddrs[i] {
+					t.Errorf("hop %d: got addr %s, want %s", i, got, tc.wantAddrs[i])
+				}
+			}
+
+			last := hops[len(hops)-1]
+			if last.proto != tc.wantProto {
+				t.Errorf("got proto %q, want %q", last.proto, tc.wantProto)
+			} else if last.host != tc.wantHost {
+				t.Errorf("got host %q, want %q", last.host, tc.wantHost)
+			}
+		})
+	}
+}
+
+func TestLastValue(t *testing.T) {
+	testCases := []struct {
+		name string
+		vals []string
+		want string
+	}{{
+		name: "none",
+		vals: nil,
+		want: "",
+	}, {
+		name: "single",
+		vals: []string{"https"},
+		want: "https",
+	}, {
+		name: "list",
+		vals: []string{"http, https "},
+		want: "https",
+	}, {
+		name: "several_fields",
+		vals: []string{"https, http", "ftp"},
+		want: "ftp",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := lastValue(tc.vals); got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTrustedProxies_Middleware(t *testing.T) {
+	p := NewTrustedProxies([]netip.Prefix{
+		netip.MustParsePrefix("198.51.100.0/24"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	})
+
+	h := p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Remote", r.RemoteAddr)
+		w.Header().Set("X-Scheme", Scheme(r))
+		w.Header().Set("X-Host", r.Host)
+	}))
+
+	testCases := []struct {
+		name       string
+		remote     string
+		header     http.Header
+		wantRemote string
+		wantScheme string
+		wantHost   string
+	}{{
+		name:       "untrusted",
+		remote:     "192.0.2.1:1234",
+		header:     http.Header{"X-Forwarded-For": {"203.0.113.1"}},
+		wantRemote: "192.0.2.1:1234",
+		wantScheme: "http",
+		wantHost:   "example.com",
+	}, {
+		name:       "no_headers",
+		remote:     "198.51.100.1:1234",
+		header:     http.Header{},
+		wantRemote: "198.51.100.1:1234",
+		wantScheme: "http",
+		wantHost:   "example.com",
+	}, {
+		name:   "x_forwarded",
+		remote: "198.51.100.1:1234",
+		header: http.Header{
+			"X-Forwarded-For":   {"203.0.113.1, 198.51.100.2"},
+			"X-Forwarded-Proto": {"https"},
+			"X-Forwarded-Host":  {"files.example.org"},
+		},
+		wantRemote: "203.0.113.1:0",
+		wantScheme: "https",
+		wantHost:   "files.example.org",
+	}, {
+		name:       "spoofed",
+		remote:     "198.51.100.1:1234",
+		header:     http.Header{"X-Forwarded-For": {"192.0.2.9, 203.0.113.1"}},
+		wantRemote: "203.0.113.1:0",
+		wantScheme: "http",
+		wantHost:   "example.com",
+	}, {
+		name:       "all_trusted",
+		remote:     "198.51.100.1:1234",
+		header:     http.Header{"X-Forwarded-For": {"198.51.100.3, 198.51.100.2"}},
+		wantRemote: "198.51.100.3:0",
+		wantScheme: "http",
+		wantHost:   "example.com",
+	}, {
+		name:   "forwarded_precedence",
+		remote: "[2001:db8::2]:1234",
+		header: http.Header{
+			"Forwarded":         {"for=203.0.113.1;proto=https;host=a.example"},
+			"X-Forwarded-For":   {"203.0.113.2"},
+			"X-Forwarded-Proto": {"http"},
+		},
+		wantRemote: "203.0.113.1:0",
+		wantScheme: "https",
+		wantHost:   "a.example",
+	}, {
+		name:   "proto_only",
+		remote: "198.51.100.1:1234",
+		header: http.Header{
+			"X-Forwarded-Proto": {"https"},
+			"X-Forwarded-Host":  {"bad host"},
+		},
+		wantRemote: "198.51.100.1:1234",
+		wantScheme: "https",
+		wantHost:   "example.com",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remote
+			for k, vals := range tc.header {
+				r.Header[k] = vals
+			}
+
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, r)
+
+			if got := rw.Header().Get("X-Remote"); got != tc.wantRemote {
+				t.Errorf("got remote %s, want %s", got, tc.wantRemote)
+			}
+
+			if got := rw.Header().Get("X-Scheme"); got != tc.wantScheme {
+				t.Errorf("got scheme %s, want %s", got, tc.wantScheme)
+			}
+
+			if got := rw.Header().Get("X-Host"); got != tc.wantHost {
+				t.Errorf("got host %s, want %s", got, tc.wantHost)
+			}
+
+			if r.RemoteAddr != tc.remote {
+				t.Errorf("request of the caller is modified")
+			}
+		})
+	}
+}
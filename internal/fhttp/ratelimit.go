@@ -0,0 +1,334 @@
+package fhttp
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"filesrv/internal/ferrors"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrTooManyRequests is returned when the request exceeds the rate limit.
+const ErrTooManyRequests ferrors.Str = "too many requests, try again later"
+
+const (
+	// limiterIdleTimeout is the time after which the unused per-client
+	// limiters are dropped.
+	limiterIdleTimeout = 10 * time.Minute
+
+	// limiterSweepInterval is the minimum interval between the sweeps of
+	// unused per-client limiters.
+	limiterSweepInterval = time.Minute
+
+	// minBandwidthBurst is the minimum burst of the bandwidth limiters in
+	// bytes.  It's also the maximum size of a single throttled chunk.
+	minBandwidthBurst = 32 * 1024
+)
+
+// RateLimitConfig is the configuration of the rate limiting middleware.
+type RateLimitConfig struct {
+	// OnLimit renders the response for requests exceeding the limits.  It
+	// receives a *StatusError wrapping [ErrTooManyRequests].  If nil,
+	// [http.Error] is used.
+	OnLimit ErrorHandler
+
+	// ClientRate is the number of requests per second allowed for a single
+	// client IP.  Zero means no limit.
+	ClientRate float64
+
+	// ClientBurst is the maximum number of requests a single client may make
+	// at once.
+	ClientBurst int
+
+	// GlobalRate is the number of requests per second allowed for all the
+	// clients together.  Zero means no limit.
+	GlobalRate float64
+
+	// GlobalBurst is the maximum number of requests all the clients may make
+	// at once.
+	GlobalBurst int
+
+	// DownloadRate is the number of response body bytes per second allowed
+	// for a single client IP.  Zero means no limit.
+	DownloadRate int64
+
+	// UploadRate is the number of request body bytes per second allowed for
+	// a single client IP.  Zero means no limit.
+	UploadRate int64
+}
+
+// clientLimiters are the limiters of a single client.  Any of the limiters may
+// be nil if the corresponding limit is disabled.
+type clientLimiters struct {
+	requests *rate.Limiter
+	download *rate.Limiter
+	upload   *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimiter limits the request rate and the bandwidth per client and
+// globally.  It must be created with [NewRateLimiter].
+type RateLimiter struct {
+	// mu protects clients and lastSweep.
+	mu        *sync.Mutex
+	clients   map[string]*clientLimiters
+	lastSweep time.Time
+
+	// global is nil if the global limit is disabled.
+	global *rate.Limiter
+
+	conf *RateLimitConfig
+}
+
+// NewRateLimiter returns a new properly initialized *RateLimiter.  conf must
+// not be nil.
+func NewRateLimiter(conf *RateLimitConfig) (l *RateLimiter) {
+	l = &RateLimiter{
+		mu:      &sync.Mutex{},
+		clients: map[string]*clientLimiters{},
+		conf:    conf,
+	}
+
+	if conf.GlobalRate > 0 {
+		l.global = rate.NewLimiter(rate.Limit(conf.GlobalRate), atLeastOne(conf.GlobalBurst))
+	}
+
+	return l
+}
+
+// Enabled returns true if any of the limits is set.
+func (conf *RateLimitConfig) Enabled() (ok bool) {
+	return conf.ClientRate > 0 ||
+		conf.GlobalRate > 0 ||
+		conf.DownloadRate > 0 ||
+		conf.UploadRate > 0
+}
+
+// type check
+var _ Middleware = (*RateLimiter)(nil).Middleware
+
+// Middleware is the [Middleware] that rejects the requests exceeding the
+// request rate limits and throttles the request and response bodies.
+func (l *RateLimiter) Middleware(h http.Handler) (wrapped http.Handler) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		cl := l.client(remoteHost(r), now)
+
+		if !allowAll(now, cl.requests, l.global) {
+			l.reject(w, r)
+
+			return
+		}
+
+		if cl.upload != nil && r.Body != nil && r.Body != http.NoBody {
+			r.Body = &throttledReader{
+				ReadCloser: r.Body,
+				ctx:        r.Context(),
+				lim:        cl.upload,
+			}
+		}
+
+		if cl.download != nil {
+			w = &throttledWriter{
+				ResponseWriter: w,
+				ctx:            r.Context(),
+				lim:            cl.download,
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// allowAll returns true if each of the non-nil limiters lims allows an event at
+// now.  Otherwise, it returns the tokens taken from the ones that did, so that
+// the requests rejected by the global limit aren't counted against the client.
+func allowAll(now time.Time, lims ...*rate.Limiter) (ok bool) {
+	reserved := make([]*rate.Reservation, 0, len(lims))
+	for _, lim := range lims {
+		if lim == nil {
+			continue
+		}
+
+		res := lim.ReserveN(now, 1)
+		if !res.OK() || res.DelayFrom(now) > 0 {
+			res.CancelAt(now)
+			for _, r := range reserved {
+				r.CancelAt(now)
+			}
+
+			return false
+		}
+
+		reserved = append(reserved, res)
+	}
+
+	return true
+}
+
+// reject responds to r with [http.StatusTooManyRequests].
+func (l *RateLimiter) reject(w http.ResponseWriter, r *http.Request) {
+	retryAfter := 1
+	if rps := l.conf.ClientRate; rps > 0 && rps < 1 {
+		retryAfter = int(math.Ceil(1 / rps))
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+
+	err := &StatusError{
+		Err:  ErrTooManyRequests,
+		Code: http.StatusTooManyRequests,
+	}
+	if l.conf.OnLimit != nil {
+		l.conf.OnLimit(w, r, err)
+	} else {
+		http.Error(w, err.Error(), err.Code)
+	}
+}
+
+// client returns the limiters for the client with addr, creating those if
+// needed.  It also drops the limiters of idle clients from time to time.
+func (l *RateLimiter) client(addr string, now time.Time) (cl *clientLimiters) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > limiterSweepInterval {
+		for a, c := range l.clients {
+			if now.Sub(c.lastSeen) > limiterIdleTimeout {
+				delete(l.clients, a)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	cl, ok := l.clients[addr]
+	if !ok {
+		cl = l.newClientLimiters()
+		l.clients[addr] = cl
+	}
+	cl.lastSeen = now
+
+	return cl
+}
+
+// newClientLimiters creates the limiters for a new client.
+func (l *RateLimiter) newClientLimiters() (cl *clientLimiters) {
+	conf := l.conf
+	cl = &clientLimiters{}
+	if conf.ClientRate > 0 {
+		cl.requests = rate.NewLimiter(rate.Limit(conf.ClientRate), atLeastOne(conf.ClientBurst))
+	}
+
+	if conf.DownloadRate > 0 {
+		cl.download = newBandwidthLimiter(conf.DownloadRate)
+	}
+
+	if conf.UploadRate > 0 {
+		cl.upload = newBandwidthLimiter(conf.UploadRate)
+	}
+
+	return cl
+}
+
+// atLeastOne returns n if it's positive and 1 otherwise.
+func atLeastOne(n int) (res int) {
+	if n < 1 {
+		return 1
+	}
+
+	return n
+}
+
+// newBandwidthLimiter returns a limiter allowing bps bytes per second.
+func newBandwidthLimiter(bps int64) (lim *rate.Limiter) {
+	burst := minBandwidthBurst
+	if bps > int64(burst) && bps < math.MaxInt32 {
+		burst = int(bps)
+	}
+
+	return rate.NewLimiter(rate.Limit(bps), burst)
+}
+
+// waitChunk returns the size of the next chunk out of n bytes allowed by lim
+// and waits until it can be transferred.
+func waitChunk(ctx context.Context, lim *rate.Limiter, n int) (chunk int, err error) {
+	chunk = n
+	if chunk > minBandwidthBurst {
+		chunk = minBandwidthBurst
+	}
+
+	return chunk, lim.WaitN(ctx, chunk)
+}
+
+// throttledWriter is an [http.ResponseWriter] that limits the rate of writes.
+type throttledWriter struct {
+	http.ResponseWriter
+
+	ctx context.Context
+	lim *rate.Limiter
+}
+
+// Write implements the [http.ResponseWriter] interface for *throttledWriter.
+func (w *throttledWriter) Write(b []byte) (n int, err error) {
+	for len(b) > 0 {
+		var chunk int
+		chunk, err = waitChunk(w.ctx, w.lim, len(b))
+		if err != nil {
+			return n, err
+		}
+
+		var written int
+		written, err = w.ResponseWriter.Write(b[:chunk])
+		n += written
+		if err != nil {
+			return n, err
+		}
+
+		b = b[chunk:]
+	}
+
+	return n, nil
+}
+
+// Flush implements the [http.Flusher] interface for *throttledWriter.
+func (w *throttledWriter) Flush() {
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying writer.  It's used by
+// [http.ResponseController].
+func (w *throttledWriter) Unwrap() (rw http.ResponseWriter) {
+	return w.ResponseWriter
+}
+
+// throttledReader is an [io.ReadCloser] that limits the rate of reads.
+type throttledReader struct {
+	io.ReadCloser
+
+	ctx context.Context
+	lim *rate.Limiter
+}
+
+// Read implements the [io.Reader] interface for *throttledReader.  It reads
+// first and then waits for the bytes actually read, since the reads are often
+// shorter than p.
+func (r *throttledReader) Read(p []byte) (n int, err error) {
+	if len(p) > minBandwidthBurst {
+		p = p[:minBandwidthBurst]
+	}
+
+	n, err = r.ReadCloser.Read(p)
+	if n > 0 {
+		waitErr := r.lim.WaitN(r.ctx, n)
+		if waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,197 @@
+package fhttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimiter_requests(t *testing.T) {
+	testCases := []struct {
+		conf           *RateLimitConfig
+		name           string
+		remotes        []string
+		wantCodes      []int
+		wantRetryAfter string
+	}{{
+		conf:      &RateLimitConfig{ClientRate: 0.001, ClientBurst: 2},
+		name:      "client_burst",
+		remotes:   []string{"192.0.2.1:1", "192.0.2.1:2", "192.0.2.1:3"},
+		wantCodes: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		// 1/0.001 seconds.
+		wantRetryAfter: "1000",
+	}, {
+		conf:           &RateLimitConfig{ClientRate: 0.001, ClientBurst: 1},
+		name:           "clients_apart",
+		remotes:        []string{"192.0.2.1:1", "192.0.2.2:1", "192.0.2.1:2"},
+		wantCodes:      []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		wantRetryAfter: "1000",
+	}, {
+		conf:           &RateLimitConfig{GlobalRate: 0.001, GlobalBurst: 2},
+		name:           "global",
+		remotes:        []string{"192.0.2.1:1", "192.0.2.2:1", "192.0.2.3:1"},
+		wantCodes:      []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		wantRetryAfter: "1",
+	}, {
+		conf:      &RateLimitConfig{ClientRate: 1000},
+		name:      "zero_burst",
+		remotes:   []string{"192.0.2.1:1"},
+		wantCodes: []int{http.StatusOK},
+	}}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var limitErr error
+			tc.conf.OnLimit = func(w http.ResponseWriter, _ *http.Request, err error) {
+				limitErr = err
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+
+			h := NewRateLimiter(tc.conf).Middleware(ok)
+			for i, remote := range tc.remotes {
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.RemoteAddr = remote
+				rw := httptest.NewRecorder()
+				h.ServeHTTP(rw, r)
+
+				if rw.Code != tc.wantCodes[i] {
+					t.Fatalf("request %d: got status %d, want %d", i, rw.Code, tc.wantCodes[i])
+				} else if rw.Code != http.StatusTooManyRequests {
+					continue
+				}
+
+				if got := rw.Header().Get("Retry-After"); got != tc.wantRetryAfter {
+					t.Errorf("got Retry-After %q, want %q", got, tc.wantRetryAfter)
+				} else if !errors.Is(limitErr, ErrTooManyRequests) {
+					t.Errorf("got error %v, want %v", limitErr, ErrTooManyRequests)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiter_bandwidth(t *testing.T) {
+	// The rates are high enough to not slow the test down, but the bodies
+	// still span several chunks.
+	const size = 3*minBandwidthBurst + 1
+
+	data := bytes.Repeat([]byte{'x'}, size)
+	h := NewRateLimiter(&RateLimitConfig{
+		DownloadRate: 1 << 30,
+		UploadRate:   1 << 30,
+	}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading body: %v", err)
+		} else if !bytes.Equal(got, data) {
+			t.Errorf("got body of %d bytes, want %d", len(got), size)
+		}
+
+		_, _ = w.Write(data)
+	}))
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+
+	if !bytes.Equal(rw.Body.Bytes(), data) {
+		t.Fatalf("got response of %d bytes, want %d", rw.Body.Len(), size)
+	}
+}
+
+func TestRateLimiter_globalRejectKeepsClientToken(t *testing.T) {
+	l := NewRateLimiter(&RateLimitConfig{
+		OnLimit: func(w http.ResponseWriter, _ *http.Request, _ error) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		},
+		ClientRate:  0.001,
+		ClientBurst: 1,
+		GlobalRate:  0.001,
+		GlobalBurst: 1,
+	})
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = fmt.Sprintf("192.0.2.%d:1", i+1)
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, r)
+
+		if rw.Code != want {
+			t.Fatalf("request %d: got status %d, want %d", i, rw.Code, want)
+		}
+	}
+
+	// The second client's request is rejected by the global limit only.
+	if got := l.client("192.0.2.2", time.Now()).requests.Tokens(); got < 0.99 {
+		t.Fatalf("got %v client tokens left, want 1", got)
+	}
+}
+
+func TestThrottledReader_shortReads(t *testing.T) {
+	const content = "0123456789"
+
+	lim := rate.NewLimiter(rate.Limit(1), minBandwidthBurst)
+	r := &throttledReader{
+		ReadCloser: io.NopCloser(iotest.OneByteReader(strings.NewReader(content))),
+		ctx:        context.Background(),
+		lim:        lim,
+	}
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(got) != content {
+		t.Fatalf("got %q, want %q", got, content)
+	}
+
+	// Only the bytes actually read are waited for.
+	used := minBandwidthBurst - lim.Tokens()
+	if math.Round(used) != float64(len(content)) {
+		t.Fatalf("got %v tokens used, want %d", used, len(content))
+	}
+}
+
+func TestNewBandwidthLimiter(t *testing.T) {
+	testCases := []struct {
+		name      string
+		bps       int64
+		wantBurst int
+	}{{
+		name:      "below_min",
+		bps:       1024,
+		wantBurst: minBandwidthBurst,
+	}, {
+		name:      "above_min",
+		bps:       1 << 20,
+		wantBurst: 1 << 20,
+	}, {
+		name:      "huge",
+		bps:       1 << 40,
+		wantBurst: minBandwidthBurst,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := newBandwidthLimiter(tc.bps).Burst(); got != tc.wantBurst {
+				t.Fatalf("got burst %d, want %d", got, tc.wantBurst)
+			}
+		})
+	}
+}
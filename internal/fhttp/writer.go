@@ -0,0 +1,83 @@
+package fhttp
+
+import (
+	"net/http"
+)
+
+// RecordingWriter is an [http.ResponseWriter] that records the status code and
+// the number of bytes written to the underlying writer.
+type RecordingWriter struct {
+	http.ResponseWriter
+
+	// status is the status code written.  It's zero until the header is
+	// written.
+	status int
+
+	// written is the number of body bytes written.
+	written int64
+}
+
+// NewRecordingWriter returns a new *RecordingWriter wrapping w.  If w is
+// already a *RecordingWriter, it is returned as is.
+func NewRecordingWriter(w http.ResponseWriter) (rw *RecordingWriter) {
+	if rw, ok := w.(*RecordingWriter); ok {
+		return rw
+	}
+
+	return &RecordingWriter{ResponseWriter: w}
+}
+
+// type check
+var _ http.Flusher = (*RecordingWriter)(nil)
+
+// WriteHeader implements the [http.ResponseWriter] interface for
+// *RecordingWriter.
+func (w *RecordingWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements the [http.ResponseWriter] interface for *RecordingWriter.
+func (w *RecordingWriter) Write(b []byte) (n int, err error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	n, err = w.ResponseWriter.Write(b)
+	w.written += int64(n)
+
+	return n, err
+}
+
+// Flush implements the [http.Flusher] interface for *RecordingWriter.
+func (w *RecordingWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying writer.  It's used by
+// [http.ResponseController].
+func (w *RecordingWriter) Unwrap() (rw http.ResponseWriter) {
+	return w.ResponseWriter
+}
+
+// Status returns the status code written.  It returns [http.StatusOK] if
+// nothing has been written yet, since that's what the server will send.
+func (w *RecordingWriter) Status() (code int) {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
+
+// Written returns the number of body bytes written.
+func (w *RecordingWriter) Written() (n int64) {
+	return w.written
+}
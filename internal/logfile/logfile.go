@@ -0,0 +1,135 @@
+// Package logfile contains the log file implementations.
+package logfile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// Rotating is an [io.WriteCloser] that appends to a file and rotates it once
+// it grows beyond the maximum size.  The rotated files are named by appending
+// a number to the original name, the greater the older.
+type Rotating struct {
+	// mu protects the fields below.
+	mu *sync.Mutex
+
+	file       *os.File
+	name       string
+	size       int64
+	maxSize    int64
+	maxBackups int
+}
+
+// type check
+var _ io.WriteCloser = (*Rotating)(nil)
+
+// Open opens the file with name for appending, creating it if needed.  The file
+// is rotated as soon as it's larger than maxSize bytes, keeping at most
+// maxBackups rotated files.  If maxSize is not positive, the file is never
+// rotated.
+func Open(name string, maxSize int64, maxBackups int) (r *Rotating, err error) {
+	r = &Rotating{
+		mu:         &sync.Mutex{},
+		name:       name,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	err = r.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// open opens the file and initializes the size.  r.mu is expected to be locked
+// or not yet shared.
+func (r *Rotating) open() (err error) {
+	// #nosec G304 -- The name is provided by the operator.
+	f, err := os.OpenFile(r.name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening log file: %w", err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return errors.Join(fmt.Errorf("stat log file: %w", err), f.Close())
+	}
+
+	r.file, r.size = f, fi.Size()
+
+	return nil
+}
+
+// Write implements the [io.Writer] interface for *Rotating.
+func (r *Rotating) Write(p []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		err = r.rotate()
+		if err != nil {
+			return 0, fmt.Errorf("rotating log file: %w", err)
+		}
+	}
+
+	n, err = r.file.Write(p)
+	r.size += int64(n)
+
+	return n, err
+}
+
+// rotate shifts the backups, moves the current file to the first backup and
+// opens a new one.  r.mu is expected to be locked.
+func (r *Rotating) rotate() (err error) {
+	err = r.file.Close()
+	if err != nil {
+		return fmt.Errorf("closing: %w", err)
+	}
+
+	if r.maxBackups <= 0 {
+		err = os.Remove(r.name)
+		if err != nil {
+			return fmt.Errorf("removing: %w", err)
+		}
+
+		return r.open()
+	}
+
+	err = os.Remove(r.backupName(r.maxBackups))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing oldest backup: %w", err)
+	}
+
+	for i := r.maxBackups - 1; i > 0; i-- {
+		err = os.Rename(r.backupName(i), r.backupName(i+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("shifting backup: %w", err)
+		}
+	}
+
+	err = os.Rename(r.name, r.backupName(1))
+	if err != nil {
+		return fmt.Errorf("moving to backup: %w", err)
+	}
+
+	return r.open()
+}
+
+// backupName returns the name of the i-th backup.
+func (r *Rotating) backupName(i int) (name string) {
+	return fmt.Sprintf("%s.%d", r.name, i)
+}
+
+// Close implements the [io.Closer] interface for *Rotating.
+func (r *Rotating) Close() (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.file.Close()
+}
@@ -0,0 +1,147 @@
+package safefs
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Dir is a directory within the root opened for changing its entries.  On
+// Linux, the entries are changed relative to the opened directory and not by
+// its path, so the symbolic links replacing the path's elements after opening
+// it can't lead the changes outside of the root.  The methods of Dir never
+// follow the symbolic links among the entries themselves.
+type Dir struct {
+	// f is the opened directory.  It's nil on the systems other than Linux,
+	// where the entries are changed by their paths.
+	f *os.File
+
+	// name is the path of the directory within the operating system's file
+	// system.
+	name string
+}
+
+// OpenDir opens the directory at the slash-separated name within the root,
+// with the symbolic links resolved according to the policy.
+func (fsys *FS) OpenDir(name string) (d *Dir, err error) {
+	rel, err := fsys.resolve(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "opendir", Path: name, Err: err}
+	}
+
+	d, err = fsys.openDir(rel)
+	if err != nil {
+		return nil, &fs.PathError{Op: "opendir", Path: name, Err: err}
+	}
+
+	return d, nil
+}
+
+// OpenParent opens the directory containing the file at the slash-separated
+// name within the root, with the symbolic links resolved according to the
+// policy, and returns the file's name within it.  If create is true, the
+// missing directories are created.
+func (fsys *FS) OpenParent(name string, create bool) (d *Dir, base string, err error) {
+	rel, err := fsys.resolve(name)
+	if err != nil {
+		return nil, "", &fs.PathError{Op: "openparent", Path: name, Err: err}
+	} else if rel == "" {
+		return nil, "", &fs.PathError{Op: "openparent", Path: name, Err: notExist(ErrInvalidPath)}
+	}
+
+	dirRel, base := path.Split(rel)
+	dirRel = strings.TrimSuffix(dirRel, "/")
+	if create {
+		d, err = fsys.mkdirAll(dirRel)
+	} else {
+		d, err = fsys.openDir(dirRel)
+	}
+	if err != nil {
+		return nil, "", &fs.PathError{Op: "openparent", Path: name, Err: err}
+	}
+
+	return d, base, nil
+}
+
+// mkdirAll opens the directory at the resolved slash-separated path rel within
+// the root, creating the missing directories.
+func (fsys *FS) mkdirAll(rel string) (d *Dir, err error) {
+	if fsys.policy == SymlinksFollow {
+		err = os.MkdirAll(filepath.Join(fsys.root, filepath.FromSlash(rel)), 0o755)
+		if err != nil {
+			return nil, err
+		}
+
+		return fsys.openDir(rel)
+	}
+
+	d, err = fsys.openDir("")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, elem := range splitPath(rel) {
+		if elem == "" {
+			continue
+		}
+
+		var sub *Dir
+		sub, err = d.Mkdir(elem)
+		_ = d.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		d = sub
+	}
+
+	return d, nil
+}
+
+// Name returns the path of the directory within the operating system's file
+// system.
+func (d *Dir) Name() (name string) {
+	return d.name
+}
+
+// Close closes the directory.
+func (d *Dir) Close() (err error) {
+	if d.f == nil {
+		return nil
+	}
+
+	return d.f.Close()
+}
+
+// entryPath returns the path of the entry name within the operating system's
+// file system.
+func (d *Dir) entryPath(name string) (p string) {
+	return filepath.Join(d.name, name)
+}
+
+// linkError returns err as [*os.LinkError] of op from oldName to newName, or
+// nil if err is nil.
+func linkError(op, oldName, newName string, err error) (wrapped error) {
+	if err == nil {
+		return nil
+	}
+
+	return &os.LinkError{Op: op, Old: oldName, New: newName, Err: err}
+}
+
+// checkEntry returns an error if name isn't a single element of a path.
+func checkEntry(name string) (err error) {
+	switch {
+	case
+		name == "",
+		name == ".",
+		name == "..",
+		strings.ContainsAny(name, "/\x00"),
+		strings.ContainsRune(name, filepath.Separator):
+		return notExist(ErrInvalidPath)
+	default:
+		return nil
+	}
+}
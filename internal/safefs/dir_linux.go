@@ -0,0 +1,191 @@
+//go:build linux
+
+package safefs
+
+import (
+	"errors"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// openDir opens the directory at the resolved slash-separated path rel within
+// the root.
+func (fsys *FS) openDir(rel string) (d *Dir, err error) {
+	var f *os.File
+	if fsys.policy == SymlinksFollow {
+		fd, openErr := unix.Open(
+			filepath.Join(fsys.root, filepath.FromSlash(rel)),
+			unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC,
+			0,
+		)
+		f, err = newFile(fd, fsys.root, rel, openErr)
+	} else {
+		f, err = fsys.openBeneath(rel, unix.O_PATH|unix.O_DIRECTORY)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Dir{f: f, name: f.Name()}, nil
+}
+
+// fd returns the file descriptor of d.
+func (d *Dir) fd() (fd int) {
+	return int(d.f.Fd())
+}
+
+// Mkdir creates the directory name within d unless it exists, and opens it.
+// An existing entry must be a directory, not a symbolic link to one.
+func (d *Dir) Mkdir(name string) (sub *Dir, err error) {
+	p := d.entryPath(name)
+	err = checkEntry(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "mkdir", Path: p, Err: err}
+	}
+
+	err = unix.Mkdirat(d.fd(), name, 0o755)
+	if err != nil && !errors.Is(err, unix.EEXIST) {
+		return nil, &fs.PathError{Op: "mkdir", Path: p, Err: err}
+	}
+
+	// Either existed or was created concurrently.
+	fd, err := unix.Openat(d.fd(), name, unix.O_PATH|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if errors.Is(err, unix.ELOOP) {
+		err = unix.ENOTDIR
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "mkdir", Path: p, Err: err}
+	}
+
+	return &Dir{f: os.NewFile(uintptr(fd), p), name: p}, nil
+}
+
+// CreateTemp creates a new file within d opened for reading and writing, like
+// [os.CreateTemp] does.  The last "*" of pattern is replaced with a random
+// string.
+func (d *Dir) CreateTemp(pattern string) (f *os.File, err error) {
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndexByte(pattern, '*'); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+
+	for try := 0; try < 10000; try++ {
+		name := prefix + strconv.FormatUint(uint64(rand.Uint32()), 10) + suffix
+		err = checkEntry(name)
+		if err != nil {
+			return nil, &fs.PathError{Op: "createtemp", Path: d.entryPath(pattern), Err: err}
+		}
+
+		var fd int
+		fd, err = unix.Openat(
+			d.fd(),
+			name,
+			unix.O_RDWR|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC,
+			0o600,
+		)
+		if errors.Is(err, unix.EEXIST) || errors.Is(err, unix.EINTR) {
+			continue
+		} else if err != nil {
+			return nil, &fs.PathError{Op: "createtemp", Path: d.entryPath(name), Err: err}
+		}
+
+		return os.NewFile(uintptr(fd), d.entryPath(name)), nil
+	}
+
+	return nil, &fs.PathError{Op: "createtemp", Path: d.entryPath(pattern), Err: fs.ErrExist}
+}
+
+// Lstat returns the information about the entry name within d.  If it's a
+// symbolic link, the information is about the link itself.
+func (d *Dir) Lstat(name string) (fi fs.FileInfo, err error) {
+	p := d.entryPath(name)
+	err = checkEntry(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: p, Err: err}
+	}
+
+	fd, err := unix.Openat(d.fd(), name, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: p, Err: err}
+	}
+
+	f := os.NewFile(uintptr(fd), p)
+	defer func() { _ = f.Close() }()
+
+	return f.Stat()
+}
+
+// Remove removes the file name within d.
+func (d *Dir) Remove(name string) (err error) {
+	p := d.entryPath(name)
+	err = checkEntry(name)
+	if err == nil {
+		err = unix.Unlinkat(d.fd(), name, 0)
+	}
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: p, Err: err}
+	}
+
+	return nil
+}
+
+// Rename renames the entry oldName within d to newName, replacing the
+// existing one.
+func (d *Dir) Rename(oldName, newName string) (err error) {
+	err = errors.Join(checkEntry(oldName), checkEntry(newName))
+	if err == nil {
+		err = unix.Renameat(d.fd(), oldName, d.fd(), newName)
+	}
+
+	return linkError("rename", d.entryPath(oldName), d.entryPath(newName), err)
+}
+
+// RenameIn moves the file at the path src within the operating system's file
+// system into d under name, replacing the existing entry.
+func (d *Dir) RenameIn(src, name string) (err error) {
+	err = checkEntry(name)
+	if err == nil {
+		err = unix.Renameat(unix.AT_FDCWD, src, d.fd(), name)
+	}
+
+	return linkError("rename", src, d.entryPath(name), err)
+}
+
+// RenameOut moves the entry name within d to the path dst within the operating
+// system's file system, replacing the existing file.
+func (d *Dir) RenameOut(name, dst string) (err error) {
+	err = checkEntry(name)
+	if err == nil {
+		err = unix.Renameat(d.fd(), name, unix.AT_FDCWD, dst)
+	}
+
+	return linkError("rename", d.entryPath(name), dst, err)
+}
+
+// LinkIn creates the hard link name within d to the file at the path src
+// within the operating system's file system.
+func (d *Dir) LinkIn(src, name string) (err error) {
+	err = checkEntry(name)
+	if err == nil {
+		err = unix.Linkat(unix.AT_FDCWD, src, d.fd(), name, 0)
+	}
+
+	return linkError("link", src, d.entryPath(name), err)
+}
+
+// LinkOut creates the hard link at the path dst within the operating system's
+// file system to the file name within d.  A symbolic link is linked itself.
+func (d *Dir) LinkOut(name, dst string) (err error) {
+	err = checkEntry(name)
+	if err == nil {
+		err = unix.Linkat(d.fd(), name, unix.AT_FDCWD, dst, 0)
+	}
+
+	return linkError("link", d.entryPath(name), dst, err)
+}
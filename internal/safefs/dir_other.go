@@ -0,0 +1,135 @@
+//go:build !linux
+
+package safefs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// openDir opens the directory at the resolved slash-separated path rel within
+// the root.  Unlike on Linux, the entries of the directory are changed by
+// their paths, so the links replacing the path's elements after opening it are
+// followed.
+func (fsys *FS) openDir(rel string) (d *Dir, err error) {
+	name := filepath.Join(fsys.root, filepath.FromSlash(rel))
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, syscall.ENOTDIR
+	}
+
+	return &Dir{name: name}, nil
+}
+
+// Mkdir creates the directory name within d unless it exists, and opens it.
+// An existing entry must be a directory, not a symbolic link to one.
+func (d *Dir) Mkdir(name string) (sub *Dir, err error) {
+	p := d.entryPath(name)
+	err = checkEntry(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "mkdir", Path: p, Err: err}
+	}
+
+	err = os.Mkdir(p, 0o755)
+	if err != nil && !errors.Is(err, fs.ErrExist) {
+		return nil, err
+	}
+
+	// Either existed or was created concurrently.
+	fi, err := os.Lstat(p)
+	if err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, &fs.PathError{Op: "mkdir", Path: p, Err: syscall.ENOTDIR}
+	}
+
+	return &Dir{name: p}, nil
+}
+
+// CreateTemp creates a new file within d opened for reading and writing, like
+// [os.CreateTemp] does.  The last "*" of pattern is replaced with a random
+// string.
+func (d *Dir) CreateTemp(pattern string) (f *os.File, err error) {
+	return os.CreateTemp(d.name, pattern)
+}
+
+// Lstat returns the information about the entry name within d.  If it's a
+// symbolic link, the information is about the link itself.
+func (d *Dir) Lstat(name string) (fi fs.FileInfo, err error) {
+	err = checkEntry(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: d.entryPath(name), Err: err}
+	}
+
+	return os.Lstat(d.entryPath(name))
+}
+
+// Remove removes the file name within d.
+func (d *Dir) Remove(name string) (err error) {
+	err = checkEntry(name)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: d.entryPath(name), Err: err}
+	}
+
+	return os.Remove(d.entryPath(name))
+}
+
+// Rename renames the entry oldName within d to newName, replacing the
+// existing one.
+func (d *Dir) Rename(oldName, newName string) (err error) {
+	err = errors.Join(checkEntry(oldName), checkEntry(newName))
+	if err != nil {
+		return linkError("rename", d.entryPath(oldName), d.entryPath(newName), err)
+	}
+
+	return os.Rename(d.entryPath(oldName), d.entryPath(newName))
+}
+
+// RenameIn moves the file at the path src within the operating system's file
+// system into d under name, replacing the existing entry.
+func (d *Dir) RenameIn(src, name string) (err error) {
+	err = checkEntry(name)
+	if err != nil {
+		return linkError("rename", src, d.entryPath(name), err)
+	}
+
+	return os.Rename(src, d.entryPath(name))
+}
+
+// RenameOut moves the entry name within d to the path dst within the operating
+// system's file system, replacing the existing file.
+func (d *Dir) RenameOut(name, dst string) (err error) {
+	err = checkEntry(name)
+	if err != nil {
+		return linkError("rename", d.entryPath(name), dst, err)
+	}
+
+	return os.Rename(d.entryPath(name), dst)
+}
+
+// LinkIn creates the hard link name within d to the file at the path src
+// within the operating system's file system.
+func (d *Dir) LinkIn(src, name string) (err error) {
+	err = checkEntry(name)
+	if err != nil {
+		return linkError("link", src, d.entryPath(name), err)
+	}
+
+	return os.Link(src, d.entryPath(name))
+}
+
+// LinkOut creates the hard link at the path dst within the operating system's
+// file system to the file name within d.
+func (d *Dir) LinkOut(name, dst string) (err error) {
+	err = checkEntry(name)
+	if err != nil {
+		return linkError("link", d.entryPath(name), dst, err)
+	}
+
+	return os.Link(d.entryPath(name), dst)
+}
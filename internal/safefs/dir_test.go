@@ -0,0 +1,166 @@
+package safefs_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"filesrv/internal/safefs"
+)
+
+func TestDir(t *testing.T) {
+	root, _ := newTree(t)
+
+	fsys, err := safefs.New(root, safefs.SymlinksWithinRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := fsys.OpenDir("rel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.Close() }()
+
+	dir := filepath.Join(root, "dir")
+	if d.Name() != dir {
+		t.Fatalf("got name %q, want %q", d.Name(), dir)
+	}
+
+	for range [2]struct{}{} {
+		sub, mkErr := d.Mkdir("new")
+		if mkErr != nil {
+			t.Fatal(mkErr)
+		}
+		_ = sub.Close()
+	}
+
+	for _, name := range []string{"file.txt", "sibling"} {
+		_, err = d.Mkdir(name)
+		if !errors.Is(err, syscall.ENOTDIR) {
+			t.Fatalf("creating over %q: got %v, want %v", name, err, syscall.ENOTDIR)
+		}
+	}
+
+	f, err := d.CreateTemp("tmp_*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	tmpName := filepath.Base(f.Name())
+	if filepath.Dir(f.Name()) != dir || !strings.HasPrefix(tmpName, "tmp_") || !strings.HasSuffix(tmpName, ".txt") {
+		t.Fatalf("created %q", f.Name())
+	} else if err = d.Rename(tmpName, "renamed.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := d.Lstat("sibling")
+	if err != nil {
+		t.Fatal(err)
+	} else if fi.Mode()&fs.ModeSymlink == 0 {
+		t.Fatalf("link is followed, got mode %v", fi.Mode())
+	}
+
+	err = d.Remove("renamed.txt")
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err = d.Lstat("renamed.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("removed file: got %v", err)
+	}
+
+	for _, name := range []string{"", ".", "..", "../file.txt", "new/x"} {
+		_, err = d.Lstat(name)
+		if !errors.Is(err, safefs.ErrInvalidPath) {
+			t.Fatalf("entry %q: got %v, want %v", name, err, safefs.ErrInvalidPath)
+		}
+	}
+}
+
+func TestFS_OpenParent(t *testing.T) {
+	root, _ := newTree(t)
+
+	fsys, err := safefs.New(root, safefs.SymlinksWithinRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		path     string
+		create   bool
+		wantDir  string
+		wantBase string
+		wantErr  error
+	}{{
+		name:     "link",
+		path:     "rel/file.txt",
+		create:   false,
+		wantDir:  filepath.Join(root, "dir"),
+		wantBase: "file.txt",
+		wantErr:  nil,
+	}, {
+		name:     "file_link",
+		path:     "chain",
+		create:   false,
+		wantDir:  filepath.Join(root, "dir"),
+		wantBase: "file.txt",
+		wantErr:  nil,
+	}, {
+		name:     "missing",
+		path:     "new/a/file.txt",
+		create:   false,
+		wantDir:  "",
+		wantBase: "",
+		wantErr:  fs.ErrNotExist,
+	}, {
+		name:     "created",
+		path:     "rel/new/a/file.txt",
+		create:   true,
+		wantDir:  filepath.Join(root, "dir", "new", "a"),
+		wantBase: "file.txt",
+		wantErr:  nil,
+	}, {
+		name:     "outside",
+		path:     "out/new/file.txt",
+		create:   true,
+		wantDir:  "",
+		wantBase: "",
+		wantErr:  safefs.ErrEscape,
+	}, {
+		name:     "root",
+		path:     "/",
+		create:   false,
+		wantDir:  "",
+		wantBase: "",
+		wantErr:  safefs.ErrInvalidPath,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, base, openErr := fsys.OpenParent(tc.path, tc.create)
+			if tc.wantErr != nil {
+				if !errors.Is(openErr, tc.wantErr) {
+					t.Fatalf("got %v, want %v", openErr, tc.wantErr)
+				}
+
+				return
+			} else if openErr != nil {
+				t.Fatal(openErr)
+			}
+			defer func() { _ = d.Close() }()
+
+			if d.Name() != tc.wantDir || base != tc.wantBase {
+				t.Fatalf("got %q and %q, want %q and %q", d.Name(), base, tc.wantDir, tc.wantBase)
+			}
+		})
+	}
+
+	if _, err = os.Stat(filepath.Join(root, "new")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("created directories without create: %v", err)
+	}
+}
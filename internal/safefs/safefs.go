@@ -0,0 +1,247 @@
+// Package safefs implements the file system confined to the served root
+// directory.
+package safefs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"filesrv/internal/ferrors"
+)
+
+// SymlinkPolicy is the way the symbolic links within the root are treated.
+type SymlinkPolicy string
+
+// Supported symbolic link policies.
+const (
+	// SymlinksFollow follows any symbolic link, like [http.Dir] does.
+	SymlinksFollow SymlinkPolicy = "follow"
+
+	// SymlinksWithinRoot only follows the symbolic links pointing within the
+	// root, directly or through the other links.
+	SymlinksWithinRoot SymlinkPolicy = "within-root"
+
+	// SymlinksDeny doesn't follow the symbolic links at all.
+	SymlinksDeny SymlinkPolicy = "deny"
+)
+
+// UnmarshalText implements the [encoding.TextUnmarshaler] interface for
+// *SymlinkPolicy.
+func (p *SymlinkPolicy) UnmarshalText(b []byte) (err error) {
+	switch sp := SymlinkPolicy(b); sp {
+	case SymlinksFollow, SymlinksWithinRoot, SymlinksDeny:
+		*p = sp
+
+		return nil
+	default:
+		return fmt.Errorf("unsupported symlink policy %q", b)
+	}
+}
+
+// Errors of resolving the paths.  Those also match [fs.ErrNotExist], so that
+// the files outside of the root aren't distinguished from the missing ones.
+const (
+	ErrEscape       ferrors.Str = "path escapes the root"
+	ErrSymlink      ferrors.Str = "symbolic links are not allowed"
+	ErrTooManyLinks ferrors.Str = "too many levels of symbolic links"
+	ErrInvalidPath  ferrors.Str = "invalid path"
+)
+
+// maxLinks is the maximum number of symbolic links followed while resolving a
+// single path, the same as Linux allows.
+const maxLinks = 40
+
+// FS is an [http.FileSystem] serving the files within the root directory.
+// Unlike [http.Dir], it treats the symbolic links according to the policy.
+type FS struct {
+	// root is the absolute path to the root directory.
+	root string
+
+	// realRoot is root with its own symbolic links resolved, which the
+	// absolute links point within.
+	realRoot string
+
+	policy SymlinkPolicy
+}
+
+// New returns a file system serving the files within root with the symbolic
+// links treated according to policy.  Empty policy means [SymlinksWithinRoot].
+func New(root string, policy SymlinkPolicy) (fsys *FS, err error) {
+	if policy == "" {
+		policy = SymlinksWithinRoot
+	}
+
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("safefs: %w", err)
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		// Not existing yet, so there is nothing to point within it.
+		realRoot = root
+	}
+
+	return &FS{
+		root:     root,
+		realRoot: realRoot,
+		policy:   policy,
+	}, nil
+}
+
+// type check
+var _ http.FileSystem = (*FS)(nil)
+
+// Open implements the [http.FileSystem] interface for *FS.
+func (fsys *FS) Open(name string) (f http.File, err error) {
+	rel, err := fsys.resolve(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	if fsys.policy == SymlinksFollow {
+		return os.Open(filepath.Join(fsys.root, filepath.FromSlash(rel)))
+	}
+
+	file, err := fsys.openNoFollow(rel)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return file, nil
+}
+
+// Resolve returns the path within the operating system's file system of the
+// slash-separated name within the root, with the symbolic links resolved
+// according to the policy.  The trailing elements of name may not exist.
+func (fsys *FS) Resolve(name string) (p string, err error) {
+	rel, err := fsys.resolve(name)
+	if err != nil {
+		return "", &fs.PathError{Op: "resolve", Path: name, Err: err}
+	}
+
+	return filepath.Join(fsys.root, filepath.FromSlash(rel)), nil
+}
+
+// resolve returns the slash-separated path relative to the root that the
+// slash-separated name refers to.  The returned path contains no symbolic
+// links, unless the policy is [SymlinksFollow].
+func (fsys *FS) resolve(name string) (rel string, err error) {
+	if strings.ContainsRune(name, 0) ||
+		(filepath.Separator != '/' && strings.ContainsRune(name, filepath.Separator)) {
+		return "", notExist(ErrInvalidPath)
+	}
+
+	// Cleaning the rooted name drops all the leading "..".
+	clean := path.Clean("/" + name)
+	if fsys.policy == SymlinksFollow {
+		return clean[1:], nil
+	}
+
+	todo := splitPath(clean)
+	var done []string
+	missing := false
+	for links := 0; len(todo) > 0; {
+		elem := todo[0]
+		todo = todo[1:]
+
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			// Only the targets of the links may contain those.
+			if len(done) == 0 {
+				return "", notExist(ErrEscape)
+			}
+
+			done = done[:len(done)-1]
+
+			continue
+		}
+
+		done = append(done, elem)
+		if missing {
+			continue
+		}
+
+		var target string
+		target, missing, err = fsys.readLink(done)
+		if err != nil {
+			return "", err
+		} else if target == "" {
+			continue
+		}
+
+		links++
+		if links > maxLinks {
+			return "", notExist(ErrTooManyLinks)
+		}
+
+		done = done[:len(done)-1]
+		if filepath.IsAbs(target) {
+			target, err = fsys.rootRel(target)
+			if err != nil {
+				return "", err
+			}
+
+			done = nil
+		}
+
+		todo = append(splitPath(filepath.ToSlash(target)), todo...)
+	}
+
+	return path.Join(done...), nil
+}
+
+// readLink returns the target of the path elems within the root if it's a
+// symbolic link, or the empty string otherwise.  missing is true if the path
+// doesn't exist.
+func (fsys *FS) readLink(elems []string) (target string, missing bool, err error) {
+	p := filepath.Join(fsys.root, filepath.FromSlash(path.Join(elems...)))
+	fi, err := os.Lstat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", true, nil
+	} else if err != nil {
+		return "", false, err
+	} else if fi.Mode()&fs.ModeSymlink == 0 {
+		return "", false, nil
+	} else if fsys.policy == SymlinksDeny {
+		return "", false, notExist(ErrSymlink)
+	}
+
+	target, err = os.Readlink(p)
+	if err != nil {
+		return "", false, err
+	}
+
+	return target, false, nil
+}
+
+// rootRel returns the slash-separated path relative to the root of the
+// absolute path p.  It returns an error if p is outside of the root.
+func (fsys *FS) rootRel(p string) (rel string, err error) {
+	for _, root := range []string{fsys.realRoot, fsys.root} {
+		rel, err = filepath.Rel(root, p)
+		if err == nil && filepath.IsLocal(rel) {
+			return filepath.ToSlash(rel), nil
+		}
+	}
+
+	return "", notExist(ErrEscape)
+}
+
+// splitPath returns the elements of the slash-separated path p.
+func splitPath(p string) (elems []string) {
+	return strings.Split(strings.Trim(p, "/"), "/")
+}
+
+// notExist returns err also matching [fs.ErrNotExist].
+func notExist(err error) (wrapped error) {
+	return fmt.Errorf("%w: %w", err, fs.ErrNotExist)
+}
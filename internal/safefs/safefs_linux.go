@@ -0,0 +1,138 @@
+//go:build linux
+
+package safefs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// noOpenat2 is set once openat2(2) turns out to be unavailable, either
+// unsupported by the kernel or denied by a seccomp filter.
+var noOpenat2 = &atomic.Bool{}
+
+// sysOpenat2 is the openat2(2) system call, replaced in tests.
+var sysOpenat2 = unix.Openat2
+
+// openNoFollow opens the file at the resolved slash-separated path rel within
+// the root without following any symbolic link, so that the links replacing
+// the path's elements after resolving it can't lead outside of the root.
+func (fsys *FS) openNoFollow(rel string) (f *os.File, err error) {
+	return fsys.openBeneath(rel, unix.O_RDONLY)
+}
+
+// openBeneath opens the file at the resolved slash-separated path rel within
+// the root with flags without following any symbolic link.
+func (fsys *FS) openBeneath(rel string, flags int) (f *os.File, err error) {
+	rootFD, err := unix.Open(fsys.root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var fd int
+	if !noOpenat2.Load() {
+		fd, err = openat2(rootFD, rel, flags)
+		if !isOpenat2Unavailable(err) {
+			_ = unix.Close(rootFD)
+
+			return newFile(fd, fsys.root, rel, err)
+		}
+
+		noOpenat2.Store(true)
+	}
+
+	// openatNoFollow closes rootFD.
+	fd, err = openatNoFollow(rootFD, rel, flags)
+
+	return newFile(fd, fsys.root, rel, err)
+}
+
+// isOpenat2Unavailable returns true if err means that openat2(2) can't be used
+// at all.  Older kernels don't implement it, and seccomp filters unaware of it,
+// like the ones of older container runtimes, deny it with either EPERM or
+// EINVAL.
+func isOpenat2Unavailable(err error) (ok bool) {
+	return errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EPERM) || errors.Is(err, unix.EINVAL)
+}
+
+// openat2 opens rel beneath the directory dirFD with flags using openat2(2),
+// failing on any symbolic link.
+func openat2(dirFD int, rel string, flags int) (fd int, err error) {
+	if rel == "" {
+		rel = "."
+	}
+
+	for {
+		fd, err = sysOpenat2(dirFD, rel, &unix.OpenHow{
+			Flags:   uint64(flags | unix.O_CLOEXEC),
+			Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_SYMLINKS | unix.RESOLVE_NO_MAGICLINKS,
+		})
+		if !errors.Is(err, unix.EAGAIN) && !errors.Is(err, unix.EINTR) {
+			break
+		}
+	}
+
+	if errors.Is(err, unix.ELOOP) {
+		return -1, notExist(ErrSymlink)
+	} else if errors.Is(err, unix.EXDEV) {
+		return -1, notExist(ErrEscape)
+	}
+
+	return fd, err
+}
+
+// openatNoFollow opens rel within the directory dirFD with flags using
+// openat(2), one element at a time with O_NOFOLLOW.  It closes dirFD.
+func openatNoFollow(dirFD int, rel string, flags int) (fd int, err error) {
+	elems := splitPath(rel)
+	if rel == "" {
+		elems = []string{"."}
+	}
+
+	fd = dirFD
+	for i, elem := range elems {
+		// Only the file itself is opened with flags, the directories leading
+		// to it only need to be searchable.
+		elemFlags := unix.O_PATH | unix.O_DIRECTORY
+		if i == len(elems)-1 {
+			elemFlags = flags
+		}
+
+		var next int
+		next, err = unix.Openat(fd, elem, elemFlags|unix.O_CLOEXEC|unix.O_NOFOLLOW, 0)
+		if err != nil && isSymlinkAt(fd, elem) {
+			err = notExist(ErrSymlink)
+		}
+		_ = unix.Close(fd)
+		if err != nil {
+			return -1, err
+		}
+
+		fd = next
+	}
+
+	return fd, nil
+}
+
+// isSymlinkAt returns true if name within the directory dirFD is a symbolic
+// link.  O_NOFOLLOW makes openat(2) fail with either ELOOP or ENOTDIR for
+// those, depending on the other flags.
+func isSymlinkAt(dirFD int, name string) (ok bool) {
+	st := &unix.Stat_t{}
+	err := unix.Fstatat(dirFD, name, st, unix.AT_SYMLINK_NOFOLLOW)
+
+	return err == nil && st.Mode&unix.S_IFMT == unix.S_IFLNK
+}
+
+// newFile returns the file of fd opened at rel within root, or err if any.
+func newFile(fd int, root, rel string, err error) (f *os.File, resErr error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(fd), filepath.Join(root, filepath.FromSlash(rel))), nil
+}
@@ -0,0 +1,146 @@
+//go:build linux
+
+package safefs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestFS_openNoFollow_fallback(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "dir"), 0o755); err != nil {
+		t.Fatal(err)
+	} else if err = os.WriteFile(filepath.Join(root, "dir", "file.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	} else if err = os.Symlink("dir", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := New(root, SymlinksWithinRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := noOpenat2.Load()
+	t.Cleanup(func() { noOpenat2.Store(prev) })
+
+	for _, disabled := range []bool{false, true} {
+		noOpenat2.Store(disabled)
+
+		for _, rel := range []string{"", "dir", "dir/file.txt"} {
+			f, openErr := fsys.openNoFollow(rel)
+			if openErr != nil {
+				t.Fatalf("openat2 disabled: %t: opening %q: %v", disabled, rel, openErr)
+			}
+			_ = f.Close()
+		}
+
+		// The resolved paths never contain links, so those must have been
+		// put there after resolving.
+		_, err = fsys.openNoFollow("link/file.txt")
+		if !errors.Is(err, ErrSymlink) || !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("openat2 disabled: %t: opening through link: got %v", disabled, err)
+		}
+	}
+}
+
+func TestFS_openNoFollow_openat2Denied(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "file.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := New(root, SymlinksWithinRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev, prevOpenat2 := noOpenat2.Load(), sysOpenat2
+	t.Cleanup(func() {
+		noOpenat2.Store(prev)
+		sysOpenat2 = prevOpenat2
+	})
+
+	for _, errno := range []unix.Errno{unix.ENOSYS, unix.EPERM, unix.EINVAL} {
+		noOpenat2.Store(false)
+		sysOpenat2 = func(_ int, _ string, _ *unix.OpenHow) (fd int, err error) {
+			return -1, errno
+		}
+
+		f, openErr := fsys.openNoFollow("file.txt")
+		if openErr != nil {
+			t.Fatalf("openat2 failing with %v: %v", errno, openErr)
+		}
+		_ = f.Close()
+
+		if !noOpenat2.Load() {
+			t.Fatalf("openat2 failing with %v is still used", errno)
+		}
+	}
+}
+
+func TestDir_replacedWithSymlink(t *testing.T) {
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "root")
+	outside := filepath.Join(tmp, "outside")
+	for _, d := range []string{filepath.Join(root, "dir"), outside} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fsys, err := New(root, SymlinksWithinRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := fsys.OpenDir("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.Close() }()
+
+	// Replace the directory after opening it.
+	moved := filepath.Join(root, "moved")
+	if err = os.Rename(filepath.Join(root, "dir"), moved); err != nil {
+		t.Fatal(err)
+	} else if err = os.Symlink(outside, filepath.Join(root, "dir")); err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := d.Mkdir("sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = sub.Close()
+
+	f, err := d.CreateTemp("file_*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	err = d.Rename(filepath.Base(f.Name()), "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(outside)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(entries) > 0 {
+		t.Fatalf("created %q outside of the root", entries[0].Name())
+	}
+
+	for _, name := range []string{"sub", "file.txt"} {
+		if _, err = os.Lstat(filepath.Join(moved, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
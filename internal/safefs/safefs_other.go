@@ -0,0 +1,15 @@
+//go:build !linux
+
+package safefs
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// openNoFollow opens the file at the resolved slash-separated path rel within
+// the root.  Unlike on Linux, the links replacing the path's elements after
+// resolving it are followed.
+func (fsys *FS) openNoFollow(rel string) (f *os.File, err error) {
+	return os.Open(filepath.Join(fsys.root, filepath.FromSlash(rel)))
+}
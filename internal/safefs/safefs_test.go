@@ -0,0 +1,381 @@
+package safefs_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filesrv/internal/safefs"
+)
+
+// policies are all the supported symbolic link policies.
+var policies = []safefs.SymlinkPolicy{
+	safefs.SymlinksFollow,
+	safefs.SymlinksWithinRoot,
+	safefs.SymlinksDeny,
+}
+
+// newTree creates the following tree within a temporary directory and returns
+// the paths to the root and to the secret file outside of it:
+//
+//	outside/secret.txt
+//	root/dir/file.txt
+//	root/dir/up          -> ../..
+//	root/dir/sibling     -> ../file.txt
+//	root/file.txt
+//	root/rel             -> dir
+//	root/abs             -> <root>/dir
+//	root/chain           -> rel/file.txt
+//	root/out             -> ../outside
+//	root/outabs          -> <outside>/secret.txt
+//	root/loop            -> loop
+//	root/missing         -> nowhere
+func newTree(t testing.TB) (root, secret string) {
+	t.Helper()
+
+	tmp := t.TempDir()
+	root = filepath.Join(tmp, "root")
+	outside := filepath.Join(tmp, "outside")
+	secret = filepath.Join(outside, "secret.txt")
+
+	for _, d := range []string{outside, filepath.Join(root, "dir")} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := map[string]string{
+		secret:                              "secret",
+		filepath.Join(root, "file.txt"):     "root file",
+		filepath.Join(root, "dir/file.txt"): "dir file",
+	}
+	for p, content := range files {
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	links := map[string]string{
+		"dir/up":      "../..",
+		"dir/sibling": "../file.txt",
+		"rel":         "dir",
+		"abs":         filepath.Join(root, "dir"),
+		"chain":       "rel/file.txt",
+		"out":         "../outside",
+		"outabs":      secret,
+		"loop":        "loop",
+		"missing":     "nowhere",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skipf("creating symlinks: %v", err)
+		}
+	}
+
+	return root, secret
+}
+
+// readFile returns the contents of name opened from fsys.
+func readFile(t testing.TB, fsys *safefs.FS, name string) (content string, err error) {
+	t.Helper()
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("reading %q: %v", name, err)
+	}
+
+	return string(b), nil
+}
+
+func TestFS_Open(t *testing.T) {
+	root, _ := newTree(t)
+
+	const notExist = "-"
+
+	testCases := []struct {
+		name       string
+		path       string
+		follow     string
+		withinRoot string
+		deny       string
+	}{{
+		name:       "plain",
+		path:       "dir/file.txt",
+		follow:     "dir file",
+		withinRoot: "dir file",
+		deny:       "dir file",
+	}, {
+		name:       "dot_dot",
+		path:       "../outside/secret.txt",
+		follow:     notExist,
+		withinRoot: notExist,
+		deny:       notExist,
+	}, {
+		name:       "dot_dot_inner",
+		path:       "dir/../../outside/secret.txt",
+		follow:     notExist,
+		withinRoot: notExist,
+		deny:       notExist,
+	}, {
+		name:       "absolute",
+		path:       "/dir/file.txt",
+		follow:     "dir file",
+		withinRoot: "dir file",
+		deny:       "dir file",
+	}, {
+		name:       "relative_link",
+		path:       "rel/file.txt",
+		follow:     "dir file",
+		withinRoot: "dir file",
+		deny:       notExist,
+	}, {
+		name:       "absolute_link",
+		path:       "abs/file.txt",
+		follow:     "dir file",
+		withinRoot: "dir file",
+		deny:       notExist,
+	}, {
+		name:       "chained_links",
+		path:       "chain",
+		follow:     "dir file",
+		withinRoot: "dir file",
+		deny:       notExist,
+	}, {
+		name:       "link_to_parent",
+		path:       "dir/sibling",
+		follow:     "root file",
+		withinRoot: "root file",
+		deny:       notExist,
+	}, {
+		name:       "link_escaping_upwards",
+		path:       "dir/up/outside/secret.txt",
+		follow:     "secret",
+		withinRoot: notExist,
+		deny:       notExist,
+	}, {
+		name:       "relative_link_outside",
+		path:       "out/secret.txt",
+		follow:     "secret",
+		withinRoot: notExist,
+		deny:       notExist,
+	}, {
+		name:       "absolute_link_outside",
+		path:       "outabs",
+		follow:     "secret",
+		withinRoot: notExist,
+		deny:       notExist,
+	}, {
+		name:       "loop",
+		path:       "loop",
+		follow:     notExist,
+		withinRoot: notExist,
+		deny:       notExist,
+	}, {
+		name:       "dangling",
+		path:       "missing",
+		follow:     notExist,
+		withinRoot: notExist,
+		deny:       notExist,
+	}, {
+		name:       "nul",
+		path:       "file.txt\x00",
+		follow:     notExist,
+		withinRoot: notExist,
+		deny:       notExist,
+	}}
+
+	for _, tc := range testCases {
+		want := map[safefs.SymlinkPolicy]string{
+			safefs.SymlinksFollow:     tc.follow,
+			safefs.SymlinksWithinRoot: tc.withinRoot,
+			safefs.SymlinksDeny:       tc.deny,
+		}
+
+		for _, policy := range policies {
+			t.Run(tc.name+"_"+string(policy), func(t *testing.T) {
+				fsys, err := safefs.New(root, policy)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				got, err := readFile(t, fsys, tc.path)
+				if want[policy] == notExist {
+					if err == nil {
+						t.Fatalf("opened %q with %q", tc.path, got)
+					} else if policy != safefs.SymlinksFollow && !errors.Is(err, fs.ErrNotExist) {
+						t.Fatalf("error %v doesn't match fs.ErrNotExist", err)
+					}
+
+					return
+				} else if err != nil {
+					t.Fatal(err)
+				} else if got != want[policy] {
+					t.Fatalf("got %q, want %q", got, want[policy])
+				}
+			})
+		}
+	}
+}
+
+func TestFS_Open_dir(t *testing.T) {
+	root, _ := newTree(t)
+
+	for _, policy := range policies {
+		fsys, err := safefs.New(root, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{"/", "", "dir/", "/dir"} {
+			f, err := fsys.Open(name)
+			if err != nil {
+				t.Fatalf("%s: opening %q: %v", policy, name, err)
+			}
+
+			entries, err := f.Readdir(-1)
+			_ = f.Close()
+			if err != nil {
+				t.Fatalf("%s: reading %q: %v", policy, name, err)
+			} else if len(entries) == 0 {
+				t.Fatalf("%s: %q is empty", policy, name)
+			}
+		}
+	}
+}
+
+func TestFS_Resolve(t *testing.T) {
+	root, _ := newTree(t)
+
+	fsys, err := safefs.New(root, safefs.SymlinksWithinRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name string
+		path string
+		want string
+	}{{
+		name: "root",
+		path: "/",
+		want: root,
+	}, {
+		name: "link",
+		path: "rel/file.txt",
+		want: filepath.Join(root, "dir", "file.txt"),
+	}, {
+		name: "new_file_behind_link",
+		path: "abs/new/file.txt",
+		want: filepath.Join(root, "dir", "new", "file.txt"),
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, resErr := fsys.Resolve(tc.path)
+			if resErr != nil {
+				t.Fatal(resErr)
+			} else if got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	_, err = fsys.Resolve("out/new.txt")
+	if !errors.Is(err, safefs.ErrEscape) {
+		t.Fatalf("resolving outside of root: got %v", err)
+	}
+}
+
+func TestSymlinkPolicy_UnmarshalText(t *testing.T) {
+	for _, policy := range policies {
+		var p safefs.SymlinkPolicy
+		if err := p.UnmarshalText([]byte(policy)); err != nil {
+			t.Fatal(err)
+		} else if p != policy {
+			t.Fatalf("got %q, want %q", p, policy)
+		}
+	}
+
+	var p safefs.SymlinkPolicy
+	if err := p.UnmarshalText([]byte("sometimes")); err == nil {
+		t.Fatal("no error for unsupported policy")
+	}
+}
+
+// FuzzFS_Open checks that no path opens a file outside of the root unless the
+// symbolic links are followed unconditionally.
+func FuzzFS_Open(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"/",
+		"..",
+		"../outside/secret.txt",
+		"dir/up/outside/secret.txt",
+		"dir/up/root/outabs",
+		"out/secret.txt",
+		"outabs",
+		"rel/../../outside",
+		"abs/up/up/secret.txt",
+		"./dir/./sibling",
+		"dir//file.txt",
+		"chain/..",
+		"loop/loop",
+		"\\..\\outside",
+	} {
+		f.Add(seed)
+	}
+
+	root, secret := newTree(f)
+	secretFI, err := os.Stat(secret)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	outsideFI, err := os.Stat(filepath.Dir(secret))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	var systems []*safefs.FS
+	for _, policy := range []safefs.SymlinkPolicy{safefs.SymlinksWithinRoot, safefs.SymlinksDeny} {
+		fsys, fsErr := safefs.New(root, policy)
+		if fsErr != nil {
+			f.Fatal(fsErr)
+		}
+
+		systems = append(systems, fsys)
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		for _, fsys := range systems {
+			if p, resErr := fsys.Resolve(name); resErr == nil {
+				rel, relErr := filepath.Rel(root, p)
+				if relErr != nil || !(rel == "." || filepath.IsLocal(rel)) {
+					t.Fatalf("%q resolved outside of root to %q", name, p)
+				}
+			}
+
+			file, openErr := fsys.Open(name)
+			if openErr != nil {
+				continue
+			}
+
+			fi, statErr := file.Stat()
+			_ = file.Close()
+			if statErr != nil {
+				t.Fatal(statErr)
+			} else if os.SameFile(fi, secretFI) || os.SameFile(fi, outsideFI) {
+				t.Fatalf("%q opened %q outside of root", name, fi.Name())
+			}
+		}
+	})
+}
@@ -0,0 +1,199 @@
+package share
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"filesrv/internal/fhttp"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Defaults of the created shares.
+const (
+	DefaultOps = OpDownload | OpList
+	DefaultTTL = 24 * time.Hour
+)
+
+// Created is the response to the request creating a share.
+type Created struct {
+	*Share
+
+	// Token is the signed token of the share.
+	Token string `json:"token"`
+
+	// URL is the share link.
+	URL string `json:"url"`
+}
+
+// isAdminPath returns true if the cleaned URL path p is within the endpoint
+// managing the shares.
+func (g *Guard) isAdminPath(p string) (ok bool) {
+	ap := g.conf.AdminPath
+	if ap == "" || len(g.conf.AdminPasswordHash) == 0 {
+		return false
+	}
+
+	return p == ap || strings.HasPrefix(p, ap+"/")
+}
+
+// serveAdmin handles the requests to the endpoint managing the shares at the
+// cleaned URL path p:
+//
+//   - GET lists the shares;
+//   - POST creates a share;
+//   - DELETE with the share's ID appended to the path revokes it.
+func (g *Guard) serveAdmin(w http.ResponseWriter, r *http.Request, p string) {
+	user, passwd, ok := r.BasicAuth()
+	if !ok ||
+		user != g.conf.AdminUser ||
+		bcrypt.CompareHashAndPassword(g.conf.AdminPasswordHash, []byte(passwd)) != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="filesrv shares", charset="UTF-8"`)
+		g.onError(w, r, &fhttp.StatusError{Code: http.StatusUnauthorized})
+
+		return
+	}
+
+	id := strings.TrimPrefix(strings.TrimPrefix(p, g.conf.AdminPath), "/")
+	switch {
+	case id == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		recs, err := g.conf.Store.List()
+		if err != nil {
+			g.onError(w, r, &fhttp.StatusError{Err: err, Code: http.StatusInternalServerError})
+
+			return
+		}
+
+		writeJSON(w, http.StatusOK, recs)
+	case id == "" && r.Method == http.MethodPost:
+		g.create(w, r)
+	case id != "" && r.Method == http.MethodDelete:
+		g.revoke(w, r, id)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST, DELETE")
+		g.onError(w, r, &fhttp.StatusError{Code: http.StatusMethodNotAllowed})
+	}
+}
+
+// create creates a share described by the form of r: the path, the ops, the
+// ttl and the max_downloads fields.
+func (g *Guard) create(w http.ResponseWriter, r *http.Request) {
+	sh, err := g.newShare(r)
+	if err != nil {
+		code := http.StatusBadRequest
+		if errors.Is(err, fs.ErrNotExist) {
+			code = http.StatusNotFound
+		}
+		g.onError(w, r, &fhttp.StatusError{Err: err, Code: code})
+
+		return
+	}
+
+	c, err := Publish(g.conf.Store, g.conf.Secret, sh, requestBase(r))
+	if err != nil {
+		g.onError(w, r, &fhttp.StatusError{Err: err, Code: http.StatusInternalServerError})
+
+		return
+	}
+
+	log.Printf("share: created %s for %s", sh.ID, sh.Path)
+	writeJSON(w, http.StatusCreated, c)
+}
+
+// newShare returns the share described by the form of r.
+func (g *Guard) newShare(r *http.Request) (sh *Share, err error) {
+	err = r.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+
+	ops, ttl, maxDownloads := DefaultOps, DefaultTTL, 0
+	if v := r.Form.Get("ops"); v != "" {
+		ops, err = ParseOp(v)
+		if err != nil {
+			return nil, fmt.Errorf("ops: %w", err)
+		}
+	}
+
+	if v := r.Form.Get("ttl"); v != "" {
+		ttl, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("ttl: %w", err)
+		}
+	}
+
+	if v := r.Form.Get("max_downloads"); v != "" {
+		maxDownloads, err = strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("max_downloads: %w", err)
+		}
+	}
+
+	return New(g.conf.FS, r.Form.Get("path"), ops, ttl, maxDownloads)
+}
+
+// Publish persists sh in s and returns its link relative to base signed with
+// secret.
+func Publish(s *Store, secret []byte, sh *Share, base *url.URL) (c *Created, err error) {
+	token, err := Sign(secret, sh)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Add(sh)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Created{
+		Share: sh,
+		Token: token,
+		URL:   sh.URL(base, token).String(),
+	}, nil
+}
+
+// requestBase returns the URL of the server as requested by r, including the
+// base path.
+func requestBase(r *http.Request) (u *url.URL) {
+	return &url.URL{
+		Scheme: fhttp.Scheme(r),
+		Host:   r.Host,
+		Path:   fhttp.BasePath(r) + "/",
+	}
+}
+
+// revoke revokes the share with id.
+func (g *Guard) revoke(w http.ResponseWriter, r *http.Request, id string) {
+	err := g.conf.Store.Revoke(id)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, ErrInvalid) {
+			code = http.StatusNotFound
+		}
+		g.onError(w, r, &fhttp.StatusError{Err: err, Code: code})
+
+		return
+	}
+
+	log.Printf("share: revoked %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON writes v as the JSON response with code.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("share: writing response: %v", err)
+	}
+}
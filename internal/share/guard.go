@@ -0,0 +1,287 @@
+package share
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+
+	"filesrv/internal/fhttp"
+)
+
+// CookieName is the name of the cookie keeping the token after the share link
+// is opened, so that the relative links within the shared directory work.
+const CookieName = "filesrv_share"
+
+// Config is the configuration of the share links.
+type Config struct {
+	// FS is the served file system used to tell files from directories.
+	FS http.FileSystem
+
+	// Store keeps the records of the shares.
+	Store *Store
+
+	// OnError renders the response for the rejected requests.  It receives a
+	// *[fhttp.StatusError].  If nil, [http.Error] is used.
+	OnError fhttp.ErrorHandler
+
+	// Public returns true if r may be served to anyone, e.g. the theme's
+	// static files.  It may be nil.
+	Public func(r *http.Request) (ok bool)
+
+	// DropBox returns a copy of r to serve its directory as a drop box.  It's
+	// applied to the requests of the shares allowing [OpUpload] but not
+	// [OpList].  If nil, those shares can't open the directory.
+	DropBox func(r *http.Request) (db *http.Request)
+
+	// Secret is the key the tokens are signed with.
+	Secret []byte
+
+	// Trusted are the networks of clients allowed to access everything without
+	// a share link.
+	Trusted []netip.Prefix
+
+	// AdminPath is the URL path of the endpoint managing the shares.  If
+	// empty, the endpoint is disabled.
+	AdminPath string
+
+	// AdminUser is the user name for the basic authentication on the
+	// endpoint.
+	AdminUser string
+
+	// AdminPasswordHash is the bcrypt hash of the password for the basic
+	// authentication on the endpoint.  If empty, the endpoint is disabled.
+	AdminPasswordHash []byte
+}
+
+// Guard restricts the access of the untrusted clients to the shared files.  It
+// must be created with [NewGuard].
+type Guard struct {
+	conf *Config
+}
+
+// NewGuard returns a new *Guard.  conf must not be nil.
+func NewGuard(conf *Config) (g *Guard) {
+	return &Guard{conf: conf}
+}
+
+// type check
+var _ fhttp.Middleware = (*Guard)(nil).Middleware
+
+// Middleware is the [fhttp.Middleware] that only lets the trusted clients and
+// the holders of valid share links through to h.  It also serves the endpoint
+// managing the shares.
+func (g *Guard) Middleware(h http.Handler) (wrapped http.Handler) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := path.Clean("/" + r.URL.Path)
+		if g.isAdminPath(p) {
+			g.serveAdmin(w, r, p)
+
+			return
+		}
+
+		if g.isTrusted(r) || (g.conf.Public != nil && g.conf.Public(r)) {
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		token, fromQuery := tokenFrom(r)
+		if token == "" {
+			g.reject(w, r, ErrRequired)
+
+			return
+		}
+
+		sh, op, err := g.authorize(r, p, token)
+		if err != nil {
+			g.reject(w, r, err)
+
+			return
+		}
+
+		if fromQuery {
+			setCookie(w, r, sh, token)
+		}
+
+		if g.isDropBox(sh) {
+			r = g.conf.DropBox(r)
+		}
+
+		if op != OpDownload || sh.MaxDownloads == 0 || r.Method != http.MethodGet {
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		g.serveCounted(w, r, h, sh)
+	})
+}
+
+// isTrusted returns true if the client of r is within the trusted networks.
+func (g *Guard) isTrusted(r *http.Request) (ok bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+
+	addr = addr.Unmap()
+	for _, n := range g.conf.Trusted {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tokenFrom returns the token of r from the query or the cookie.  fromQuery is
+// true if the token is taken from the query.
+func tokenFrom(r *http.Request) (token string, fromQuery bool) {
+	if token = r.URL.Query().Get(QueryKey); token != "" {
+		return token, true
+	}
+
+	c, err := r.Cookie(CookieName)
+	if err != nil {
+		return "", false
+	}
+
+	return c.Value, false
+}
+
+// authorize returns the share of token if it allows r to the cleaned URL path
+// p.  op is the operation requested.
+func (g *Guard) authorize(r *http.Request, p, token string) (sh *Share, op Op, err error) {
+	sh, err = Verify(g.conf.Secret, token)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = g.conf.Store.check(sh)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !sh.covers(p) {
+		return nil, 0, ErrForbidden
+	}
+
+	op = g.operation(r, p)
+	if sh.Ops&op == 0 && !(op == OpList && g.isDropBox(sh)) {
+		return nil, 0, ErrForbidden
+	}
+
+	return sh, op, nil
+}
+
+// isDropBox returns true if the directory shared by sh is served as a drop box.
+func (g *Guard) isDropBox(sh *Share) (ok bool) {
+	return g.conf.DropBox != nil && sh.Ops&OpUpload != 0 && sh.Ops&OpList == 0
+}
+
+// operation returns the operation requested by r to the cleaned URL path p or
+// zero if it's not one of the share operations.
+func (g *Guard) operation(r *http.Request, p string) (op Op) {
+	isDir := false
+	if f, err := g.conf.FS.Open(p); err == nil {
+		fi, statErr := f.Stat()
+		isDir = statErr == nil && fi.IsDir()
+		_ = f.Close()
+	}
+
+	// Polling and canceling the progress of an upload are part of uploading.
+	q := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if isDir && q.Has("progress") {
+			return OpUpload
+		} else if isDir {
+			return OpList
+		}
+
+		return OpDownload
+	case http.MethodPost:
+		if isDir && (q.Has("upload") || q.Has("cancel")) {
+			return OpUpload
+		}
+	}
+
+	return 0
+}
+
+// setCookie keeps token of sh in the cookie scoped to the shared path under the
+// base path of r.
+func setCookie(w http.ResponseWriter, r *http.Request, sh *Share, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    token,
+		Path:     fhttp.BasePath(r) + sh.Path,
+		Expires:  sh.Expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// serveCounted serves the download of the file shared by sh with the limited
+// number of downloads.  Each response with the content is counted, including
+// the partial ones and the ones aborted by the client, so that the range
+// requests can't bypass the limit.  The failed requests aren't counted.
+func (g *Guard) serveCounted(w http.ResponseWriter, r *http.Request, h http.Handler, sh *Share) {
+	err := g.conf.Store.acquire(sh.ID)
+	if err != nil {
+		g.reject(w, r, err)
+
+		return
+	}
+
+	rw := fhttp.NewRecordingWriter(w)
+	h.ServeHTTP(rw, r)
+
+	if code := rw.Status(); code == http.StatusOK || code == http.StatusPartialContent {
+		return
+	}
+
+	err = g.conf.Store.release(sh.ID)
+	if err != nil {
+		log.Printf("share: releasing download of %s: %v", sh.ID, err)
+	}
+}
+
+// reject responds to r with the status code appropriate for err.
+func (g *Guard) reject(w http.ResponseWriter, r *http.Request, err error) {
+	code := http.StatusForbidden
+	switch {
+	case
+		errors.Is(err, ErrExpired),
+		errors.Is(err, ErrRevoked),
+		errors.Is(err, ErrExhausted):
+		code = http.StatusGone
+	case
+		errors.Is(err, ErrInvalid),
+		errors.Is(err, ErrForbidden),
+		errors.Is(err, ErrRequired):
+		// Go on.
+	default:
+		log.Printf("share: %v", err)
+		code = http.StatusInternalServerError
+	}
+
+	g.onError(w, r, &fhttp.StatusError{Err: err, Code: code})
+}
+
+// onError renders err with the configured handler.
+func (g *Guard) onError(w http.ResponseWriter, r *http.Request, err *fhttp.StatusError) {
+	if g.conf.OnError != nil {
+		g.conf.OnError(w, r, err)
+	} else {
+		http.Error(w, err.Error(), err.Code)
+	}
+}
@@ -0,0 +1,281 @@
+package share
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestGuard returns the guard of fsys trusting 198.51.100.0/24, which
+// doesn't contain the default client of the test requests, and the store of
+// its shares.
+func newTestGuard(t *testing.T, fsys http.FileSystem) (g *Guard, s *Store) {
+	t.Helper()
+
+	s = NewStore(filepath.Join(t.TempDir(), "shares"))
+	g = NewGuard(&Config{
+		FS:      fsys,
+		Store:   s,
+		Secret:  []byte("secret"),
+		Trusted: []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")},
+	})
+
+	return g, s
+}
+
+// newToken adds the share of p in fsys to s and returns its token.
+func newToken(t *testing.T, fsys http.FileSystem, s *Store, p string, ops Op, maxDownloads int) (token string) {
+	t.Helper()
+
+	sh, err := New(fsys, p, ops, time.Hour, maxDownloads)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Add(sh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err = Sign([]byte("secret"), sh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestGuard_Middleware(t *testing.T) {
+	fsys := newTestFS(t)
+	g, s := newTestGuard(t, fsys)
+
+	fileToken := newToken(t, fsys, s, "/docs/a.txt", OpDownload, 0)
+	listToken := newToken(t, fsys, s, "/docs", OpList, 0)
+	uploadToken := newToken(t, fsys, s, "/docs", OpUpload|OpList, 0)
+	revokedToken := newToken(t, fsys, s, "/docs/a.txt", OpDownload, 0)
+	revoked, err := Verify([]byte("secret"), revokedToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Revoke(revoked.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := g.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	testCases := []struct {
+		name       string
+		method     string
+		target     string
+		remote     string
+		token      string
+		wantCode   int
+		wantCookie bool
+	}{{
+		name:     "trusted",
+		method:   http.MethodGet,
+		target:   "/other.txt",
+		remote:   "198.51.100.1:1",
+		wantCode: http.StatusOK,
+	}, {
+		name:     "no_token",
+		method:   http.MethodGet,
+		target:   "/other.txt",
+		wantCode: http.StatusForbidden,
+	}, {
+		name:       "file",
+		method:     http.MethodGet,
+		target:     "/docs/a.txt",
+		token:      fileToken,
+		wantCode:   http.StatusOK,
+		wantCookie: true,
+	}, {
+		name:     "file_outside",
+		method:   http.MethodGet,
+		target:   "/other.txt",
+		token:    fileToken,
+		wantCode: http.StatusForbidden,
+	}, {
+		name:     "file_upload",
+		method:   http.MethodPost,
+		target:   "/docs/a.txt?upload",
+		token:    fileToken,
+		wantCode: http.StatusForbidden,
+	}, {
+		name:       "list",
+		method:     http.MethodGet,
+		target:     "/docs/",
+		token:      listToken,
+		wantCode:   http.StatusOK,
+		wantCookie: true,
+	}, {
+		name:     "list_download",
+		method:   http.MethodGet,
+		target:   "/docs/a.txt",
+		token:    listToken,
+		wantCode: http.StatusForbidden,
+	}, {
+		name:     "list_upload",
+		method:   http.MethodPost,
+		target:   "/docs/?upload",
+		token:    listToken,
+		wantCode: http.StatusForbidden,
+	}, {
+		name:       "upload",
+		method:     http.MethodPost,
+		target:     "/docs/?upload",
+		token:      uploadToken,
+		wantCode:   http.StatusOK,
+		wantCookie: true,
+	}, {
+		name:       "upload_progress",
+		method:     http.MethodGet,
+		target:     "/docs/?progress=abc",
+		token:      uploadToken,
+		wantCode:   http.StatusOK,
+		wantCookie: true,
+	}, {
+		name:     "revoked",
+		method:   http.MethodGet,
+		target:   "/docs/a.txt",
+		token:    revokedToken,
+		wantCode: http.StatusGone,
+	}, {
+		name:     "invalid",
+		method:   http.MethodGet,
+		target:   "/docs/a.txt",
+		token:    fileToken + "x",
+		wantCode: http.StatusForbidden,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.target, nil)
+			if tc.remote != "" {
+				r.RemoteAddr = tc.remote
+			}
+			if tc.token != "" {
+				q := r.URL.Query()
+				q.Set(QueryKey, tc.token)
+				r.URL.RawQuery = q.Encode()
+			}
+
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, r)
+
+			if rw.Code != tc.wantCode {
+				t.Fatalf("got status %d, want %d", rw.Code, tc.wantCode)
+			} else if hasCookie := len(rw.Result().Cookies()) > 0; hasCookie != tc.wantCookie {
+				t.Fatalf("got cookie %t, want %t", hasCookie, tc.wantCookie)
+			}
+		})
+	}
+}
+
+func TestGuard_Middleware_cookie(t *testing.T) {
+	fsys := newTestFS(t)
+	g, s := newTestGuard(t, fsys)
+	token := newToken(t, fsys, s, "/docs", OpList|OpDownload, 0)
+
+	h := g.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/docs/?"+QueryKey+"="+token, nil))
+
+	cookies := rw.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	} else if c := cookies[0]; c.Name != CookieName || c.Path != "/docs/" || !c.HttpOnly {
+		t.Fatalf("got cookie %+v", c)
+	}
+
+	// The relative links within the shared directory carry no token.
+	r := httptest.NewRequest(http.MethodGet, "/docs/a.txt", nil)
+	r.AddCookie(cookies[0])
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, r)
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rw.Code, http.StatusOK)
+	}
+}
+
+func TestGuard_Middleware_maxDownloads(t *testing.T) {
+	fsys := newTestFS(t)
+	g, s := newTestGuard(t, fsys)
+	token := newToken(t, fsys, s, "/docs/a.txt", OpDownload, 1)
+
+	code := http.StatusInternalServerError
+	h := g.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(code)
+	}))
+
+	// The failed downloads aren't counted.
+	wantCodes := []int{http.StatusInternalServerError, http.StatusOK, http.StatusGone}
+	for i, want := range wantCodes {
+		if i > 0 {
+			code = http.StatusOK
+		}
+
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/docs/a.txt?"+QueryKey+"="+token, nil))
+		if rw.Code != want {
+			t.Fatalf("download %d: got status %d, want %d", i, rw.Code, want)
+		}
+	}
+}
+
+func TestGuard_Middleware_maxDownloadsRange(t *testing.T) {
+	fsys := newTestFS(t)
+	g, s := newTestGuard(t, fsys)
+	h := g.Middleware(http.FileServer(fsys))
+
+	testCases := []struct {
+		name     string
+		rng      string
+		wantCode int
+	}{{
+		name:     "whole",
+		rng:      "bytes=0-",
+		wantCode: http.StatusPartialContent,
+	}, {
+		name:     "part",
+		rng:      "bytes=0-1",
+		wantCode: http.StatusPartialContent,
+	}, {
+		name:     "unsatisfiable",
+		rng:      "bytes=100-",
+		wantCode: http.StatusRequestedRangeNotSatisfiable,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			token := newToken(t, fsys, s, "/docs/a.txt", OpDownload, 1)
+			wantCodes := []int{tc.wantCode, http.StatusGone}
+			if tc.wantCode == http.StatusRequestedRangeNotSatisfiable {
+				// The failed downloads aren't counted.
+				wantCodes = []int{tc.wantCode, tc.wantCode}
+			}
+
+			for i, want := range wantCodes {
+				r := httptest.NewRequest(http.MethodGet, "/docs/a.txt?"+QueryKey+"="+token, nil)
+				r.Header.Set("Range", tc.rng)
+
+				rw := httptest.NewRecorder()
+				h.ServeHTTP(rw, r)
+				if rw.Code != want {
+					t.Fatalf("download %d: got status %d, want %d", i, rw.Code, want)
+				}
+			}
+		})
+	}
+}
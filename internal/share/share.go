@@ -0,0 +1,269 @@
+// Package share implements the expiring share links.  A share link carries a
+// token signed with HMAC-SHA256, which grants the limited access to a single
+// file or directory to the clients not trusted otherwise.
+package share
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"filesrv/internal/ferrors"
+
+	"golang.org/x/exp/slices"
+)
+
+// Errors of the share links.
+const (
+	ErrInvalid   ferrors.Str = "invalid share link"
+	ErrExpired   ferrors.Str = "the share link has expired"
+	ErrRevoked   ferrors.Str = "the share link has been revoked"
+	ErrExhausted ferrors.Str = "the share link's download limit is reached"
+	ErrForbidden ferrors.Str = "not allowed by the share link"
+	ErrRequired  ferrors.Str = "a share link is required"
+)
+
+// QueryKey is the query parameter carrying the token.
+const QueryKey = "share"
+
+// Op is a set of the operations allowed by a share.
+type Op uint8
+
+// Operations.
+const (
+	// OpDownload allows downloading files.
+	OpDownload Op = 1 << iota
+
+	// OpList allows listing directories.
+	OpList
+
+	// OpUpload allows uploading files into directories.
+	OpUpload
+)
+
+// opNames are the names of the operations in the order of their bits.
+var opNames = []string{"download", "list", "upload"}
+
+// ParseOp parses the comma-separated list of the operation names.
+func ParseOp(s string) (op Op, err error) {
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		i := slices.Index(opNames, name)
+		if i < 0 {
+			return 0, fmt.Errorf("unknown operation %q", name)
+		}
+
+		op |= 1 << i
+	}
+
+	if op == 0 {
+		return 0, fmt.Errorf("no operations")
+	}
+
+	return op, nil
+}
+
+// String implements the [fmt.Stringer] interface for Op.
+func (op Op) String() (s string) {
+	var names []string
+	for i, name := range opNames {
+		if op&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+
+	return strings.Join(names, ",")
+}
+
+// MarshalText implements the [encoding.TextMarshaler] interface for Op.
+func (op Op) MarshalText() (b []byte, err error) {
+	return []byte(op.String()), nil
+}
+
+// UnmarshalText implements the [encoding.TextUnmarshaler] interface for *Op.
+func (op *Op) UnmarshalText(b []byte) (err error) {
+	*op, err = ParseOp(string(b))
+
+	return err
+}
+
+// Share describes the access granted by a share link.
+type Share struct {
+	// Expires is the time the share expires at.
+	Expires time.Time `json:"expires"`
+
+	// ID is the unique identifier of the share.
+	ID string `json:"id"`
+
+	// Path is the cleaned URL path of the shared file or directory.  The path
+	// of a directory ends with a slash.
+	Path string `json:"path"`
+
+	// MaxDownloads is the number of downloads allowed.  Zero means no limit.
+	MaxDownloads int `json:"max_downloads,omitempty"`
+
+	// Ops are the allowed operations.
+	Ops Op `json:"ops"`
+}
+
+// idLen is the length of the share identifier in bytes.
+const idLen = 8
+
+// New returns a new share of the file or directory at the URL path p in fsys,
+// allowing ops for ttl.  Only [OpDownload] of ops is kept for files.
+func New(fsys http.FileSystem, p string, ops Op, ttl time.Duration, maxDownloads int) (sh *Share, err error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("ttl must be positive, got %s", ttl)
+	} else if maxDownloads < 0 {
+		return nil, fmt.Errorf("max downloads must not be negative, got %d", maxDownloads)
+	}
+
+	p = path.Clean("/" + p)
+	f, err := fsys.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("opening shared file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("opening shared file: %w", err)
+	}
+
+	if fi.IsDir() {
+		if p != "/" {
+			p += "/"
+		}
+	} else if ops &= OpDownload; ops == 0 {
+		return nil, fmt.Errorf("files may only be shared for %s", OpDownload)
+	}
+
+	id := make([]byte, idLen)
+	_, err = rand.Read(id)
+	if err != nil {
+		return nil, fmt.Errorf("generating id: %w", err)
+	}
+
+	return &Share{
+		Expires:      time.Now().Add(ttl).Truncate(time.Second),
+		ID:           hex.EncodeToString(id),
+		Path:         p,
+		MaxDownloads: maxDownloads,
+		Ops:          ops,
+	}, nil
+}
+
+// isDir returns true if sh shares a directory.
+func (sh *Share) isDir() (ok bool) {
+	return strings.HasSuffix(sh.Path, "/")
+}
+
+// covers returns true if the cleaned URL path p is within the share.
+func (sh *Share) covers(p string) (ok bool) {
+	if !sh.isDir() {
+		return p == sh.Path
+	}
+
+	return sh.Path == "/" || p+"/" == sh.Path || strings.HasPrefix(p, sh.Path)
+}
+
+// URL returns the share link to sh with token relative to base.
+func (sh *Share) URL(base *url.URL, token string) (u *url.URL) {
+	u = base.JoinPath(sh.Path)
+	if sh.isDir() && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawQuery = url.Values{QueryKey: []string{token}}.Encode()
+
+	return u
+}
+
+// payload is the signed part of the token.  The keys are short to keep the
+// links short.
+type payload struct {
+	ID           string `json:"i"`
+	Path         string `json:"p"`
+	Expires      int64  `json:"e"`
+	MaxDownloads int    `json:"m,omitempty"`
+	Ops          Op     `json:"o"`
+}
+
+// encoding is the encoding of the token parts.
+var encoding = base64.RawURLEncoding
+
+// Sign returns the token of sh signed with secret.
+func Sign(secret []byte, sh *Share) (token string, err error) {
+	b, err := json.Marshal(&payload{
+		ID:           sh.ID,
+		Path:         sh.Path,
+		Expires:      sh.Expires.Unix(),
+		MaxDownloads: sh.MaxDownloads,
+		Ops:          sh.Ops,
+	})
+	if err != nil {
+		return "", fmt.Errorf("encoding share: %w", err)
+	}
+
+	data := encoding.EncodeToString(b)
+
+	return data + "." + encoding.EncodeToString(sum(secret, data)), nil
+}
+
+// Verify returns the share from token, if it's signed with secret and not
+// expired.
+func Verify(secret []byte, token string) (sh *Share, err error) {
+	data, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalid
+	}
+
+	gotSum, err := encoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(gotSum, sum(secret, data)) {
+		return nil, ErrInvalid
+	}
+
+	b, err := encoding.DecodeString(data)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	p := &payload{}
+	err = json.Unmarshal(b, p)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	sh = &Share{
+		Expires:      time.Unix(p.Expires, 0),
+		ID:           p.ID,
+		Path:         p.Path,
+		MaxDownloads: p.MaxDownloads,
+		Ops:          p.Ops,
+	}
+	if !time.Now().Before(sh.Expires) {
+		return nil, ErrExpired
+	}
+
+	return sh, nil
+}
+
+// sum returns the HMAC-SHA256 of data with secret.
+func sum(secret []byte, data string) (b []byte) {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
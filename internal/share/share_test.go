@@ -0,0 +1,279 @@
+package share
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestFS returns the file system with the docs directory containing
+// a.txt, and the root file other.txt.
+func newTestFS(t *testing.T) (fsys http.FileSystem) {
+	t.Helper()
+
+	root := t.TempDir()
+	err := os.Mkdir(filepath.Join(root, "docs"), 0o700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"docs/a.txt", "other.txt"} {
+		err = os.WriteFile(filepath.Join(root, filepath.FromSlash(name)), []byte(name), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return http.Dir(root)
+}
+
+func TestParseOp(t *testing.T) {
+	testCases := []struct {
+		name    string
+		in      string
+		want    Op
+		wantErr bool
+	}{{
+		name: "single",
+		in:   "download",
+		want: OpDownload,
+	}, {
+		name: "several",
+		in:   " list, upload ,",
+		want: OpList | OpUpload,
+	}, {
+		name:    "unknown",
+		in:      "download,delete",
+		wantErr: true,
+	}, {
+		name:    "empty",
+		in:      " , ",
+		wantErr: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseOp(tc.in)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got %s, want error", got)
+				}
+
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			} else if got != tc.want {
+				t.Fatalf("got %s, want %s", got, tc.want)
+			} else if back, _ := ParseOp(got.String()); back != got {
+				t.Fatalf("%s doesn't round trip", got)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	fsys := newTestFS(t)
+
+	testCases := []struct {
+		name     string
+		path     string
+		ops      Op
+		wantPath string
+		wantOps  Op
+		wantErr  bool
+	}{{
+		name:     "dir",
+		path:     "docs",
+		ops:      OpList | OpUpload,
+		wantPath: "/docs/",
+		wantOps:  OpList | OpUpload,
+	}, {
+		name:     "root",
+		path:     "/",
+		ops:      OpList,
+		wantPath: "/",
+		wantOps:  OpList,
+	}, {
+		name:     "file",
+		path:     "/docs/../docs/a.txt",
+		ops:      OpDownload | OpList,
+		wantPath: "/docs/a.txt",
+		wantOps:  OpDownload,
+	}, {
+		name:    "file_without_download",
+		path:    "/other.txt",
+		ops:     OpList,
+		wantErr: true,
+	}, {
+		name:    "missing",
+		path:    "/missing",
+		ops:     OpDownload,
+		wantErr: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sh, err := New(fsys, tc.path, tc.ops, time.Hour, 0)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want error", sh)
+				}
+
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			} else if sh.Path != tc.wantPath || sh.Ops != tc.wantOps {
+				t.Fatalf("got %s for %s, want %s for %s", sh.Ops, sh.Path, tc.wantOps, tc.wantPath)
+			} else if len(sh.ID) != 2*idLen {
+				t.Fatalf("got id %q", sh.ID)
+			}
+		})
+	}
+}
+
+func TestShare_covers(t *testing.T) {
+	testCases := []struct {
+		name  string
+		share string
+		path  string
+		want  bool
+	}{{
+		name:  "file",
+		share: "/docs/a.txt",
+		path:  "/docs/a.txt",
+		want:  true,
+	}, {
+		name:  "file_other",
+		share: "/docs/a.txt",
+		path:  "/docs/a.txt.sha256",
+		want:  false,
+	}, {
+		name:  "dir_itself",
+		share: "/docs/",
+		path:  "/docs",
+		want:  true,
+	}, {
+		name:  "dir_nested",
+		share: "/docs/",
+		path:  "/docs/sub/a.txt",
+		want:  true,
+	}, {
+		name:  "dir_sibling_prefix",
+		share: "/docs/",
+		path:  "/docs2/a.txt",
+		want:  false,
+	}, {
+		name:  "dir_parent",
+		share: "/docs/",
+		path:  "/",
+		want:  false,
+	}, {
+		name:  "root",
+		share: "/",
+		path:  "/anything",
+		want:  true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sh := &Share{Path: tc.share}
+			if got := sh.covers(tc.path); got != tc.want {
+				t.Fatalf("got %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	secret := []byte("secret")
+	sh := &Share{
+		Expires:      time.Now().Add(time.Hour).Truncate(time.Second),
+		ID:           "0123456789abcdef",
+		Path:         "/docs/",
+		MaxDownloads: 3,
+		Ops:          OpList | OpDownload,
+	}
+
+	token, err := Sign(secret, sh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := *sh
+	expired.Expires = time.Now().Add(-time.Second)
+	expiredToken, err := Sign(secret, &expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, sig, _ := strings.Cut(token, ".")
+	otherData, _, _ := strings.Cut(expiredToken, ".")
+
+	testCases := []struct {
+		wantErr error
+		name    string
+		secret  []byte
+		token   string
+	}{{
+		wantErr: nil,
+		name:    "valid",
+		secret:  secret,
+		token:   token,
+	}, {
+		wantErr: ErrInvalid,
+		name:    "other_secret",
+		secret:  []byte("other"),
+		token:   token,
+	}, {
+		wantErr: ErrInvalid,
+		name:    "swapped_payload",
+		secret:  secret,
+		token:   otherData + "." + sig,
+	}, {
+		wantErr: ErrInvalid,
+		name:    "truncated_signature",
+		secret:  secret,
+		token:   data + "." + sig[:len(sig)-1],
+	}, {
+		wantErr: ErrInvalid,
+		name:    "no_signature",
+		secret:  secret,
+		token:   data,
+	}, {
+		wantErr: ErrInvalid,
+		name:    "garbage",
+		secret:  secret,
+		token:   "!!!.???",
+	}, {
+		wantErr: ErrExpired,
+		name:    "expired",
+		secret:  secret,
+		token:   expiredToken,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, verifyErr := Verify(tc.secret, tc.token)
+			if tc.wantErr != nil {
+				if !errors.Is(verifyErr, tc.wantErr) {
+					t.Fatalf("got %v, want %v", verifyErr, tc.wantErr)
+				}
+
+				return
+			} else if verifyErr != nil {
+				t.Fatal(verifyErr)
+			} else if !got.Expires.Equal(sh.Expires) {
+				t.Fatalf("got expiration %s, want %s", got.Expires, sh.Expires)
+			}
+
+			got.Expires = sh.Expires
+			if *got != *sh {
+				t.Fatalf("got %+v, want %+v", got, sh)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package share
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Record is the persisted state of a share.
+type Record struct {
+	Share
+
+	// Created is the time the share was created at.
+	Created time.Time `json:"created"`
+
+	// Downloads is the number of the complete downloads made so far.
+	Downloads int `json:"downloads"`
+
+	// Revoked is true if the share is revoked.
+	Revoked bool `json:"revoked,omitempty"`
+}
+
+// recordExt is the extension of the record files.
+const recordExt = ".json"
+
+// Store keeps the records of the shares as JSON files within a directory.  The
+// records are read on each use, so that the shares revoked by another process
+// are rejected immediately.
+type Store struct {
+	// mu serializes the modifications of the records.
+	mu  *sync.Mutex
+	dir string
+}
+
+// NewStore returns a new store keeping the records within dir, which is created
+// on the first write.
+func NewStore(dir string) (s *Store) {
+	return &Store{
+		mu:  &sync.Mutex{},
+		dir: dir,
+	}
+}
+
+// path returns the path of the record file of the share with id.
+func (s *Store) path(id string) (name string, err error) {
+	if len(id) != 2*idLen {
+		return "", ErrInvalid
+	} else if _, err = hex.DecodeString(id); err != nil {
+		return "", ErrInvalid
+	}
+
+	return filepath.Join(s.dir, id+recordExt), nil
+}
+
+// Add persists the record of the new share sh.
+func (s *Store) Add(sh *Share) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = os.MkdirAll(s.dir, 0o700)
+	if err != nil {
+		return fmt.Errorf("creating share store: %w", err)
+	}
+
+	return s.write(&Record{
+		Share:   *sh,
+		Created: time.Now(),
+	})
+}
+
+// Get returns the record of the share with id.  err wraps [fs.ErrNotExist] if
+// there is none.
+func (s *Store) Get(id string) (rec *Record, err error) {
+	name, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("reading share: %w", err)
+	}
+
+	rec = &Record{}
+	err = json.Unmarshal(b, rec)
+	if err != nil {
+		return nil, fmt.Errorf("decoding share %s: %w", id, err)
+	}
+
+	return rec, nil
+}
+
+// List returns all the records, the oldest first.
+func (s *Store) List() (recs []*Record, err error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("reading share store: %w", err)
+	}
+
+	recs = []*Record{}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), recordExt)
+		if !ok || !e.Type().IsRegular() {
+			continue
+		}
+
+		var rec *Record
+		rec, err = s.Get(id)
+		if err != nil {
+			return nil, err
+		}
+
+		recs = append(recs, rec)
+	}
+
+	slices.SortFunc(recs, func(a, b *Record) bool { return a.Created.Before(b.Created) })
+
+	return recs, nil
+}
+
+// Revoke revokes the share with id.
+func (s *Store) Revoke(id string) (err error) {
+	return s.update(id, func(rec *Record) (err error) {
+		rec.Revoked = true
+
+		return nil
+	})
+}
+
+// check returns an error if the share sh is revoked or exhausted.
+func (s *Store) check(sh *Share) (err error) {
+	rec, err := s.Get(sh.ID)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrRevoked
+	} else if err != nil {
+		return err
+	}
+
+	return rec.check()
+}
+
+// check returns an error if rec is revoked or exhausted.
+func (rec *Record) check() (err error) {
+	if rec.Revoked {
+		return ErrRevoked
+	} else if rec.MaxDownloads > 0 && rec.Downloads >= rec.MaxDownloads {
+		return ErrExhausted
+	}
+
+	return nil
+}
+
+// acquire counts a download of the share with id unless it's revoked or
+// exhausted.
+func (s *Store) acquire(id string) (err error) {
+	return s.update(id, func(rec *Record) (err error) {
+		err = rec.check()
+		if err != nil {
+			return err
+		}
+
+		rec.Downloads++
+
+		return nil
+	})
+}
+
+// release uncounts the download of the share with id counted by acquire.
+func (s *Store) release(id string) (err error) {
+	return s.update(id, func(rec *Record) (err error) {
+		if rec.Downloads > 0 {
+			rec.Downloads--
+		}
+
+		return nil
+	})
+}
+
+// update applies f to the record of the share with id and persists it unless f
+// returns an error.
+func (s *Store) update(id string, f func(rec *Record) (err error)) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+
+	err = f(rec)
+	if err != nil {
+		return err
+	}
+
+	return s.write(rec)
+}
+
+// write atomically replaces the record file of rec.  s.mu must be locked.
+func (s *Store) write(rec *Record) (err error) {
+	name, err := s.path(rec.ID)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(rec, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encoding share: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("writing share: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	_, err = tmp.Write(b)
+	err = errors.Join(err, tmp.Close())
+	if err != nil {
+		return fmt.Errorf("writing share: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), name)
+	if err != nil {
+		return fmt.Errorf("writing share: %w", err)
+	}
+
+	return nil
+}
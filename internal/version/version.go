@@ -0,0 +1,58 @@
+// Package version contains the build information set by the linker.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// These are set by the linker.  Unfortunately, we cannot set constants during
+// linking, so they are variables.  See scripts/make/go-build.sh.
+var (
+	version    string
+	commit     string
+	committime string
+)
+
+// Version returns the version of the build.  It's "v0.0.0-dev" unless set by
+// the linker.
+func Version() (v string) {
+	if version == "" {
+		return "v0.0.0-dev"
+	}
+
+	return version
+}
+
+// Commit returns the short hash of the commit the binary is built from.  It's
+// empty unless set by the linker.
+func Commit() (c string) {
+	return commit
+}
+
+// CommitTime returns the time of the commit the binary is built from.  It's
+// zero unless set by the linker.
+func CommitTime() (t time.Time) {
+	sec, err := strconv.ParseInt(committime, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return time.Unix(sec, 0).UTC()
+}
+
+// Full returns the human-readable description of the build.
+func Full() (s string) {
+	s = fmt.Sprintf("filesrv %s", Version())
+	if c := Commit(); c != "" {
+		s += fmt.Sprintf(" (commit %s", c)
+		if t := CommitTime(); !t.IsZero() {
+			s += fmt.Sprintf(" at %s", t.Format(time.RFC3339))
+		}
+		s += ")"
+	}
+
+	return s + fmt.Sprintf(", %s %s/%s", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -57,11 +57,16 @@ readonly committime
 # Set the linker flags accordingly: set the release channel and the current
 # version as well as goarm and gomips variable values, if the variables are set
 # and are not empty.
-version_pkg="scr/internal/version"
+version_pkg="filesrv/internal/version"
 readonly version_pkg
 
+# Set the commit hash unless already set.
+commit="${COMMIT:-$( git rev-parse --short HEAD )}"
+readonly commit
+
 ldflags="-s -w"
 ldflags="${ldflags} -X ${version_pkg}.version=${version}"
+ldflags="${ldflags} -X ${version_pkg}.commit=${commit}"
 ldflags="${ldflags} -X ${version_pkg}.committime=${committime}"
 if [ "${GOARM:-}" != '' ]
 then